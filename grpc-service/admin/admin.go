@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/reflection"
+)
+
+type Config struct {
+	// Reflection lets tools like grpcurl discover services without the
+	// .proto files.
+	Reflection bool
+	// Channelz exposes connection and call statistics over gRPC.
+	Channelz bool
+}
+
+// Register adds the debug services enabled in cfg to s. It must be called
+// before s starts serving.
+func Register(s *grpc.Server, cfg Config) {
+	if cfg.Reflection {
+		reflection.Register(s)
+	}
+	if cfg.Channelz {
+		channelz.RegisterChannelzServiceToServer(s)
+	}
+}
+
+// NewHandler serves pprof under /debug/pprof/ and expvar under /debug/vars.
+// It is meant for a listener that is not reachable from outside the pod.
+func NewHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
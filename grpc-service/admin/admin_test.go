@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/javibauza/final-project/grpc-service/pb"
+)
+
+func dial(t *testing.T, cfg Config) *grpc.ClientConn {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterUserServiceServer(s, pb.UnimplementedUserServiceServer{})
+	Register(s, cfg)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func listServices(conn *grpc.ClientConn) ([]string, error) {
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	err = stream.Send(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	res, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, s := range res.GetListServicesResponse().GetService() {
+		names = append(names, s.Name)
+	}
+	return names, nil
+}
+
+func TestRegister(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		conn := dial(t, Config{})
+
+		_, err := listServices(conn)
+		assert.Error(t, err)
+	})
+
+	t.Run("reflection and channelz", func(t *testing.T) {
+		conn := dial(t, Config{Reflection: true, Channelz: true})
+
+		names, err := listServices(conn)
+		assert.NoError(t, err)
+		assert.Contains(t, names, "pb.UserService")
+		assert.Contains(t, names, "grpc.channelz.v1.Channelz")
+
+		_, err = channelzpb.NewChannelzClient(conn).GetServers(context.Background(), &channelzpb.GetServersRequest{})
+		assert.NoError(t, err)
+	})
+}
+
+func TestNewHandler(t *testing.T) {
+	srv := httptest.NewServer(NewHandler())
+	defer srv.Close()
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/vars"} {
+		res, err := http.Get(srv.URL + path)
+		assert.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode, path)
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"expvar"
 	"flag"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,19 +17,60 @@ import (
 	"github.com/go-kit/log/level"
 	_ "github.com/mattn/go-sqlite3"
 
+	"github.com/javibauza/final-project/grpc-service/admin"
+	"github.com/javibauza/final-project/grpc-service/config"
 	"github.com/javibauza/final-project/grpc-service/endpoints"
+	"github.com/javibauza/final-project/grpc-service/interceptor"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/notify"
 	"github.com/javibauza/final-project/grpc-service/pb"
 	"github.com/javibauza/final-project/grpc-service/repository"
 	"github.com/javibauza/final-project/grpc-service/service"
+	"github.com/javibauza/final-project/grpc-service/svcauth"
+	"github.com/javibauza/final-project/grpc-service/tlsutil"
+	"github.com/javibauza/final-project/grpc-service/tracing"
 	"github.com/javibauza/final-project/grpc-service/transport"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		if err := config.Print(os.Stdout, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+		return
+	}
+
 	var logger log.Logger
 	{
-		logger = log.NewLogfmtLogger(os.Stderr)
-		logger = log.NewSyncLogger(logger)
+		var closer io.Closer
+		var err error
+
+		logger, closer, err = logging.New(logging.Config{
+			Format: cfg.Log.Format,
+			Level:  cfg.Log.Level,
+			Output: cfg.Log.Output,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+		defer closer.Close()
+
 		logger = log.With(logger,
 			"service", "grpcUserService",
 			"time:", log.DefaultTimestampUTC,
@@ -39,19 +84,58 @@ func main() {
 	{
 		var err error
 
-		db, err = sql.Open("sqlite3", "./users.db")
+		db, err = sql.Open("sqlite3", cfg.DBPath)
 		if err != nil {
 			level.Error(logger).Log("exit", err)
 			os.Exit(-1)
 		}
+		if err := repository.Migrate(context.Background(), db); err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
 	}
 
-	flag.Parse()
+	{
+		tp, err := tracing.NewProvider(context.Background(), tracing.Config{
+			ServiceName:  "grpcUserService",
+			Exporter:     cfg.Trace.Exporter,
+			OTLPEndpoint: cfg.Trace.OTLPEndpoint,
+		})
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+		defer tp.Shutdown(context.Background())
+	}
+
+	var notifier notify.Notifier
+	switch cfg.Email.Notifier {
+	case notify.KindSMTP:
+		notifier = notify.NewSMTPNotifier(notify.SMTPConfig{
+			Addr:     cfg.Email.SMTPAddr,
+			From:     cfg.Email.From,
+			Username: cfg.Email.SMTPUsername,
+			Password: cfg.Email.SMTPPassword,
+		})
+	default:
+		notifier = notify.NewFileNotifier(cfg.Email.File, cfg.Email.From)
+	}
 
 	var srv service.Service
 	{
-		repository := repository.NewRepo(db, logger)
-		srv = service.NewService(repository, logger)
+		repo := repository.NewRepo(db, logger)
+		repo = repository.TracingMiddleware()(repo)
+		srv = service.NewService(repo, cfg.Users.DeleteGracePeriod, service.EmailConfig{
+			Notifier:        notifier,
+			VerificationTTL: cfg.Email.VerificationTTL,
+			VerifyURL:       cfg.Email.VerifyURL,
+		}, service.TOTPConfig{
+			Issuer:       cfg.TOTP.Issuer,
+			ChallengeTTL: cfg.TOTP.ChallengeTTL,
+		}, logger)
+		srv = service.TracingMiddleware()(srv)
+
+		go repository.RunPurger(context.Background(), repo, cfg.Users.DeleteGracePeriod, cfg.Users.PurgeInterval, logger)
 	}
 
 	endpoints := endpoints.MakeEndpoints(srv)
@@ -65,18 +149,61 @@ func main() {
 		errs <- fmt.Errorf("%s", <-c)
 	}()
 
-	grpcListener, err := net.Listen("tcp", ":50051")
+	grpcListener, err := net.Listen("tcp", cfg.Addr)
 	if err != nil {
 		level.Error(logger).Log("exit", err)
 		os.Exit(-1)
 	}
 
+	chainCfg := interceptor.Config{
+		Logger:          logger,
+		AccessLog:       cfg.Server.AccessLog,
+		MaxRequestBytes: cfg.Server.MaxRequestBytes,
+		Metrics:         interceptor.NewMetrics(),
+	}
+	expvar.Publish("grpc_server", chainCfg.Metrics)
+	if cfg.Auth.Enabled {
+		authenticator := svcauth.NewAuthenticator(svcauth.Config{
+			APIKeys:      cfg.Auth.APIKeys,
+			TokenSecrets: cfg.Auth.TokenSecrets,
+			Allowlist:    cfg.Auth.Allowlist,
+		})
+		chainCfg.Auth = authenticator.Authorize
+	}
+
+	serverOpts := interceptor.ServerOptions(chainCfg)
+	if cfg.TLS.Enabled {
+		reloader, err := tlsutil.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+		go reloader.Watch(context.Background(), cfg.TLS.ReloadInterval, func(err error) {
+			level.Error(logger).Log("msg", "certificate reload failed", "err", err)
+		})
+
+		tlsConfig := tlsutil.ServerConfig(reloader, cfg.TLS.RequireClientCert, cfg.TLS.AllowedSubjects)
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	baseServer := grpc.NewServer(serverOpts...)
+	pb.RegisterUserServiceServer(baseServer, grpcServer)
+	admin.Register(baseServer, admin.Config{
+		Reflection: cfg.Debug.Reflection,
+		Channelz:   cfg.Debug.Channelz,
+	})
+
 	go func() {
-		baseServer := grpc.NewServer()
-		pb.RegisterUserServiceServer(baseServer, grpcServer)
 		level.Info(logger).Log("msg", "Server started successfully")
-		baseServer.Serve(grpcListener)
+		errs <- baseServer.Serve(grpcListener)
 	}()
 
+	if cfg.Debug.AdminAddr != "" {
+		go func() {
+			level.Info(logger).Log("msg", "admin listener started", "addr", cfg.Debug.AdminAddr)
+			errs <- http.ListenAndServe(cfg.Debug.AdminAddr, admin.NewHandler())
+		}()
+	}
+
 	level.Error(logger).Log("exit", <-errs)
 }
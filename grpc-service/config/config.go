@@ -0,0 +1,294 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/notify"
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+const EnvPrefix = "USER_GRPC_"
+
+type Config struct {
+	ConfigFile  string `yaml:"-" env:"CONFIG" flag:"config" usage:"path to a YAML config file"`
+	PrintConfig bool   `yaml:"-" flag:"print-config" usage:"print the effective configuration and exit"`
+
+	Addr   string `yaml:"addr" env:"ADDR" flag:"addr" usage:"gRPC listen address in the format of host:port"`
+	DBPath string `yaml:"db_path" env:"DB_PATH" flag:"db" usage:"path to the sqlite database"`
+
+	Server ServerConfig `yaml:"server"`
+	Log    LogConfig    `yaml:"log"`
+	Trace  TraceConfig  `yaml:"trace"`
+	TLS    TLSConfig    `yaml:"tls"`
+	Auth   AuthConfig   `yaml:"auth"`
+	Debug  DebugConfig  `yaml:"debug"`
+	Users  UsersConfig  `yaml:"users"`
+	Email  EmailConfig  `yaml:"email"`
+	TOTP   TOTPConfig   `yaml:"totp"`
+}
+
+type ServerConfig struct {
+	AccessLog       bool `yaml:"access_log" env:"ACCESS_LOG" flag:"access-log" usage:"log every RPC"`
+	MaxRequestBytes int  `yaml:"max_request_bytes" env:"MAX_REQUEST_BYTES" flag:"max-request-bytes" usage:"reject requests larger than this many bytes, 0 keeps gRPC's 4 MiB default"`
+}
+
+type LogConfig struct {
+	Format string `yaml:"format" env:"LOG_FORMAT" flag:"log-format" usage:"log format: logfmt or json"`
+	Level  string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" usage:"minimum log level: debug, info, warn or error"`
+	Output string `yaml:"output" env:"LOG_OUTPUT" flag:"log-output" usage:"log output: stderr, stdout or a file path"`
+}
+
+type TraceConfig struct {
+	Exporter     string `yaml:"exporter" env:"TRACE_EXPORTER" flag:"trace-exporter" usage:"trace exporter: none, stdout or otlp"`
+	OTLPEndpoint string `yaml:"otlp_endpoint" env:"OTLP_ENDPOINT" flag:"otlp-endpoint" usage:"OTLP collector address in the format of host:port"`
+}
+
+type TLSConfig struct {
+	Enabled           bool          `yaml:"enabled" env:"TLS_ENABLED" flag:"tls" usage:"serve gRPC over TLS"`
+	CertFile          string        `yaml:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert" usage:"server certificate file"`
+	KeyFile           string        `yaml:"key_file" env:"TLS_KEY_FILE" flag:"tls-key" usage:"server private key file"`
+	ClientCAFile      string        `yaml:"client_ca_file" env:"TLS_CLIENT_CA_FILE" flag:"tls-client-ca" usage:"CA bundle used to verify client certificates"`
+	RequireClientCert bool          `yaml:"require_client_cert" env:"TLS_REQUIRE_CLIENT_CERT" flag:"tls-require-client-cert" usage:"require and verify client certificates (mTLS)"`
+	AllowedSubjects   []string      `yaml:"allowed_subjects" env:"TLS_ALLOWED_SUBJECTS" flag:"tls-allowed-subjects" usage:"comma separated client certificate common names allowed to connect"`
+	ReloadInterval    time.Duration `yaml:"reload_interval" env:"TLS_RELOAD_INTERVAL" flag:"tls-reload-interval" usage:"how often certificate files are checked for changes"`
+}
+
+type AuthConfig struct {
+	Enabled      bool                `yaml:"enabled" env:"AUTH_ENABLED" flag:"auth" usage:"require service credentials on every RPC"`
+	APIKeys      map[string]string   `yaml:"api_keys" env:"AUTH_API_KEYS" secret:"true" usage:"client API keys as client=key pairs"`
+	TokenSecrets map[string]string   `yaml:"token_secrets" env:"AUTH_TOKEN_SECRETS" secret:"true" usage:"client token signing secrets as client=secret pairs"`
+	Allowlist    map[string][]string `yaml:"allowlist"`
+}
+
+type DebugConfig struct {
+	Reflection bool   `yaml:"reflection" env:"DEBUG_REFLECTION" flag:"reflection" usage:"enable gRPC server reflection"`
+	Channelz   bool   `yaml:"channelz" env:"DEBUG_CHANNELZ" flag:"channelz" usage:"register the channelz service"`
+	AdminAddr  string `yaml:"admin_addr" env:"DEBUG_ADMIN_ADDR" flag:"admin-addr" usage:"address of the admin listener serving pprof and expvar, empty disables it"`
+}
+
+type UsersConfig struct {
+	DeleteGracePeriod time.Duration `yaml:"delete_grace_period" env:"DELETE_GRACE_PERIOD" flag:"delete-grace-period" usage:"how long a deleted user can be restored before it is purged"`
+	PurgeInterval     time.Duration `yaml:"purge_interval" env:"PURGE_INTERVAL" flag:"purge-interval" usage:"how often users deleted longer than the grace period are purged"`
+}
+
+type EmailConfig struct {
+	Notifier        string        `yaml:"notifier" env:"EMAIL_NOTIFIER" flag:"email-notifier" usage:"how emails are sent: file or smtp"`
+	File            string        `yaml:"file" env:"EMAIL_FILE" flag:"email-file" usage:"file the file notifier appends emails to"`
+	From            string        `yaml:"from" env:"EMAIL_FROM" flag:"email-from" usage:"sender address of emails"`
+	SMTPAddr        string        `yaml:"smtp_addr" env:"EMAIL_SMTP_ADDR" flag:"smtp-addr" usage:"SMTP server address in the format of host:port"`
+	SMTPUsername    string        `yaml:"smtp_username" env:"EMAIL_SMTP_USERNAME" flag:"smtp-username" usage:"SMTP user name, empty disables authentication"`
+	SMTPPassword    string        `yaml:"smtp_password" env:"EMAIL_SMTP_PASSWORD" secret:"true" usage:"SMTP password"`
+	VerifyURL       string        `yaml:"verify_url" env:"EMAIL_VERIFY_URL" flag:"email-verify-url" usage:"link sent in verification emails with the token appended as the token query parameter, empty sends the bare token"`
+	VerificationTTL time.Duration `yaml:"verification_ttl" env:"EMAIL_VERIFICATION_TTL" flag:"email-verification-ttl" usage:"how long an email verification token is valid"`
+}
+
+type TOTPConfig struct {
+	Issuer       string        `yaml:"issuer" env:"TOTP_ISSUER" flag:"totp-issuer" usage:"service name shown by authenticator apps"`
+	ChallengeTTL time.Duration `yaml:"challenge_ttl" env:"TOTP_CHALLENGE_TTL" flag:"totp-challenge-ttl" usage:"how long a login waits for a two-factor code"`
+}
+
+func Default() Config {
+	return Config{
+		Addr:   ":50051",
+		DBPath: "./users.db",
+		Server: ServerConfig{
+			AccessLog:       true,
+			MaxRequestBytes: 64 << 10,
+		},
+		Log: LogConfig{
+			Format: logging.FormatLogfmt,
+			Level:  "info",
+			Output: "stderr",
+		},
+		Trace: TraceConfig{
+			Exporter:     tracing.ExporterNone,
+			OTLPEndpoint: "localhost:4317",
+		},
+		TLS: TLSConfig{
+			ReloadInterval: 30 * time.Second,
+		},
+		Users: UsersConfig{
+			DeleteGracePeriod: 30 * 24 * time.Hour,
+			PurgeInterval:     time.Hour,
+		},
+		Email: EmailConfig{
+			Notifier:        notify.KindFile,
+			File:            "./emails.log",
+			From:            "no-reply@localhost",
+			VerificationTTL: 24 * time.Hour,
+		},
+		TOTP: TOTPConfig{
+			Issuer:       "final-project",
+			ChallengeTTL: 5 * time.Minute,
+		},
+	}
+}
+
+func (c Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("addr: %w", err)
+	}
+	if c.DBPath == "" {
+		return fmt.Errorf("db_path is required")
+	}
+	if c.Server.MaxRequestBytes < 0 {
+		return fmt.Errorf("server.max_request_bytes must not be negative")
+	}
+	if err := c.Log.validate(); err != nil {
+		return err
+	}
+	if err := c.Trace.validate(); err != nil {
+		return err
+	}
+	if err := c.TLS.validate(); err != nil {
+		return err
+	}
+	if err := c.Auth.validate(); err != nil {
+		return err
+	}
+	if err := c.Debug.validate(); err != nil {
+		return err
+	}
+	if err := c.Users.validate(); err != nil {
+		return err
+	}
+	if err := c.Email.validate(); err != nil {
+		return err
+	}
+	return c.TOTP.validate()
+}
+
+func (c LogConfig) validate() error {
+	switch c.Format {
+	case logging.FormatLogfmt, logging.FormatJSON:
+	default:
+		return fmt.Errorf("log.format: unknown format %q", c.Format)
+	}
+	switch c.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log.level: unknown level %q", c.Level)
+	}
+	if c.Output == "" {
+		return fmt.Errorf("log.output is required")
+	}
+	return nil
+}
+
+func (c TraceConfig) validate() error {
+	switch c.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		if _, _, err := net.SplitHostPort(c.OTLPEndpoint); err != nil {
+			return fmt.Errorf("trace.otlp_endpoint: %w", err)
+		}
+	default:
+		return fmt.Errorf("trace.exporter: unknown exporter %q", c.Exporter)
+	}
+	return nil
+}
+
+func (c TLSConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return fmt.Errorf("tls.cert_file and tls.key_file are required when tls is enabled")
+	}
+	if c.RequireClientCert && c.ClientCAFile == "" {
+		return fmt.Errorf("tls.client_ca_file is required when client certificates are required")
+	}
+	if c.ReloadInterval <= 0 {
+		return fmt.Errorf("tls.reload_interval must be positive")
+	}
+	return nil
+}
+
+func (c AuthConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.APIKeys) == 0 && len(c.TokenSecrets) == 0 {
+		return fmt.Errorf("auth.api_keys or auth.token_secrets are required when auth is enabled")
+	}
+	for client, key := range c.APIKeys {
+		if key == "" {
+			return fmt.Errorf("auth.api_keys: empty key for client %q", client)
+		}
+	}
+	for client, secret := range c.TokenSecrets {
+		if len(secret) < 32 {
+			return fmt.Errorf("auth.token_secrets: secret for client %q must be at least 32 bytes", client)
+		}
+	}
+	if len(c.Allowlist) == 0 {
+		return fmt.Errorf("auth.allowlist is required when auth is enabled")
+	}
+	return nil
+}
+
+func (c DebugConfig) validate() error {
+	if c.AdminAddr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.AdminAddr); err != nil {
+		return fmt.Errorf("debug.admin_addr: %w", err)
+	}
+	return nil
+}
+
+func (c UsersConfig) validate() error {
+	if c.DeleteGracePeriod <= 0 {
+		return fmt.Errorf("users.delete_grace_period must be positive")
+	}
+	if c.PurgeInterval <= 0 {
+		return fmt.Errorf("users.purge_interval must be positive")
+	}
+	return nil
+}
+
+func (c EmailConfig) validate() error {
+	switch c.Notifier {
+	case notify.KindFile:
+		if c.File == "" {
+			return fmt.Errorf("email.file is required by the file notifier")
+		}
+	case notify.KindSMTP:
+		if _, _, err := net.SplitHostPort(c.SMTPAddr); err != nil {
+			return fmt.Errorf("email.smtp_addr: %w", err)
+		}
+	default:
+		return fmt.Errorf("email.notifier: unknown notifier %q", c.Notifier)
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("email.from: %w", err)
+	}
+	if c.VerifyURL != "" {
+		if u, err := url.Parse(c.VerifyURL); err != nil || !u.IsAbs() {
+			return fmt.Errorf("email.verify_url must be an absolute URL")
+		}
+	}
+	if c.VerificationTTL <= 0 {
+		return fmt.Errorf("email.verification_ttl must be positive")
+	}
+	return nil
+}
+
+func (c TOTPConfig) validate() error {
+	if c.Issuer == "" {
+		return fmt.Errorf("totp.issuer is required")
+	}
+	if strings.Contains(c.Issuer, ":") {
+		return fmt.Errorf("totp.issuer must not contain a colon")
+	}
+	if c.ChallengeTTL <= 0 {
+		return fmt.Errorf("totp.challenge_ttl must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(file, []byte("addr: \":6000\"\ndb_path: /data/file.db\nlog:\n  format: json\n  level: debug\n"), 0o600)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		testName      string
+		args          []string
+		env           map[string]string
+		checkResponse func(t *testing.T, cfg Config, err error)
+	}{
+		{
+			testName: "defaults",
+			checkResponse: func(t *testing.T, cfg Config, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, Default(), cfg)
+			},
+		},
+		{
+			testName: "file overrides defaults",
+			args:     []string{"-config", file},
+			checkResponse: func(t *testing.T, cfg Config, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, ":6000", cfg.Addr)
+				assert.Equal(t, "/data/file.db", cfg.DBPath)
+				assert.Equal(t, "json", cfg.Log.Format)
+				assert.Equal(t, "stderr", cfg.Log.Output)
+			},
+		},
+		{
+			testName: "env overrides file",
+			args:     []string{"-config", file},
+			env:      map[string]string{"USER_GRPC_DB_PATH": "/env.db", "USER_GRPC_LOG_LEVEL": "warn"},
+			checkResponse: func(t *testing.T, cfg Config, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, ":6000", cfg.Addr)
+				assert.Equal(t, "/env.db", cfg.DBPath)
+				assert.Equal(t, "warn", cfg.Log.Level)
+			},
+		},
+		{
+			testName: "flags override env",
+			args:     []string{"-db", "/flag.db", "-print-config"},
+			env:      map[string]string{"USER_GRPC_CONFIG": file, "USER_GRPC_DB_PATH": "/env.db"},
+			checkResponse: func(t *testing.T, cfg Config, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, ":6000", cfg.Addr)
+				assert.Equal(t, "/flag.db", cfg.DBPath)
+				assert.True(t, cfg.PrintConfig)
+			},
+		},
+		{
+			testName: "auth keys from env",
+			env:      map[string]string{"USER_GRPC_AUTH_API_KEYS": "rest-service=k1, batch=k2"},
+			checkResponse: func(t *testing.T, cfg Config, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, map[string]string{"rest-service": "k1", "batch": "k2"}, cfg.Auth.APIKeys)
+			},
+		},
+		{
+			testName: "unknown flag",
+			args:     []string{"-nope"},
+			checkResponse: func(t *testing.T, cfg Config, err error) {
+				assert.Error(t, err)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := Load(tc.args)
+			tc.checkResponse(t, cfg, err)
+		})
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("adr: \":6000\"\n"), 0o600))
+
+	_, err := Load([]string{"-config", file})
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Addr = "50051"
+	assert.Error(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.Log.Level = "verbose"
+	assert.Error(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.Trace.Exporter = "otlp"
+	cfg.Trace.OTLPEndpoint = ""
+	assert.Error(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.Users.DeleteGracePeriod = 0
+	assert.Error(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.Users.PurgeInterval = -time.Minute
+	assert.Error(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.Email.Notifier = "smtp"
+	assert.Error(t, cfg.Validate(), "smtp requires an address")
+	cfg.Email.SMTPAddr = "smtp.example.com:587"
+	assert.NoError(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.Email.From = "not an address"
+	assert.Error(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.Email.VerifyURL = "/verify"
+	assert.Error(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.TOTP.Issuer = "final:project"
+	assert.Error(t, cfg.Validate(), "the issuer prefixes the account name with a colon")
+
+	cfg = Default()
+	cfg.TOTP.ChallengeTTL = 0
+	assert.Error(t, cfg.Validate())
+}
+
+func TestPrint(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, Print(&buf, Default()))
+	assert.Contains(t, buf.String(), "addr: :50051\n")
+	assert.Contains(t, buf.String(), "db_path: ./users.db\n")
+	assert.NotContains(t, buf.String(), "print")
+}
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"io"
+
+	"github.com/javibauza/final-project/grpc-service/configutil"
+)
+
+// Load builds the configuration from, in increasing order of precedence,
+// the defaults, the YAML file given by -config (or USER_GRPC_CONFIG),
+// USER_GRPC_* environment variables and command line flags.
+func Load(args []string) (Config, error) {
+	cfg := Default()
+	if err := configutil.Load("grpc-service", EnvPrefix, &cfg, args); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Print writes the effective configuration as YAML with every field tagged
+// secret:"true" masked.
+func Print(w io.Writer, cfg Config) error {
+	return configutil.Print(w, cfg)
+}
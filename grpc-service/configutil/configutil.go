@@ -0,0 +1,226 @@
+package configutil
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const maskedValue = "******"
+
+// Load fills cfg, a pointer to a struct holding the defaults, from, in
+// increasing order of precedence, the YAML file given by -config (or the
+// <envPrefix>CONFIG variable), environment variables named by envPrefix and
+// the fields' env tags, and the command line flags named by their flag tags.
+func Load(app, envPrefix string, cfg interface{}, args []string) error {
+	fs := flag.NewFlagSet(app, flag.ContinueOnError)
+	flags := map[string]string{}
+	for _, f := range fields(reflect.ValueOf(cfg).Elem()) {
+		name := f.tag.Get("flag")
+		if name == "" {
+			continue
+		}
+		fs.Var(&rawFlag{name: name, isBool: f.value.Kind() == reflect.Bool, values: flags}, name, f.tag.Get("usage"))
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path, ok := flags["config"]
+	if !ok {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && err != io.EOF {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	for _, f := range fields(reflect.ValueOf(cfg).Elem()) {
+		name := f.tag.Get("env")
+		if name == "" {
+			continue
+		}
+		if raw, ok := os.LookupEnv(envPrefix + name); ok {
+			if err := set(f.value, raw); err != nil {
+				return fmt.Errorf("%s%s: %w", envPrefix, name, err)
+			}
+		}
+	}
+
+	for _, f := range fields(reflect.ValueOf(cfg).Elem()) {
+		name := f.tag.Get("flag")
+		raw, ok := flags[name]
+		if name == "" || !ok {
+			continue
+		}
+		if err := set(f.value, raw); err != nil {
+			return fmt.Errorf("-%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Print writes cfg, a struct, as YAML with every field tagged secret:"true"
+// masked.
+func Print(w io.Writer, cfg interface{}) error {
+	masked := reflect.New(reflect.TypeOf(cfg)).Elem()
+	masked.Set(reflect.ValueOf(cfg))
+	for _, f := range fields(masked) {
+		if f.tag.Get("secret") == "true" {
+			mask(f.value)
+		}
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(masked.Interface()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+type field struct {
+	value reflect.Value
+	tag   reflect.StructTag
+}
+
+func fields(v reflect.Value) []field {
+	var out []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		fv := v.Field(i)
+		if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Duration(0)) {
+			out = append(out, fields(fv)...)
+			continue
+		}
+		out = append(out, field{value: fv, tag: sf.Tag})
+	}
+	return out
+}
+
+func set(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		v.Set(reflect.ValueOf(splitList(raw)))
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		m := map[string]string{}
+		for _, item := range splitList(raw) {
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("%q is not in the form key=value", item)
+			}
+			m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func mask(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.String() != "" {
+			v.SetString(maskedValue)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			items := make([]string, v.Len())
+			for i := range items {
+				items[i] = maskedValue
+			}
+			v.Set(reflect.ValueOf(items))
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() == reflect.String {
+			m := reflect.MakeMap(v.Type())
+			for _, k := range v.MapKeys() {
+				m.SetMapIndex(k, reflect.ValueOf(maskedValue))
+			}
+			v.Set(m)
+		}
+	}
+}
+
+// rawFlag records the raw text of a flag so it can be applied after the
+// config file and environment have been read.
+type rawFlag struct {
+	name   string
+	isBool bool
+	values map[string]string
+}
+
+func (f *rawFlag) String() string {
+	return ""
+}
+
+func (f *rawFlag) Set(s string) error {
+	f.values[f.name] = s
+	return nil
+}
+
+func (f *rawFlag) IsBoolFlag() bool {
+	return f.isBool
+}
@@ -0,0 +1,85 @@
+package configutil
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfig struct {
+	Addr    string            `yaml:"addr" env:"ADDR" flag:"addr"`
+	Timeout time.Duration     `yaml:"timeout" env:"TIMEOUT" flag:"timeout"`
+	Debug   bool              `yaml:"debug" flag:"debug"`
+	Keys    map[string]string `yaml:"keys" env:"KEYS" secret:"true"`
+	Tokens  []string          `yaml:"tokens" env:"TOKENS" secret:"true"`
+	Nested  struct {
+		Port int `yaml:"port" env:"NESTED_PORT" flag:"port"`
+	} `yaml:"nested"`
+}
+
+func TestLoad(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		args          []string
+		env           map[string]string
+		checkResponse func(t *testing.T, cfg testConfig, err error)
+	}{
+		{
+			testName: "defaults",
+			checkResponse: func(t *testing.T, cfg testConfig, err error) {
+				require.NoError(t, err)
+				assert.Equal(t, ":80", cfg.Addr)
+			},
+		},
+		{
+			testName: "flags win over the environment",
+			args:     []string{"-addr", ":8080", "-debug", "-port", "9"},
+			env:      map[string]string{"TEST_ADDR": ":7070", "TEST_TIMEOUT": "2s", "TEST_KEYS": "a=1, b=2", "TEST_TOKENS": "x,,y"},
+			checkResponse: func(t *testing.T, cfg testConfig, err error) {
+				require.NoError(t, err)
+				assert.Equal(t, ":8080", cfg.Addr)
+				assert.Equal(t, 2*time.Second, cfg.Timeout)
+				assert.True(t, cfg.Debug)
+				assert.Equal(t, 9, cfg.Nested.Port)
+				assert.Equal(t, map[string]string{"a": "1", "b": "2"}, cfg.Keys)
+				assert.Equal(t, []string{"x", "y"}, cfg.Tokens)
+			},
+		},
+		{
+			testName: "invalid value",
+			env:      map[string]string{"TEST_NESTED_PORT": "ninety"},
+			checkResponse: func(t *testing.T, cfg testConfig, err error) {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "TEST_NESTED_PORT")
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			cfg := testConfig{Addr: ":80"}
+			err := Load("test", "TEST_", &cfg, tc.args)
+			tc.checkResponse(t, cfg, err)
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cfg := testConfig{Addr: ":80", Keys: map[string]string{"a": "1"}, Tokens: []string{"x"}}
+
+	var buf bytes.Buffer
+	require.NoError(t, Print(&buf, cfg))
+	assert.Contains(t, buf.String(), "addr: :80")
+	assert.Contains(t, buf.String(), "a: '******'")
+	assert.NotContains(t, buf.String(), "- x")
+
+	assert.Equal(t, map[string]string{"a": "1"}, cfg.Keys, "the caller's config is not masked")
+	assert.Equal(t, []string{"x"}, cfg.Tokens)
+}
@@ -2,18 +2,39 @@ package endpoints
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-kit/kit/endpoint"
 
 	erro "github.com/javibauza/final-project/grpc-service/errors"
 	"github.com/javibauza/final-project/grpc-service/service"
+	"github.com/javibauza/final-project/grpc-service/tracing"
 )
 
 type Endpoints struct {
-	Authenticate endpoint.Endpoint
-	CreateUser   endpoint.Endpoint
-	UpdateUser   endpoint.Endpoint
-	GetUser      endpoint.Endpoint
+	Authenticate      endpoint.Endpoint
+	CreateUser        endpoint.Endpoint
+	UpdateUser        endpoint.Endpoint
+	GetUser           endpoint.Endpoint
+	GetAuditLog       endpoint.Endpoint
+	DeleteUser        endpoint.Endpoint
+	RestoreUser       endpoint.Endpoint
+	SendVerification  endpoint.Endpoint
+	VerifyEmail       endpoint.Endpoint
+	CompleteAuth      endpoint.Endpoint
+	EnrollTOTP        endpoint.Endpoint
+	ConfirmTOTP       endpoint.Endpoint
+	CreateAPIKey      endpoint.Endpoint
+	ListAPIKeys       endpoint.Endpoint
+	RevokeAPIKey      endpoint.Endpoint
+	AuthAPIKey        endpoint.Endpoint
+	CreateOAuthClient endpoint.Endpoint
+	GetOAuthClient    endpoint.Endpoint
+	CreateAuthCode    endpoint.Endpoint
+	ExchangeAuthCode  endpoint.Endpoint
+	LinkIdentity      endpoint.Endpoint
+	ProvisionIdentity endpoint.Endpoint
+	AuthIdentity      endpoint.Endpoint
 }
 
 type AuthRequest struct {
@@ -22,44 +43,240 @@ type AuthRequest struct {
 }
 
 type AuthResponse struct {
-	UserId string
+	UserId    string
+	Challenge string
+}
+
+type CompleteAuthChallengeRequest struct {
+	Challenge string
+	Code      string
 }
 
 type CreateUserRequest struct {
-	Name    string
-	Pwd     string
-	Age     uint32
-	AddInfo string
+	Name      string
+	Pwd       string
+	Age       uint32
+	BirthDate string
+	Profile   service.Profile
 }
 
 type CreateUserResponse struct {
-	UserId string
+	UserId    string
+	Name      string
+	Age       uint32
+	BirthDate string
+	Profile   service.Profile
+	Version   int64
 }
 
 type UpdateUserRequest struct {
-	UserId  string
-	Name    string
-	Pwd     string
-	Age     uint32
-	AddInfo string
+	UserId          string
+	Name            string
+	Pwd             string
+	Age             uint32
+	BirthDate       string
+	Profile         service.Profile
+	ExpectedVersion int64
+	Fields          []string
+}
+
+type UpdateUserResponse struct {
+	UserId        string
+	Name          string
+	Age           uint32
+	BirthDate     string
+	Profile       service.Profile
+	EmailVerified bool
+	Version       int64
 }
 
 type GetUserRequest struct {
 	UserId string
 }
 type GetUserResponse struct {
+	UserId        string
+	Name          string
+	Age           uint32
+	BirthDate     string
+	Profile       service.Profile
+	EmailVerified bool
+	Version       int64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	LastLoginAt   time.Time
+}
+
+type GetAuditLogRequest struct {
+	UserId    string
+	PageSize  int32
+	PageToken string
+}
+type GetAuditLogResponse struct {
+	Entries       []service.AuditEntry
+	NextPageToken string
+}
+
+type DeleteUserRequest struct {
+	UserId string
+}
+type DeleteUserResponse struct{}
+
+type RestoreUserRequest struct {
+	UserId string
+}
+
+type SendVerificationRequest struct {
+	UserId string
+}
+type SendVerificationResponse struct{}
+
+type VerifyEmailRequest struct {
+	Token string
+}
+
+type EnrollTOTPRequest struct {
+	UserId string
+}
+type EnrollTOTPResponse struct {
+	Secret string
+	URI    string
+}
+
+type ConfirmTOTPRequest struct {
+	UserId string
+	Code   string
+}
+type ConfirmTOTPResponse struct {
+	RecoveryCodes []string
+}
+
+type CreateAPIKeyRequest struct {
+	UserId    string
+	Name      string
+	Scopes    []string
+	ExpiresAt time.Time
+}
+type CreateAPIKeyResponse struct {
+	APIKey service.APIKey
+	Key    string
+}
+
+type ListAPIKeysRequest struct {
+	UserId string
+}
+type ListAPIKeysResponse struct {
+	APIKeys []service.APIKey
+}
+
+type RevokeAPIKeyRequest struct {
+	UserId string
+	KeyId  string
+}
+type RevokeAPIKeyResponse struct{}
+
+type AuthAPIKeyRequest struct {
+	Key string
+}
+type AuthAPIKeyResponse struct {
+	KeyId  string
+	UserId string
+	Scopes []string
+}
+
+type CreateOAuthClientRequest struct {
+	Name         string
+	RedirectURIs []string
+	Confidential bool
+}
+type CreateOAuthClientResponse struct {
+	Client service.OAuthClient
+	Secret string
+}
+
+type GetOAuthClientRequest struct {
+	ClientId string
+}
+type GetOAuthClientResponse struct {
+	Client service.OAuthClient
+}
+
+type CreateAuthCodeRequest struct {
+	ClientId      string
+	UserId        string
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+}
+type CreateAuthCodeResponse struct {
+	Code string
+}
+
+type ExchangeAuthCodeRequest struct {
+	ClientId     string
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+}
+type ExchangeAuthCodeResponse struct {
+	UserId string
+	Scope  string
+	Nonce  string
+}
+
+type LinkIdentityRequest struct {
 	UserId  string
-	Name    string
-	Age     uint32
-	AddInfo string
+	Issuer  string
+	Subject string
+}
+type LinkIdentityResponse struct{}
+
+type ProvisionIdentityRequest struct {
+	Issuer        string
+	Subject       string
+	DisplayName   string
+	Email         string
+	EmailVerified bool
+}
+type ProvisionIdentityResponse struct {
+	UserId string
+}
+
+type AuthIdentityRequest struct {
+	Issuer  string
+	Subject string
+}
+type AuthIdentityResponse struct {
+	UserId    string
+	Challenge string
 }
 
 func MakeEndpoints(s service.Service) Endpoints {
 	return Endpoints{
-		Authenticate: makeAuthEndpoint(s),
-		CreateUser:   makeCreateUserEndpoint(s),
-		UpdateUser:   makeUpdateUserEndpoint(s),
-		GetUser:      makeGetUserEndpoint(s),
+		Authenticate:      tracing.EndpointMiddleware("Authenticate")(makeAuthEndpoint(s)),
+		CreateUser:        tracing.EndpointMiddleware("CreateUser")(makeCreateUserEndpoint(s)),
+		UpdateUser:        tracing.EndpointMiddleware("UpdateUser")(makeUpdateUserEndpoint(s)),
+		GetUser:           tracing.EndpointMiddleware("GetUser")(makeGetUserEndpoint(s)),
+		GetAuditLog:       tracing.EndpointMiddleware("GetAuditLog")(makeGetAuditLogEndpoint(s)),
+		DeleteUser:        tracing.EndpointMiddleware("DeleteUser")(makeDeleteUserEndpoint(s)),
+		RestoreUser:       tracing.EndpointMiddleware("RestoreUser")(makeRestoreUserEndpoint(s)),
+		SendVerification:  tracing.EndpointMiddleware("SendVerification")(makeSendVerificationEndpoint(s)),
+		VerifyEmail:       tracing.EndpointMiddleware("VerifyEmail")(makeVerifyEmailEndpoint(s)),
+		CompleteAuth:      tracing.EndpointMiddleware("CompleteAuthChallenge")(makeCompleteAuthEndpoint(s)),
+		EnrollTOTP:        tracing.EndpointMiddleware("EnrollTOTP")(makeEnrollTOTPEndpoint(s)),
+		ConfirmTOTP:       tracing.EndpointMiddleware("ConfirmTOTP")(makeConfirmTOTPEndpoint(s)),
+		CreateAPIKey:      tracing.EndpointMiddleware("CreateAPIKey")(makeCreateAPIKeyEndpoint(s)),
+		ListAPIKeys:       tracing.EndpointMiddleware("ListAPIKeys")(makeListAPIKeysEndpoint(s)),
+		RevokeAPIKey:      tracing.EndpointMiddleware("RevokeAPIKey")(makeRevokeAPIKeyEndpoint(s)),
+		AuthAPIKey:        tracing.EndpointMiddleware("AuthenticateAPIKey")(makeAuthAPIKeyEndpoint(s)),
+		CreateOAuthClient: tracing.EndpointMiddleware("CreateOAuthClient")(makeCreateOAuthClientEndpoint(s)),
+		GetOAuthClient:    tracing.EndpointMiddleware("GetOAuthClient")(makeGetOAuthClientEndpoint(s)),
+		CreateAuthCode:    tracing.EndpointMiddleware("CreateAuthorizationCode")(makeCreateAuthCodeEndpoint(s)),
+		ExchangeAuthCode:  tracing.EndpointMiddleware("ExchangeAuthorizationCode")(makeExchangeAuthCodeEndpoint(s)),
+		LinkIdentity:      tracing.EndpointMiddleware("LinkIdentity")(makeLinkIdentityEndpoint(s)),
+		ProvisionIdentity: tracing.EndpointMiddleware("ProvisionIdentity")(makeProvisionIdentityEndpoint(s)),
+		AuthIdentity:      tracing.EndpointMiddleware("AuthenticateIdentity")(makeAuthIdentityEndpoint(s)),
 	}
 }
 
@@ -70,7 +287,7 @@ func makeAuthEndpoint(s service.Service) endpoint.Endpoint {
 			return AuthResponse{}, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
 		}
 
-		userId, err := s.Authenticate(ctx, service.AuthRequest{
+		res, err := s.Authenticate(ctx, service.AuthRequest{
 			Name: req.Name,
 			Pwd:  req.Pwd,
 		})
@@ -79,7 +296,29 @@ func makeAuthEndpoint(s service.Service) endpoint.Endpoint {
 		}
 
 		return AuthResponse{
-			UserId: userId,
+			UserId:    res.UserId,
+			Challenge: res.Challenge,
+		}, nil
+	}
+}
+
+func makeCompleteAuthEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(CompleteAuthChallengeRequest)
+		if !ok {
+			return AuthResponse{}, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		res, err := s.CompleteAuthChallenge(ctx, service.CompleteAuthChallengeRequest{
+			Challenge: req.Challenge,
+			Code:      req.Code,
+		})
+		if err != nil {
+			return AuthResponse{}, err
+		}
+
+		return AuthResponse{
+			UserId: res.UserId,
 		}, nil
 	}
 }
@@ -92,17 +331,23 @@ func makeCreateUserEndpoint(s service.Service) endpoint.Endpoint {
 		}
 
 		res, err := s.CreateUser(ctx, service.CreateUserRequest{
-			Name:    req.Name,
-			Pwd:     req.Pwd,
-			Age:     req.Age,
-			AddInfo: req.AddInfo,
+			Name:      req.Name,
+			Pwd:       req.Pwd,
+			Age:       req.Age,
+			BirthDate: req.BirthDate,
+			Profile:   req.Profile,
 		})
 		if err != nil {
 			return CreateUserResponse{}, err
 		}
 
 		return CreateUserResponse{
-			UserId: res.UserId,
+			UserId:    res.UserId,
+			Name:      res.Name,
+			Age:       res.Age,
+			BirthDate: res.BirthDate,
+			Profile:   res.Profile,
+			Version:   res.Version,
 		}, err
 	}
 }
@@ -114,18 +359,29 @@ func makeUpdateUserEndpoint(s service.Service) endpoint.Endpoint {
 			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
 		}
 
-		err := s.UpdateUser(ctx, service.UpdateUserRequest{
-			UserId:  req.UserId,
-			Name:    req.Name,
-			Pwd:     req.Pwd,
-			Age:     req.Age,
-			AddInfo: req.AddInfo,
+		res, err := s.UpdateUser(ctx, service.UpdateUserRequest{
+			UserId:          req.UserId,
+			Name:            req.Name,
+			Pwd:             req.Pwd,
+			Age:             req.Age,
+			BirthDate:       req.BirthDate,
+			Profile:         req.Profile,
+			ExpectedVersion: req.ExpectedVersion,
+			Fields:          req.Fields,
 		})
 		if err != nil {
 			return nil, err
 		}
 
-		return nil, nil
+		return UpdateUserResponse{
+			UserId:        res.UserId,
+			Name:          res.Name,
+			Age:           res.Age,
+			BirthDate:     res.BirthDate,
+			Profile:       res.Profile,
+			EmailVerified: res.EmailVerified,
+			Version:       res.Version,
+		}, nil
 	}
 }
 
@@ -141,11 +397,371 @@ func makeGetUserEndpoint(s service.Service) endpoint.Endpoint {
 			return GetUserResponse{}, err
 		}
 
-		return GetUserResponse{
-			UserId:  user.UserId,
-			Name:    user.Name,
-			Age:     user.Age,
-			AddInfo: user.AddInfo,
+		return getUserResponse(user), nil
+	}
+}
+
+func makeGetAuditLogEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(GetAuditLogRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		res, err := s.GetAuditLog(ctx, service.GetAuditLogRequest{
+			UserId:    req.UserId,
+			PageSize:  req.PageSize,
+			PageToken: req.PageToken,
+		})
+		if err != nil {
+			return GetAuditLogResponse{}, err
+		}
+
+		return GetAuditLogResponse{
+			Entries:       res.Entries,
+			NextPageToken: res.NextPageToken,
+		}, nil
+	}
+}
+
+func makeDeleteUserEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(DeleteUserRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		if err := s.DeleteUser(ctx, req.UserId); err != nil {
+			return nil, err
+		}
+
+		return DeleteUserResponse{}, nil
+	}
+}
+
+// makeRestoreUserEndpoint responds with the restored user as a
+// GetUserResponse.
+func makeRestoreUserEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(RestoreUserRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		user, err := s.RestoreUser(ctx, req.UserId)
+		if err != nil {
+			return nil, err
+		}
+
+		return getUserResponse(user), nil
+	}
+}
+
+func makeSendVerificationEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(SendVerificationRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		if err := s.SendVerification(ctx, req.UserId); err != nil {
+			return nil, err
+		}
+
+		return SendVerificationResponse{}, nil
+	}
+}
+
+// makeVerifyEmailEndpoint responds with the verified user as a
+// GetUserResponse.
+func makeVerifyEmailEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(VerifyEmailRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		user, err := s.VerifyEmail(ctx, req.Token)
+		if err != nil {
+			return nil, err
+		}
+
+		return getUserResponse(user), nil
+	}
+}
+
+func makeEnrollTOTPEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(EnrollTOTPRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		res, err := s.EnrollTOTP(ctx, req.UserId)
+		if err != nil {
+			return nil, err
+		}
+
+		return EnrollTOTPResponse{
+			Secret: res.Secret,
+			URI:    res.URI,
+		}, nil
+	}
+}
+
+func makeConfirmTOTPEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(ConfirmTOTPRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		res, err := s.ConfirmTOTP(ctx, service.ConfirmTOTPRequest{
+			UserId: req.UserId,
+			Code:   req.Code,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return ConfirmTOTPResponse{
+			RecoveryCodes: res.RecoveryCodes,
+		}, nil
+	}
+}
+
+func getUserResponse(user service.GetUserResponse) GetUserResponse {
+	return GetUserResponse{
+		UserId:        user.UserId,
+		Name:          user.Name,
+		Age:           user.Age,
+		BirthDate:     user.BirthDate,
+		Profile:       user.Profile,
+		EmailVerified: user.EmailVerified,
+		Version:       user.Version,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		LastLoginAt:   user.LastLoginAt,
+	}
+}
+
+func makeCreateAPIKeyEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(CreateAPIKeyRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		res, err := s.CreateAPIKey(ctx, service.CreateAPIKeyRequest{
+			UserId:    req.UserId,
+			Name:      req.Name,
+			Scopes:    req.Scopes,
+			ExpiresAt: req.ExpiresAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return CreateAPIKeyResponse{
+			APIKey: res.APIKey,
+			Key:    res.Key,
+		}, nil
+	}
+}
+
+func makeListAPIKeysEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(ListAPIKeysRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		keys, err := s.ListAPIKeys(ctx, req.UserId)
+		if err != nil {
+			return nil, err
+		}
+
+		return ListAPIKeysResponse{APIKeys: keys}, nil
+	}
+}
+
+func makeRevokeAPIKeyEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(RevokeAPIKeyRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		if err := s.RevokeAPIKey(ctx, req.UserId, req.KeyId); err != nil {
+			return nil, err
+		}
+
+		return RevokeAPIKeyResponse{}, nil
+	}
+}
+
+func makeAuthAPIKeyEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(AuthAPIKeyRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		res, err := s.AuthenticateAPIKey(ctx, req.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		return AuthAPIKeyResponse{
+			KeyId:  res.KeyId,
+			UserId: res.UserId,
+			Scopes: res.Scopes,
+		}, nil
+	}
+}
+
+func makeCreateOAuthClientEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(CreateOAuthClientRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		res, err := s.CreateOAuthClient(ctx, service.CreateOAuthClientRequest{
+			Name:         req.Name,
+			RedirectURIs: req.RedirectURIs,
+			Confidential: req.Confidential,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return CreateOAuthClientResponse{
+			Client: res.Client,
+			Secret: res.Secret,
 		}, nil
 	}
 }
+
+func makeGetOAuthClientEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(GetOAuthClientRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		client, err := s.GetOAuthClient(ctx, req.ClientId)
+		if err != nil {
+			return nil, err
+		}
+
+		return GetOAuthClientResponse{Client: client}, nil
+	}
+}
+
+func makeCreateAuthCodeEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(CreateAuthCodeRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		code, err := s.CreateAuthorizationCode(ctx, service.CreateAuthorizationCodeRequest{
+			ClientId:      req.ClientId,
+			UserId:        req.UserId,
+			RedirectURI:   req.RedirectURI,
+			Scope:         req.Scope,
+			Nonce:         req.Nonce,
+			CodeChallenge: req.CodeChallenge,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return CreateAuthCodeResponse{Code: code}, nil
+	}
+}
+
+func makeExchangeAuthCodeEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(ExchangeAuthCodeRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		res, err := s.ExchangeAuthorizationCode(ctx, service.ExchangeAuthorizationCodeRequest{
+			ClientId:     req.ClientId,
+			ClientSecret: req.ClientSecret,
+			Code:         req.Code,
+			RedirectURI:  req.RedirectURI,
+			CodeVerifier: req.CodeVerifier,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return ExchangeAuthCodeResponse{
+			UserId: res.UserId,
+			Scope:  res.Scope,
+			Nonce:  res.Nonce,
+		}, nil
+	}
+}
+
+func makeLinkIdentityEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(LinkIdentityRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		err := s.LinkIdentity(ctx, service.LinkIdentityRequest{
+			UserId:  req.UserId,
+			Issuer:  req.Issuer,
+			Subject: req.Subject,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return LinkIdentityResponse{}, nil
+	}
+}
+
+func makeProvisionIdentityEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(ProvisionIdentityRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		userId, err := s.ProvisionIdentity(ctx, service.ProvisionIdentityRequest{
+			Issuer:        req.Issuer,
+			Subject:       req.Subject,
+			DisplayName:   req.DisplayName,
+			Email:         req.Email,
+			EmailVerified: req.EmailVerified,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return ProvisionIdentityResponse{UserId: userId}, nil
+	}
+}
+
+func makeAuthIdentityEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(AuthIdentityRequest)
+		if !ok {
+			return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+		}
+
+		res, err := s.AuthenticateIdentity(ctx, req.Issuer, req.Subject)
+		if err != nil {
+			return nil, err
+		}
+
+		return AuthIdentityResponse{UserId: res.UserId, Challenge: res.Challenge}, nil
+	}
+}
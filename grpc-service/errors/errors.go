@@ -10,6 +10,37 @@ const ErrUserNotFound = "user not found"
 const ErrWrongPassword = "wrong password"
 const ErrNoFieldsForUpdate = "no fields for update"
 const ErrInvalidRequestType = "invalid request type"
+const ErrVersionMismatch = "user version does not match"
+const ErrInvalidPageSize = "page_size must not be negative"
+const ErrInvalidPageToken = "invalid page_token"
+const ErrUserNotDeleted = "user is not deleted"
+const ErrGracePeriodExpired = "restore grace period has expired"
+const ErrInvalidEmail = "profile.email is not a valid email address"
+const ErrInvalidLocale = "profile.locale is not a BCP 47 language tag"
+const ErrInvalidTimezone = "profile.timezone is not an IANA time zone"
+const ErrEmptyMetadataKey = "profile.metadata keys must not be empty"
+const ErrEmailTaken = "email address is already in use"
+const ErrNoEmail = "user has no email address"
+const ErrEmailAlreadyVerified = "email address is already verified"
+const ErrInvalidVerificationToken = "invalid or expired verification token"
+const ErrInvalidBirthDate = "birth_date must be a date in the format YYYY-MM-DD"
+const ErrTOTPEnabled = "two-factor authentication is already enabled"
+const ErrTOTPNotEnrolled = "two-factor authentication is not enrolled"
+const ErrWrongCode = "wrong or already used two-factor code"
+const ErrInvalidChallenge = "invalid or expired login challenge"
+const ErrTOTPLocked = "too many wrong two-factor codes, try again later"
+const ErrAPIKeyNotFound = "API key not found"
+const ErrInvalidAPIKey = "invalid, expired or revoked API key"
+const ErrExpiresAtInPast = "expires_at must be in the future"
+const ErrOAuthClientNotFound = "OAuth client not found"
+const ErrRedirectURINotRegistered = "redirect_uri is not registered for the client"
+const ErrInvalidClientSecret = "invalid client credentials"
+const ErrInvalidAuthorizationCode = "invalid, expired or already used authorization code"
+const ErrIdentityNotLinked = "identity is not linked to a user"
+const ErrIdentityLinked = "identity is already linked to a user"
+const ErrLinkedUserDeleted = "the user linked to this identity is deleted"
+const ErrEmailNotLinkable = "email address belongs to another account that can't be linked automatically"
+const ErrReservedName = "name uses the prefix reserved for federated users"
 
 type ErrNotFound struct {
 	Err error
@@ -23,6 +54,14 @@ type ErrPermissionDenied struct {
 	Err error
 }
 
+type ErrFailedPrecondition struct {
+	Err error
+}
+
+type ErrAlreadyExists struct {
+	Err error
+}
+
 func (r *ErrNotFound) Error() string {
 	return fmt.Sprintf("%v", r.Err)
 }
@@ -44,6 +83,24 @@ func NewErrPermissionDenied(message string) *ErrPermissionDenied {
 	return &ErrPermissionDenied{Err: errors.New(message)}
 }
 
+func (r *ErrFailedPrecondition) Error() string {
+	return fmt.Sprintf("%v", r.Err)
+}
+func NewErrFailedPrecondition(message string) *ErrFailedPrecondition {
+	return &ErrFailedPrecondition{Err: errors.New(message)}
+}
+
+func (r *ErrAlreadyExists) Error() string {
+	return fmt.Sprintf("%v", r.Err)
+}
+func NewErrAlreadyExists(message string) *ErrAlreadyExists {
+	return &ErrAlreadyExists{Err: errors.New(message)}
+}
+
+var ErrUnknownUpdateField = func(field string) string {
+	return "unknown field " + field + " in update mask"
+}
+
 var ErrRequiredFields = func(fields ...string) string {
 	if len(fields) > 1 {
 		return strings.Join(fields, ", ") + " are required"
@@ -51,3 +108,23 @@ var ErrRequiredFields = func(fields ...string) string {
 		return fields[0] + " is required"
 	}
 }
+
+var ErrTooLong = func(field string, max int) string {
+	return fmt.Sprintf("%s must not be longer than %d characters", field, max)
+}
+
+var ErrTooManyMetadataEntries = func(max int) string {
+	return fmt.Sprintf("profile.metadata must not have more than %d entries", max)
+}
+
+var ErrUnknownScope = func(scope string) string {
+	return fmt.Sprintf("unknown scope %q", scope)
+}
+
+var ErrInvalidRedirectURI = func(uri string) string {
+	return fmt.Sprintf("redirect_uri %q must be an absolute https URL, or http on a loopback host, without a fragment", uri)
+}
+
+var ErrImplausibleBirthDate = func(maxAge int) string {
+	return fmt.Sprintf("birth_date must not be in the future or more than %d years ago", maxAge)
+}
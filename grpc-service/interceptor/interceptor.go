@@ -0,0 +1,160 @@
+package interceptor
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/requestid"
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+// AuthFunc authorizes a call to fullMethod. The returned context replaces
+// the incoming one, so it can carry the authenticated caller.
+type AuthFunc func(ctx context.Context, fullMethod string) (context.Context, error)
+
+type Config struct {
+	Logger log.Logger
+	// AccessLog logs one line per call.
+	AccessLog bool
+	// MaxRequestBytes rejects requests whose encoded size is larger before
+	// they are read. Zero keeps gRPC's default of 4 MiB.
+	MaxRequestBytes int
+	// Metrics, when set, counts calls and latency per method.
+	Metrics *Metrics
+	// Auth, when set, is called before every handler.
+	Auth AuthFunc
+}
+
+// Interceptors returns the unary server interceptors described by cfg, in
+// the order they must run.
+func Interceptors(cfg Config) []grpc.UnaryServerInterceptor {
+	interceptors := []grpc.UnaryServerInterceptor{
+		// First, so that a panic in any interceptor is recovered too.
+		Recovery(cfg.Logger),
+		requestid.UnaryServerInterceptor(),
+		tracing.UnaryServerInterceptor(),
+	}
+	if cfg.AccessLog {
+		interceptors = append(interceptors, logging.AccessLogInterceptor(cfg.Logger))
+	}
+	if cfg.Metrics != nil {
+		interceptors = append(interceptors, cfg.Metrics.UnaryServerInterceptor())
+	}
+	if cfg.Auth != nil {
+		interceptors = append(interceptors, Auth(cfg.Auth))
+	}
+	// Again around the handler, so that the access log and metrics count its
+	// panics as INTERNAL errors.
+	return append(interceptors, Recovery(cfg.Logger), Validate())
+}
+
+func Chain(cfg Config) grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(Interceptors(cfg)...)
+}
+
+// ServerOptions returns the options that apply cfg to a server: the
+// interceptor chain, authorization of streaming calls such as reflection and
+// channelz, and the request size limit.
+func ServerOptions(cfg Config) []grpc.ServerOption {
+	streamInterceptors := []grpc.StreamServerInterceptor{StreamRecovery(cfg.Logger)}
+	if cfg.Auth != nil {
+		streamInterceptors = append(streamInterceptors, StreamAuth(cfg.Auth))
+	}
+	opts := []grpc.ServerOption{Chain(cfg), grpc.ChainStreamInterceptor(streamInterceptors...)}
+	if cfg.MaxRequestBytes > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(cfg.MaxRequestBytes))
+	}
+	return opts
+}
+
+// Recovery turns a panic in the handler, or in the interceptors after it, into
+// an INTERNAL error and logs the stack trace.
+func Recovery(logger log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				resp, err = nil, recovered(ctx, logger, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecovery is Recovery for streaming calls.
+func StreamRecovery(logger log.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(ss.Context(), logger, info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func recovered(ctx context.Context, logger log.Logger, method string, r interface{}) error {
+	level.Error(logging.WithContext(ctx, logger)).Log(
+		"msg", "panic in handler",
+		"method", method,
+		"panic", r,
+		"stack", string(debug.Stack()),
+	)
+	return status.Error(codes.Internal, "internal error")
+}
+
+func Auth(authorize AuthFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authorize(ctx, info.FullMethod)
+		if err != nil {
+			if _, ok := status.FromError(err); !ok {
+				err = status.Error(codes.Unauthenticated, err.Error())
+			}
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authorizedStream replaces the context of a stream with the one
+// authorization returned.
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamAuth is Auth for streaming calls.
+func StreamAuth(authorize AuthFunc) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			if _, ok := status.FromError(err); !ok {
+				err = status.Error(codes.Unauthenticated, err.Error())
+			}
+			return err
+		}
+		return handler(srv, authorizedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// Validate rejects requests that implement Validate() error and fail it.
+func Validate() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if v, ok := req.(interface{ Validate() error }); ok {
+			if err := v.Validate(); err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,260 @@
+package interceptor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/javibauza/final-project/grpc-service/pb"
+	"github.com/javibauza/final-project/grpc-service/svcauth"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+type userService struct {
+	pb.UnimplementedUserServiceServer
+}
+
+func (userService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	if req.UserId == "panic" {
+		panic("boom")
+	}
+	if req.UserId == "principal" {
+		return &pb.GetUserResponse{UserId: svcauth.PrincipalFromContext(ctx)}, nil
+	}
+	return &pb.GetUserResponse{UserId: req.UserId}, nil
+}
+
+func dial(t *testing.T, cfg Config) pb.UserServiceClient {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer(ServerOptions(cfg)...)
+	pb.RegisterUserServiceServer(s, userService{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewUserServiceClient(conn)
+}
+
+func TestChain(t *testing.T) {
+	allowOnlyTrusted := func(ctx context.Context, fullMethod string) (context.Context, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if len(md.Get("x-trusted")) == 0 {
+			return nil, errors.New("untrusted caller")
+		}
+		return ctx, nil
+	}
+
+	testCases := []struct {
+		testName      string
+		cfg           Config
+		ctx           context.Context
+		req           *pb.GetUserRequest
+		checkResponse func(t *testing.T, res *pb.GetUserResponse, err error, logs string, metrics *Metrics)
+	}{
+		{
+			testName: "ok",
+			cfg:      Config{AccessLog: true},
+			req:      &pb.GetUserRequest{UserId: "1"},
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error, logs string, metrics *Metrics) {
+				assert.NoError(t, err)
+				assert.Equal(t, "1", res.UserId)
+				assert.Contains(t, logs, "method=/pb.UserService/GetUser")
+				assert.Contains(t, logs, "code=OK")
+				assert.Equal(t, int64(1), metrics.Calls("/pb.UserService/GetUser", "OK"))
+			},
+		},
+		{
+			testName: "panic is recovered",
+			cfg:      Config{AccessLog: true},
+			req:      &pb.GetUserRequest{UserId: "panic"},
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error, logs string, metrics *Metrics) {
+				assert.Equal(t, codes.Internal, status.Code(err))
+				assert.Contains(t, logs, "panic=boom")
+				assert.Contains(t, logs, "interceptor_test.go")
+				assert.Contains(t, logs, "code=Internal")
+				assert.Equal(t, int64(1), metrics.Calls("/pb.UserService/GetUser", "Internal"))
+			},
+		},
+		{
+			testName: "panic in an interceptor is recovered",
+			cfg: Config{Auth: func(ctx context.Context, fullMethod string) (context.Context, error) {
+				panic("auth boom")
+			}},
+			req: &pb.GetUserRequest{UserId: "1"},
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error, logs string, metrics *Metrics) {
+				assert.Equal(t, codes.Internal, status.Code(err))
+				assert.Contains(t, logs, `panic="auth boom"`)
+			},
+		},
+		{
+			testName: "request too large",
+			cfg:      Config{MaxRequestBytes: 16},
+			req:      &pb.GetUserRequest{UserId: strings.Repeat("x", 32)},
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error, logs string, metrics *Metrics) {
+				assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+			},
+		},
+		{
+			testName: "principal of an unauthenticated caller is ignored",
+			ctx:      metadata.AppendToOutgoingContext(context.Background(), svcauth.PrincipalMetadataKey, "user:admin"),
+			req:      &pb.GetUserRequest{UserId: "principal"},
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error, logs string, metrics *Metrics) {
+				assert.NoError(t, err)
+				assert.Empty(t, res.UserId)
+			},
+		},
+		{
+			testName: "auth hook rejects",
+			cfg:      Config{Auth: allowOnlyTrusted},
+			req:      &pb.GetUserRequest{UserId: "1"},
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error, logs string, metrics *Metrics) {
+				assert.Equal(t, codes.Unauthenticated, status.Code(err))
+				assert.Equal(t, int64(1), metrics.Calls("/pb.UserService/GetUser", "Unauthenticated"))
+			},
+		},
+		{
+			testName: "auth hook accepts",
+			cfg:      Config{Auth: allowOnlyTrusted},
+			ctx:      metadata.AppendToOutgoingContext(context.Background(), "x-trusted", "yes"),
+			req:      &pb.GetUserRequest{UserId: "1"},
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error, logs string, metrics *Metrics) {
+				assert.NoError(t, err)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			var logs syncBuffer
+			tc.cfg.Logger = log.NewLogfmtLogger(&logs)
+			tc.cfg.Metrics = NewMetrics()
+			client := dial(t, tc.cfg)
+
+			ctx := tc.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			res, err := client.GetUser(ctx, tc.req)
+			tc.checkResponse(t, res, err, logs.String(), tc.cfg.Metrics)
+		})
+	}
+}
+
+func dialReflection(t *testing.T, cfg Config) *grpc.ClientConn {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer(ServerOptions(cfg)...)
+	reflection.Register(s)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// listServices makes a streaming call.
+func listServices(ctx context.Context, conn *grpc.ClientConn) error {
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_ListServices{}}); err != nil {
+		return err
+	}
+	_, err = stream.Recv()
+	return err
+}
+
+func TestStreamAuth(t *testing.T) {
+	allowOnlyTrusted := func(ctx context.Context, fullMethod string) (context.Context, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if len(md.Get("x-trusted")) == 0 {
+			return nil, errors.New("untrusted caller")
+		}
+		return ctx, nil
+	}
+	conn := dialReflection(t, Config{Logger: log.NewNopLogger(), Auth: allowOnlyTrusted})
+
+	err := listServices(context.Background(), conn)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	err = listServices(metadata.AppendToOutgoingContext(context.Background(), "x-trusted", "yes"), conn)
+	assert.NoError(t, err)
+}
+
+func TestStreamRecovery(t *testing.T) {
+	var logs syncBuffer
+	conn := dialReflection(t, Config{
+		Logger: log.NewLogfmtLogger(&logs),
+		Auth: func(ctx context.Context, fullMethod string) (context.Context, error) {
+			panic("auth boom")
+		},
+	})
+
+	err := listServices(context.Background(), conn)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, logs.String(), `panic="auth boom"`)
+}
+
+type validatedRequest struct {
+	err error
+}
+
+func (r validatedRequest) Validate() error {
+	return r.err
+}
+
+func TestValidate(t *testing.T) {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/pb.UserService/GetUser"}
+
+	_, err := Validate()(context.Background(), validatedRequest{err: errors.New("user_id is required")}, info, handler)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	resp, err := Validate()(context.Background(), validatedRequest{}, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+}
@@ -0,0 +1,48 @@
+package interceptor
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Metrics counts calls per method and status code and accumulates their
+// latency. It implements expvar.Var so it can be published with
+// expvar.Publish.
+type Metrics struct {
+	calls   *expvar.Map
+	latency *expvar.Map
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		calls:   new(expvar.Map).Init(),
+		latency: new(expvar.Map).Init(),
+	}
+}
+
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		begin := time.Now()
+		resp, err := handler(ctx, req)
+
+		m.calls.Add(info.FullMethod+" "+status.Code(err).String(), 1)
+		m.latency.AddFloat(info.FullMethod, time.Since(begin).Seconds())
+		return resp, err
+	}
+}
+
+// Calls returns how many calls to fullMethod ended with code.
+func (m *Metrics) Calls(fullMethod, code string) int64 {
+	if v, ok := m.calls.Get(fullMethod + " " + code).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func (m *Metrics) String() string {
+	return `{"calls": ` + m.calls.String() + `, "latency_seconds": ` + m.latency.String() + `}`
+}
@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/javibauza/final-project/grpc-service/pb"
+)
+
+func AccessLogInterceptor(logger log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		begin := time.Now()
+		resp, err := handler(ctx, req)
+
+		keyvals := []interface{}{
+			"msg", "access",
+			"method", info.FullMethod,
+			"code", status.Code(err).String(),
+			"latency", time.Since(begin),
+			"user", userFrom(req, resp),
+		}
+		if r, ok := resp.(interface{ GetStatus() *pb.Status }); ok && r.GetStatus() != nil {
+			keyvals = append(keyvals, "status", r.GetStatus().Code)
+		}
+		level.Info(WithContext(ctx, logger)).Log(keyvals...)
+
+		return resp, err
+	}
+}
+
+func userFrom(req, resp interface{}) string {
+	for _, m := range []interface{}{req, resp} {
+		if u, ok := m.(interface{ GetUserId() string }); ok && u.GetUserId() != "" {
+			return u.GetUserId()
+		}
+	}
+	return ""
+}
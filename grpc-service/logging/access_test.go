@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/javibauza/final-project/grpc-service/pb"
+	"github.com/javibauza/final-project/grpc-service/requestid"
+)
+
+func TestAccessLogInterceptor(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogfmtLogger(&buf)
+
+	chain := func(ctx context.Context, req interface{}, handler grpc.UnaryHandler) (interface{}, error) {
+		info := &grpc.UnaryServerInfo{FullMethod: "/pb.UserService/GetUser"}
+		return requestid.UnaryServerInterceptor()(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return AccessLogInterceptor(logger)(ctx, req, info, handler)
+		})
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestid.MetadataKey, "abc-123"))
+	_, err := chain(ctx, &pb.GetUserRequest{UserId: "u1"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		WithContext(ctx, logger).Log("msg", "handler")
+		return &pb.GetUserResponse{Status: &pb.Status{Code: 5}}, nil
+	})
+	assert.NoError(t, err)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+	assert.Contains(t, string(lines[0]), "request_id=abc-123")
+	assert.Contains(t, string(lines[1]), "request_id=abc-123")
+	assert.Contains(t, string(lines[1]), "method=/pb.UserService/GetUser")
+	assert.Contains(t, string(lines[1]), "code=OK")
+	assert.Contains(t, string(lines[1]), "user=u1")
+	assert.Contains(t, string(lines[1]), "status=5")
+}
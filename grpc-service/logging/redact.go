@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/go-kit/log"
+)
+
+const Redacted = "[REDACTED]"
+
+var sensitiveKeys = []string{"password", "pwd", "hash", "token", "secret", "authorization", "api_key", "apikey"}
+
+var sensitivePatterns = []*regexp.Regexp{
+	// bcrypt hashes
+	regexp.MustCompile(`\$2[abxy]?\$\d{2}\$[./A-Za-z0-9]{53}`),
+	// API keys
+	regexp.MustCompile(`fpk_[a-z2-7]+_[A-Za-z0-9\-_]+`),
+	// bearer credentials
+	regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9\-._~+/]+=*`),
+	// key=value or key: value pairs embedded in messages
+	regexp.MustCompile(`(?i)((?:password|pwd|token|secret|api_key|hash)\w*)(["']?\s*[:=]\s*)("[^"]*"|'[^']*'|[^\s,;&]+)`),
+}
+
+type redactor struct {
+	next log.Logger
+}
+
+// NewRedactor wraps next so that values under sensitive keys, and anything
+// that looks like a password hash or credential inside other values, never
+// reach the underlying writer.
+func NewRedactor(next log.Logger) log.Logger {
+	return &redactor{next: next}
+}
+
+func (r *redactor) Log(keyvals ...interface{}) error {
+	redacted := make([]interface{}, len(keyvals))
+	copy(redacted, keyvals)
+
+	for i := 1; i < len(redacted); i += 2 {
+		if isSensitiveKey(redacted[i-1]) {
+			redacted[i] = Redacted
+			continue
+		}
+		redacted[i] = redactValue(redacted[i])
+	}
+
+	return r.next.Log(redacted...)
+}
+
+func isSensitiveKey(key interface{}) bool {
+	k, ok := key.(string)
+	if !ok {
+		return false
+	}
+	k = strings.ToLower(k)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactValue(value interface{}) interface{} {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	default:
+		return value
+	}
+
+	return RedactString(s)
+}
+
+func RedactString(s string) string {
+	for _, p := range sensitivePatterns {
+		if p.NumSubexp() == 3 {
+			s = p.ReplaceAllString(s, "${1}${2}"+Redacted)
+		} else {
+			s = p.ReplaceAllString(s, Redacted)
+		}
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	testCases := []struct {
+		testName string
+		keyvals  []interface{}
+		expected string
+	}{
+		{
+			testName: "sensitive keys",
+			keyvals:  []interface{}{"password", "hunter2", "pwd_hash", "x", "api_key", "k", "userId", "u1"},
+			expected: "password=[REDACTED] pwd_hash=[REDACTED] api_key=[REDACTED] userId=u1\n",
+		},
+		{
+			testName: "bcrypt hash in error",
+			keyvals:  []interface{}{"err", errors.New("bad hash $2a$12$Lhdc.gbeLbQbm8uz3H1T4.EPaxqclyblPeM1N1rxhNCth1/sZkCwC")},
+			expected: "err=\"bad hash [REDACTED]\"\n",
+		},
+		{
+			testName: "credentials in message",
+			keyvals:  []interface{}{"msg", "login password=hunter2 token: abc Authorization: Bearer eyJhbGciOi"},
+			expected: "msg=\"login password=[REDACTED] token: [REDACTED] Authorization: [REDACTED]\"\n",
+		},
+		{
+			testName: "credential columns in error",
+			keyvals:  []interface{}{"err", errors.New("constraint failed: token_hash='abc' totp_secret=JBSWY3DP")},
+			expected: "err=\"constraint failed: token_hash=[REDACTED] totp_secret=[REDACTED]\"\n",
+		},
+		{
+			testName: "API key in message",
+			keyvals:  []interface{}{"msg", "rejected fpk_abcdefghijklm_Zm9v-YmFy_cXV4 for u1"},
+			expected: "msg=\"rejected [REDACTED] for u1\"\n",
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			NewRedactor(log.NewLogfmtLogger(&buf)).Log(tc.keyvals...)
+			assert.Equal(t, tc.expected, buf.String())
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, _, err := New(Config{Format: "xml"})
+	assert.Error(t, err)
+
+	_, _, err = New(Config{Level: "verbose"})
+	assert.Error(t, err)
+
+	logger, closer, err := New(Config{Format: FormatJSON, Level: "warn", Output: "stdout"})
+	assert.NoError(t, err)
+	assert.NotNil(t, logger)
+	assert.NoError(t, closer.Close())
+}
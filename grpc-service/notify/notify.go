@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifier kinds.
+const (
+	KindFile = "file"
+	KindSMTP = "smtp"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// FileNotifier appends every message to a file instead of sending it, for
+// local development and tests.
+type FileNotifier struct {
+	path string
+	from string
+	now  func() time.Time
+	mu   sync.Mutex
+}
+
+func NewFileNotifier(path, from string) *FileNotifier {
+	return &FileNotifier{path: path, from: from, now: time.Now}
+}
+
+func (n *FileNotifier) Notify(_ context.Context, msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(format(n.from, msg, n.now())); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type SMTPConfig struct {
+	// Addr is the server address in the format of host:port.
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// SMTPNotifier sends messages through an SMTP server, authenticating with
+// PLAIN when a username is configured.
+type SMTPNotifier struct {
+	cfg      SMTPConfig
+	now      func() time.Time
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewSMTPNotifier(cfg SMTPConfig) *SMTPNotifier {
+	return &SMTPNotifier{cfg: cfg, now: time.Now, sendMail: smtp.SendMail}
+}
+
+func (n *SMTPNotifier) Notify(_ context.Context, msg Message) error {
+	var auth smtp.Auth
+	if n.cfg.Username != "" {
+		host := n.cfg.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, host)
+	}
+	return n.sendMail(n.cfg.Addr, auth, n.cfg.From, []string{msg.To}, format(n.cfg.From, msg, n.now()))
+}
+
+// format renders msg as an RFC 5322 message. Header values come from the
+// service, but line breaks are stripped anyway so they can't add headers.
+func format(from string, msg Message, date time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", header(from))
+	fmt.Fprintf(&b, "To: %s\r\n", header(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", header(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+func header(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"context"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var date = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "emails.log")
+	notifier := NewFileNotifier(path, "users@example.com")
+	notifier.now = func() time.Time { return date }
+
+	msg := Message{To: "javier@example.com", Subject: "Verify\r\nBcc: x@example.com", Body: "line 1\nline 2"}
+	assert.NoError(t, notifier.Notify(context.Background(), msg))
+	assert.NoError(t, notifier.Notify(context.Background(), msg))
+
+	written, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	one := "From: users@example.com\r\n" +
+		"To: javier@example.com\r\n" +
+		"Subject: VerifyBcc: x@example.com\r\n" +
+		"Date: Fri, 02 Jan 2026 03:04:05 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"line 1\r\nline 2\r\n"
+	assert.Equal(t, one+one, string(written))
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		cfg           SMTPConfig
+		checkResponse func(t *testing.T, addr string, auth smtp.Auth, from string, to []string, msg []byte)
+	}{
+		{
+			testName: "with credentials",
+			cfg:      SMTPConfig{Addr: "smtp.example.com:587", From: "users@example.com", Username: "users", Password: "secret"},
+			checkResponse: func(t *testing.T, addr string, auth smtp.Auth, from string, to []string, msg []byte) {
+				assert.Equal(t, "smtp.example.com:587", addr)
+				assert.Equal(t, smtp.PlainAuth("", "users", "secret", "smtp.example.com"), auth)
+				assert.Equal(t, "users@example.com", from)
+				assert.Equal(t, []string{"javier@example.com"}, to)
+				assert.Contains(t, string(msg), "Subject: Verify\r\n")
+			},
+		},
+		{
+			testName: "without credentials",
+			cfg:      SMTPConfig{Addr: "localhost:25", From: "users@example.com"},
+			checkResponse: func(t *testing.T, addr string, auth smtp.Auth, from string, to []string, msg []byte) {
+				assert.Equal(t, "localhost:25", addr)
+				assert.Nil(t, auth)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			notifier := NewSMTPNotifier(tc.cfg)
+			notifier.sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+				tc.checkResponse(t, addr, auth, from, to, msg)
+				return nil
+			}
+			err := notifier.Notify(context.Background(), Message{To: "javier@example.com", Subject: "Verify", Body: "token"})
+			assert.NoError(t, err)
+		})
+	}
+}
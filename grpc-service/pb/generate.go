@@ -0,0 +1,3 @@
+package pb
+
+//go:generate protoc -I . -I ../third_party/googleapis --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative --grpc-gateway_out=. --grpc-gateway_opt=paths=source_relative user.proto
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+// APIKey is a key a user's scripts authenticate with. Only the hash of the
+// key is stored, under Id, the prefix it is looked up by.
+type APIKey struct {
+	Id      string
+	UserId  string
+	Name    string
+	KeyHash string
+	Scopes  []string
+	// ExpiresAt is zero for keys that do not expire, and LastUsedAt for keys
+	// never used.
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+}
+
+// CreateAPIKey stores key, setting its CreatedAt.
+func (repo *SQLRepo) CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "CreateAPIKey")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return APIKey{}, err
+	}
+	defer tx.Rollback()
+
+	key.CreatedAt = repo.now().UTC()
+	scopes := strings.Join(key.Scopes, " ")
+	_, span := tracing.StartDBSpan(ctx, "INSERT", insertAPIKeySQL)
+	_, err = tx.ExecContext(ctx, insertAPIKeySQL, key.Id, key.UserId, key.Name, key.KeyHash, scopes, formatTime(key.CreatedAt), timeColumn(key.ExpiresAt))
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return APIKey{}, err
+	}
+
+	changes := map[string]string{FieldAPIKey: key.Id, FieldAPIKeyScopes: scopes}
+	if !key.ExpiresAt.IsZero() {
+		changes[FieldAPIKeyExpiresAt] = formatTime(key.ExpiresAt)
+	}
+	if err := repo.writeAudit(ctx, tx, ActionCreateAPIKey, key.UserId, changes); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return APIKey{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return APIKey{}, err
+	}
+
+	return key, nil
+}
+
+// ListAPIKeys returns the user's keys, oldest first, without their hashes.
+func (repo *SQLRepo) ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "ListAPIKeys")
+
+	_, span := tracing.StartDBSpan(ctx, "SELECT", apiKeysSQL)
+	rows, err := repo.db.QueryContext(ctx, apiKeysSQL, userId)
+	if err != nil {
+		tracing.EndSpan(span, err)
+		level.Error(logger).Log("err", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key := APIKey{UserId: userId}
+		var scopes, createdAt string
+		var expiresAt, lastUsedAt sql.NullString
+		if err = rows.Scan(&key.Id, &key.Name, &scopes, &createdAt, &expiresAt, &lastUsedAt); err != nil {
+			break
+		}
+		key.Scopes = strings.Fields(scopes)
+		if key.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+			break
+		}
+		if key.ExpiresAt, err = parseTime(expiresAt); err != nil {
+			break
+		}
+		if key.LastUsedAt, err = parseTime(lastUsedAt); err != nil {
+			break
+		}
+		keys = append(keys, key)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetAPIKey returns the key with keyId and its hash, or ErrNotFound when
+// there is none or its user is deleted.
+func (repo *SQLRepo) GetAPIKey(ctx context.Context, keyId string) (APIKey, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "GetAPIKey")
+
+	key := APIKey{Id: keyId}
+	var scopes string
+	var expiresAt sql.NullString
+	_, span := tracing.StartDBSpan(ctx, "SELECT", apiKeySQL)
+	err := repo.db.QueryRowContext(ctx, apiKeySQL, keyId).Scan(&key.UserId, &key.KeyHash, &scopes, &expiresAt)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return APIKey{}, &erro.ErrNotFound{Err: errors.New(erro.ErrAPIKeyNotFound)}
+		}
+		level.Error(logger).Log("err", err.Error())
+		return APIKey{}, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	if key.ExpiresAt, err = parseTime(expiresAt); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return APIKey{}, err
+	}
+
+	return key, nil
+}
+
+// RecordAPIKeyUse sets the key's LastUsedAt to now.
+func (repo *SQLRepo) RecordAPIKeyUse(ctx context.Context, keyId string) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "RecordAPIKeyUse")
+
+	_, span := tracing.StartDBSpan(ctx, "UPDATE", useAPIKeySQL)
+	_, err := repo.db.ExecContext(ctx, useAPIKeySQL, formatTime(repo.now()), keyId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// RevokeAPIKey deletes one of the user's keys, failing with ErrNotFound when
+// the user has no key with keyId.
+func (repo *SQLRepo) RevokeAPIKey(ctx context.Context, userId, keyId string) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "RevokeAPIKey")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	_, span := tracing.StartDBSpan(ctx, "DELETE", revokeAPIKeySQL)
+	res, err := tx.ExecContext(ctx, revokeAPIKeySQL, keyId, userId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	if rowCnt == 0 {
+		level.Error(logger).Log("err", erro.ErrAPIKeyNotFound, "userId", userId, "keyId", keyId)
+		return &erro.ErrNotFound{Err: errors.New(erro.ErrAPIKeyNotFound)}
+	}
+
+	if err := repo.writeAudit(ctx, tx, ActionRevokeAPIKey, userId, map[string]string{FieldAPIKey: keyId}); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
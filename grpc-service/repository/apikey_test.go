@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+)
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u1", Name: "javier", PwdHash: "hash"}))
+
+	expiresAt := now.Add(30 * 24 * time.Hour)
+	key, err := repo.CreateAPIKey(ctx, APIKey{Id: "k1", UserId: "u1", Name: "batch", KeyHash: "hash-1", Scopes: []string{"users:read", "users:write"}, ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	assert.Equal(t, now, key.CreatedAt)
+	now = now.Add(time.Second)
+	_, err = repo.CreateAPIKey(ctx, APIKey{Id: "k2", UserId: "u1", Name: "reports", KeyHash: "hash-2", Scopes: []string{"users:read"}})
+	require.NoError(t, err)
+
+	found, err := repo.GetAPIKey(ctx, "k1")
+	require.NoError(t, err)
+	assert.Equal(t, APIKey{Id: "k1", UserId: "u1", KeyHash: "hash-1", Scopes: []string{"users:read", "users:write"}, ExpiresAt: expiresAt}, found)
+	_, err = repo.GetAPIKey(ctx, "k3")
+	assert.IsType(t, &erro.ErrNotFound{}, err)
+	assert.EqualError(t, err, erro.ErrAPIKeyNotFound)
+
+	require.NoError(t, repo.RecordAPIKeyUse(ctx, "k2"))
+	keys, err := repo.ListAPIKeys(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, []APIKey{
+		{Id: "k1", UserId: "u1", Name: "batch", Scopes: []string{"users:read", "users:write"}, CreatedAt: now.Add(-time.Second), ExpiresAt: expiresAt},
+		{Id: "k2", UserId: "u1", Name: "reports", Scopes: []string{"users:read"}, CreatedAt: now, LastUsedAt: now},
+	}, keys, "hashes are not listed")
+
+	assert.IsType(t, &erro.ErrNotFound{}, repo.RevokeAPIKey(ctx, "u2", "k1"), "only the owner can revoke a key")
+	require.NoError(t, repo.RevokeAPIKey(ctx, "u1", "k1"))
+	assert.IsType(t, &erro.ErrNotFound{}, repo.RevokeAPIKey(ctx, "u1", "k1"))
+	_, err = repo.GetAPIKey(ctx, "k1")
+	assert.IsType(t, &erro.ErrNotFound{}, err)
+
+	entries, err := repo.GetAuditLog(ctx, "u1", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, ActionRevokeAPIKey, entries[0].Action)
+	assert.Equal(t, map[string]string{FieldAPIKey: "k1"}, entries[0].Changes)
+	assert.Equal(t, ActionCreateAPIKey, entries[2].Action)
+	assert.Equal(t, map[string]string{FieldAPIKey: "k1", FieldAPIKeyScopes: "users:read users:write", FieldAPIKeyExpiresAt: formatTime(expiresAt)}, entries[2].Changes)
+
+	require.NoError(t, repo.DeleteUser(ctx, "u1"))
+	_, err = repo.GetAPIKey(ctx, "k2")
+	assert.IsType(t, &erro.ErrNotFound{}, err, "deleted users' keys don't authenticate")
+}
@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/requestid"
+	"github.com/javibauza/final-project/grpc-service/svcauth"
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+// Audited actions.
+const (
+	ActionCreateUser     = "user.create"
+	ActionUpdateUser     = "user.update"
+	ActionChangePassword = "user.password_change"
+	ActionDeleteUser     = "user.delete"
+	ActionRestoreUser    = "user.restore"
+	ActionPurgeUser      = "user.purge"
+	ActionVerifyEmail    = "user.email_verify"
+	ActionEnableTOTP     = "user.totp_enable"
+	ActionUseRecovery    = "user.recovery_code_use"
+	ActionCreateAPIKey   = "user.api_key_create"
+	ActionRevokeAPIKey   = "user.api_key_revoke"
+	ActionLinkIdentity   = "user.identity_link"
+)
+
+// Masked stands in for sensitive values in the audit log.
+const Masked = "***"
+
+// AnonymousActor is recorded when the caller did not act for an
+// authenticated user or API key.
+const AnonymousActor = "anonymous"
+
+// SystemActor is recorded for changes made by the service itself.
+const SystemActor = "system"
+
+// AuditEntry is one row of the append-only audit log. Changes maps the
+// written columns to their new values. Actor is the principal the change was
+// made for and Service the client service that made it, empty when service
+// authentication is disabled.
+type AuditEntry struct {
+	Id        int64
+	Actor     string
+	Action    string
+	Target    string
+	Changes   map[string]string
+	RequestId string
+	Service   string
+	CreatedAt time.Time
+}
+
+var createFields = []string{FieldPwdHash, FieldAge, FieldBirthDate, FieldName, FieldProfile}
+
+func auditActor(ctx context.Context) string {
+	if principal := svcauth.PrincipalFromContext(ctx); principal != "" {
+		return principal
+	}
+	return AnonymousActor
+}
+
+// userChanges returns the values of fields in user, masking the password
+// hash.
+func userChanges(user User, fields []string) map[string]string {
+	changes := make(map[string]string, len(fields))
+	for _, field := range fields {
+		switch field {
+		case FieldPwdHash:
+			changes[field] = Masked
+		case FieldAge:
+			changes[field] = strconv.FormatUint(uint64(user.Age), 10)
+		case FieldBirthDate:
+			changes[field] = formatDate(user.BirthDate)
+		case FieldName:
+			changes[field] = user.Name
+		case FieldProfile:
+			changes[field] = encodeProfile(user.Profile)
+		case FieldEmail:
+			changes[field] = user.Profile.Email
+		case FieldDisplayName:
+			changes[field] = user.Profile.DisplayName
+		case FieldLocale:
+			changes[field] = user.Profile.Locale
+		case FieldTimezone:
+			changes[field] = user.Profile.Timezone
+		case FieldMetadata:
+			metadata, _ := json.Marshal(user.Profile.Metadata)
+			changes[field] = string(metadata)
+		default:
+			if key, ok := metadataKey(field); ok {
+				changes[field] = user.Profile.Metadata[key]
+			}
+		}
+	}
+	return changes
+}
+
+// writeAudit appends an entry to the audit log within tx, so it is only
+// recorded if the change it describes is.
+func (repo *SQLRepo) writeAudit(ctx context.Context, tx *sql.Tx, action, target string, changes map[string]string) error {
+	return repo.writeAuditAs(ctx, tx, auditActor(ctx), action, target, changes)
+}
+
+func (repo *SQLRepo) writeAuditAs(ctx context.Context, tx *sql.Tx, actor, action, target string, changes map[string]string) error {
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	_, span := tracing.StartDBSpan(ctx, "INSERT", insertAuditSQL)
+	_, err = tx.ExecContext(ctx, insertAuditSQL,
+		actor,
+		action,
+		target,
+		string(encoded),
+		requestid.FromContext(ctx),
+		svcauth.ClientFromContext(ctx),
+		formatTime(repo.now()),
+	)
+	tracing.EndSpan(span, err)
+	return err
+}
+
+// GetAuditLog returns up to limit entries about target, newest first,
+// starting after the entry with id beforeId, or with the newest when
+// beforeId is 0.
+func (repo *SQLRepo) GetAuditLog(ctx context.Context, target string, beforeId int64, limit int) ([]AuditEntry, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "GetAuditLog")
+
+	if beforeId <= 0 {
+		beforeId = math.MaxInt64
+	}
+
+	_, span := tracing.StartDBSpan(ctx, "SELECT", auditLogSQL)
+	rows, err := repo.db.QueryContext(ctx, auditLogSQL, target, beforeId, limit)
+	if err != nil {
+		tracing.EndSpan(span, err)
+		level.Error(logger).Log("err", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var changes, createdAt string
+		var requestId, service sql.NullString
+		if err = rows.Scan(&entry.Id, &entry.Actor, &entry.Action, &entry.Target, &changes, &requestId, &service, &createdAt); err != nil {
+			break
+		}
+		if err = json.Unmarshal([]byte(changes), &entry.Changes); err != nil {
+			break
+		}
+		if entry.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+			break
+		}
+		entry.RequestId = requestId.String
+		entry.Service = service.String
+		entries = append(entries, entry)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// scrubAudit masks every value in the audit entries about target, keeping
+// which fields were changed but not what they were changed to.
+func scrubAudit(ctx context.Context, tx *sql.Tx, target string) error {
+	_, span := tracing.StartDBSpan(ctx, "SELECT", auditChangesSQL)
+	rows, err := tx.QueryContext(ctx, auditChangesSQL, target)
+	if err != nil {
+		tracing.EndSpan(span, err)
+		return err
+	}
+
+	var ids []int64
+	var scrubbed []string
+	for rows.Next() {
+		var id int64
+		var encoded string
+		if err = rows.Scan(&id, &encoded); err != nil {
+			break
+		}
+		var changes map[string]string
+		if err = json.Unmarshal([]byte(encoded), &changes); err != nil {
+			break
+		}
+		for field := range changes {
+			changes[field] = Masked
+		}
+		var masked []byte
+		if masked, err = json.Marshal(changes); err != nil {
+			break
+		}
+		ids = append(ids, id)
+		scrubbed = append(scrubbed, string(masked))
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	tracing.EndSpan(span, err)
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		_, span := tracing.StartDBSpan(ctx, "UPDATE", scrubAuditSQL)
+		_, err := tx.ExecContext(ctx, scrubAuditSQL, scrubbed[i], id)
+		tracing.EndSpan(span, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
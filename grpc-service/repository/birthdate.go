@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+// DateLayout is the format of the birth_date column, which sorts
+// chronologically as text.
+const DateLayout = "2006-01-02"
+
+// formatDate formats date in DateLayout, or as "" when it is unknown.
+func formatDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(DateLayout)
+}
+
+// birthDateColumn is the birth_date column value of date, NULL when it is
+// unknown.
+func birthDateColumn(date time.Time) interface{} {
+	if date.IsZero() {
+		return nil
+	}
+	return formatDate(date)
+}
+
+// parseDate reads a nullable date column; NULL is the zero time.
+func parseDate(value sql.NullString) (time.Time, error) {
+	if !value.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse(DateLayout, value.String)
+}
+
+// Age is the age on day of someone born on birthDate. Someone born on
+// February 29 turns a year older on March 1 in common years.
+func Age(birthDate, day time.Time) uint32 {
+	years := day.Year() - birthDate.Year()
+	if day.Month() < birthDate.Month() || day.Month() == birthDate.Month() && day.Day() < birthDate.Day() {
+		years--
+	}
+	if years < 0 {
+		return 0
+	}
+	return uint32(years)
+}
+
+// latestBirthDate is the last day someone at least years old on day can have
+// been born. On February 29 that is February 28 of a common year, since Age
+// makes those born on March 1 wait for March 1.
+func latestBirthDate(day time.Time, years int) time.Time {
+	date := time.Date(day.Year()-years, day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	if date.Month() != day.Month() {
+		date = date.AddDate(0, 0, -date.Day())
+	}
+	return date
+}
+
+// FindUsersByAge returns the users aged between min and max years today,
+// both inclusive, ordered by user_id. Users whose birth date is unknown are
+// never returned, whatever their stored age.
+func (repo *SQLRepo) FindUsersByAge(ctx context.Context, min, max uint32) ([]User, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "FindUsersByAge")
+
+	if min > max {
+		return nil, nil
+	}
+
+	// Born on or before the last birth date of someone min years old, and
+	// after that of someone max+1 years old.
+	now := repo.now().UTC()
+	youngest := latestBirthDate(now, int(min))
+	if youngest.Year() < 1 {
+		return nil, nil
+	}
+	query := findByAgeSQL
+	args := []interface{}{youngest.Format(DateLayout)}
+	if oldest := latestBirthDate(now, int(max)+1); oldest.Year() >= 1 {
+		query += " AND birth_date>?"
+		args = append(args, oldest.Format(DateLayout))
+	}
+	query += " ORDER BY user_id"
+
+	_, span := tracing.StartDBSpan(ctx, "SELECT", query)
+	users, err := queryUsers(ctx, repo.db, query, args...)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func queryUsers(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]User, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		var birthDate sql.NullString
+		var profile string
+		if err := rows.Scan(&user.UserId, &user.Name, &user.Age, &birthDate, &profile, &user.Version); err != nil {
+			return nil, err
+		}
+		if user.BirthDate, err = parseDate(birthDate); err != nil {
+			return nil, err
+		}
+		if user.Profile, err = decodeProfile(profile); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestAge(t *testing.T) {
+	testCases := []struct {
+		testName  string
+		birthDate time.Time
+		day       time.Time
+		age       uint32
+	}{
+		{testName: "day before the birthday", birthDate: date(1988, 7, 9), day: date(2026, 7, 8), age: 37},
+		{testName: "on the birthday", birthDate: date(1988, 7, 9), day: date(2026, 7, 9), age: 38},
+		{testName: "earlier month", birthDate: date(1988, 7, 9), day: date(2026, 6, 30), age: 37},
+		{testName: "born today", birthDate: date(2026, 7, 9), day: date(2026, 7, 9), age: 0},
+		{testName: "leap day in a common year", birthDate: date(2000, 2, 29), day: date(2026, 2, 28), age: 25},
+		{testName: "leap day on march 1", birthDate: date(2000, 2, 29), day: date(2026, 3, 1), age: 26},
+		{testName: "leap day in a leap year", birthDate: date(2000, 2, 29), day: date(2028, 2, 29), age: 28},
+		{testName: "not born yet", birthDate: date(2027, 1, 1), day: date(2026, 7, 9), age: 0},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			assert.Equal(t, tc.age, Age(tc.birthDate, tc.day))
+		})
+	}
+}
+
+func TestFindUsersByAge(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	for _, user := range []User{
+		{UserId: "baby", BirthDate: date(2026, 1, 1)},
+		{UserId: "leap", BirthDate: date(2008, 2, 29)},
+		{UserId: "tomorrow", BirthDate: date(2008, 3, 2)},
+		{UserId: "adult", BirthDate: date(1988, 7, 9)},
+		{UserId: "legacy", Age: 30},
+		{UserId: "deleted", BirthDate: date(1990, 1, 1)},
+	} {
+		user.Name, user.PwdHash = user.UserId, "hash"
+		require.NoError(t, repo.CreateUser(ctx, user))
+	}
+	require.NoError(t, repo.DeleteUser(ctx, "deleted"))
+
+	testCases := []struct {
+		testName string
+		min      uint32
+		max      uint32
+		userIds  []string
+	}{
+		{testName: "exact age", min: 18, max: 18, userIds: []string{"leap"}},
+		{testName: "one year younger", min: 17, max: 17, userIds: []string{"tomorrow"}},
+		{testName: "newborns", min: 0, max: 0, userIds: []string{"baby"}},
+		{testName: "range", min: 17, max: 40, userIds: []string{"adult", "leap", "tomorrow"}},
+		{testName: "everyone with a birth date", min: 0, max: 4294967295, userIds: []string{"adult", "baby", "leap", "tomorrow"}},
+		{testName: "nobody that old", min: 4294967295, max: 4294967295},
+		{testName: "empty range", min: 40, max: 30},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			users, err := repo.FindUsersByAge(ctx, tc.min, tc.max)
+			require.NoError(t, err)
+
+			var userIds []string
+			for _, user := range users {
+				userIds = append(userIds, user.UserId)
+				assert.GreaterOrEqual(t, Age(user.BirthDate, now), tc.min)
+				assert.LessOrEqual(t, Age(user.BirthDate, now), tc.max)
+			}
+			assert.Equal(t, tc.userIds, userIds)
+		})
+	}
+
+	user, err := repo.UpdateUser(ctx, User{UserId: "leap"}, []string{FieldBirthDate}, nil)
+	require.NoError(t, err)
+	assert.True(t, user.BirthDate.IsZero(), "an empty birth date clears it")
+	users, err := repo.FindUsersByAge(ctx, 18, 18)
+	require.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func TestFindUsersByAgeOnLeapDay(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	for _, user := range []User{
+		{UserId: "one", BirthDate: date(2027, 2, 28)},
+		{UserId: "zero", BirthDate: date(2027, 3, 1)},
+		{UserId: "two", BirthDate: date(2026, 2, 28)},
+		{UserId: "almost-two", BirthDate: date(2026, 3, 1)},
+		{UserId: "leap", BirthDate: date(2024, 2, 29)},
+	} {
+		user.Name, user.PwdHash = user.UserId, "hash"
+		require.NoError(t, repo.CreateUser(ctx, user))
+	}
+
+	testCases := []struct {
+		testName string
+		min      uint32
+		max      uint32
+		userIds  []string
+	}{
+		{testName: "born after february 28 last year", min: 0, max: 0, userIds: []string{"zero"}},
+		{testName: "born on or before february 28 last year", min: 1, max: 1, userIds: []string{"almost-two", "one"}},
+		{testName: "two years old", min: 2, max: 2, userIds: []string{"two"}},
+		{testName: "leap day birthday", min: 4, max: 4, userIds: []string{"leap"}},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			users, err := repo.FindUsersByAge(ctx, tc.min, tc.max)
+			require.NoError(t, err)
+
+			var userIds []string
+			for _, user := range users {
+				userIds = append(userIds, user.UserId)
+				assert.GreaterOrEqual(t, Age(user.BirthDate, now), tc.min)
+				assert.LessOrEqual(t, Age(user.BirthDate, now), tc.max)
+			}
+			assert.Equal(t, tc.userIds, userIds)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+// DeleteUser soft deletes a user: it disappears from GetUser and
+// Authenticate but its row is kept until PurgeUsers removes it.
+func (repo *SQLRepo) DeleteUser(ctx context.Context, userId string) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "DeleteUser")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	_, span := tracing.StartDBSpan(ctx, "UPDATE", deleteSQL)
+	res, err := tx.ExecContext(ctx, deleteSQL, formatTime(repo.now()), userId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	if rowCnt == 0 {
+		level.Error(logger).Log("err", erro.ErrUserNotFound, "userId", userId)
+		return erro.NewErrNotFound()
+	}
+
+	if err := repo.writeAudit(ctx, tx, ActionDeleteUser, userId, map[string]string{}); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// RestoreUser undoes DeleteUser for a user deleted less than gracePeriod ago.
+func (repo *SQLRepo) RestoreUser(ctx context.Context, userId string, gracePeriod time.Duration) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "RestoreUser")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	now := repo.now()
+	_, span := tracing.StartDBSpan(ctx, "UPDATE", restoreSQL)
+	res, err := tx.ExecContext(ctx, restoreSQL, formatTime(now), userId, formatTime(now.Add(-gracePeriod)))
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	if rowCnt == 0 {
+		return notRestorable(ctx, tx, logger, userId)
+	}
+
+	if err := repo.writeAudit(ctx, tx, ActionRestoreUser, userId, map[string]string{}); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// notRestorable tells why a restore matched no row: the user does not exist,
+// is not deleted, or was deleted before the grace period.
+func notRestorable(ctx context.Context, tx *sql.Tx, logger log.Logger, userId string) error {
+	var deletedAt sql.NullString
+	_, span := tracing.StartDBSpan(ctx, "SELECT", deletedAtSQL)
+	err := tx.QueryRowContext(ctx, deletedAtSQL, userId).Scan(&deletedAt)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			level.Error(logger).Log("err", erro.ErrUserNotFound, "userId", userId)
+			return erro.NewErrNotFound()
+		}
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	if !deletedAt.Valid {
+		level.Error(logger).Log("err", erro.ErrUserNotDeleted, "userId", userId)
+		return erro.NewErrFailedPrecondition(erro.ErrUserNotDeleted)
+	}
+	level.Error(logger).Log("err", erro.ErrGracePeriodExpired, "userId", userId, "deletedAt", deletedAt.String)
+	return erro.NewErrFailedPrecondition(erro.ErrGracePeriodExpired)
+}
@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+)
+
+const gracePeriod = 30 * 24 * time.Hour
+
+func newSQLiteRepo(t *testing.T, now *time.Time) (*sql.DB, Repository) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, Migrate(context.Background(), db))
+
+	repo := NewRepo(db, log.NewNopLogger())
+	repo.(*SQLRepo).now = func() time.Time { return *now }
+	return db, repo
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u1", Name: "javier", PwdHash: "hash", Age: 37}))
+	require.NoError(t, repo.DeleteUser(ctx, "u1"))
+
+	_, err := repo.GetUser(ctx, "u1")
+	assert.IsType(t, &erro.ErrNotFound{}, err, "deleted users are not found")
+	_, err = repo.Authenticate(ctx, "javier")
+	assert.IsType(t, &erro.ErrNotFound{}, err, "deleted users can't log in")
+	_, err = repo.UpdateUser(ctx, User{UserId: "u1", Age: 38}, []string{FieldAge}, nil)
+	assert.IsType(t, &erro.ErrNotFound{}, err, "deleted users can't be updated")
+	err = repo.DeleteUser(ctx, "u1")
+	assert.IsType(t, &erro.ErrNotFound{}, err, "deleted users can't be deleted again")
+	err = repo.DeleteUser(ctx, "missing")
+	assert.IsType(t, &erro.ErrNotFound{}, err)
+
+	entries, err := repo.GetAuditLog(ctx, "u1", 0, 10)
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	assert.Equal(t, ActionDeleteUser, entries[0].Action)
+}
+
+func TestRestoreUser(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		userId        string
+		deleted       bool
+		elapsed       time.Duration
+		checkResponse func(t *testing.T, repo Repository, resError error)
+	}{
+		{
+			testName: "restored within the grace period",
+			userId:   "u1",
+			deleted:  true,
+			elapsed:  gracePeriod - time.Second,
+			checkResponse: func(t *testing.T, repo Repository, resError error) {
+				require.NoError(t, resError)
+				user, err := repo.GetUser(context.Background(), "u1")
+				assert.NoError(t, err)
+				assert.Equal(t, int64(3), user.Version, "delete and restore are both changes")
+			},
+		},
+		{
+			testName: "grace period expired",
+			userId:   "u1",
+			deleted:  true,
+			elapsed:  gracePeriod + time.Second,
+			checkResponse: func(t *testing.T, repo Repository, resError error) {
+				res, ok := resError.(*erro.ErrFailedPrecondition)
+				require.True(t, ok)
+				assert.Equal(t, erro.ErrGracePeriodExpired, res.Err.Error())
+			},
+		},
+		{
+			testName: "user not deleted",
+			userId:   "u1",
+			checkResponse: func(t *testing.T, repo Repository, resError error) {
+				res, ok := resError.(*erro.ErrFailedPrecondition)
+				require.True(t, ok)
+				assert.Equal(t, erro.ErrUserNotDeleted, res.Err.Error())
+			},
+		},
+		{
+			testName: "user not found",
+			userId:   "missing",
+			checkResponse: func(t *testing.T, repo Repository, resError error) {
+				assert.IsType(t, &erro.ErrNotFound{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+			_, repo := newSQLiteRepo(t, &now)
+
+			require.NoError(t, repo.CreateUser(ctx, User{UserId: "u1", Name: "javier", PwdHash: "hash", Age: 37}))
+			if tc.deleted {
+				require.NoError(t, repo.DeleteUser(ctx, "u1"))
+			}
+			now = now.Add(tc.elapsed)
+
+			tc.checkResponse(t, repo, repo.RestoreUser(ctx, tc.userId, gracePeriod))
+		})
+	}
+}
+
+func TestPurgeUsers(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	db, repo := newSQLiteRepo(t, &now)
+
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "expired", Name: "javier", PwdHash: "hash", Age: 37}))
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "recent", Name: "ana", PwdHash: "hash", Age: 30}))
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "active", Name: "luis", PwdHash: "hash", Age: 41}))
+	require.NoError(t, repo.EnrollTOTP(ctx, "expired", "SECRET"))
+	require.NoError(t, repo.ConfirmTOTP(ctx, "expired", 100, []string{"code-1"}))
+	require.NoError(t, repo.CreateAuthChallenge(ctx, "expired", "challenge", gracePeriod*2))
+	_, err := repo.CreateAPIKey(ctx, APIKey{Id: "k1", UserId: "expired", Name: "batch", KeyHash: "hash", Scopes: []string{"users:read"}})
+	require.NoError(t, err)
+	require.NoError(t, repo.CreateAuthorizationCode(ctx, AuthorizationCode{CodeHash: "code", ClientId: "c1", UserId: "expired"}, gracePeriod*2))
+	_, err = repo.LinkIdentity(ctx, LinkedIdentity{Issuer: "https://idp.example.com", Subject: "248289761001", UserId: "expired"})
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteUser(ctx, "expired"))
+	now = now.Add(gracePeriod)
+	require.NoError(t, repo.DeleteUser(ctx, "recent"))
+	now = now.Add(time.Second)
+
+	purged, err := repo.PurgeUsers(ctx, gracePeriod)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	var count int
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE user_id='expired'").Scan(&count))
+	assert.Zero(t, count, "the expired user is hard deleted")
+	for _, table := range []string{"totp", "recovery_codes", "auth_challenges", "api_keys", "oauth_codes", "linked_identities"} {
+		require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE user_id='expired'").Scan(&count))
+		assert.Zero(t, count, "the expired user's %s are deleted", table)
+	}
+	assert.IsType(t, &erro.ErrNotFound{}, repo.RestoreUser(ctx, "expired", gracePeriod))
+	assert.NoError(t, repo.RestoreUser(ctx, "recent", gracePeriod), "users within the grace period are kept")
+	_, err = repo.GetUser(ctx, "active")
+	assert.NoError(t, err)
+
+	entries, err := repo.GetAuditLog(ctx, "expired", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 6)
+	assert.Equal(t, ActionPurgeUser, entries[0].Action)
+	assert.Equal(t, SystemActor, entries[0].Actor)
+	assert.Equal(t, ActionCreateUser, entries[5].Action)
+	assert.Equal(t, map[string]string{
+		FieldPwdHash:   Masked,
+		FieldAge:       Masked,
+		FieldBirthDate: Masked,
+		FieldName:      Masked,
+		FieldProfile:   Masked,
+	}, entries[5].Changes, "audit values of purged users are scrubbed")
+
+	entries, err = repo.GetAuditLog(ctx, "active", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, "luis", entries[0].Changes[FieldName], "other users' audit entries are kept")
+
+	purged, err = repo.PurgeUsers(ctx, gracePeriod)
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+}
+
+func TestRunPurger(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	require.NoError(t, repo.CreateUser(context.Background(), User{UserId: "u1", Name: "javier", PwdHash: "hash"}))
+	require.NoError(t, repo.DeleteUser(context.Background(), "u1"))
+	now = now.Add(gracePeriod + time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunPurger(ctx, repo, gracePeriod, time.Hour, log.NewNopLogger())
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, ok := repo.RestoreUser(context.Background(), "u1", gracePeriod).(*erro.ErrNotFound)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond, "the purger runs before waiting for the first tick")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the purger did not stop when its context was done")
+	}
+}
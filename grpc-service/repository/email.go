@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/mattn/go-sqlite3"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+// NormalizeEmail is the form of an email address stored in the email column,
+// which is unique across users.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// emailColumn is the email column value of profile, NULL when it has none.
+func emailColumn(profile Profile) interface{} {
+	if profile.Email == "" {
+		return nil
+	}
+	return NormalizeEmail(profile.Email)
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// AuthenticateByEmail looks up a user by verified email address.
+func (repo *SQLRepo) AuthenticateByEmail(ctx context.Context, email string) (User, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "AuthenticateByEmail")
+
+	var user User
+	_, span := tracing.StartDBSpan(ctx, "SELECT", authenticateByEmailSQL)
+	err := repo.db.QueryRowContext(ctx, authenticateByEmailSQL, NormalizeEmail(email)).Scan(&user.UserId, &user.PwdHash)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			level.Error(logger).Log("err", erro.ErrUserNotFound)
+			return User{}, erro.NewErrNotFound()
+		}
+		level.Error(logger).Log("err", err.Error())
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// CreateEmailVerification stores the hash of a token that verifies email for
+// the user until ttl from now, replacing the user's previous tokens.
+func (repo *SQLRepo) CreateEmailVerification(ctx context.Context, userId, email, tokenHash string, ttl time.Duration) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "CreateEmailVerification")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	_, span := tracing.StartDBSpan(ctx, "DELETE", deleteVerificationsSQL)
+	_, err = tx.ExecContext(ctx, deleteVerificationsSQL, userId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	expiresAt := formatTime(repo.now().Add(ttl))
+	_, span = tracing.StartDBSpan(ctx, "INSERT", insertVerificationSQL)
+	_, err = tx.ExecContext(ctx, insertVerificationSQL, tokenHash, userId, NormalizeEmail(email), expiresAt)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email address a token was created for as verified
+// and returns the id of its user. The token is rejected once expired or when
+// the user's email address changed since it was created.
+func (repo *SQLRepo) VerifyEmail(ctx context.Context, tokenHash string) (string, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "VerifyEmail")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var userId, email, expiresAt string
+	_, span := tracing.StartDBSpan(ctx, "SELECT", verificationSQL)
+	err = tx.QueryRowContext(ctx, verificationSQL, tokenHash).Scan(&userId, &email, &expiresAt)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			level.Error(logger).Log("err", erro.ErrInvalidVerificationToken)
+			return "", erro.NewErrInvalidArgument(erro.ErrInvalidVerificationToken)
+		}
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+
+	now := formatTime(repo.now())
+	if expiresAt <= now {
+		level.Error(logger).Log("err", erro.ErrInvalidVerificationToken, "userId", userId, "expiresAt", expiresAt)
+		return "", erro.NewErrInvalidArgument(erro.ErrInvalidVerificationToken)
+	}
+
+	_, span = tracing.StartDBSpan(ctx, "UPDATE", verifyEmailSQL)
+	res, err := tx.ExecContext(ctx, verifyEmailSQL, now, now, userId, email)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+	if rowCnt == 0 {
+		level.Error(logger).Log("err", erro.ErrInvalidVerificationToken, "userId", userId)
+		return "", erro.NewErrInvalidArgument(erro.ErrInvalidVerificationToken)
+	}
+
+	_, span = tracing.StartDBSpan(ctx, "DELETE", deleteVerificationsSQL)
+	_, err = tx.ExecContext(ctx, deleteVerificationsSQL, userId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+
+	if err := repo.writeAudit(ctx, tx, ActionVerifyEmail, userId, map[string]string{FieldEmailVerifiedAt: now}); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+
+	return userId, nil
+}
+
+// migrateEmails fills the email column from the profiles. When several users
+// share an address only the first keeps it indexed; the others have to
+// change it before they can verify it.
+func migrateEmails(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, profile FROM users ORDER BY id")
+	if err != nil {
+		return err
+	}
+
+	emails := map[int64]string{}
+	taken := map[string]bool{}
+	for rows.Next() {
+		var id int64
+		var encoded string
+		if err = rows.Scan(&id, &encoded); err != nil {
+			break
+		}
+		var profile Profile
+		if profile, err = decodeProfile(encoded); err != nil {
+			break
+		}
+		email := NormalizeEmail(profile.Email)
+		if email == "" || taken[email] {
+			continue
+		}
+		taken[email] = true
+		emails[id] = email
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for id, email := range emails {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET email=? WHERE id=?", email, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+)
+
+const verificationTTL = 24 * time.Hour
+
+func TestEmailUniqueness(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u1", Name: "javier", PwdHash: "hash", Profile: Profile{Email: "Javier@Example.com"}}))
+
+	err := repo.CreateUser(ctx, User{UserId: "u2", Name: "ana", PwdHash: "hash", Profile: Profile{Email: "javier@example.com"}})
+	assert.IsType(t, &erro.ErrAlreadyExists{}, err, "addresses are compared case-insensitively")
+	assert.EqualError(t, err, erro.ErrEmailTaken)
+
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u2", Name: "ana", PwdHash: "hash"}))
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u3", Name: "eva", PwdHash: "hash"}), "users without an address don't collide")
+
+	_, err = repo.UpdateUser(ctx, User{UserId: "u2", Profile: Profile{Email: "JAVIER@example.com"}}, []string{FieldEmail}, nil)
+	assert.IsType(t, &erro.ErrAlreadyExists{}, err)
+
+	_, err = repo.UpdateUser(ctx, User{UserId: "u1", Profile: Profile{Email: "javi@example.com"}}, []string{FieldEmail}, nil)
+	require.NoError(t, err)
+	_, err = repo.UpdateUser(ctx, User{UserId: "u2", Profile: Profile{Email: "javier@example.com"}}, []string{FieldEmail}, nil)
+	require.NoError(t, err, "a released address can be reused")
+}
+
+func TestVerifyEmail(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		tokenHash     string
+		elapsed       time.Duration
+		update        *Profile
+		checkResponse func(t *testing.T, repo Repository, userId string, resError error)
+	}{
+		{
+			testName:  "email verified",
+			tokenHash: "hash-1",
+			elapsed:   verificationTTL - time.Second,
+			checkResponse: func(t *testing.T, repo Repository, userId string, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, "u1", userId)
+
+				ctx := context.Background()
+				user, err := repo.GetUser(ctx, "u1")
+				require.NoError(t, err)
+				assert.True(t, user.EmailVerified)
+				assert.Equal(t, int64(2), user.Version)
+
+				authenticated, err := repo.AuthenticateByEmail(ctx, " JAVIER@example.com")
+				require.NoError(t, err)
+				assert.Equal(t, "u1", authenticated.UserId)
+
+				_, err = repo.VerifyEmail(ctx, "hash-1")
+				assert.IsType(t, &erro.ErrInvalidArgument{}, err, "tokens are single use")
+
+				_, err = repo.UpdateUser(ctx, User{UserId: "u1", Profile: Profile{Email: "javier@example.com", Locale: "es"}}, []string{FieldProfile}, nil)
+				require.NoError(t, err)
+				user, err = repo.GetUser(ctx, "u1")
+				require.NoError(t, err)
+				assert.True(t, user.EmailVerified, "rewriting the same address keeps it verified")
+
+				_, err = repo.UpdateUser(ctx, User{UserId: "u1", Profile: Profile{Email: "javi@example.com"}}, []string{FieldEmail}, nil)
+				require.NoError(t, err)
+				user, err = repo.GetUser(ctx, "u1")
+				require.NoError(t, err)
+				assert.False(t, user.EmailVerified, "a new address has to be verified again")
+				_, err = repo.AuthenticateByEmail(ctx, "javi@example.com")
+				assert.IsType(t, &erro.ErrNotFound{}, err)
+			},
+		},
+		{
+			testName:  "unknown token",
+			tokenHash: "other",
+			checkResponse: func(t *testing.T, repo Repository, userId string, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+				assert.EqualError(t, resError, erro.ErrInvalidVerificationToken)
+			},
+		},
+		{
+			testName:  "token expired",
+			tokenHash: "hash-1",
+			elapsed:   verificationTTL,
+			checkResponse: func(t *testing.T, repo Repository, userId string, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+			},
+		},
+		{
+			testName:  "email changed after the token was sent",
+			tokenHash: "hash-1",
+			update:    &Profile{Email: "javi@example.com"},
+			checkResponse: func(t *testing.T, repo Repository, userId string, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+			_, repo := newSQLiteRepo(t, &now)
+
+			require.NoError(t, repo.CreateUser(ctx, User{UserId: "u1", Name: "javier", PwdHash: "hash", Profile: Profile{Email: "javier@example.com"}}))
+			_, err := repo.AuthenticateByEmail(ctx, "javier@example.com")
+			assert.IsType(t, &erro.ErrNotFound{}, err, "unverified addresses can't be used to log in")
+
+			require.NoError(t, repo.CreateEmailVerification(ctx, "u1", "javier@example.com", "hash-0", verificationTTL))
+			require.NoError(t, repo.CreateEmailVerification(ctx, "u1", "javier@example.com", "hash-1", verificationTTL))
+			_, err = repo.VerifyEmail(ctx, "hash-0")
+			assert.IsType(t, &erro.ErrInvalidArgument{}, err, "a new token replaces the previous ones")
+
+			if tc.update != nil {
+				_, err = repo.UpdateUser(ctx, User{UserId: "u1", Profile: *tc.update}, []string{FieldEmail}, nil)
+				require.NoError(t, err)
+			}
+			now = now.Add(tc.elapsed)
+
+			userId, err := repo.VerifyEmail(ctx, tc.tokenHash)
+			tc.checkResponse(t, repo, userId, err)
+		})
+	}
+}
+
+func TestMigrateEmails(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Stop right before the email column is added.
+	_, err = db.ExecContext(ctx, createMigrationsSQL)
+	require.NoError(t, err)
+	for i, m := range migrations[:16] {
+		require.NoError(t, migrate(ctx, db, i+1, m))
+	}
+	for _, stmt := range []string{
+		`INSERT INTO users (user_id, name, pwd_hash, age, profile) VALUES ('first', 'javier', 'hash', 37, '{"email":"Javier@example.com"}')`,
+		`INSERT INTO users (user_id, name, pwd_hash, age, profile) VALUES ('second', 'javi', 'hash', 37, '{"email":"javier@example.com"}')`,
+		`INSERT INTO users (user_id, name, pwd_hash, age, profile) VALUES ('none', 'ana', 'hash', 30, '{}')`,
+	} {
+		_, err := db.ExecContext(ctx, stmt)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, Migrate(ctx, db))
+
+	emails := map[string]sql.NullString{}
+	rows, err := db.QueryContext(ctx, "SELECT user_id, email FROM users")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var userId string
+		var email sql.NullString
+		require.NoError(t, rows.Scan(&userId, &email))
+		emails[userId] = email
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, map[string]sql.NullString{
+		"first":  {String: "javier@example.com", Valid: true},
+		"second": {},
+		"none":   {},
+	}, emails)
+
+	repo := NewRepo(db, log.NewNopLogger())
+	second, err := repo.GetUser(ctx, "second")
+	require.NoError(t, err)
+	assert.Equal(t, "javier@example.com", second.Profile.Email, "duplicate addresses stay in the profile")
+}
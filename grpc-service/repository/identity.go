@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+// LinkedIdentity is an account of an external OpenID Connect provider,
+// identified by the provider's Issuer and the account's Subject, that logs
+// in as UserId.
+type LinkedIdentity struct {
+	Issuer    string
+	Subject   string
+	UserId    string
+	CreatedAt time.Time
+}
+
+// LinkIdentity links identity to its user, setting its CreatedAt. It fails
+// with ErrNotFound when the user does not exist and with ErrAlreadyExists
+// when the identity is already linked, to that or another user.
+func (repo *SQLRepo) LinkIdentity(ctx context.Context, identity LinkedIdentity) (LinkedIdentity, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "LinkIdentity")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return LinkedIdentity{}, err
+	}
+	defer tx.Rollback()
+
+	identity, err = repo.linkIdentity(ctx, tx, identity)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error(), "userId", identity.UserId)
+		return LinkedIdentity{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return LinkedIdentity{}, err
+	}
+
+	return identity, nil
+}
+
+// ProvisionIdentity links an identity that is not linked yet to a user, in
+// one transaction. When user has an email address the identity is linked to
+// the user who verified that address; an address that is unverified, held by
+// a deleted user or by a user with two-factor authentication enabled fails
+// with ErrAlreadyExists rather than being taken over. Otherwise user is created and the identity linked to it. The
+// UserId of the returned identity tells which happened.
+func (repo *SQLRepo) ProvisionIdentity(ctx context.Context, user User, identity LinkedIdentity) (LinkedIdentity, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "ProvisionIdentity")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return LinkedIdentity{}, err
+	}
+	defer tx.Rollback()
+
+	identity.UserId = user.UserId
+	if user.Profile.Email != "" {
+		var ownerId string
+		var linkable bool
+		_, span := tracing.StartDBSpan(ctx, "SELECT", emailOwnerSQL)
+		err = tx.QueryRowContext(ctx, emailOwnerSQL, NormalizeEmail(user.Profile.Email)).Scan(&ownerId, &linkable)
+		tracing.EndSpan(span, err)
+		switch {
+		case err == nil && !linkable:
+			level.Error(logger).Log("err", erro.ErrEmailNotLinkable, "userId", ownerId)
+			return LinkedIdentity{}, erro.NewErrAlreadyExists(erro.ErrEmailNotLinkable)
+		case err == nil:
+			identity.UserId = ownerId
+		case err != sql.ErrNoRows:
+			level.Error(logger).Log("err", err.Error())
+			return LinkedIdentity{}, err
+		}
+	}
+	if identity.UserId == user.UserId {
+		if err := repo.createUser(ctx, tx, user); err != nil {
+			level.Error(logger).Log("err", err.Error())
+			return LinkedIdentity{}, err
+		}
+	}
+
+	identity, err = repo.linkIdentity(ctx, tx, identity)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error(), "userId", identity.UserId)
+		return LinkedIdentity{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return LinkedIdentity{}, err
+	}
+
+	return identity, nil
+}
+
+// linkIdentity inserts and audits identity in tx, setting its CreatedAt.
+func (repo *SQLRepo) linkIdentity(ctx context.Context, tx *sql.Tx, identity LinkedIdentity) (LinkedIdentity, error) {
+	identity.CreatedAt = repo.now().UTC()
+	_, span := tracing.StartDBSpan(ctx, "INSERT", insertLinkedIdentitySQL)
+	res, err := tx.ExecContext(ctx, insertLinkedIdentitySQL, identity.Issuer, identity.Subject, formatTime(identity.CreatedAt), identity.UserId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return LinkedIdentity{}, erro.NewErrAlreadyExists(erro.ErrIdentityLinked)
+		}
+		return LinkedIdentity{}, err
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		return LinkedIdentity{}, err
+	}
+	if rowCnt == 0 {
+		return LinkedIdentity{}, erro.NewErrNotFound()
+	}
+
+	changes := map[string]string{FieldIdentityIssuer: identity.Issuer, FieldIdentitySubject: identity.Subject}
+	if err := repo.writeAudit(ctx, tx, ActionLinkIdentity, identity.UserId, changes); err != nil {
+		return LinkedIdentity{}, err
+	}
+
+	return identity, nil
+}
+
+// GetLinkedIdentity returns the identity of issuer and subject, or
+// ErrNotFound when it is not linked. The user it is linked to may have been
+// deleted since.
+func (repo *SQLRepo) GetLinkedIdentity(ctx context.Context, issuer, subject string) (LinkedIdentity, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "GetLinkedIdentity")
+
+	identity := LinkedIdentity{Issuer: issuer, Subject: subject}
+	var createdAt string
+	_, span := tracing.StartDBSpan(ctx, "SELECT", linkedIdentitySQL)
+	err := repo.db.QueryRowContext(ctx, linkedIdentitySQL, issuer, subject).Scan(&identity.UserId, &createdAt)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return LinkedIdentity{}, &erro.ErrNotFound{Err: errors.New(erro.ErrIdentityNotLinked)}
+		}
+		level.Error(logger).Log("err", err.Error())
+		return LinkedIdentity{}, err
+	}
+	if identity.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return LinkedIdentity{}, err
+	}
+
+	return identity, nil
+}
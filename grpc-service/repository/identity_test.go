@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+)
+
+func TestLinkedIdentities(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u1", Name: "javier", PwdHash: "hash"}))
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u2", Name: "ana", PwdHash: "hash"}))
+
+	identity, err := repo.LinkIdentity(ctx, LinkedIdentity{Issuer: "https://idp.example.com", Subject: "s1", UserId: "u1"})
+	require.NoError(t, err)
+	assert.Equal(t, now, identity.CreatedAt)
+	_, err = repo.LinkIdentity(ctx, LinkedIdentity{Issuer: "https://other.example.com", Subject: "s1", UserId: "u2"})
+	require.NoError(t, err, "subjects are scoped to their issuer")
+
+	_, err = repo.LinkIdentity(ctx, LinkedIdentity{Issuer: "https://idp.example.com", Subject: "s1", UserId: "u2"})
+	assert.IsType(t, &erro.ErrAlreadyExists{}, err)
+	assert.EqualError(t, err, erro.ErrIdentityLinked)
+	_, err = repo.LinkIdentity(ctx, LinkedIdentity{Issuer: "https://idp.example.com", Subject: "s2", UserId: "u3"})
+	assert.IsType(t, &erro.ErrNotFound{}, err)
+
+	found, err := repo.GetLinkedIdentity(ctx, "https://idp.example.com", "s1")
+	require.NoError(t, err)
+	assert.Equal(t, identity, found)
+	_, err = repo.GetLinkedIdentity(ctx, "https://idp.example.com", "s2")
+	assert.IsType(t, &erro.ErrNotFound{}, err)
+	assert.EqualError(t, err, erro.ErrIdentityNotLinked)
+
+	entries, err := repo.GetAuditLog(ctx, "u1", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, ActionLinkIdentity, entries[0].Action)
+	assert.Equal(t, map[string]string{FieldIdentityIssuer: "https://idp.example.com", FieldIdentitySubject: "s1"}, entries[0].Changes)
+
+	require.NoError(t, repo.DeleteUser(ctx, "u2"))
+	_, err = repo.LinkIdentity(ctx, LinkedIdentity{Issuer: "https://idp.example.com", Subject: "s3", UserId: "u2"})
+	assert.IsType(t, &erro.ErrNotFound{}, err, "identities can't be linked to deleted users")
+}
+
+func TestProvisionIdentity(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u1", Name: "javier", PwdHash: "hash", Profile: Profile{Email: "javier@example.com"}}))
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u2", Name: "ana", PwdHash: "hash", Profile: Profile{Email: "ana@example.com"}}))
+	require.NoError(t, repo.CreateEmailVerification(ctx, "u1", "javier@example.com", "hash-1", time.Hour))
+	_, err := repo.VerifyEmail(ctx, "hash-1")
+	require.NoError(t, err)
+
+	identity, err := repo.ProvisionIdentity(ctx,
+		User{UserId: "u3", Name: "federated:new", PwdHash: "hash", Profile: Profile{Email: "Javier@example.com"}},
+		LinkedIdentity{Issuer: "https://idp.example.com", Subject: "s1"})
+	require.NoError(t, err)
+	assert.Equal(t, "u1", identity.UserId, "verified addresses link the existing user")
+	_, err = repo.GetUser(ctx, "u3")
+	assert.IsType(t, &erro.ErrNotFound{}, err)
+
+	_, err = repo.ProvisionIdentity(ctx,
+		User{UserId: "u3", Name: "federated:new", PwdHash: "hash", Profile: Profile{Email: "ana@example.com"}},
+		LinkedIdentity{Issuer: "https://idp.example.com", Subject: "s2"})
+	assert.IsType(t, &erro.ErrAlreadyExists{}, err)
+	assert.EqualError(t, err, erro.ErrEmailNotLinkable, "unverified addresses can't be taken over")
+
+	identity, err = repo.ProvisionIdentity(ctx,
+		User{UserId: "u3", Name: "federated:new", PwdHash: "hash", Profile: Profile{DisplayName: "New"}},
+		LinkedIdentity{Issuer: "https://idp.example.com", Subject: "s3"})
+	require.NoError(t, err)
+	assert.Equal(t, LinkedIdentity{Issuer: "https://idp.example.com", Subject: "s3", UserId: "u3", CreatedAt: now}, identity)
+	user, err := repo.GetUser(ctx, "u3")
+	require.NoError(t, err)
+	assert.Equal(t, "federated:new", user.Name)
+	entries, err := repo.GetAuditLog(ctx, "u3", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, ActionLinkIdentity, entries[0].Action)
+	assert.Equal(t, ActionCreateUser, entries[1].Action)
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u7", Name: "federated:new", PwdHash: "other", Profile: Profile{DisplayName: "New"}}))
+	created, err := repo.GetAuditLog(ctx, "u7", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, created[0].Changes, entries[1].Changes, "the user is audited as CreateUser audits it")
+
+	_, err = repo.ProvisionIdentity(ctx,
+		User{UserId: "u4", Name: "federated:other", PwdHash: "hash"},
+		LinkedIdentity{Issuer: "https://idp.example.com", Subject: "s3"})
+	assert.IsType(t, &erro.ErrAlreadyExists{}, err)
+	assert.EqualError(t, err, erro.ErrIdentityLinked)
+	_, err = repo.GetUser(ctx, "u4")
+	assert.IsType(t, &erro.ErrNotFound{}, err, "the user is not created without its identity")
+	_, err = repo.GetLinkedIdentity(ctx, "https://idp.example.com", "s2")
+	assert.IsType(t, &erro.ErrNotFound{}, err)
+
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u5", Name: "maria", PwdHash: "hash", Profile: Profile{Email: "maria@example.com"}}))
+	require.NoError(t, repo.CreateEmailVerification(ctx, "u5", "maria@example.com", "hash-5", time.Hour))
+	_, err = repo.VerifyEmail(ctx, "hash-5")
+	require.NoError(t, err)
+	require.NoError(t, repo.EnrollTOTP(ctx, "u5", "secret"))
+	require.NoError(t, repo.ConfirmTOTP(ctx, "u5", 1, nil))
+	_, err = repo.ProvisionIdentity(ctx,
+		User{UserId: "u6", Name: "federated:maria", PwdHash: "hash", Profile: Profile{Email: "maria@example.com"}},
+		LinkedIdentity{Issuer: "https://idp.example.com", Subject: "s5"})
+	assert.IsType(t, &erro.ErrAlreadyExists{}, err)
+	assert.EqualError(t, err, erro.ErrEmailNotLinkable, "users with two-factor authentication aren't linked by their address")
+	_, err = repo.GetLinkedIdentity(ctx, "https://idp.example.com", "s5")
+	assert.IsType(t, &erro.ErrNotFound{}, err)
+}
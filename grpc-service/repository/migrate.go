@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const createMigrationsSQL = "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"
+const currentMigrationSQL = "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"
+const recordMigrationSQL = "INSERT INTO schema_migrations (version) VALUES (?)"
+
+// A migration is a single SQL statement or, for data migrations SQL can't
+// express, a function run in the migration's transaction.
+type migration struct {
+	stmt string
+	run  func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations are applied in order and each runs exactly once. Append new
+// statements at the end; never edit or reorder the existing ones.
+var migrations = []migration{
+	// 1: the original schema, a no-op on databases created before migrations.
+	{stmt: "CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY, pwd_hash TEXT NOT NULL, name TEXT NOT NULL, age INTEGER NOT NULL, additional_information TEXT, user_id TEXT)"},
+	// 2: optimistic concurrency.
+	{stmt: "ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1"},
+	// 3-6: the append-only audit log.
+	{stmt: "CREATE TABLE audit_log (id INTEGER PRIMARY KEY AUTOINCREMENT, actor TEXT NOT NULL, action TEXT NOT NULL, target TEXT NOT NULL, changes TEXT NOT NULL, request_id TEXT, created_at TEXT NOT NULL)"},
+	{stmt: "CREATE INDEX audit_log_target ON audit_log (target, id)"},
+	{stmt: "CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END"},
+	{stmt: "CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END"},
+	// 7-9: account timestamps, NULL where unknown.
+	{stmt: "ALTER TABLE users ADD COLUMN created_at TEXT"},
+	{stmt: "ALTER TABLE users ADD COLUMN updated_at TEXT"},
+	{stmt: "ALTER TABLE users ADD COLUMN last_login_at TEXT"},
+	// 10-11: soft delete.
+	{stmt: "ALTER TABLE users ADD COLUMN deleted_at TEXT"},
+	{stmt: "CREATE INDEX users_deleted_at ON users (deleted_at)"},
+	// 12-13: let the purger scrub the changes of purged users' audit entries.
+	{stmt: "DROP TRIGGER audit_log_no_update"},
+	{stmt: "CREATE TRIGGER audit_log_no_update BEFORE UPDATE OF id, actor, action, target, request_id, created_at ON audit_log BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END"},
+	// 14-16: a structured profile replaces additional_information.
+	{stmt: "ALTER TABLE users ADD COLUMN profile TEXT NOT NULL DEFAULT '{}'"},
+	{run: migrateAddInfo},
+	{stmt: "ALTER TABLE users DROP COLUMN additional_information"},
+	// 17-22: unique, verifiable email addresses.
+	{stmt: "ALTER TABLE users ADD COLUMN email TEXT"},
+	{stmt: "ALTER TABLE users ADD COLUMN email_verified_at TEXT"},
+	{run: migrateEmails},
+	{stmt: "CREATE UNIQUE INDEX users_email ON users (email)"},
+	{stmt: "CREATE TABLE email_verifications (token_hash TEXT PRIMARY KEY, user_id TEXT NOT NULL, email TEXT NOT NULL, expires_at TEXT NOT NULL)"},
+	{stmt: "CREATE INDEX email_verifications_user_id ON email_verifications (user_id)"},
+	// 23-24: birth dates, NULL where unknown, replace the stored age.
+	{stmt: "ALTER TABLE users ADD COLUMN birth_date TEXT"},
+	{stmt: "CREATE INDEX users_birth_date ON users (birth_date)"},
+	// 25-29: TOTP two-factor authentication, its recovery codes and the
+	// challenges of logins waiting for a code.
+	{stmt: "CREATE TABLE totp (user_id TEXT PRIMARY KEY, secret TEXT NOT NULL, confirmed_at TEXT, last_step INTEGER NOT NULL DEFAULT 0)"},
+	{stmt: "CREATE TABLE recovery_codes (code_hash TEXT PRIMARY KEY, user_id TEXT NOT NULL, used_at TEXT)"},
+	{stmt: "CREATE INDEX recovery_codes_user_id ON recovery_codes (user_id)"},
+	{stmt: "CREATE TABLE auth_challenges (token_hash TEXT PRIMARY KEY, user_id TEXT NOT NULL, attempts INTEGER NOT NULL DEFAULT 0, expires_at TEXT NOT NULL)"},
+	{stmt: "CREATE INDEX auth_challenges_user_id ON auth_challenges (user_id)"},
+	// 30-31: API keys, stored hashed under their lookup prefix.
+	{stmt: "CREATE TABLE api_keys (key_id TEXT PRIMARY KEY, user_id TEXT NOT NULL, name TEXT NOT NULL, key_hash TEXT NOT NULL, scopes TEXT NOT NULL, created_at TEXT NOT NULL, expires_at TEXT, last_used_at TEXT)"},
+	{stmt: "CREATE INDEX api_keys_user_id ON api_keys (user_id)"},
+	// 32-34: OAuth clients of the OpenID Connect provider and the single use
+	// authorization codes issued to them.
+	{stmt: "CREATE TABLE oauth_clients (client_id TEXT PRIMARY KEY, name TEXT NOT NULL, secret_hash TEXT, redirect_uris TEXT NOT NULL, created_at TEXT NOT NULL)"},
+	{stmt: "CREATE TABLE oauth_codes (code_hash TEXT PRIMARY KEY, client_id TEXT NOT NULL, user_id TEXT NOT NULL, redirect_uri TEXT NOT NULL, scope TEXT NOT NULL, nonce TEXT NOT NULL, code_challenge TEXT NOT NULL, expires_at TEXT NOT NULL)"},
+	{stmt: "CREATE INDEX oauth_codes_user_id ON oauth_codes (user_id)"},
+	// 35-37: accounts of external OpenID Connect providers linked to users.
+	{stmt: "CREATE TABLE linked_identities (issuer TEXT NOT NULL, subject TEXT NOT NULL, user_id TEXT NOT NULL, created_at TEXT NOT NULL)"},
+	{stmt: "CREATE UNIQUE INDEX linked_identities_issuer_subject ON linked_identities (issuer, subject)"},
+	{stmt: "CREATE INDEX linked_identities_user_id ON linked_identities (user_id)"},
+	// 38-39: wrong two-factor codes, counted per user across challenges.
+	{stmt: "ALTER TABLE totp ADD COLUMN failed_attempts INTEGER NOT NULL DEFAULT 0"},
+	{stmt: "ALTER TABLE totp ADD COLUMN locked_until TEXT"},
+	// 40-42: the client service of audited calls, now that the actor is the
+	// user or API key it called for. NULL for earlier entries, whose actor is
+	// the service.
+	{stmt: "ALTER TABLE audit_log ADD COLUMN service TEXT"},
+	{stmt: "DROP TRIGGER audit_log_no_update"},
+	{stmt: "CREATE TRIGGER audit_log_no_update BEFORE UPDATE OF id, actor, action, target, request_id, service, created_at ON audit_log BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END"},
+}
+
+// Migrate brings the database schema up to date.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, createMigrationsSQL); err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRowContext(ctx, currentMigrationSQL).Scan(&current); err != nil {
+		return err
+	}
+
+	for i := current; i < len(migrations); i++ {
+		if err := migrate(ctx, db, i+1, migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func migrate(ctx context.Context, db *sql.DB, version int, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if m.run != nil {
+		err = m.run(ctx, tx)
+	} else {
+		_, err = tx.ExecContext(ctx, m.stmt)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, recordMigrationSQL, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	testCases := []struct {
+		testName string
+		setup    []string
+	}{
+		{
+			testName: "empty database",
+		},
+		{
+			testName: "database created before migrations",
+			setup: []string{
+				"CREATE TABLE users(id INTEGER PRIMARY KEY, pwd_hash TEXT NOT NULL, name TEXT NOT NULL, age INTEGER NOT NULL, additional_information TEXT, user_id TEXT)",
+				"INSERT INTO users (user_id, name, pwd_hash, age, additional_information) VALUES ('existing', 'javier', 'hash', 37, 'likes \"go\"')",
+				"INSERT INTO users (user_id, name, pwd_hash, age, additional_information) VALUES ('no-info', 'ana', 'hash', 30, '')",
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			ctx := context.Background()
+			db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+			require.NoError(t, err)
+			defer db.Close()
+
+			for _, stmt := range tc.setup {
+				_, err := db.ExecContext(ctx, stmt)
+				require.NoError(t, err)
+			}
+
+			require.NoError(t, Migrate(ctx, db))
+			require.NoError(t, Migrate(ctx, db), "migrations must be idempotent")
+
+			var current int
+			require.NoError(t, db.QueryRowContext(ctx, currentMigrationSQL).Scan(&current))
+			assert.Equal(t, len(migrations), current)
+
+			now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+			repo := NewRepo(db, log.NewNopLogger())
+			repo.(*SQLRepo).now = func() time.Time { return now }
+			require.NoError(t, repo.CreateUser(ctx, User{UserId: "new", Name: "ana", PwdHash: "hash", Age: 30}))
+			require.NoError(t, repo.RecordLogin(ctx, "new"))
+
+			created, err := repo.GetUser(ctx, "new")
+			require.NoError(t, err)
+			assert.Equal(t, now, created.CreatedAt)
+			assert.Equal(t, now, created.UpdatedAt)
+			assert.Equal(t, now, created.LastLoginAt)
+
+			if len(tc.setup) > 0 {
+				existing, err := repo.GetUser(ctx, "existing")
+				require.NoError(t, err)
+				assert.True(t, existing.CreatedAt.IsZero(), "timestamps of existing users are unknown")
+				assert.Equal(t, Profile{Metadata: map[string]string{AddInfoKey: `likes "go"`}}, existing.Profile, "add_info is migrated into the profile")
+
+				noInfo, err := repo.GetUser(ctx, "no-info")
+				require.NoError(t, err)
+				assert.Equal(t, Profile{}, noInfo.Profile)
+			}
+
+			rows, err := db.QueryContext(ctx, "SELECT version FROM users")
+			require.NoError(t, err)
+			defer rows.Close()
+			for rows.Next() {
+				var version int64
+				require.NoError(t, rows.Scan(&version))
+				assert.Equal(t, int64(1), version)
+			}
+			require.NoError(t, rows.Err())
+
+			_, err = db.ExecContext(ctx, "UPDATE audit_log SET actor='someone else'")
+			assert.Error(t, err, "audit_log must be append-only")
+			_, err = db.ExecContext(ctx, "UPDATE audit_log SET changes='{}'")
+			assert.NoError(t, err, "changes can be scrubbed")
+			_, err = db.ExecContext(ctx, "DELETE FROM audit_log")
+			assert.Error(t, err, "audit_log must be append-only")
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+// OAuthClient is an application registered to delegate its users' login to
+// the OpenID Connect provider. SecretHash is empty for public clients, which
+// authenticate with PKCE alone.
+type OAuthClient struct {
+	Id           string
+	Name         string
+	SecretHash   string
+	RedirectURIs []string
+	CreatedAt    time.Time
+}
+
+// AuthorizationCode is a single use code a client exchanges for tokens. Only
+// its hash is stored.
+type AuthorizationCode struct {
+	CodeHash      string
+	ClientId      string
+	UserId        string
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+// CreateOAuthClient stores client, setting its CreatedAt.
+func (repo *SQLRepo) CreateOAuthClient(ctx context.Context, client OAuthClient) (OAuthClient, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "CreateOAuthClient")
+
+	client.CreatedAt = repo.now().UTC()
+	var secretHash sql.NullString
+	if client.SecretHash != "" {
+		secretHash = sql.NullString{String: client.SecretHash, Valid: true}
+	}
+	_, span := tracing.StartDBSpan(ctx, "INSERT", insertOAuthClientSQL)
+	_, err := repo.db.ExecContext(ctx, insertOAuthClientSQL, client.Id, client.Name, secretHash, strings.Join(client.RedirectURIs, " "), formatTime(client.CreatedAt))
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return OAuthClient{}, err
+	}
+
+	return client, nil
+}
+
+// GetOAuthClient returns the client with clientId, or ErrNotFound when there
+// is none.
+func (repo *SQLRepo) GetOAuthClient(ctx context.Context, clientId string) (OAuthClient, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "GetOAuthClient")
+
+	client := OAuthClient{Id: clientId}
+	var secretHash sql.NullString
+	var redirectURIs, createdAt string
+	_, span := tracing.StartDBSpan(ctx, "SELECT", oauthClientSQL)
+	err := repo.db.QueryRowContext(ctx, oauthClientSQL, clientId).Scan(&client.Name, &secretHash, &redirectURIs, &createdAt)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return OAuthClient{}, &erro.ErrNotFound{Err: errors.New(erro.ErrOAuthClientNotFound)}
+		}
+		level.Error(logger).Log("err", err.Error())
+		return OAuthClient{}, err
+	}
+	client.SecretHash = secretHash.String
+	client.RedirectURIs = strings.Fields(redirectURIs)
+	if client.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return OAuthClient{}, err
+	}
+
+	return client, nil
+}
+
+// CreateAuthorizationCode stores code until ttl from now, dropping the codes
+// that expired.
+func (repo *SQLRepo) CreateAuthorizationCode(ctx context.Context, code AuthorizationCode, ttl time.Duration) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "CreateAuthorizationCode")
+
+	now := repo.now()
+	_, span := tracing.StartDBSpan(ctx, "DELETE", deleteExpiredOAuthCodesSQL)
+	_, err := repo.db.ExecContext(ctx, deleteExpiredOAuthCodesSQL, formatTime(now))
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	_, span = tracing.StartDBSpan(ctx, "INSERT", insertOAuthCodeSQL)
+	_, err = repo.db.ExecContext(ctx, insertOAuthCodeSQL, code.CodeHash, code.ClientId, code.UserId, code.RedirectURI,
+		code.Scope, code.Nonce, code.CodeChallenge, formatTime(now.Add(ttl)))
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeAuthorizationCode deletes the code with codeHash and returns it, so
+// that it can be exchanged once. It fails with ErrNotFound when there is no
+// such code; expired codes are returned and left to the caller to reject.
+func (repo *SQLRepo) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (AuthorizationCode, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "ConsumeAuthorizationCode")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthorizationCode{}, err
+	}
+	defer tx.Rollback()
+
+	code := AuthorizationCode{CodeHash: codeHash}
+	var expiresAt string
+	_, span := tracing.StartDBSpan(ctx, "SELECT", oauthCodeSQL)
+	err = tx.QueryRowContext(ctx, oauthCodeSQL, codeHash).Scan(&code.ClientId, &code.UserId, &code.RedirectURI,
+		&code.Scope, &code.Nonce, &code.CodeChallenge, &expiresAt)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return AuthorizationCode{}, &erro.ErrNotFound{Err: errors.New(erro.ErrInvalidAuthorizationCode)}
+		}
+		level.Error(logger).Log("err", err.Error())
+		return AuthorizationCode{}, err
+	}
+	if code.ExpiresAt, err = time.Parse(time.RFC3339Nano, expiresAt); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthorizationCode{}, err
+	}
+
+	_, span = tracing.StartDBSpan(ctx, "DELETE", deleteOAuthCodeSQL)
+	_, err = tx.ExecContext(ctx, deleteOAuthCodeSQL, codeHash)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthorizationCode{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthorizationCode{}, err
+	}
+
+	return code, nil
+}
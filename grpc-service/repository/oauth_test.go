@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+)
+
+func TestOAuthClients(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	public, err := repo.CreateOAuthClient(ctx, OAuthClient{Id: "c1", Name: "wiki", RedirectURIs: []string{"https://wiki.example.com/cb", "http://localhost:3000/cb"}})
+	require.NoError(t, err)
+	assert.Equal(t, now, public.CreatedAt)
+	_, err = repo.CreateOAuthClient(ctx, OAuthClient{Id: "c2", Name: "crm", SecretHash: "hash", RedirectURIs: []string{"https://crm.example.com/cb"}})
+	require.NoError(t, err)
+
+	found, err := repo.GetOAuthClient(ctx, "c1")
+	require.NoError(t, err)
+	assert.Equal(t, public, found)
+	found, err = repo.GetOAuthClient(ctx, "c2")
+	require.NoError(t, err)
+	assert.Equal(t, "hash", found.SecretHash)
+
+	_, err = repo.GetOAuthClient(ctx, "c3")
+	assert.IsType(t, &erro.ErrNotFound{}, err)
+	assert.EqualError(t, err, erro.ErrOAuthClientNotFound)
+}
+
+func TestAuthorizationCodes(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	code := AuthorizationCode{CodeHash: "h1", ClientId: "c1", UserId: "u1", RedirectURI: "https://wiki.example.com/cb", Scope: "openid email", Nonce: "n", CodeChallenge: "challenge"}
+	require.NoError(t, repo.CreateAuthorizationCode(ctx, code, time.Minute))
+	require.NoError(t, repo.CreateAuthorizationCode(ctx, AuthorizationCode{CodeHash: "h2", ClientId: "c1", UserId: "u1"}, time.Minute))
+
+	consumed, err := repo.ConsumeAuthorizationCode(ctx, "h1")
+	require.NoError(t, err)
+	code.ExpiresAt = now.Add(time.Minute)
+	assert.Equal(t, code, consumed)
+
+	_, err = repo.ConsumeAuthorizationCode(ctx, "h1")
+	assert.IsType(t, &erro.ErrNotFound{}, err, "codes are single use")
+	assert.EqualError(t, err, erro.ErrInvalidAuthorizationCode)
+
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, repo.CreateAuthorizationCode(ctx, AuthorizationCode{CodeHash: "h3", ClientId: "c1", UserId: "u1"}, time.Minute))
+	_, err = repo.ConsumeAuthorizationCode(ctx, "h2")
+	assert.IsType(t, &erro.ErrNotFound{}, err, "expired codes are dropped")
+}
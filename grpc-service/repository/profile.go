@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+)
+
+// Profile is the user's structured profile, stored as a JSON object in the
+// profile column.
+type Profile struct {
+	Email       string            `json:"email,omitempty"`
+	DisplayName string            `json:"display_name,omitempty"`
+	Locale      string            `json:"locale,omitempty"`
+	Timezone    string            `json:"timezone,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// Profile fields UpdateUser can write. FieldProfile replaces the whole
+// profile and the others a single member of it. FieldMetadata followed by
+// "." and a key sets that key, or removes it when it is not in Metadata.
+const (
+	FieldProfile     = "profile"
+	FieldEmail       = "profile.email"
+	FieldDisplayName = "profile.display_name"
+	FieldLocale      = "profile.locale"
+	FieldTimezone    = "profile.timezone"
+	FieldMetadata    = "profile.metadata"
+)
+
+// AddInfoKey is the metadata key the former additional_information column
+// was migrated to.
+const AddInfoKey = "add_info"
+
+func isProfileField(field string) bool {
+	return field == FieldProfile || strings.HasPrefix(field, FieldProfile+".")
+}
+
+// metadataKey returns the key of a FieldMetadata.<key> field.
+func metadataKey(field string) (string, bool) {
+	if !strings.HasPrefix(field, FieldMetadata+".") {
+		return "", false
+	}
+	return strings.TrimPrefix(field, FieldMetadata+"."), true
+}
+
+// mergeProfile applies the profile fields of update listed in fields to
+// current, in order.
+func mergeProfile(current, update Profile, fields []string) Profile {
+	merged := current
+	merged.Metadata = copyMetadata(current.Metadata)
+
+	for _, field := range fields {
+		switch field {
+		case FieldProfile:
+			merged = update
+			merged.Metadata = copyMetadata(update.Metadata)
+		case FieldEmail:
+			merged.Email = update.Email
+		case FieldDisplayName:
+			merged.DisplayName = update.DisplayName
+		case FieldLocale:
+			merged.Locale = update.Locale
+		case FieldTimezone:
+			merged.Timezone = update.Timezone
+		case FieldMetadata:
+			merged.Metadata = copyMetadata(update.Metadata)
+		default:
+			key, ok := metadataKey(field)
+			if !ok {
+				continue
+			}
+			if value, ok := update.Metadata[key]; ok {
+				if merged.Metadata == nil {
+					merged.Metadata = map[string]string{}
+				}
+				merged.Metadata[key] = value
+			} else {
+				delete(merged.Metadata, key)
+			}
+		}
+	}
+	if len(merged.Metadata) == 0 {
+		merged.Metadata = nil
+	}
+
+	return merged
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	return copied
+}
+
+func encodeProfile(profile Profile) string {
+	// Marshaling only strings can't fail.
+	encoded, _ := json.Marshal(profile)
+	return string(encoded)
+}
+
+func decodeProfile(encoded string) (Profile, error) {
+	var profile Profile
+	if encoded == "" {
+		return profile, nil
+	}
+	err := json.Unmarshal([]byte(encoded), &profile)
+	return profile, err
+}
+
+// migrateAddInfo moves the free-text additional_information of every user
+// into the add_info key of their profile metadata.
+func migrateAddInfo(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, additional_information FROM users WHERE additional_information <> ''")
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	var profiles []string
+	for rows.Next() {
+		var id int64
+		var addInfo string
+		if err = rows.Scan(&id, &addInfo); err != nil {
+			break
+		}
+		ids = append(ids, id)
+		profiles = append(profiles, encodeProfile(Profile{Metadata: map[string]string{AddInfoKey: addInfo}}))
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET profile=? WHERE id=?", profiles[i], id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+// purgeUserDataSQL delete the rows of other tables that belong to a purged
+// user.
+var purgeUserDataSQL = []string{deleteVerificationsSQL, deleteTOTPSQL, deleteRecoveryCodesSQL, deleteChallengesSQL, deleteAPIKeysSQL, deleteOAuthCodesSQL, deleteLinkedIdentitiesSQL}
+
+// PurgeUsers hard deletes the users deleted more than gracePeriod ago and
+// scrubs the values from their audit entries. It returns how many users were
+// purged.
+func (repo *SQLRepo) PurgeUsers(ctx context.Context, gracePeriod time.Duration) (int, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "PurgeUsers")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cutoff := formatTime(repo.now().Add(-gracePeriod))
+	_, span := tracing.StartDBSpan(ctx, "SELECT", expiredSQL)
+	rows, err := tx.QueryContext(ctx, expiredSQL, cutoff)
+	if err != nil {
+		tracing.EndSpan(span, err)
+		level.Error(logger).Log("err", err.Error())
+		return 0, err
+	}
+	var userIds []string
+	for rows.Next() {
+		var userId string
+		if err = rows.Scan(&userId); err != nil {
+			break
+		}
+		userIds = append(userIds, userId)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return 0, err
+	}
+
+	for _, userId := range userIds {
+		_, span := tracing.StartDBSpan(ctx, "DELETE", purgeSQL)
+		_, err := tx.ExecContext(ctx, purgeSQL, userId, cutoff)
+		tracing.EndSpan(span, err)
+		if err != nil {
+			level.Error(logger).Log("err", err.Error())
+			return 0, err
+		}
+
+		for _, query := range purgeUserDataSQL {
+			_, span := tracing.StartDBSpan(ctx, "DELETE", query)
+			_, err := tx.ExecContext(ctx, query, userId)
+			tracing.EndSpan(span, err)
+			if err != nil {
+				level.Error(logger).Log("err", err.Error())
+				return 0, err
+			}
+		}
+
+		if err := scrubAudit(ctx, tx, userId); err != nil {
+			level.Error(logger).Log("err", err.Error())
+			return 0, err
+		}
+		if err := repo.writeAuditAs(ctx, tx, SystemActor, ActionPurgeUser, userId, map[string]string{}); err != nil {
+			level.Error(logger).Log("err", err.Error())
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return 0, err
+	}
+
+	return len(userIds), nil
+}
+
+// RunPurger calls PurgeUsers every interval until ctx is done.
+func RunPurger(ctx context.Context, repo Repository, gracePeriod, interval time.Duration, logger log.Logger) {
+	logger = log.With(logger, "component", "purger")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := repo.PurgeUsers(ctx, gracePeriod)
+		if err != nil {
+			level.Error(logger).Log("msg", "purge failed", "err", err)
+		} else if purged > 0 {
+			level.Info(logger).Log("msg", "purged deleted users", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,36 +1,146 @@
 package repository
 
 import (
-	"strings"
+	"database/sql"
+	"time"
 )
 
-const authenticateSQL = "SELECT user_id, pwd_hash FROM users WHERE name=?"
-const createSQL = "INSERT INTO users (user_id, name, pwd_hash, age, additional_information) VALUES (?, ?, ?, ?, ?)"
-const getSQL = "SELECT user_id, name, age, additional_information FROM users WHERE user_id=?"
+const authenticateSQL = "SELECT user_id, pwd_hash FROM users WHERE name=? AND deleted_at IS NULL"
+const authenticateByEmailSQL = "SELECT user_id, pwd_hash FROM users WHERE email=? AND email_verified_at IS NOT NULL AND deleted_at IS NULL"
+const createSQL = "INSERT INTO users (user_id, name, pwd_hash, age, birth_date, profile, email, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+const getSQL = "SELECT user_id, name, age, birth_date, profile, email_verified_at, version, created_at, updated_at, last_login_at FROM users WHERE user_id=? AND deleted_at IS NULL"
+const profileSQL = "SELECT profile FROM users WHERE user_id=? AND deleted_at IS NULL"
+const recordLoginSQL = "UPDATE users SET last_login_at=? WHERE user_id=? AND deleted_at IS NULL"
+const versionSQL = "SELECT version FROM users WHERE user_id=? AND deleted_at IS NULL"
+const deleteSQL = "UPDATE users SET deleted_at=?, version=version+1 WHERE user_id=? AND deleted_at IS NULL"
+const restoreSQL = "UPDATE users SET deleted_at=NULL, updated_at=?, version=version+1 WHERE user_id=? AND deleted_at>=?"
+const deletedAtSQL = "SELECT deleted_at FROM users WHERE user_id=?"
+const expiredSQL = "SELECT user_id FROM users WHERE deleted_at<?"
+const purgeSQL = "DELETE FROM users WHERE user_id=? AND deleted_at<?"
+const insertVerificationSQL = "INSERT INTO email_verifications (token_hash, user_id, email, expires_at) VALUES (?, ?, ?, ?)"
+const verificationSQL = "SELECT user_id, email, expires_at FROM email_verifications WHERE token_hash=?"
+const deleteVerificationsSQL = "DELETE FROM email_verifications WHERE user_id=?"
+const verifyEmailSQL = "UPDATE users SET email_verified_at=?, updated_at=?, version=version+1 WHERE user_id=? AND email=? AND deleted_at IS NULL"
+const enrollTOTPSQL = "INSERT INTO totp (user_id, secret) VALUES (?, ?) ON CONFLICT (user_id) DO UPDATE SET secret=excluded.secret WHERE confirmed_at IS NULL"
+const totpSQL = "SELECT secret, confirmed_at, last_step, locked_until FROM totp WHERE user_id=?"
+const confirmTOTPSQL = "UPDATE totp SET confirmed_at=?, last_step=? WHERE user_id=? AND confirmed_at IS NULL"
+const useTOTPStepSQL = "UPDATE totp SET last_step=?, failed_attempts=0 WHERE user_id=? AND confirmed_at IS NOT NULL AND last_step<?"
+const totpFailureSQL = "UPDATE totp SET failed_attempts=CASE WHEN failed_attempts+1>=? THEN 0 ELSE failed_attempts+1 END, locked_until=CASE WHEN failed_attempts+1>=? THEN ? ELSE locked_until END WHERE user_id=?"
+const resetTOTPFailuresSQL = "UPDATE totp SET failed_attempts=0 WHERE user_id=?"
+const deleteTOTPSQL = "DELETE FROM totp WHERE user_id=?"
+const insertRecoveryCodeSQL = "INSERT INTO recovery_codes (code_hash, user_id) VALUES (?, ?)"
+const useRecoveryCodeSQL = "UPDATE recovery_codes SET used_at=? WHERE code_hash=? AND user_id=? AND used_at IS NULL"
+const deleteRecoveryCodesSQL = "DELETE FROM recovery_codes WHERE user_id=?"
+const insertChallengeSQL = "INSERT INTO auth_challenges (token_hash, user_id, expires_at) VALUES (?, ?, ?)"
+const deleteExpiredChallengesSQL = "DELETE FROM auth_challenges WHERE expires_at<=?"
+const attemptChallengeSQL = "UPDATE auth_challenges SET attempts=attempts+1 WHERE token_hash=? AND expires_at>? AND attempts<?"
+const challengeUserSQL = "SELECT user_id FROM auth_challenges WHERE token_hash=?"
+const deleteChallengeSQL = "DELETE FROM auth_challenges WHERE token_hash=?"
+const deleteChallengesSQL = "DELETE FROM auth_challenges WHERE user_id=?"
+const insertAPIKeySQL = "INSERT INTO api_keys (key_id, user_id, name, key_hash, scopes, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+const apiKeysSQL = "SELECT key_id, name, scopes, created_at, expires_at, last_used_at FROM api_keys WHERE user_id=? ORDER BY created_at, key_id"
+const apiKeySQL = "SELECT k.user_id, k.key_hash, k.scopes, k.expires_at FROM api_keys k JOIN users u ON u.user_id=k.user_id WHERE k.key_id=? AND u.deleted_at IS NULL"
+const useAPIKeySQL = "UPDATE api_keys SET last_used_at=? WHERE key_id=?"
+const revokeAPIKeySQL = "DELETE FROM api_keys WHERE key_id=? AND user_id=?"
+const deleteAPIKeysSQL = "DELETE FROM api_keys WHERE user_id=?"
+const insertOAuthClientSQL = "INSERT INTO oauth_clients (client_id, name, secret_hash, redirect_uris, created_at) VALUES (?, ?, ?, ?, ?)"
+const oauthClientSQL = "SELECT name, secret_hash, redirect_uris, created_at FROM oauth_clients WHERE client_id=?"
+const insertOAuthCodeSQL = "INSERT INTO oauth_codes (code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+const deleteExpiredOAuthCodesSQL = "DELETE FROM oauth_codes WHERE expires_at<=?"
+const oauthCodeSQL = "SELECT client_id, user_id, redirect_uri, scope, nonce, code_challenge, expires_at FROM oauth_codes WHERE code_hash=?"
+const deleteOAuthCodeSQL = "DELETE FROM oauth_codes WHERE code_hash=?"
+const deleteOAuthCodesSQL = "DELETE FROM oauth_codes WHERE user_id=?"
+const insertLinkedIdentitySQL = "INSERT INTO linked_identities (issuer, subject, user_id, created_at) SELECT ?, ?, user_id, ? FROM users WHERE user_id=? AND deleted_at IS NULL"
+const linkedIdentitySQL = "SELECT user_id, created_at FROM linked_identities WHERE issuer=? AND subject=?"
+const emailOwnerSQL = "SELECT user_id, email_verified_at IS NOT NULL AND deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM totp WHERE totp.user_id=users.user_id AND confirmed_at IS NOT NULL) FROM users WHERE email=?"
+const deleteLinkedIdentitiesSQL = "DELETE FROM linked_identities WHERE user_id=?"
+const findByAgeSQL = "SELECT user_id, name, age, birth_date, profile, version FROM users WHERE deleted_at IS NULL AND birth_date<=?"
+const insertAuditSQL = "INSERT INTO audit_log (actor, action, target, changes, request_id, service, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"
+const auditLogSQL = "SELECT id, actor, action, target, changes, request_id, service, created_at FROM audit_log WHERE target=? AND id<? ORDER BY id DESC LIMIT ?"
+const auditChangesSQL = "SELECT id, changes FROM audit_log WHERE target=?"
+const scrubAuditSQL = "UPDATE audit_log SET changes=? WHERE id=?"
 
-func updateSQL(user *User) (args []interface{}, query string) {
-	query = "UPDATE users"
-	queryArgs := " SET "
+// updateSQL writes the given fields of user, in the order of updateFields,
+// and bumps the version and updated_at. Any profile field writes the whole
+// user.Profile and its email, which has to be verified again if it changed.
+func updateSQL(user *User, fields []string) (args []interface{}, query string) {
+	query = "UPDATE users SET"
 
-	if user.PwdHash != "" {
-		args = append(args, user.PwdHash)
-		queryArgs += " pwd_hash=?,"
+	for _, field := range updateFields {
+		if !writesColumn(fields, field) {
+			continue
+		}
+		switch field {
+		case FieldPwdHash:
+			args = append(args, user.PwdHash)
+		case FieldAge:
+			args = append(args, user.Age)
+		case FieldBirthDate:
+			args = append(args, birthDateColumn(user.BirthDate))
+		case FieldName:
+			args = append(args, user.Name)
+		case FieldProfile:
+			args = append(args, encodeProfile(user.Profile))
+		}
+		query += " " + field + "=?,"
+		if field == FieldProfile {
+			email := emailColumn(user.Profile)
+			query += " email=?, email_verified_at=CASE WHEN email IS ? THEN email_verified_at END,"
+			args = append(args, email, email)
+		}
 	}
-	if user.Age > 0 {
-		args = append(args, user.Age)
-		queryArgs += " age=?,"
+	query += " version=version+1, updated_at=? WHERE user_id=? AND deleted_at IS NULL"
+	args = append(args, formatTime(user.UpdatedAt), user.UserId)
+	if user.Version > 0 {
+		query += " AND version=?"
+		args = append(args, user.Version)
 	}
-	if user.Name != "" {
-		args = append(args, user.Name)
-		queryArgs += " name=?,"
+
+	return args, query
+}
+
+func writesColumn(fields []string, column string) bool {
+	if column == FieldProfile {
+		for _, field := range fields {
+			if isProfileField(field) {
+				return true
+			}
+		}
+		return false
 	}
-	if user.AddInfo.String != "" {
-		args = append(args, user.AddInfo.String)
-		queryArgs += " additional_information=?,"
+	return containsField(fields, column)
+}
+
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
 	}
-	queryArgs = strings.TrimSuffix(queryArgs, ",")
-	query += queryArgs + " WHERE user_id=?"
-	args = append(args, user.UserId)
+	return false
+}
 
-	return args, query
+// timeLayout is RFC 3339 in UTC with a fixed number of fractional digits, so
+// stored timestamps compare correctly as text.
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+// timeColumn is the value of a nullable timestamp column, NULL for the zero
+// time.
+func timeColumn(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return formatTime(t)
+}
+
+// parseTime reads a nullable timestamp column; NULL is the zero time.
+func parseTime(value sql.NullString) (time.Time, error) {
+	if !value.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value.String)
 }
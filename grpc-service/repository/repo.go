@@ -3,52 +3,133 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 
 	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/tracing"
 )
 
 type SQLRepo struct {
 	db     *sql.DB
 	logger log.Logger
+	now    func() time.Time
 }
 
 type Repository interface {
 	Authenticate(ctx context.Context, userName string) (User, error)
+	AuthenticateByEmail(ctx context.Context, email string) (User, error)
 	CreateUser(ctx context.Context, user User) error
-	UpdateUser(ctx context.Context, user User) error
+	UpdateUser(ctx context.Context, user User, fields []string, validate func(Profile) error) (User, error)
 	GetUser(ctx context.Context, userId string) (User, error)
+	RecordLogin(ctx context.Context, userId string) error
+	DeleteUser(ctx context.Context, userId string) error
+	RestoreUser(ctx context.Context, userId string, gracePeriod time.Duration) error
+	PurgeUsers(ctx context.Context, gracePeriod time.Duration) (int, error)
+	GetAuditLog(ctx context.Context, target string, beforeId int64, limit int) ([]AuditEntry, error)
+	CreateEmailVerification(ctx context.Context, userId, email, tokenHash string, ttl time.Duration) error
+	VerifyEmail(ctx context.Context, tokenHash string) (string, error)
+	FindUsersByAge(ctx context.Context, min, max uint32) ([]User, error)
+	EnrollTOTP(ctx context.Context, userId, secret string) error
+	GetTOTP(ctx context.Context, userId string) (TOTP, error)
+	ConfirmTOTP(ctx context.Context, userId string, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(ctx context.Context, userId string, step int64) error
+	UseRecoveryCode(ctx context.Context, userId, codeHash string) error
+	RecordTOTPFailure(ctx context.Context, userId string, maxFailures int, lockout time.Duration) error
+	CreateAuthChallenge(ctx context.Context, userId, tokenHash string, ttl time.Duration) error
+	AttemptAuthChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error)
+	DeleteAuthChallenge(ctx context.Context, tokenHash string) error
+	CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error)
+	ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error)
+	GetAPIKey(ctx context.Context, keyId string) (APIKey, error)
+	RecordAPIKeyUse(ctx context.Context, keyId string) error
+	RevokeAPIKey(ctx context.Context, userId, keyId string) error
+	CreateOAuthClient(ctx context.Context, client OAuthClient) (OAuthClient, error)
+	GetOAuthClient(ctx context.Context, clientId string) (OAuthClient, error)
+	CreateAuthorizationCode(ctx context.Context, code AuthorizationCode, ttl time.Duration) error
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (AuthorizationCode, error)
+	LinkIdentity(ctx context.Context, identity LinkedIdentity) (LinkedIdentity, error)
+	ProvisionIdentity(ctx context.Context, user User, identity LinkedIdentity) (LinkedIdentity, error)
+	GetLinkedIdentity(ctx context.Context, issuer, subject string) (LinkedIdentity, error)
 }
 
+// Columns UpdateUser can write.
+const (
+	FieldPwdHash   = "pwd_hash"
+	FieldAge       = "age"
+	FieldBirthDate = "birth_date"
+	FieldName      = "name"
+)
+
+// FieldEmailVerifiedAt is audited when an email address is verified.
+const FieldEmailVerifiedAt = "email_verified_at"
+
+// FieldTOTPConfirmedAt is audited when two-factor authentication is enabled.
+const FieldTOTPConfirmedAt = "totp_confirmed_at"
+
+// Fields audited when an API key is created or revoked.
+const (
+	FieldAPIKey          = "api_key"
+	FieldAPIKeyScopes    = "api_key_scopes"
+	FieldAPIKeyExpiresAt = "api_key_expires_at"
+)
+
+// Fields audited when an external identity is linked.
+const (
+	FieldIdentityIssuer  = "identity_issuer"
+	FieldIdentitySubject = "identity_subject"
+)
+
+var updateFields = []string{FieldPwdHash, FieldAge, FieldBirthDate, FieldName, FieldProfile}
+
 type User struct {
 	Id      int
 	UserId  string
 	PwdHash string
 	Name    string
-	Age     uint32
-	AddInfo sql.NullString
+	// Age is the age stored before birth dates were, and is stale; prefer
+	// BirthDate when it is known.
+	Age uint32
+	// BirthDate is a UTC date, zero when unknown.
+	BirthDate time.Time
+	Profile   Profile
+	// EmailVerified is set once the profile email has been verified, and
+	// cleared when it changes.
+	EmailVerified bool
+	// Version is bumped on every update. When set on an update it must
+	// match the stored version for the update to apply.
+	Version int64
+	// Timestamps maintained by SQLRepo. They are zero for accounts created
+	// before they were tracked, and LastLoginAt for users who never logged in.
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	LastLoginAt time.Time
 }
 
 func NewRepo(db *sql.DB, logger log.Logger) Repository {
 	return &SQLRepo{
 		db:     db,
 		logger: log.With(logger, "error", "db"),
+		now:    time.Now,
 	}
 }
 
 func (repo *SQLRepo) Authenticate(ctx context.Context, userName string) (User, error) {
-	logger := log.With(repo.logger, "method", "Authenticate")
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "Authenticate")
 
-	stmt, err := repo.db.Prepare(authenticateSQL)
+	stmt, err := repo.db.PrepareContext(ctx, authenticateSQL)
 	if err != nil {
 		level.Error(logger).Log("err", err.Error())
 		return User{}, err
 	}
 
 	var user User
-	err = stmt.QueryRow(userName).Scan(&user.UserId, &user.PwdHash)
+	_, span := tracing.StartDBSpan(ctx, "SELECT", authenticateSQL)
+	err = stmt.QueryRowContext(ctx, userName).Scan(&user.UserId, &user.PwdHash)
+	tracing.EndSpan(span, err)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			level.Error(logger).Log("err", erro.ErrUserNotFound)
@@ -62,63 +143,197 @@ func (repo *SQLRepo) Authenticate(ctx context.Context, userName string) (User, e
 }
 
 func (repo *SQLRepo) CreateUser(ctx context.Context, user User) error {
-	logger := log.With(repo.logger, "method", "CreateUser")
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "CreateUser")
 
-	stmt, err := repo.db.Prepare(createSQL)
+	tx, err := repo.db.BeginTx(ctx, nil)
 	if err != nil {
 		level.Error(logger).Log("err", err.Error())
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = stmt.Exec(user.UserId, user.Name, user.PwdHash, user.Age, user.AddInfo)
-	if err != nil {
-		level.Error(logger).Log("err", err)
+	if err := repo.createUser(ctx, tx, user); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
 		return err
 	}
 
 	return nil
 }
 
-func (repo *SQLRepo) UpdateUser(ctx context.Context, user User) error {
-	logger := log.With(repo.logger, "method", "UpdateUser")
+// createUser inserts and audits user in tx.
+func (repo *SQLRepo) createUser(ctx context.Context, tx *sql.Tx, user User) error {
+	stmt, err := tx.PrepareContext(ctx, createSQL)
+	if err != nil {
+		return err
+	}
 
-	args, query := updateSQL(&user)
-	stmt, err := repo.db.Prepare(query)
+	now := formatTime(repo.now())
+	_, span := tracing.StartDBSpan(ctx, "INSERT", createSQL)
+	_, err = stmt.ExecContext(ctx, user.UserId, user.Name, user.PwdHash, user.Age, birthDateColumn(user.BirthDate), encodeProfile(user.Profile), emailColumn(user.Profile), now, now)
+	tracing.EndSpan(span, err)
 	if err != nil {
-		level.Error(logger).Log("err", err.Error())
+		if isUniqueViolation(err) {
+			return erro.NewErrAlreadyExists(erro.ErrEmailTaken)
+		}
 		return err
 	}
 
-	queryRes, err := stmt.Exec(args...)
+	return repo.writeAudit(ctx, tx, ActionCreateUser, user.UserId, userChanges(user, createFields))
+}
+
+// UpdateUser writes the listed fields of user, including zero values, so an
+// empty profile member clears it. Profile fields are merged into the stored
+// profile. A password change is audited separately from the other fields.
+// The updated user is read in the same transaction, so its Version is the
+// one this update wrote. validate, unless nil, checks the merged profile
+// before it is written, as a patch within limits can still push the stored
+// profile past them.
+func (repo *SQLRepo) UpdateUser(ctx context.Context, user User, fields []string, validate func(Profile) error) (User, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "UpdateUser")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
 	if err != nil {
 		level.Error(logger).Log("err", err.Error())
-		return err
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	if writesColumn(fields, FieldProfile) {
+		var current Profile
+		if !containsField(fields, FieldProfile) {
+			if current, err = currentProfile(ctx, tx, user.UserId); err != nil {
+				level.Error(logger).Log("err", err.Error(), "userId", user.UserId)
+				return User{}, err
+			}
+		}
+		user.Profile = mergeProfile(current, user.Profile, fields)
+		if validate != nil {
+			if err := validate(user.Profile); err != nil {
+				level.Error(logger).Log("err", err.Error(), "userId", user.UserId)
+				return User{}, err
+			}
+		}
+	}
+
+	user.UpdatedAt = repo.now()
+	args, query := updateSQL(&user, fields)
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return User{}, err
+	}
+
+	_, span := tracing.StartDBSpan(ctx, "UPDATE", query)
+	queryRes, err := stmt.ExecContext(ctx, args...)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		if isUniqueViolation(err) {
+			return User{}, erro.NewErrAlreadyExists(erro.ErrEmailTaken)
+		}
+		return User{}, err
 	}
 
 	rowCnt, err := queryRes.RowsAffected()
 	if err != nil {
 		level.Error(logger).Log("err", err.Error())
-		return err
+		return User{}, err
 	}
 	if rowCnt == 0 {
+		if user.Version > 0 {
+			return User{}, versionMismatch(ctx, tx, logger, user)
+		}
 		level.Error(logger).Log("err", erro.ErrUserNotFound, "userId", user.UserId)
-		return erro.NewErrNotFound()
+		return User{}, erro.NewErrNotFound()
 	}
 
-	return nil
+	var changed []string
+	for _, field := range fields {
+		if field != FieldPwdHash {
+			changed = append(changed, field)
+		}
+	}
+	if containsField(fields, FieldPwdHash) {
+		if err := repo.writeAudit(ctx, tx, ActionChangePassword, user.UserId, userChanges(user, []string{FieldPwdHash})); err != nil {
+			level.Error(logger).Log("err", err.Error())
+			return User{}, err
+		}
+	}
+	if len(changed) > 0 {
+		if err := repo.writeAudit(ctx, tx, ActionUpdateUser, user.UserId, userChanges(user, changed)); err != nil {
+			level.Error(logger).Log("err", err.Error())
+			return User{}, err
+		}
+	}
+
+	_, span = tracing.StartDBSpan(ctx, "SELECT", getSQL)
+	updated, err := scanUser(tx.QueryRowContext(ctx, getSQL, user.UserId))
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return User{}, err
+	}
+
+	return updated, nil
+}
+
+// currentProfile reads the stored profile of a user about to be updated.
+func currentProfile(ctx context.Context, tx *sql.Tx, userId string) (Profile, error) {
+	var encoded string
+	_, span := tracing.StartDBSpan(ctx, "SELECT", profileSQL)
+	err := tx.QueryRowContext(ctx, profileSQL, userId).Scan(&encoded)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Profile{}, erro.NewErrNotFound()
+		}
+		return Profile{}, err
+	}
+	return decodeProfile(encoded)
+}
+
+// versionMismatch tells apart an update that matched no row because the user
+// does not exist from one whose expected version is stale.
+func versionMismatch(ctx context.Context, tx *sql.Tx, logger log.Logger, user User) error {
+	var version int64
+	_, span := tracing.StartDBSpan(ctx, "SELECT", versionSQL)
+	err := tx.QueryRowContext(ctx, versionSQL, user.UserId).Scan(&version)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			level.Error(logger).Log("err", erro.ErrUserNotFound, "userId", user.UserId)
+			return erro.NewErrNotFound()
+		}
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	level.Error(logger).Log("err", erro.ErrVersionMismatch, "userId", user.UserId, "expected", user.Version, "actual", version)
+	return erro.NewErrFailedPrecondition(erro.ErrVersionMismatch)
 }
 
 func (repo *SQLRepo) GetUser(ctx context.Context, userId string) (User, error) {
-	logger := log.With(repo.logger, "method", "GetUser")
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "GetUser")
 
-	stmt, err := repo.db.Prepare(getSQL)
+	stmt, err := repo.db.PrepareContext(ctx, getSQL)
 	if err != nil {
 		level.Error(logger).Log("err", err.Error())
 		return User{}, err
 	}
 
-	var user User
-	err = stmt.QueryRow(userId).Scan(&user.UserId, &user.Name, &user.Age, &user.AddInfo)
+	_, span := tracing.StartDBSpan(ctx, "SELECT", getSQL)
+	user, err := scanUser(stmt.QueryRowContext(ctx, userId))
+	tracing.EndSpan(span, err)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			level.Error(logger).Log("err", erro.ErrUserNotFound, "userId", userId)
@@ -130,3 +345,56 @@ func (repo *SQLRepo) GetUser(ctx context.Context, userId string) (User, error) {
 
 	return user, nil
 }
+
+// scanUser reads a row of getSQL.
+func scanUser(row *sql.Row) (User, error) {
+	var user User
+	var profile string
+	var birthDate, emailVerifiedAt, createdAt, updatedAt, lastLoginAt sql.NullString
+	err := row.Scan(&user.UserId, &user.Name, &user.Age, &birthDate, &profile, &emailVerifiedAt, &user.Version, &createdAt, &updatedAt, &lastLoginAt)
+	if err != nil {
+		return User{}, err
+	}
+	user.EmailVerified = emailVerifiedAt.Valid
+	if user.BirthDate, err = parseDate(birthDate); err != nil {
+		return User{}, err
+	}
+	if user.Profile, err = decodeProfile(profile); err != nil {
+		return User{}, err
+	}
+	if user.CreatedAt, err = parseTime(createdAt); err != nil {
+		return User{}, err
+	}
+	if user.UpdatedAt, err = parseTime(updatedAt); err != nil {
+		return User{}, err
+	}
+	if user.LastLoginAt, err = parseTime(lastLoginAt); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// RecordLogin stamps the user's last successful login with the current time.
+func (repo *SQLRepo) RecordLogin(ctx context.Context, userId string) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "RecordLogin")
+
+	_, span := tracing.StartDBSpan(ctx, "UPDATE", recordLoginSQL)
+	res, err := repo.db.ExecContext(ctx, recordLoginSQL, formatTime(repo.now()), userId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	if rowCnt == 0 {
+		level.Error(logger).Log("err", erro.ErrUserNotFound, "userId", userId)
+		return erro.NewErrNotFound()
+	}
+
+	return nil
+}
@@ -3,6 +3,9 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"math"
+	"time"
 
 	"os"
 	"testing"
@@ -11,8 +14,12 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/svcauth"
 	"github.com/javibauza/final-project/grpc-service/utils"
 )
 
@@ -22,7 +29,24 @@ var user = &User{
 	PwdHash: "$2a$12$Lhdc.gbeLbQbm8uz3H1T4.EPaxqclyblPeM1N1rxhNCth1/sZkCwC", //jbauza123
 	Name:    "javier",
 	Age:     37,
-	AddInfo: sql.NullString{},
+	Profile: Profile{Email: "javier@example.com", Metadata: map[string]string{AddInfoKey: "info"}},
+}
+
+var auditTime = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+var nowStamp = formatTime(auditTime)
+
+func expectAudit(mock sqlmock.Sqlmock, action, target, changes string) {
+	mock.ExpectExec(insertAuditSQL).
+		WithArgs(AnonymousActor, action, target, changes, "", "", nowStamp).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// expectUpdatedUser expects UpdateUser to read back the user it updated.
+func expectUpdatedUser(mock sqlmock.Sqlmock, userId string) {
+	mock.ExpectQuery(getSQL).
+		WithArgs(userId).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "name", "age", "birth_date", "profile", "email_verified_at", "version", "created_at", "updated_at", "last_login_at"}).
+			AddRow(userId, "javier", 37, nil, "{}", nil, 3, "2026-01-01T00:00:00Z", nowStamp, nil))
 }
 
 func NewMock(logger log.Logger) (*sql.DB, sqlmock.Sqlmock) {
@@ -51,6 +75,7 @@ func TestAuthenticate(t *testing.T) {
 	defer db.Close()
 
 	repo := NewRepo(db, logger)
+	repo.(*SQLRepo).now = func() time.Time { return auditTime }
 
 	testCases := []struct {
 		testName      string
@@ -114,6 +139,7 @@ func TestCreateUser(t *testing.T) {
 	defer db.Close()
 
 	repo := NewRepo(db, logger)
+	repo.(*SQLRepo).now = func() time.Time { return auditTime }
 
 	testCases := []struct {
 		testName      string
@@ -123,22 +149,47 @@ func TestCreateUser(t *testing.T) {
 	}{
 		{
 			testName: "user created",
+			userData: &User{
+				UserId:    user.UserId,
+				PwdHash:   user.PwdHash,
+				Name:      user.Name,
+				Age:       user.Age,
+				BirthDate: time.Date(1988, 7, 9, 0, 0, 0, 0, time.UTC),
+				Profile:   user.Profile,
+			},
+			buildStubs: func(mock sqlmock.Sqlmock, request *User) {
+				var lastInsertID, affected int64
+				mock.ExpectBegin()
+				mock.ExpectPrepare(createSQL)
+				mock.ExpectExec(createSQL).
+					WithArgs(request.UserId, request.Name, request.PwdHash, request.Age, "1988-07-09", `{"email":"javier@example.com","metadata":{"add_info":"info"}}`, "javier@example.com", nowStamp, nowStamp).
+					WillReturnResult(sqlmock.NewResult(lastInsertID, affected))
+				expectAudit(mock, ActionCreateUser, request.UserId, `{"age":"37","birth_date":"1988-07-09","name":"javier","profile":"{\"email\":\"javier@example.com\",\"metadata\":{\"add_info\":\"info\"}}","pwd_hash":"***"}`)
+				mock.ExpectCommit()
+			},
+			checkResponse: func(t *testing.T, err error) {
+				assert.NoError(t, err)
+			},
+		},
+		{
+			testName: "audit log write fails",
 			userData: &User{
 				UserId:  user.UserId,
 				PwdHash: user.PwdHash,
 				Name:    user.Name,
 				Age:     user.Age,
-				AddInfo: user.AddInfo,
 			},
 			buildStubs: func(mock sqlmock.Sqlmock, request *User) {
-				var lastInsertID, affected int64
+				mock.ExpectBegin()
 				mock.ExpectPrepare(createSQL)
 				mock.ExpectExec(createSQL).
-					WithArgs(request.UserId, request.Name, request.PwdHash, request.Age, request.AddInfo).
-					WillReturnResult(sqlmock.NewResult(lastInsertID, affected))
+					WithArgs(request.UserId, request.Name, request.PwdHash, request.Age, nil, "{}", nil, nowStamp, nowStamp).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(insertAuditSQL).WillReturnError(errors.New("disk full"))
+				mock.ExpectRollback()
 			},
 			checkResponse: func(t *testing.T, err error) {
-				assert.NoError(t, err)
+				assert.EqualError(t, err, "disk full")
 			},
 		},
 	}
@@ -151,14 +202,16 @@ func TestCreateUser(t *testing.T) {
 			tc.buildStubs(mock, tc.userData)
 
 			request := User{
-				UserId:  tc.userData.UserId,
-				PwdHash: tc.userData.PwdHash,
-				Name:    tc.userData.Name,
-				Age:     tc.userData.Age,
-				AddInfo: tc.userData.AddInfo,
+				UserId:    tc.userData.UserId,
+				PwdHash:   tc.userData.PwdHash,
+				Name:      tc.userData.Name,
+				Age:       tc.userData.Age,
+				BirthDate: tc.userData.BirthDate,
+				Profile:   tc.userData.Profile,
 			}
 			err := repo.CreateUser(ctx, request)
 			tc.checkResponse(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
 }
@@ -179,12 +232,14 @@ func TestUpdateUser(t *testing.T) {
 	defer db.Close()
 
 	repo := NewRepo(db, logger)
+	repo.(*SQLRepo).now = func() time.Time { return auditTime }
 
 	testCases := []struct {
 		testName      string
 		userData      *User
-		buildStubs    func(mock sqlmock.Sqlmock, user *User)
-		checkResponse func(t *testing.T, resError error)
+		fields        []string
+		buildStubs    func(mock sqlmock.Sqlmock, user *User, fields []string)
+		checkResponse func(t *testing.T, updated User, resError error)
 	}{
 		{
 			testName: "user updated",
@@ -193,15 +248,23 @@ func TestUpdateUser(t *testing.T) {
 				Name:    user.Name,
 				Age:     user.Age,
 			},
-			buildStubs: func(mock sqlmock.Sqlmock, user *User) {
-				_, query := updateSQL(user)
+			fields: []string{FieldName, FieldPwdHash, FieldAge},
+			buildStubs: func(mock sqlmock.Sqlmock, user *User, fields []string) {
+				_, query := updateSQL(user, fields)
+				assert.Equal(t, "UPDATE users SET pwd_hash=?, age=?, name=?, version=version+1, updated_at=? WHERE user_id=? AND deleted_at IS NULL", query)
+				mock.ExpectBegin()
 				mock.ExpectPrepare(query)
 				mock.ExpectExec(query).
-					WithArgs(user.PwdHash, user.Age, user.Name, user.UserId).
+					WithArgs(user.PwdHash, user.Age, user.Name, nowStamp, user.UserId).
 					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAudit(mock, ActionChangePassword, user.UserId, `{"pwd_hash":"***"}`)
+				expectAudit(mock, ActionUpdateUser, user.UserId, `{"age":"37","name":"javier"}`)
+				expectUpdatedUser(mock, user.UserId)
+				mock.ExpectCommit()
 			},
-			checkResponse: func(t *testing.T, resError error) {
+			checkResponse: func(t *testing.T, updated User, resError error) {
 				assert.NoError(t, resError)
+				assert.Equal(t, int64(3), updated.Version, "the version is read in the update's transaction")
 			},
 		},
 		{
@@ -211,19 +274,195 @@ func TestUpdateUser(t *testing.T) {
 				PwdHash: user.PwdHash,
 				Age:     user.Age,
 			},
-			buildStubs: func(mock sqlmock.Sqlmock, user *User) {
-				_, query := updateSQL(user)
+			fields: []string{FieldName, FieldPwdHash, FieldAge},
+			buildStubs: func(mock sqlmock.Sqlmock, user *User, fields []string) {
+				_, query := updateSQL(user, fields)
+				mock.ExpectBegin()
 				mock.ExpectPrepare(query)
 				mock.ExpectExec(query).
-					WithArgs(user.PwdHash, user.Age, user.Name, user.UserId).
+					WithArgs(user.PwdHash, user.Age, user.Name, nowStamp, user.UserId).
 					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
 			},
-			checkResponse: func(t *testing.T, resError error) {
+			checkResponse: func(t *testing.T, updated User, resError error) {
 				res, ok := resError.(*erro.ErrNotFound)
 				assert.EqualValues(t, true, ok)
 				assert.Equal(t, res.Err.Error(), erro.ErrUserNotFound)
 			},
 		},
+		{
+			testName: "age cleared",
+			userData: &User{},
+			fields:   []string{FieldAge},
+			buildStubs: func(mock sqlmock.Sqlmock, user *User, fields []string) {
+				_, query := updateSQL(user, fields)
+				assert.Equal(t, "UPDATE users SET age=?, version=version+1, updated_at=? WHERE user_id=? AND deleted_at IS NULL", query)
+				mock.ExpectBegin()
+				mock.ExpectPrepare(query)
+				mock.ExpectExec(query).
+					WithArgs(uint32(0), nowStamp, user.UserId).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAudit(mock, ActionUpdateUser, user.UserId, `{"age":"0"}`)
+				expectUpdatedUser(mock, user.UserId)
+				mock.ExpectCommit()
+			},
+			checkResponse: func(t *testing.T, updated User, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "profile cleared",
+			userData: &User{},
+			fields:   []string{FieldProfile},
+			buildStubs: func(mock sqlmock.Sqlmock, user *User, fields []string) {
+				_, query := updateSQL(user, fields)
+				assert.Equal(t, "UPDATE users SET profile=?, email=?, email_verified_at=CASE WHEN email IS ? THEN email_verified_at END, version=version+1, updated_at=? WHERE user_id=? AND deleted_at IS NULL", query)
+				mock.ExpectBegin()
+				mock.ExpectPrepare(query)
+				mock.ExpectExec(query).
+					WithArgs("{}", nil, nil, nowStamp, user.UserId).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAudit(mock, ActionUpdateUser, user.UserId, `{"profile":"{}"}`)
+				expectUpdatedUser(mock, user.UserId)
+				mock.ExpectCommit()
+			},
+			checkResponse: func(t *testing.T, updated User, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "profile fields merged into the stored profile",
+			userData: &User{
+				Profile: Profile{Locale: "es-MX", Metadata: map[string]string{"team": "core"}},
+			},
+			fields: []string{FieldLocale, FieldEmail, FieldMetadata + ".team", FieldMetadata + "." + AddInfoKey},
+			buildStubs: func(mock sqlmock.Sqlmock, user *User, fields []string) {
+				query := "UPDATE users SET profile=?, email=?, email_verified_at=CASE WHEN email IS ? THEN email_verified_at END, version=version+1, updated_at=? WHERE user_id=? AND deleted_at IS NULL"
+				mock.ExpectBegin()
+				mock.ExpectQuery(profileSQL).
+					WithArgs(user.UserId).
+					WillReturnRows(sqlmock.NewRows([]string{"profile"}).AddRow(`{"email":"old@example.com","timezone":"UTC","metadata":{"add_info":"info"}}`))
+				mock.ExpectPrepare(query)
+				mock.ExpectExec(query).
+					WithArgs(`{"locale":"es-MX","timezone":"UTC","metadata":{"team":"core"}}`, nil, nil, nowStamp, user.UserId).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAudit(mock, ActionUpdateUser, user.UserId, `{"profile.email":"","profile.locale":"es-MX","profile.metadata.add_info":"","profile.metadata.team":"core"}`)
+				expectUpdatedUser(mock, user.UserId)
+				mock.ExpectCommit()
+			},
+			checkResponse: func(t *testing.T, updated User, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "profile of a missing user",
+			userData: &User{},
+			fields:   []string{FieldLocale},
+			buildStubs: func(mock sqlmock.Sqlmock, user *User, fields []string) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(profileSQL).
+					WithArgs(user.UserId).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			checkResponse: func(t *testing.T, updated User, resError error) {
+				assert.IsType(t, &erro.ErrNotFound{}, resError)
+			},
+		},
+		{
+			testName: "fields outside the mask are not written",
+			userData: &User{
+				Name:    user.Name,
+				Profile: Profile{Email: "ignored@example.com"},
+			},
+			fields: []string{FieldName},
+			buildStubs: func(mock sqlmock.Sqlmock, user *User, fields []string) {
+				_, query := updateSQL(user, fields)
+				assert.Equal(t, "UPDATE users SET name=?, version=version+1, updated_at=? WHERE user_id=? AND deleted_at IS NULL", query)
+				mock.ExpectBegin()
+				mock.ExpectPrepare(query)
+				mock.ExpectExec(query).
+					WithArgs(user.Name, nowStamp, user.UserId).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAudit(mock, ActionUpdateUser, user.UserId, `{"name":"javier"}`)
+				expectUpdatedUser(mock, user.UserId)
+				mock.ExpectCommit()
+			},
+			checkResponse: func(t *testing.T, updated User, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "expected version matches",
+			userData: &User{
+				Name:    user.Name,
+				Version: 2,
+			},
+			fields: []string{FieldName},
+			buildStubs: func(mock sqlmock.Sqlmock, user *User, fields []string) {
+				_, query := updateSQL(user, fields)
+				mock.ExpectBegin()
+				mock.ExpectPrepare(query)
+				mock.ExpectExec(query).
+					WithArgs(user.Name, nowStamp, user.UserId, user.Version).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAudit(mock, ActionUpdateUser, user.UserId, `{"name":"javier"}`)
+				expectUpdatedUser(mock, user.UserId)
+				mock.ExpectCommit()
+			},
+			checkResponse: func(t *testing.T, updated User, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "expected version is stale",
+			userData: &User{
+				Name:    user.Name,
+				Version: 2,
+			},
+			fields: []string{FieldName},
+			buildStubs: func(mock sqlmock.Sqlmock, user *User, fields []string) {
+				_, query := updateSQL(user, fields)
+				mock.ExpectBegin()
+				mock.ExpectPrepare(query)
+				mock.ExpectExec(query).
+					WithArgs(user.Name, nowStamp, user.UserId, user.Version).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(versionSQL).
+					WithArgs(user.UserId).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+				mock.ExpectRollback()
+			},
+			checkResponse: func(t *testing.T, updated User, resError error) {
+				res, ok := resError.(*erro.ErrFailedPrecondition)
+				assert.EqualValues(t, true, ok)
+				assert.Equal(t, res.Err.Error(), erro.ErrVersionMismatch)
+			},
+		},
+		{
+			testName: "expected version for unknown user",
+			userData: &User{
+				Name:    user.Name,
+				Version: 2,
+			},
+			fields: []string{FieldName},
+			buildStubs: func(mock sqlmock.Sqlmock, user *User, fields []string) {
+				_, query := updateSQL(user, fields)
+				mock.ExpectBegin()
+				mock.ExpectPrepare(query)
+				mock.ExpectExec(query).
+					WithArgs(user.Name, nowStamp, user.UserId, user.Version).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(versionSQL).
+					WithArgs(user.UserId).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}))
+				mock.ExpectRollback()
+			},
+			checkResponse: func(t *testing.T, updated User, resError error) {
+				_, ok := resError.(*erro.ErrNotFound)
+				assert.EqualValues(t, true, ok)
+			},
+		},
 	}
 
 	for i := range testCases {
@@ -231,17 +470,19 @@ func TestUpdateUser(t *testing.T) {
 		t.Run(tc.testName, func(t *testing.T) {
 			ctx := context.Background()
 
-			tc.buildStubs(mock, tc.userData)
+			tc.buildStubs(mock, tc.userData, tc.fields)
 
 			request := User{
 				UserId:  tc.userData.UserId,
 				Name:    tc.userData.Name,
 				PwdHash: tc.userData.PwdHash,
 				Age:     tc.userData.Age,
-				AddInfo: tc.userData.AddInfo,
+				Profile: tc.userData.Profile,
+				Version: tc.userData.Version,
 			}
-			err := repo.UpdateUser(ctx, request)
-			tc.checkResponse(t, err)
+			updated, err := repo.UpdateUser(ctx, request, tc.fields, nil)
+			tc.checkResponse(t, updated, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
 }
@@ -262,6 +503,7 @@ func TestGetUser(t *testing.T) {
 	defer db.Close()
 
 	repo := NewRepo(db, logger)
+	repo.(*SQLRepo).now = func() time.Time { return auditTime }
 
 	testCases := []struct {
 		testName      string
@@ -273,8 +515,8 @@ func TestGetUser(t *testing.T) {
 			testName: "user obtained",
 			userId:   "",
 			buildStubs: func(mock sqlmock.Sqlmock, userId string) {
-				rows := sqlmock.NewRows([]string{"user_id", "name", "age", "additional_information"}).
-					AddRow(user.UserId, user.Name, user.Age, user.AddInfo)
+				rows := sqlmock.NewRows([]string{"user_id", "name", "age", "birth_date", "profile", "email_verified_at", "version", "created_at", "updated_at", "last_login_at"}).
+					AddRow(user.UserId, user.Name, user.Age, "1988-07-09", encodeProfile(user.Profile), nowStamp, 3, "2026-01-01T00:00:00Z", nowStamp, nil)
 
 				mock.ExpectPrepare(getSQL)
 				mock.ExpectQuery(getSQL).
@@ -283,6 +525,12 @@ func TestGetUser(t *testing.T) {
 			},
 			checkResponse: func(t *testing.T, response User, resError error) {
 				assert.NoError(t, resError)
+				assert.Equal(t, int64(3), response.Version)
+				assert.True(t, response.EmailVerified)
+				assert.Equal(t, time.Date(1988, 7, 9, 0, 0, 0, 0, time.UTC), response.BirthDate)
+				assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), response.CreatedAt)
+				assert.Equal(t, auditTime, response.UpdatedAt)
+				assert.True(t, response.LastLoginAt.IsZero())
 			},
 		},
 		{
@@ -313,3 +561,201 @@ func TestGetUser(t *testing.T) {
 		})
 	}
 }
+
+func TestRecordLogin(t *testing.T) {
+	logger := log.NewNopLogger()
+
+	db, mock := NewMock(logger)
+	defer db.Close()
+
+	repo := NewRepo(db, logger)
+	repo.(*SQLRepo).now = func() time.Time { return auditTime }
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		buildStubs    func(mock sqlmock.Sqlmock, userId string)
+		checkResponse func(t *testing.T, resError error)
+	}{
+		{
+			testName: "login recorded",
+			userId:   user.UserId,
+			buildStubs: func(mock sqlmock.Sqlmock, userId string) {
+				mock.ExpectExec(recordLoginSQL).
+					WithArgs(nowStamp, userId).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "user not found",
+			userId:   "missing",
+			buildStubs: func(mock sqlmock.Sqlmock, userId string) {
+				mock.ExpectExec(recordLoginSQL).
+					WithArgs(nowStamp, userId).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.EqualError(t, resError, erro.ErrUserNotFound)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			tc.buildStubs(mock, tc.userId)
+
+			err := repo.RecordLogin(context.Background(), tc.userId)
+			tc.checkResponse(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetAuditLog(t *testing.T) {
+	logger := log.NewNopLogger()
+
+	db, mock := NewMock(logger)
+	defer db.Close()
+
+	repo := NewRepo(db, logger)
+	columns := []string{"id", "actor", "action", "target", "changes", "request_id", "service", "created_at"}
+
+	testCases := []struct {
+		testName      string
+		beforeId      int64
+		buildStubs    func(mock sqlmock.Sqlmock, beforeId int64)
+		checkResponse func(t *testing.T, entries []AuditEntry, resError error)
+	}{
+		{
+			testName: "newest entries",
+			beforeId: 0,
+			buildStubs: func(mock sqlmock.Sqlmock, beforeId int64) {
+				rows := sqlmock.NewRows(columns).
+					AddRow(2, "user:"+user.UserId, ActionUpdateUser, user.UserId, `{"age":"38"}`, "req-2", "rest-service", "2026-01-02T03:04:05Z").
+					AddRow(1, "rest-service", ActionCreateUser, user.UserId, `{"name":"javier"}`, nil, nil, "2026-01-01T03:04:05Z")
+				mock.ExpectQuery(auditLogSQL).
+					WithArgs(user.UserId, int64(math.MaxInt64), 2).
+					WillReturnRows(rows)
+			},
+			checkResponse: func(t *testing.T, entries []AuditEntry, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, []AuditEntry{
+					{
+						Id:        2,
+						Actor:     "user:" + user.UserId,
+						Action:    ActionUpdateUser,
+						Target:    user.UserId,
+						Changes:   map[string]string{"age": "38"},
+						RequestId: "req-2",
+						Service:   "rest-service",
+						CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+					},
+					{
+						Id:        1,
+						Actor:     "rest-service",
+						Action:    ActionCreateUser,
+						Target:    user.UserId,
+						Changes:   map[string]string{"name": "javier"},
+						CreatedAt: time.Date(2026, 1, 1, 3, 4, 5, 0, time.UTC),
+					},
+				}, entries)
+			},
+		},
+		{
+			testName: "entries before an id",
+			beforeId: 2,
+			buildStubs: func(mock sqlmock.Sqlmock, beforeId int64) {
+				mock.ExpectQuery(auditLogSQL).
+					WithArgs(user.UserId, beforeId, 2).
+					WillReturnRows(sqlmock.NewRows(columns))
+			},
+			checkResponse: func(t *testing.T, entries []AuditEntry, resError error) {
+				assert.NoError(t, resError)
+				assert.Empty(t, entries)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			tc.buildStubs(mock, tc.beforeId)
+
+			entries, err := repo.GetAuditLog(context.Background(), user.UserId, tc.beforeId, 2)
+			tc.checkResponse(t, entries, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpdateUserValidatesMergedProfile(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+	tooMany := errors.New("too many metadata entries")
+	atMostTwo := func(profile Profile) error {
+		if len(profile.Metadata) > 2 {
+			return tooMany
+		}
+		return nil
+	}
+
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u1", Name: "javier", PwdHash: "hash", Profile: Profile{Metadata: map[string]string{"a": "1", "b": "2"}}}))
+
+	_, err := repo.UpdateUser(ctx, User{UserId: "u1", Profile: Profile{Metadata: map[string]string{"c": "3"}}}, []string{FieldMetadata + ".c"}, atMostTwo)
+	assert.Equal(t, tooMany, err, "a valid patch can't push the stored profile past the limits")
+	user, err := repo.GetUser(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, user.Profile.Metadata)
+	assert.Equal(t, int64(1), user.Version)
+
+	updated, err := repo.UpdateUser(ctx, User{UserId: "u1", Profile: Profile{Metadata: map[string]string{"c": "3"}}}, []string{FieldMetadata + ".a", FieldMetadata + ".c"}, atMostTwo)
+	require.NoError(t, err, "removing a key makes room for another")
+	assert.Equal(t, map[string]string{"b": "2", "c": "3"}, updated.Profile.Metadata)
+}
+
+func TestAuditActor(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+	authenticator := svcauth.NewAuthenticator(svcauth.Config{
+		APIKeys:   map[string]string{"rest-service": "rest-key"},
+		Allowlist: map[string][]string{"rest-service": {"*"}},
+	})
+
+	// call runs f as a call of the rest-service for principal would run.
+	call := func(principal string, f func(ctx context.Context) error) {
+		md := metadata.Pairs(svcauth.APIKeyMetadataKey, "rest-key", svcauth.PrincipalMetadataKey, principal)
+		info := &grpc.UnaryServerInfo{FullMethod: "/pb.UserService/UpdateUser"}
+		_, err := authenticator.UnaryServerInterceptor()(metadata.NewIncomingContext(ctx, md), nil, info,
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, f(ctx)
+			},
+		)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u1", Name: "javier", PwdHash: "hash"}))
+	call("user:u1", func(ctx context.Context) error {
+		_, err := repo.UpdateUser(ctx, User{UserId: "u1", Name: "javi"}, []string{FieldName}, nil)
+		return err
+	})
+	call("api_key:k1", func(ctx context.Context) error {
+		_, err := repo.UpdateUser(ctx, User{UserId: "u1", Name: "javier"}, []string{FieldName}, nil)
+		return err
+	})
+	// Without service authentication the principal is not trusted.
+	unauthenticated := metadata.NewIncomingContext(ctx, metadata.Pairs(svcauth.PrincipalMetadataKey, "user:admin"))
+	_, err := repo.UpdateUser(unauthenticated, User{UserId: "u1", Name: "javi"}, []string{FieldName}, nil)
+	require.NoError(t, err)
+
+	entries, err := repo.GetAuditLog(ctx, "u1", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	assert.Equal(t, []string{AnonymousActor, "api_key:k1", "user:u1", AnonymousActor}, []string{entries[0].Actor, entries[1].Actor, entries[2].Actor, entries[3].Actor})
+	assert.Equal(t, []string{"", "rest-service", "rest-service", ""}, []string{entries[0].Service, entries[1].Service, entries[2].Service, entries[3].Service})
+}
@@ -0,0 +1,320 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+// TOTP is a user's two-factor authentication secret. It is only required at
+// login once Confirmed.
+type TOTP struct {
+	Secret    string
+	Confirmed bool
+	// LastStep is the time step of the last code accepted, which can't be
+	// used again.
+	LastStep int64
+	// LockedUntil is when codes are accepted again after too many wrong
+	// ones, zero when they are.
+	LockedUntil time.Time
+}
+
+// EnrollTOTP stores a new, unconfirmed secret for the user, replacing any
+// earlier unconfirmed one.
+func (repo *SQLRepo) EnrollTOTP(ctx context.Context, userId, secret string) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "EnrollTOTP")
+
+	_, span := tracing.StartDBSpan(ctx, "INSERT", enrollTOTPSQL)
+	res, err := repo.db.ExecContext(ctx, enrollTOTPSQL, userId, secret)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	if rowCnt == 0 {
+		level.Error(logger).Log("err", erro.ErrTOTPEnabled, "userId", userId)
+		return erro.NewErrFailedPrecondition(erro.ErrTOTPEnabled)
+	}
+
+	return nil
+}
+
+// GetTOTP returns the user's secret, or ErrNotFound when the user never
+// enrolled.
+func (repo *SQLRepo) GetTOTP(ctx context.Context, userId string) (TOTP, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "GetTOTP")
+
+	var factor TOTP
+	var confirmedAt, lockedUntil sql.NullString
+	_, span := tracing.StartDBSpan(ctx, "SELECT", totpSQL)
+	err := repo.db.QueryRowContext(ctx, totpSQL, userId).Scan(&factor.Secret, &confirmedAt, &factor.LastStep, &lockedUntil)
+	if err == nil {
+		factor.LockedUntil, err = parseTime(lockedUntil)
+	}
+	tracing.EndSpan(span, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return TOTP{}, &erro.ErrNotFound{Err: errors.New(erro.ErrTOTPNotEnrolled)}
+		}
+		level.Error(logger).Log("err", err.Error())
+		return TOTP{}, err
+	}
+	factor.Confirmed = confirmedAt.Valid
+
+	return factor, nil
+}
+
+// ConfirmTOTP enables two-factor authentication for the user, accepting the
+// code of step as its first, and replaces the user's recovery codes.
+func (repo *SQLRepo) ConfirmTOTP(ctx context.Context, userId string, step int64, recoveryCodeHashes []string) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "ConfirmTOTP")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	now := formatTime(repo.now())
+	_, span := tracing.StartDBSpan(ctx, "UPDATE", confirmTOTPSQL)
+	res, err := tx.ExecContext(ctx, confirmTOTPSQL, now, step, userId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	if rowCnt == 0 {
+		level.Error(logger).Log("err", erro.ErrTOTPEnabled, "userId", userId)
+		return erro.NewErrFailedPrecondition(erro.ErrTOTPEnabled)
+	}
+
+	_, span = tracing.StartDBSpan(ctx, "DELETE", deleteRecoveryCodesSQL)
+	_, err = tx.ExecContext(ctx, deleteRecoveryCodesSQL, userId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	for _, codeHash := range recoveryCodeHashes {
+		_, span := tracing.StartDBSpan(ctx, "INSERT", insertRecoveryCodeSQL)
+		_, err := tx.ExecContext(ctx, insertRecoveryCodeSQL, codeHash, userId)
+		tracing.EndSpan(span, err)
+		if err != nil {
+			level.Error(logger).Log("err", err.Error())
+			return err
+		}
+	}
+
+	if err := repo.writeAudit(ctx, tx, ActionEnableTOTP, userId, map[string]string{FieldTOTPConfirmedAt: now}); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// UseTOTPStep records that the code of step was accepted, failing with
+// ErrPermissionDenied when a code of that or a later step already was.
+func (repo *SQLRepo) UseTOTPStep(ctx context.Context, userId string, step int64) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "UseTOTPStep")
+
+	_, span := tracing.StartDBSpan(ctx, "UPDATE", useTOTPStepSQL)
+	res, err := repo.db.ExecContext(ctx, useTOTPStepSQL, step, userId, step)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	if rowCnt == 0 {
+		level.Error(logger).Log("err", erro.ErrWrongCode, "userId", userId, "step", step)
+		return erro.NewErrPermissionDenied(erro.ErrWrongCode)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode spends one of the user's recovery codes, failing with
+// ErrPermissionDenied when it is not one or was already used.
+func (repo *SQLRepo) UseRecoveryCode(ctx context.Context, userId, codeHash string) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "UseRecoveryCode")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	_, span := tracing.StartDBSpan(ctx, "UPDATE", useRecoveryCodeSQL)
+	res, err := tx.ExecContext(ctx, useRecoveryCodeSQL, formatTime(repo.now()), codeHash, userId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	if rowCnt == 0 {
+		level.Error(logger).Log("err", erro.ErrWrongCode, "userId", userId)
+		return erro.NewErrPermissionDenied(erro.ErrWrongCode)
+	}
+
+	_, span = tracing.StartDBSpan(ctx, "UPDATE", resetTOTPFailuresSQL)
+	_, err = tx.ExecContext(ctx, resetTOTPFailuresSQL, userId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	if err := repo.writeAudit(ctx, tx, ActionUseRecovery, userId, map[string]string{}); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// RecordTOTPFailure counts a wrong code of the user. The maxFailures-th in a
+// row locks the user's codes for lockout and starts the count over. Accepted
+// codes reset the count.
+func (repo *SQLRepo) RecordTOTPFailure(ctx context.Context, userId string, maxFailures int, lockout time.Duration) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "RecordTOTPFailure")
+
+	lockedUntil := formatTime(repo.now().Add(lockout))
+	_, span := tracing.StartDBSpan(ctx, "UPDATE", totpFailureSQL)
+	_, err := repo.db.ExecContext(ctx, totpFailureSQL, maxFailures, maxFailures, lockedUntil, userId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// CreateAuthChallenge stores the hash of a token that completes the user's
+// login until ttl from now, dropping the challenges that expired.
+func (repo *SQLRepo) CreateAuthChallenge(ctx context.Context, userId, tokenHash string, ttl time.Duration) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "CreateAuthChallenge")
+
+	now := repo.now()
+	_, span := tracing.StartDBSpan(ctx, "DELETE", deleteExpiredChallengesSQL)
+	_, err := repo.db.ExecContext(ctx, deleteExpiredChallengesSQL, formatTime(now))
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	_, span = tracing.StartDBSpan(ctx, "INSERT", insertChallengeSQL)
+	_, err = repo.db.ExecContext(ctx, insertChallengeSQL, tokenHash, userId, formatTime(now.Add(ttl)))
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// AttemptAuthChallenge counts an attempt to complete a challenge and returns
+// the id of its user. It fails with ErrPermissionDenied once the challenge
+// expired or was attempted maxAttempts times.
+func (repo *SQLRepo) AttemptAuthChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error) {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "AttemptAuthChallenge")
+
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, span := tracing.StartDBSpan(ctx, "UPDATE", attemptChallengeSQL)
+	res, err := tx.ExecContext(ctx, attemptChallengeSQL, tokenHash, formatTime(repo.now()), maxAttempts)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+	rowCnt, err := res.RowsAffected()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+	if rowCnt == 0 {
+		level.Error(logger).Log("err", erro.ErrInvalidChallenge)
+		return "", erro.NewErrPermissionDenied(erro.ErrInvalidChallenge)
+	}
+
+	var userId string
+	_, span = tracing.StartDBSpan(ctx, "SELECT", challengeUserSQL)
+	err = tx.QueryRowContext(ctx, challengeUserSQL, tokenHash).Scan(&userId)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+
+	return userId, nil
+}
+
+// DeleteAuthChallenge drops a completed challenge.
+func (repo *SQLRepo) DeleteAuthChallenge(ctx context.Context, tokenHash string) error {
+	logger := log.With(logging.WithContext(ctx, repo.logger), "method", "DeleteAuthChallenge")
+
+	_, span := tracing.StartDBSpan(ctx, "DELETE", deleteChallengeSQL)
+	_, err := repo.db.ExecContext(ctx, deleteChallengeSQL, tokenHash)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
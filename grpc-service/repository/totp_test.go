@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+)
+
+const challengeTTL = 5 * time.Minute
+
+func TestTOTPEnrollment(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	require.NoError(t, repo.CreateUser(ctx, User{UserId: "u1", Name: "javier", PwdHash: "hash"}))
+
+	_, err := repo.GetTOTP(ctx, "u1")
+	assert.IsType(t, &erro.ErrNotFound{}, err)
+	assert.EqualError(t, err, erro.ErrTOTPNotEnrolled)
+
+	require.NoError(t, repo.EnrollTOTP(ctx, "u1", "SECRET1"))
+	require.NoError(t, repo.EnrollTOTP(ctx, "u1", "SECRET2"), "an unconfirmed secret can be replaced")
+	factor, err := repo.GetTOTP(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, TOTP{Secret: "SECRET2"}, factor)
+
+	require.NoError(t, repo.ConfirmTOTP(ctx, "u1", 100, []string{"code-1", "code-2"}))
+	factor, err = repo.GetTOTP(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, TOTP{Secret: "SECRET2", Confirmed: true, LastStep: 100}, factor)
+
+	err = repo.EnrollTOTP(ctx, "u1", "SECRET3")
+	assert.IsType(t, &erro.ErrFailedPrecondition{}, err, "a confirmed secret is kept")
+	assert.EqualError(t, err, erro.ErrTOTPEnabled)
+	err = repo.ConfirmTOTP(ctx, "u1", 101, nil)
+	assert.IsType(t, &erro.ErrFailedPrecondition{}, err)
+
+	entries, err := repo.GetAuditLog(ctx, "u1", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, ActionEnableTOTP, entries[0].Action)
+	assert.Equal(t, map[string]string{FieldTOTPConfirmedAt: formatTime(now)}, entries[0].Changes)
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	require.NoError(t, repo.EnrollTOTP(ctx, "u1", "SECRET"))
+	assert.IsType(t, &erro.ErrPermissionDenied{}, repo.UseTOTPStep(ctx, "u1", 101), "unconfirmed secrets can't log in")
+
+	require.NoError(t, repo.ConfirmTOTP(ctx, "u1", 100, nil))
+	assert.IsType(t, &erro.ErrPermissionDenied{}, repo.UseTOTPStep(ctx, "u1", 100), "the confirmation code can't be reused")
+	require.NoError(t, repo.UseTOTPStep(ctx, "u1", 101))
+	assert.IsType(t, &erro.ErrPermissionDenied{}, repo.UseTOTPStep(ctx, "u1", 101))
+	assert.IsType(t, &erro.ErrPermissionDenied{}, repo.UseTOTPStep(ctx, "u1", 100), "earlier steps can't be used either")
+	assert.IsType(t, &erro.ErrPermissionDenied{}, repo.UseTOTPStep(ctx, "u2", 102))
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	require.NoError(t, repo.EnrollTOTP(ctx, "u1", "SECRET"))
+	require.NoError(t, repo.ConfirmTOTP(ctx, "u1", 100, []string{"code-1", "code-2"}))
+	require.NoError(t, repo.EnrollTOTP(ctx, "u2", "SECRET"))
+	require.NoError(t, repo.ConfirmTOTP(ctx, "u2", 100, []string{"code-3"}))
+
+	require.NoError(t, repo.UseRecoveryCode(ctx, "u1", "code-1"))
+	assert.IsType(t, &erro.ErrPermissionDenied{}, repo.UseRecoveryCode(ctx, "u1", "code-1"), "codes are single use")
+	assert.IsType(t, &erro.ErrPermissionDenied{}, repo.UseRecoveryCode(ctx, "u1", "code-3"), "other users' codes are rejected")
+	assert.IsType(t, &erro.ErrPermissionDenied{}, repo.UseRecoveryCode(ctx, "u1", "unknown"))
+	require.NoError(t, repo.UseRecoveryCode(ctx, "u1", "code-2"))
+
+	entries, err := repo.GetAuditLog(ctx, "u1", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, ActionUseRecovery, entries[0].Action)
+	assert.Equal(t, ActionUseRecovery, entries[1].Action)
+}
+
+func TestAuthChallenge(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	db, repo := newSQLiteRepo(t, &now)
+
+	require.NoError(t, repo.CreateAuthChallenge(ctx, "u1", "expiring", challengeTTL))
+	require.NoError(t, repo.CreateAuthChallenge(ctx, "u1", "limited", challengeTTL))
+
+	for i := 0; i < 3; i++ {
+		userId, err := repo.AttemptAuthChallenge(ctx, "limited", 3)
+		require.NoError(t, err)
+		assert.Equal(t, "u1", userId)
+	}
+	_, err := repo.AttemptAuthChallenge(ctx, "limited", 3)
+	assert.IsType(t, &erro.ErrPermissionDenied{}, err, "attempts are limited")
+	assert.EqualError(t, err, erro.ErrInvalidChallenge)
+
+	_, err = repo.AttemptAuthChallenge(ctx, "unknown", 3)
+	assert.IsType(t, &erro.ErrPermissionDenied{}, err)
+
+	now = now.Add(challengeTTL)
+	_, err = repo.AttemptAuthChallenge(ctx, "expiring", 3)
+	assert.IsType(t, &erro.ErrPermissionDenied{}, err, "challenges expire")
+
+	require.NoError(t, repo.CreateAuthChallenge(ctx, "u1", "completed", challengeTTL))
+	var count int
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM auth_challenges").Scan(&count))
+	assert.Equal(t, 1, count, "expired challenges are dropped")
+
+	_, err = repo.AttemptAuthChallenge(ctx, "completed", 3)
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteAuthChallenge(ctx, "completed"))
+	_, err = repo.AttemptAuthChallenge(ctx, "completed", 3)
+	assert.IsType(t, &erro.ErrPermissionDenied{}, err, "completed challenges can't be reused")
+}
+
+func TestRecordTOTPFailure(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, repo := newSQLiteRepo(t, &now)
+
+	require.NoError(t, repo.EnrollTOTP(ctx, "u1", "SECRET"))
+	require.NoError(t, repo.ConfirmTOTP(ctx, "u1", 100, []string{"code-1"}))
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, repo.RecordTOTPFailure(ctx, "u1", 3, time.Minute))
+	}
+	require.NoError(t, repo.UseTOTPStep(ctx, "u1", 101))
+	require.NoError(t, repo.RecordTOTPFailure(ctx, "u1", 3, time.Minute))
+	factor, err := repo.GetTOTP(ctx, "u1")
+	require.NoError(t, err)
+	assert.True(t, factor.LockedUntil.IsZero(), "accepted codes start the count over")
+
+	require.NoError(t, repo.RecordTOTPFailure(ctx, "u1", 3, time.Minute))
+	require.NoError(t, repo.UseRecoveryCode(ctx, "u1", "code-1"))
+	for i := 0; i < 2; i++ {
+		require.NoError(t, repo.RecordTOTPFailure(ctx, "u1", 3, time.Minute))
+	}
+	factor, err = repo.GetTOTP(ctx, "u1")
+	require.NoError(t, err)
+	assert.True(t, factor.LockedUntil.IsZero(), "recovery codes start the count over")
+
+	require.NoError(t, repo.RecordTOTPFailure(ctx, "u1", 3, time.Minute))
+	factor, err = repo.GetTOTP(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Minute), factor.LockedUntil)
+}
@@ -0,0 +1,292 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+type Middleware func(Repository) Repository
+
+type tracingMiddleware struct {
+	next Repository
+}
+
+func TracingMiddleware() Middleware {
+	return func(next Repository) Repository {
+		return &tracingMiddleware{next: next}
+	}
+}
+
+func (mw *tracingMiddleware) Authenticate(ctx context.Context, userName string) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.Authenticate")
+	user, err := mw.next.Authenticate(ctx, userName)
+	tracing.EndSpan(span, err)
+
+	return user, err
+}
+
+func (mw *tracingMiddleware) AuthenticateByEmail(ctx context.Context, email string) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.AuthenticateByEmail")
+	user, err := mw.next.AuthenticateByEmail(ctx, email)
+	tracing.EndSpan(span, err)
+
+	return user, err
+}
+
+func (mw *tracingMiddleware) CreateUser(ctx context.Context, user User) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.CreateUser")
+	err := mw.next.CreateUser(ctx, user)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) UpdateUser(ctx context.Context, user User, fields []string, validate func(Profile) error) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.UpdateUser")
+	res, err := mw.next.UpdateUser(ctx, user, fields, validate)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) GetUser(ctx context.Context, userId string) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.GetUser")
+	user, err := mw.next.GetUser(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return user, err
+}
+
+func (mw *tracingMiddleware) GetAuditLog(ctx context.Context, target string, beforeId int64, limit int) ([]AuditEntry, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.GetAuditLog")
+	entries, err := mw.next.GetAuditLog(ctx, target, beforeId, limit)
+	tracing.EndSpan(span, err)
+
+	return entries, err
+}
+
+func (mw *tracingMiddleware) RecordLogin(ctx context.Context, userId string) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.RecordLogin")
+	err := mw.next.RecordLogin(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) DeleteUser(ctx context.Context, userId string) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.DeleteUser")
+	err := mw.next.DeleteUser(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) RestoreUser(ctx context.Context, userId string, gracePeriod time.Duration) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.RestoreUser")
+	err := mw.next.RestoreUser(ctx, userId, gracePeriod)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) PurgeUsers(ctx context.Context, gracePeriod time.Duration) (int, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.PurgeUsers")
+	purged, err := mw.next.PurgeUsers(ctx, gracePeriod)
+	tracing.EndSpan(span, err)
+
+	return purged, err
+}
+
+func (mw *tracingMiddleware) CreateEmailVerification(ctx context.Context, userId, email, tokenHash string, ttl time.Duration) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.CreateEmailVerification")
+	err := mw.next.CreateEmailVerification(ctx, userId, email, tokenHash, ttl)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) VerifyEmail(ctx context.Context, tokenHash string) (string, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.VerifyEmail")
+	userId, err := mw.next.VerifyEmail(ctx, tokenHash)
+	tracing.EndSpan(span, err)
+
+	return userId, err
+}
+
+func (mw *tracingMiddleware) FindUsersByAge(ctx context.Context, min, max uint32) ([]User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.FindUsersByAge")
+	users, err := mw.next.FindUsersByAge(ctx, min, max)
+	tracing.EndSpan(span, err)
+
+	return users, err
+}
+
+func (mw *tracingMiddleware) EnrollTOTP(ctx context.Context, userId, secret string) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.EnrollTOTP")
+	err := mw.next.EnrollTOTP(ctx, userId, secret)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) GetTOTP(ctx context.Context, userId string) (TOTP, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.GetTOTP")
+	factor, err := mw.next.GetTOTP(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return factor, err
+}
+
+func (mw *tracingMiddleware) ConfirmTOTP(ctx context.Context, userId string, step int64, recoveryCodeHashes []string) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.ConfirmTOTP")
+	err := mw.next.ConfirmTOTP(ctx, userId, step, recoveryCodeHashes)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) UseTOTPStep(ctx context.Context, userId string, step int64) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.UseTOTPStep")
+	err := mw.next.UseTOTPStep(ctx, userId, step)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) UseRecoveryCode(ctx context.Context, userId, codeHash string) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.UseRecoveryCode")
+	err := mw.next.UseRecoveryCode(ctx, userId, codeHash)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) RecordTOTPFailure(ctx context.Context, userId string, maxFailures int, lockout time.Duration) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.RecordTOTPFailure")
+	err := mw.next.RecordTOTPFailure(ctx, userId, maxFailures, lockout)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) CreateAuthChallenge(ctx context.Context, userId, tokenHash string, ttl time.Duration) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.CreateAuthChallenge")
+	err := mw.next.CreateAuthChallenge(ctx, userId, tokenHash, ttl)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) AttemptAuthChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.AttemptAuthChallenge")
+	userId, err := mw.next.AttemptAuthChallenge(ctx, tokenHash, maxAttempts)
+	tracing.EndSpan(span, err)
+
+	return userId, err
+}
+
+func (mw *tracingMiddleware) DeleteAuthChallenge(ctx context.Context, tokenHash string) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.DeleteAuthChallenge")
+	err := mw.next.DeleteAuthChallenge(ctx, tokenHash)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.CreateAPIKey")
+	key, err := mw.next.CreateAPIKey(ctx, key)
+	tracing.EndSpan(span, err)
+
+	return key, err
+}
+
+func (mw *tracingMiddleware) ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.ListAPIKeys")
+	keys, err := mw.next.ListAPIKeys(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return keys, err
+}
+
+func (mw *tracingMiddleware) GetAPIKey(ctx context.Context, keyId string) (APIKey, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.GetAPIKey")
+	key, err := mw.next.GetAPIKey(ctx, keyId)
+	tracing.EndSpan(span, err)
+
+	return key, err
+}
+
+func (mw *tracingMiddleware) RecordAPIKeyUse(ctx context.Context, keyId string) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.RecordAPIKeyUse")
+	err := mw.next.RecordAPIKeyUse(ctx, keyId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) RevokeAPIKey(ctx context.Context, userId, keyId string) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.RevokeAPIKey")
+	err := mw.next.RevokeAPIKey(ctx, userId, keyId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) CreateOAuthClient(ctx context.Context, client OAuthClient) (OAuthClient, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.CreateOAuthClient")
+	client, err := mw.next.CreateOAuthClient(ctx, client)
+	tracing.EndSpan(span, err)
+
+	return client, err
+}
+
+func (mw *tracingMiddleware) GetOAuthClient(ctx context.Context, clientId string) (OAuthClient, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.GetOAuthClient")
+	client, err := mw.next.GetOAuthClient(ctx, clientId)
+	tracing.EndSpan(span, err)
+
+	return client, err
+}
+
+func (mw *tracingMiddleware) CreateAuthorizationCode(ctx context.Context, code AuthorizationCode, ttl time.Duration) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.CreateAuthorizationCode")
+	err := mw.next.CreateAuthorizationCode(ctx, code, ttl)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (AuthorizationCode, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.ConsumeAuthorizationCode")
+	code, err := mw.next.ConsumeAuthorizationCode(ctx, codeHash)
+	tracing.EndSpan(span, err)
+
+	return code, err
+}
+
+func (mw *tracingMiddleware) ProvisionIdentity(ctx context.Context, user User, identity LinkedIdentity) (LinkedIdentity, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.ProvisionIdentity")
+	identity, err := mw.next.ProvisionIdentity(ctx, user, identity)
+	tracing.EndSpan(span, err)
+
+	return identity, err
+}
+
+func (mw *tracingMiddleware) LinkIdentity(ctx context.Context, identity LinkedIdentity) (LinkedIdentity, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.LinkIdentity")
+	identity, err := mw.next.LinkIdentity(ctx, identity)
+	tracing.EndSpan(span, err)
+
+	return identity, err
+}
+
+func (mw *tracingMiddleware) GetLinkedIdentity(ctx context.Context, issuer, subject string) (LinkedIdentity, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.GetLinkedIdentity")
+	identity, err := mw.next.GetLinkedIdentity(ctx, issuer, subject)
+	tracing.EndSpan(span, err)
+
+	return identity, err
+}
@@ -2,21 +2,28 @@ package service
 
 import (
 	"context"
-	"database/sql"
+	"encoding/base64"
 	"errors"
+	"strconv"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"golang.org/x/crypto/bcrypt"
 
 	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
 	"github.com/javibauza/final-project/grpc-service/repository"
 	"github.com/javibauza/final-project/grpc-service/utils"
 )
 
 type service struct {
-	repository repository.Repository
-	logger     log.Logger
+	repository  repository.Repository
+	gracePeriod time.Duration
+	email       EmailConfig
+	totp        TOTPConfig
+	logger      log.Logger
+	now         func() time.Time
 }
 
 type AuthRequest struct {
@@ -24,131 +31,473 @@ type AuthRequest struct {
 	Pwd  string
 }
 
+// AuthResponse has the UserId of the logged in user, or a Challenge to
+// complete with CompleteAuthChallenge when the user has two-factor
+// authentication enabled.
+type AuthResponse struct {
+	UserId    string
+	Challenge string
+}
+
+// Birth dates are in the repository.DateLayout format, or empty when unknown.
+// Ages in responses are computed from the birth date when it is known.
+
 type CreateUserRequest struct {
-	Pwd     string
-	Name    string
-	Age     uint32
-	AddInfo string
+	Pwd       string
+	Name      string
+	Age       uint32
+	BirthDate string
+	Profile   Profile
 }
 
 type CreateUserResponse struct {
-	UserId string
+	UserId    string
+	Name      string
+	Age       uint32
+	BirthDate string
+	Profile   Profile
+	Version   int64
 }
 
+// Update mask paths, named after the UpdateUserRequest proto fields.
+const (
+	FieldUserName = "user_name"
+	FieldPassword = "password"
+	FieldUserAge  = "user_age"
+)
+
 type UpdateUserRequest struct {
-	UserId  string
-	Name    string
-	Pwd     string
-	Age     uint32
-	AddInfo string
+	UserId          string
+	Name            string
+	Pwd             string
+	Age             uint32
+	BirthDate       string
+	Profile         Profile
+	ExpectedVersion int64
+	// Fields lists the fields to write. When empty, every non-empty field
+	// is written.
+	Fields []string
+}
+
+type UpdateUserResponse struct {
+	UserId        string
+	Name          string
+	Age           uint32
+	BirthDate     string
+	Profile       Profile
+	EmailVerified bool
+	Version       int64
 }
 
 type GetUserResponse struct {
-	UserId  string
-	Name    string
-	Age     uint32
-	AddInfo string
+	UserId        string
+	Name          string
+	Age           uint32
+	BirthDate     string
+	Profile       Profile
+	EmailVerified bool
+	Version       int64
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	LastLoginAt   time.Time
+}
+
+// Audit log page sizes.
+const (
+	DefaultAuditPageSize = 20
+	MaxAuditPageSize     = 100
+)
+
+type GetAuditLogRequest struct {
+	UserId    string
+	PageSize  int32
+	PageToken string
+}
+
+type AuditEntry struct {
+	Id        int64
+	Actor     string
+	Action    string
+	Target    string
+	Changes   map[string]string
+	RequestId string
+	Service   string
+	CreatedAt time.Time
+}
+
+type GetAuditLogResponse struct {
+	Entries       []AuditEntry
+	NextPageToken string
 }
 
 type Service interface {
-	Authenticate(ctx context.Context, req AuthRequest) (string, error)
+	Authenticate(ctx context.Context, req AuthRequest) (AuthResponse, error)
+	CompleteAuthChallenge(ctx context.Context, req CompleteAuthChallengeRequest) (AuthResponse, error)
 	CreateUser(ctx context.Context, req CreateUserRequest) (CreateUserResponse, error)
-	UpdateUser(ctx context.Context, req UpdateUserRequest) error
+	UpdateUser(ctx context.Context, req UpdateUserRequest) (UpdateUserResponse, error)
 	GetUser(ctx context.Context, userId string) (GetUserResponse, error)
+	GetAuditLog(ctx context.Context, req GetAuditLogRequest) (GetAuditLogResponse, error)
+	DeleteUser(ctx context.Context, userId string) error
+	RestoreUser(ctx context.Context, userId string) (GetUserResponse, error)
+	SendVerification(ctx context.Context, userId string) error
+	VerifyEmail(ctx context.Context, token string) (GetUserResponse, error)
+	EnrollTOTP(ctx context.Context, userId string) (EnrollTOTPResponse, error)
+	ConfirmTOTP(ctx context.Context, req ConfirmTOTPRequest) (ConfirmTOTPResponse, error)
+	CreateAPIKey(ctx context.Context, req CreateAPIKeyRequest) (CreateAPIKeyResponse, error)
+	ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, userId, keyId string) error
+	AuthenticateAPIKey(ctx context.Context, key string) (AuthenticateAPIKeyResponse, error)
+	CreateOAuthClient(ctx context.Context, req CreateOAuthClientRequest) (CreateOAuthClientResponse, error)
+	GetOAuthClient(ctx context.Context, clientId string) (OAuthClient, error)
+	CreateAuthorizationCode(ctx context.Context, req CreateAuthorizationCodeRequest) (string, error)
+	ExchangeAuthorizationCode(ctx context.Context, req ExchangeAuthorizationCodeRequest) (ExchangeAuthorizationCodeResponse, error)
+	LinkIdentity(ctx context.Context, req LinkIdentityRequest) error
+	ProvisionIdentity(ctx context.Context, req ProvisionIdentityRequest) (string, error)
+	AuthenticateIdentity(ctx context.Context, issuer, subject string) (AuthResponse, error)
 }
 
-func NewService(rep repository.Repository, logger log.Logger) Service {
+// NewService returns the user service. Deleted users can be restored for
+// gracePeriod, after which the purger removes them.
+func NewService(rep repository.Repository, gracePeriod time.Duration, email EmailConfig, totp TOTPConfig, logger log.Logger) Service {
 	return &service{
-		repository: rep,
-		logger:     logger,
+		repository:  rep,
+		gracePeriod: gracePeriod,
+		email:       email,
+		totp:        totp,
+		logger:      logger,
+		now:         time.Now,
 	}
 }
 
-func (s service) Authenticate(ctx context.Context, req AuthRequest) (string, error) {
-	logger := log.With(s.logger, "method", "Authenticate")
+// Authenticate checks the user's password. Users with two-factor
+// authentication enabled are only logged in once they complete the
+// challenge returned.
+func (s service) Authenticate(ctx context.Context, req AuthRequest) (AuthResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "Authenticate")
 
 	if req.Name == "" || req.Pwd == "" {
 		level.Error(logger).Log("err", erro.ErrRequiredFields("name", "password"))
-		return "", erro.NewErrInvalidArgument(erro.ErrRequiredFields("name", "password"))
+		return AuthResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("name", "password"))
 	}
 
 	res, err := s.repository.Authenticate(ctx, req.Name)
+	if _, notFound := err.(*erro.ErrNotFound); notFound && isEmail(req.Name) {
+		res, err = s.repository.AuthenticateByEmail(ctx, req.Name)
+	}
 	if err != nil {
 		level.Error(logger).Log("err", err.Error())
-		return "", err
+		return AuthResponse{}, err
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(res.PwdHash), []byte(req.Pwd)); err != nil {
 		level.Error(logger).Log("err", erro.ErrWrongPassword)
-		return "", &erro.ErrPermissionDenied{Err: errors.New(erro.ErrWrongPassword)}
+		return AuthResponse{}, &erro.ErrPermissionDenied{Err: errors.New(erro.ErrWrongPassword)}
 	}
 
-	return res.UserId, nil
+	factor, err := s.repository.GetTOTP(ctx, res.UserId)
+	if _, notFound := err.(*erro.ErrNotFound); !notFound && err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, err
+	}
+	if factor.Confirmed {
+		challenge, err := s.challenge(ctx, res.UserId)
+		if err != nil {
+			level.Error(logger).Log("err", err.Error())
+		}
+		return challenge, err
+	}
+
+	if err := s.repository.RecordLogin(ctx, res.UserId); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, err
+	}
+
+	return AuthResponse{UserId: res.UserId}, nil
 }
 
 func (s service) CreateUser(ctx context.Context, req CreateUserRequest) (response CreateUserResponse, err error) {
-	logger := log.With(s.logger, "method", "CreateUser")
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "CreateUser")
 
-	if req.Name == "" || req.Pwd == "" {
-		level.Error(logger).Log("err", erro.ErrRequiredFields("name", "password"))
-		return CreateUserResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("name", "password"))
+	if req.Name != "" {
+		if err := validateName(req.Name); err != nil {
+			level.Error(logger).Log("err", err.Error())
+			return CreateUserResponse{}, err
+		}
 	}
-
-	userId := utils.RandomString(12)
-	pwdHash, err := utils.HashPassword(req.Pwd)
+	user, err := s.newUser(req)
 	if err != nil {
 		level.Error(logger).Log("err", err.Error())
 		return CreateUserResponse{}, err
 	}
 
-	err = s.repository.CreateUser(ctx, repository.User{
-		UserId:  userId,
-		PwdHash: pwdHash,
-		Name:    req.Name,
-		Age:     req.Age,
-		AddInfo: sql.NullString{String: req.AddInfo, Valid: true},
-	})
+	err = s.repository.CreateUser(ctx, user)
 	if err != nil {
 		level.Error(logger).Log("err", err.Error())
 		return CreateUserResponse{}, err
 	}
 
-	return CreateUserResponse{UserId: userId}, nil
+	return CreateUserResponse{
+		UserId:    user.UserId,
+		Name:      user.Name,
+		Age:       s.age(user),
+		BirthDate: formatBirthDate(user.BirthDate),
+		Profile:   user.Profile,
+		Version:   1,
+	}, nil
+}
+
+// newUser validates req and builds the user it creates. Federated users are
+// built here too, so they pass the same checks as any other user.
+func (s service) newUser(req CreateUserRequest) (repository.User, error) {
+	if req.Name == "" || req.Pwd == "" {
+		return repository.User{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("name", "password"))
+	}
+	if err := validateProfile(req.Profile); err != nil {
+		return repository.User{}, err
+	}
+	birthDate, err := parseBirthDate(req.BirthDate, s.now())
+	if err != nil {
+		return repository.User{}, err
+	}
+
+	pwdHash, err := utils.HashPassword(req.Pwd)
+	if err != nil {
+		return repository.User{}, err
+	}
+
+	return repository.User{
+		UserId:    utils.RandomString(12),
+		PwdHash:   pwdHash,
+		Name:      req.Name,
+		Age:       req.Age,
+		BirthDate: birthDate,
+		Profile:   req.Profile,
+	}, nil
 }
 
-func (s service) UpdateUser(ctx context.Context, req UpdateUserRequest) error {
-	logger := log.With(s.logger, "method", "UpdateUser")
-	user := repository.User{}
+func (s service) UpdateUser(ctx context.Context, req UpdateUserRequest) (UpdateUserResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "UpdateUser")
 
 	if req.UserId == "" {
 		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
-		return erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId"))
+		return UpdateUserResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId"))
+	}
+	if err := validateProfile(req.Profile); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return UpdateUserResponse{}, err
+	}
+
+	paths := req.Fields
+	if len(paths) == 0 {
+		paths = nonEmptyFields(req)
 	}
-	if req.Pwd == "" && req.Age <= 0 && req.AddInfo == "" && req.Name == "" {
+	if len(paths) == 0 {
 		level.Error(logger).Log("err", erro.ErrNoFieldsForUpdate)
-		return erro.NewErrInvalidArgument(erro.ErrNoFieldsForUpdate)
+		return UpdateUserResponse{}, erro.NewErrInvalidArgument(erro.ErrNoFieldsForUpdate)
 	}
 
-	user.UserId = req.UserId
-	user.Name = req.Name
+	user := repository.User{
+		UserId:  req.UserId,
+		Version: req.ExpectedVersion,
+	}
+	var fields []string
+	for _, path := range paths {
+		switch path {
+		case FieldUserName:
+			if req.Name == "" {
+				level.Error(logger).Log("err", erro.ErrRequiredFields(FieldUserName))
+				return UpdateUserResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields(FieldUserName))
+			}
+			if err := validateName(req.Name); err != nil {
+				level.Error(logger).Log("err", err.Error())
+				return UpdateUserResponse{}, err
+			}
+			user.Name = req.Name
+			fields = append(fields, repository.FieldName)
+		case FieldPassword:
+			if req.Pwd == "" {
+				level.Error(logger).Log("err", erro.ErrRequiredFields(FieldPassword))
+				return UpdateUserResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields(FieldPassword))
+			}
+			pwdHash, err := utils.HashPassword(req.Pwd)
+			if err != nil {
+				level.Error(logger).Log("err", err.Error())
+				return UpdateUserResponse{}, err
+			}
+			user.PwdHash = pwdHash
+			fields = append(fields, repository.FieldPwdHash)
+		case FieldUserAge:
+			user.Age = req.Age
+			fields = append(fields, repository.FieldAge)
+		case FieldBirthDate:
+			birthDate, err := parseBirthDate(req.BirthDate, s.now())
+			if err != nil {
+				level.Error(logger).Log("err", err.Error())
+				return UpdateUserResponse{}, err
+			}
+			user.BirthDate = birthDate
+			fields = append(fields, repository.FieldBirthDate)
+		default:
+			field, ok := profileField(path)
+			if !ok {
+				level.Error(logger).Log("err", erro.ErrUnknownUpdateField(path))
+				return UpdateUserResponse{}, erro.NewErrInvalidArgument(erro.ErrUnknownUpdateField(path))
+			}
+			user.Profile = req.Profile
+			fields = append(fields, field)
+		}
+	}
+
+	updated, err := s.repository.UpdateUser(ctx, user, fields, validateProfile)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return UpdateUserResponse{}, err
+	}
+
+	return UpdateUserResponse{
+		UserId:        updated.UserId,
+		Name:          updated.Name,
+		Age:           s.age(updated),
+		BirthDate:     formatBirthDate(updated.BirthDate),
+		Profile:       updated.Profile,
+		EmailVerified: updated.EmailVerified,
+		Version:       updated.Version,
+	}, nil
+}
 
+// nonEmptyFields is the update mask of a request without one: every field
+// that is set.
+func nonEmptyFields(req UpdateUserRequest) []string {
+	var paths []string
+	if req.Name != "" {
+		paths = append(paths, FieldUserName)
+	}
 	if req.Pwd != "" {
-		pwdHash, err := utils.HashPassword(req.Pwd)
-		if err != nil {
-			level.Error(logger).Log("err", err.Error())
-			return err
-		}
-		user.PwdHash = pwdHash
+		paths = append(paths, FieldPassword)
 	}
 	if req.Age > 0 {
-		user.Age = req.Age
+		paths = append(paths, FieldUserAge)
 	}
-	if req.AddInfo != "" {
-		user.AddInfo = sql.NullString{String: req.AddInfo}
+	if req.BirthDate != "" {
+		paths = append(paths, FieldBirthDate)
+	}
+	return append(paths, nonEmptyProfileFields(req.Profile)...)
+}
+
+func (s service) GetUser(ctx context.Context, userId string) (GetUserResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "GetUser")
+
+	if userId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return GetUserResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId"))
 	}
 
-	err := s.repository.UpdateUser(ctx, user)
+	user, err := s.repository.GetUser(ctx, userId)
 	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return GetUserResponse{}, err
+	}
+
+	return GetUserResponse{
+		UserId:        user.UserId,
+		Name:          user.Name,
+		Age:           s.age(user),
+		BirthDate:     formatBirthDate(user.BirthDate),
+		Profile:       user.Profile,
+		EmailVerified: user.EmailVerified,
+		Version:       user.Version,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		LastLoginAt:   user.LastLoginAt,
+	}, nil
+}
+
+func (s service) GetAuditLog(ctx context.Context, req GetAuditLogRequest) (GetAuditLogResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "GetAuditLog")
+
+	if req.UserId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return GetAuditLogResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId"))
+	}
+
+	pageSize := int(req.PageSize)
+	switch {
+	case pageSize < 0:
+		level.Error(logger).Log("err", erro.ErrInvalidPageSize)
+		return GetAuditLogResponse{}, erro.NewErrInvalidArgument(erro.ErrInvalidPageSize)
+	case pageSize == 0:
+		pageSize = DefaultAuditPageSize
+	case pageSize > MaxAuditPageSize:
+		pageSize = MaxAuditPageSize
+	}
+
+	beforeId, err := decodePageToken(req.PageToken)
+	if err != nil {
+		level.Error(logger).Log("err", erro.ErrInvalidPageToken)
+		return GetAuditLogResponse{}, erro.NewErrInvalidArgument(erro.ErrInvalidPageToken)
+	}
+
+	// One extra entry tells whether there is a next page.
+	entries, err := s.repository.GetAuditLog(ctx, req.UserId, beforeId, pageSize+1)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return GetAuditLogResponse{}, err
+	}
+
+	var res GetAuditLogResponse
+	if len(entries) > pageSize {
+		entries = entries[:pageSize]
+		res.NextPageToken = encodePageToken(entries[pageSize-1].Id)
+	}
+	for _, entry := range entries {
+		res.Entries = append(res.Entries, AuditEntry{
+			Id:        entry.Id,
+			Actor:     entry.Actor,
+			Action:    entry.Action,
+			Target:    entry.Target,
+			Changes:   entry.Changes,
+			RequestId: entry.RequestId,
+			Service:   entry.Service,
+			CreatedAt: entry.CreatedAt,
+		})
+	}
+
+	return res, nil
+}
+
+// Page tokens are opaque to clients; they hold the id of the last entry
+// returned.
+func encodePageToken(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodePageToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(string(decoded), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if id < 1 {
+		return 0, errors.New(erro.ErrInvalidPageToken)
+	}
+	return id, nil
+}
+
+func (s service) DeleteUser(ctx context.Context, userId string) error {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "DeleteUser")
+
+	if userId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId"))
+	}
+
+	if err := s.repository.DeleteUser(ctx, userId); err != nil {
 		level.Error(logger).Log("err", err.Error())
 		return err
 	}
@@ -156,24 +505,18 @@ func (s service) UpdateUser(ctx context.Context, req UpdateUserRequest) error {
 	return nil
 }
 
-func (s service) GetUser(ctx context.Context, userId string) (GetUserResponse, error) {
-	logger := log.With(s.logger, "method", "GetUser")
+func (s service) RestoreUser(ctx context.Context, userId string) (GetUserResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "RestoreUser")
 
 	if userId == "" {
 		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
 		return GetUserResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId"))
 	}
 
-	user, err := s.repository.GetUser(ctx, userId)
-	if err != nil {
+	if err := s.repository.RestoreUser(ctx, userId, s.gracePeriod); err != nil {
 		level.Error(logger).Log("err", err.Error())
 		return GetUserResponse{}, err
 	}
 
-	return GetUserResponse{
-		UserId:  user.UserId,
-		Name:    user.Name,
-		Age:     user.Age,
-		AddInfo: user.AddInfo.String,
-	}, nil
+	return s.GetUser(ctx, userId)
 }
@@ -2,20 +2,24 @@ package service
 
 import (
 	"context"
-	"database/sql"
+	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
 	erro "github.com/javibauza/final-project/grpc-service/errors"
 	"github.com/javibauza/final-project/grpc-service/repository"
 	"github.com/javibauza/final-project/grpc-service/utils"
 )
 
+const gracePeriod = 30 * 24 * time.Hour
+
 type repoMock struct {
 	mock.Mock
 }
@@ -36,10 +40,10 @@ func (m *repoMock) CreateUser(ctx context.Context, user repository.User) error {
 	return args.Error(0)
 }
 
-func (m *repoMock) UpdateUser(ctx context.Context, user repository.User) error {
-	args := m.Called(ctx, user)
+func (m *repoMock) UpdateUser(ctx context.Context, user repository.User, fields []string, validate func(repository.Profile) error) (repository.User, error) {
+	args := m.Called(ctx, user, fields, validate)
 
-	return args.Error(0)
+	return args.Get(0).(repository.User), args.Error(1)
 }
 
 func (m *repoMock) GetUser(ctx context.Context, userId string) (repository.User, error) {
@@ -48,6 +52,199 @@ func (m *repoMock) GetUser(ctx context.Context, userId string) (repository.User,
 	return args.Get(0).(repository.User), args.Error(1)
 }
 
+func (m *repoMock) RecordLogin(ctx context.Context, userId string) error {
+	args := m.Called(ctx, userId)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) DeleteUser(ctx context.Context, userId string) error {
+	args := m.Called(ctx, userId)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) RestoreUser(ctx context.Context, userId string, gracePeriod time.Duration) error {
+	args := m.Called(ctx, userId, gracePeriod)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) PurgeUsers(ctx context.Context, gracePeriod time.Duration) (int, error) {
+	args := m.Called(ctx, gracePeriod)
+
+	return args.Int(0), args.Error(1)
+}
+
+func (m *repoMock) GetAuditLog(ctx context.Context, target string, beforeId int64, limit int) ([]repository.AuditEntry, error) {
+	args := m.Called(ctx, target, beforeId, limit)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).([]repository.AuditEntry), args.Error(1)
+}
+
+func (m *repoMock) AuthenticateByEmail(ctx context.Context, email string) (repository.User, error) {
+	args := m.Called(ctx, email)
+
+	return args.Get(0).(repository.User), args.Error(1)
+}
+
+func (m *repoMock) CreateEmailVerification(ctx context.Context, userId, email, tokenHash string, ttl time.Duration) error {
+	args := m.Called(ctx, userId, email, tokenHash, ttl)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) VerifyEmail(ctx context.Context, tokenHash string) (string, error) {
+	args := m.Called(ctx, tokenHash)
+
+	return args.String(0), args.Error(1)
+}
+
+func (m *repoMock) EnrollTOTP(ctx context.Context, userId, secret string) error {
+	args := m.Called(ctx, userId, secret)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) GetTOTP(ctx context.Context, userId string) (repository.TOTP, error) {
+	args := m.Called(ctx, userId)
+
+	return args.Get(0).(repository.TOTP), args.Error(1)
+}
+
+func (m *repoMock) ConfirmTOTP(ctx context.Context, userId string, step int64, recoveryCodeHashes []string) error {
+	args := m.Called(ctx, userId, step, recoveryCodeHashes)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) UseTOTPStep(ctx context.Context, userId string, step int64) error {
+	args := m.Called(ctx, userId, step)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) UseRecoveryCode(ctx context.Context, userId, codeHash string) error {
+	args := m.Called(ctx, userId, codeHash)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) RecordTOTPFailure(ctx context.Context, userId string, maxFailures int, lockout time.Duration) error {
+	args := m.Called(ctx, userId, maxFailures, lockout)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) CreateAuthChallenge(ctx context.Context, userId, tokenHash string, ttl time.Duration) error {
+	args := m.Called(ctx, userId, tokenHash, ttl)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) AttemptAuthChallenge(ctx context.Context, tokenHash string, maxAttempts int) (string, error) {
+	args := m.Called(ctx, tokenHash, maxAttempts)
+
+	return args.String(0), args.Error(1)
+}
+
+func (m *repoMock) DeleteAuthChallenge(ctx context.Context, tokenHash string) error {
+	args := m.Called(ctx, tokenHash)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) CreateAPIKey(ctx context.Context, key repository.APIKey) (repository.APIKey, error) {
+	args := m.Called(ctx, key)
+
+	// The key is generated by the service, so stubs may return it as stored.
+	if stored, ok := args.Get(0).(func(context.Context, repository.APIKey) repository.APIKey); ok {
+		return stored(ctx, key), args.Error(1)
+	}
+	return args.Get(0).(repository.APIKey), args.Error(1)
+}
+
+func (m *repoMock) ListAPIKeys(ctx context.Context, userId string) ([]repository.APIKey, error) {
+	args := m.Called(ctx, userId)
+
+	return args.Get(0).([]repository.APIKey), args.Error(1)
+}
+
+func (m *repoMock) GetAPIKey(ctx context.Context, keyId string) (repository.APIKey, error) {
+	args := m.Called(ctx, keyId)
+
+	return args.Get(0).(repository.APIKey), args.Error(1)
+}
+
+func (m *repoMock) RecordAPIKeyUse(ctx context.Context, keyId string) error {
+	args := m.Called(ctx, keyId)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) RevokeAPIKey(ctx context.Context, userId, keyId string) error {
+	args := m.Called(ctx, userId, keyId)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) CreateOAuthClient(ctx context.Context, client repository.OAuthClient) (repository.OAuthClient, error) {
+	args := m.Called(ctx, client)
+
+	// The client id is generated by the service, so stubs may return it as
+	// stored.
+	if stored, ok := args.Get(0).(func(context.Context, repository.OAuthClient) repository.OAuthClient); ok {
+		return stored(ctx, client), args.Error(1)
+	}
+	return args.Get(0).(repository.OAuthClient), args.Error(1)
+}
+
+func (m *repoMock) GetOAuthClient(ctx context.Context, clientId string) (repository.OAuthClient, error) {
+	args := m.Called(ctx, clientId)
+
+	return args.Get(0).(repository.OAuthClient), args.Error(1)
+}
+
+func (m *repoMock) CreateAuthorizationCode(ctx context.Context, code repository.AuthorizationCode, ttl time.Duration) error {
+	args := m.Called(ctx, code, ttl)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (repository.AuthorizationCode, error) {
+	args := m.Called(ctx, codeHash)
+
+	return args.Get(0).(repository.AuthorizationCode), args.Error(1)
+}
+
+func (m *repoMock) LinkIdentity(ctx context.Context, identity repository.LinkedIdentity) (repository.LinkedIdentity, error) {
+	args := m.Called(ctx, identity)
+
+	return args.Get(0).(repository.LinkedIdentity), args.Error(1)
+}
+
+func (m *repoMock) ProvisionIdentity(ctx context.Context, user repository.User, identity repository.LinkedIdentity) (repository.LinkedIdentity, error) {
+	args := m.Called(ctx, user, identity)
+
+	return args.Get(0).(repository.LinkedIdentity), args.Error(1)
+}
+
+func (m *repoMock) GetLinkedIdentity(ctx context.Context, issuer, subject string) (repository.LinkedIdentity, error) {
+	args := m.Called(ctx, issuer, subject)
+
+	return args.Get(0).(repository.LinkedIdentity), args.Error(1)
+}
+
+func (m *repoMock) FindUsersByAge(ctx context.Context, min, max uint32) ([]repository.User, error) {
+	args := m.Called(ctx, min, max)
+
+	return args.Get(0).([]repository.User), args.Error(1)
+}
+
 func TestAuthenticate(t *testing.T) {
 	var logger log.Logger
 	{
@@ -62,7 +259,7 @@ func TestAuthenticate(t *testing.T) {
 
 	repoSvc := new(repoMock)
 
-	service := NewService(repoSvc, logger)
+	service := NewService(repoSvc, gracePeriod, EmailConfig{}, TOTPConfig{}, logger)
 
 	testCases := []struct {
 		testName      string
@@ -170,10 +367,17 @@ func TestAuthenticate(t *testing.T) {
 				repoResponse, err := tc.repoResponse(tc.userId, tc.userPwdHash)
 				repoSvc.On("Authenticate", ctx, tc.userName).
 					Return(repoResponse, err)
+				repoSvc.On("GetTOTP", ctx, tc.userId).Return(repository.TOTP{}, &erro.ErrNotFound{})
+				repoSvc.On("RecordLogin", ctx, tc.userId).Return(nil)
 			}
 
 			res, err := service.Authenticate(ctx, tc.request(tc.userName, tc.userPwd))
-			tc.checkResponse(t, res, tc.userId, err)
+			tc.checkResponse(t, res.UserId, tc.userId, err)
+			if err == nil {
+				repoSvc.AssertCalled(t, "RecordLogin", ctx, tc.userId)
+			} else {
+				repoSvc.AssertNotCalled(t, "RecordLogin", ctx, tc.userId)
+			}
 		})
 	}
 }
@@ -192,7 +396,7 @@ func TestCreateUser(t *testing.T) {
 
 	repoSvc := new(repoMock)
 
-	service := NewService(repoSvc, logger)
+	service := NewService(repoSvc, gracePeriod, EmailConfig{}, TOTPConfig{}, logger)
 
 	testCases := []struct {
 		testName string
@@ -200,11 +404,11 @@ func TestCreateUser(t *testing.T) {
 			Name    string
 			Pwd     string
 			Age     uint32
-			AddInfo string
+			Profile Profile
 		}
 		userId        string
 		pwdHash       string
-		request       func(name, pwd, addInfo string, age uint32) CreateUserRequest
+		request       func(name, pwd string, profile Profile, age uint32) CreateUserRequest
 		repoResponse  func(userId string) error
 		checkResponse func(t *testing.T, userId string, response CreateUserResponse, resError error)
 	}{
@@ -214,17 +418,17 @@ func TestCreateUser(t *testing.T) {
 				Name    string
 				Pwd     string
 				Age     uint32
-				AddInfo string
+				Profile Profile
 			}{
-				"javier", "javier123", 45, ""},
+				"javier", "javier123", 45, Profile{}},
 			pwdHash: "$2a$12$RXSLrffQZDUGljSPdQAPI.W4txkPKkeASl0qSM/tbx7mgMMqnDhui",
 			userId:  utils.RandomString(12),
-			request: func(name, pwd, addInfo string, age uint32) CreateUserRequest {
+			request: func(name, pwd string, profile Profile, age uint32) CreateUserRequest {
 				return CreateUserRequest{
 					Name:    name,
 					Pwd:     pwd,
 					Age:     age,
-					AddInfo: addInfo,
+					Profile: profile,
 				}
 			},
 			repoResponse: func(userId string) error {
@@ -232,6 +436,9 @@ func TestCreateUser(t *testing.T) {
 			},
 			checkResponse: func(t *testing.T, userId string, response CreateUserResponse, resError error) {
 				assert.NoError(t, resError)
+				assert.NotEmpty(t, response.UserId)
+				assert.Equal(t, "javier", response.Name)
+				assert.Equal(t, uint32(45), response.Age)
 			},
 		},
 		{
@@ -240,16 +447,16 @@ func TestCreateUser(t *testing.T) {
 				Name    string
 				Pwd     string
 				Age     uint32
-				AddInfo string
+				Profile Profile
 			}{
-				"", "javier123", 45, ""},
+				"", "javier123", 45, Profile{}},
 			userId: utils.RandomString(12),
-			request: func(name, pwd, addInfo string, age uint32) CreateUserRequest {
+			request: func(name, pwd string, profile Profile, age uint32) CreateUserRequest {
 				return CreateUserRequest{
 					Name:    name,
 					Pwd:     pwd,
 					Age:     age,
-					AddInfo: addInfo,
+					Profile: profile,
 				}
 			},
 			repoResponse: func(userId string) error {
@@ -268,16 +475,16 @@ func TestCreateUser(t *testing.T) {
 				Name    string
 				Pwd     string
 				Age     uint32
-				AddInfo string
+				Profile Profile
 			}{
-				"javier", "", 45, ""},
+				"javier", "", 45, Profile{}},
 			userId: utils.RandomString(12),
-			request: func(name, pwd, addInfo string, age uint32) CreateUserRequest {
+			request: func(name, pwd string, profile Profile, age uint32) CreateUserRequest {
 				return CreateUserRequest{
 					Name:    name,
 					Pwd:     pwd,
 					Age:     age,
-					AddInfo: addInfo,
+					Profile: profile,
 				}
 			},
 			repoResponse: func(userId string) error {
@@ -291,6 +498,34 @@ func TestCreateUser(t *testing.T) {
 				assert.Equal(t, res.Err.Error(), erro.ErrRequiredFields("name", "password"))
 			},
 		},
+		{
+			testName: "federated user name",
+			userData: struct {
+				Name    string
+				Pwd     string
+				Age     uint32
+				Profile Profile
+			}{
+				"Federated:javier", "javier123", 45, Profile{}},
+			userId: utils.RandomString(12),
+			request: func(name, pwd string, profile Profile, age uint32) CreateUserRequest {
+				return CreateUserRequest{
+					Name:    name,
+					Pwd:     pwd,
+					Age:     age,
+					Profile: profile,
+				}
+			},
+			repoResponse: func(userId string) error {
+				return nil
+			},
+			checkResponse: func(t *testing.T, userId string, response CreateUserResponse, resError error) {
+				assert.Empty(t, response)
+				res, ok := resError.(*erro.ErrInvalidArgument)
+				assert.EqualValues(t, true, ok)
+				assert.Equal(t, erro.ErrReservedName, res.Err.Error())
+			},
+		},
 	}
 
 	for i := range testCases {
@@ -300,7 +535,7 @@ func TestCreateUser(t *testing.T) {
 			repoErr := tc.repoResponse(tc.userId)
 			repoSvc.On("CreateUser", ctx, mock.AnythingOfType("repository.User")).
 				Return(repoErr)
-			res, err := service.CreateUser(ctx, tc.request(tc.userData.Name, tc.userData.Pwd, tc.userData.AddInfo, tc.userData.Age))
+			res, err := service.CreateUser(ctx, tc.request(tc.userData.Name, tc.userData.Pwd, tc.userData.Profile, tc.userData.Age))
 			tc.checkResponse(t, tc.userId, res, err)
 		})
 	}
@@ -320,7 +555,7 @@ func TestUpdateUser(t *testing.T) {
 
 	repoSvc := new(repoMock)
 
-	service := NewService(repoSvc, logger)
+	service := NewService(repoSvc, gracePeriod, EmailConfig{}, TOTPConfig{}, logger)
 
 	testCases := []struct {
 		testName string
@@ -329,11 +564,11 @@ func TestUpdateUser(t *testing.T) {
 			Name    string
 			Pwd     string
 			Age     uint32
-			AddInfo string
+			Profile Profile
 		}
-		request       func(userId, name, pwd, addInfo string, age uint32) UpdateUserRequest
+		request       func(userId, name, pwd string, profile Profile, age uint32) UpdateUserRequest
 		repoResponse  error
-		checkResponse func(t *testing.T, resError error)
+		checkResponse func(t *testing.T, userId string, response UpdateUserResponse, resError error)
 	}{
 		{
 			testName: "user updated",
@@ -342,21 +577,27 @@ func TestUpdateUser(t *testing.T) {
 				Name    string
 				Pwd     string
 				Age     uint32
-				AddInfo string
+				Profile Profile
 			}{
-				utils.RandomString(12), "javier", "javier123", 45, "Some additional info"},
-			request: func(userId, name, pwd, addInfo string, age uint32) UpdateUserRequest {
+				utils.RandomString(12), "javier", "javier123", 45, Profile{DisplayName: "Javier B"}},
+			request: func(userId, name, pwd string, profile Profile, age uint32) UpdateUserRequest {
 				return UpdateUserRequest{
 					UserId:  userId,
 					Name:    name,
 					Pwd:     pwd,
 					Age:     age,
-					AddInfo: addInfo,
+					Profile: profile,
 				}
 			},
 			repoResponse: nil,
-			checkResponse: func(t *testing.T, resError error) {
+			checkResponse: func(t *testing.T, userId string, response UpdateUserResponse, resError error) {
 				assert.NoError(t, resError)
+				assert.Equal(t, UpdateUserResponse{
+					UserId:  userId,
+					Name:    "javier",
+					Age:     45,
+					Profile: Profile{DisplayName: "Javier B"},
+				}, response)
 			},
 		},
 		{
@@ -366,20 +607,21 @@ func TestUpdateUser(t *testing.T) {
 				Name    string
 				Pwd     string
 				Age     uint32
-				AddInfo string
+				Profile Profile
 			}{
-				"", "javier", "javier123", 45, "Some additional info"},
-			request: func(userId, name, pwd, addInfo string, age uint32) UpdateUserRequest {
+				"", "javier", "javier123", 45, Profile{DisplayName: "Javier B"}},
+			request: func(userId, name, pwd string, profile Profile, age uint32) UpdateUserRequest {
 				return UpdateUserRequest{
 					UserId:  userId,
 					Name:    name,
 					Pwd:     pwd,
 					Age:     age,
-					AddInfo: addInfo,
+					Profile: profile,
 				}
 			},
 			repoResponse: nil,
-			checkResponse: func(t *testing.T, resError error) {
+			checkResponse: func(t *testing.T, userId string, response UpdateUserResponse, resError error) {
+				assert.Empty(t, response)
 				res, ok := resError.(*erro.ErrInvalidArgument)
 				assert.EqualValues(t, true, ok)
 				assert.Equal(t, res.Err.Error(), erro.ErrRequiredFields("userId"))
@@ -392,20 +634,21 @@ func TestUpdateUser(t *testing.T) {
 				Name    string
 				Pwd     string
 				Age     uint32
-				AddInfo string
+				Profile Profile
 			}{
-				utils.RandomString(12), "", "", 0, ""},
-			request: func(userId, name, pwd, addInfo string, age uint32) UpdateUserRequest {
+				utils.RandomString(12), "", "", 0, Profile{}},
+			request: func(userId, name, pwd string, profile Profile, age uint32) UpdateUserRequest {
 				return UpdateUserRequest{
 					UserId:  userId,
 					Name:    name,
 					Pwd:     pwd,
 					Age:     age,
-					AddInfo: addInfo,
+					Profile: profile,
 				}
 			},
 			repoResponse: nil,
-			checkResponse: func(t *testing.T, resError error) {
+			checkResponse: func(t *testing.T, userId string, response UpdateUserResponse, resError error) {
+				assert.Empty(t, response)
 				res, ok := resError.(*erro.ErrInvalidArgument)
 				assert.EqualValues(t, true, ok)
 				assert.Equal(t, res.Err.Error(), erro.ErrNoFieldsForUpdate)
@@ -417,14 +660,181 @@ func TestUpdateUser(t *testing.T) {
 		tc := testCases[i]
 		t.Run(tc.testName, func(t *testing.T) {
 			ctx := context.Background()
-			repoSvc.On("UpdateUser", ctx, mock.AnythingOfType("repository.User")).
-				Return(tc.repoResponse)
-			err := service.UpdateUser(ctx, tc.request(tc.userData.UserId, tc.userData.Name, tc.userData.Pwd, tc.userData.AddInfo, tc.userData.Age))
+			repoSvc.On("UpdateUser", ctx, mock.AnythingOfType("repository.User"), mock.Anything, mock.Anything).
+				Return(repository.User{
+					UserId:  tc.userData.UserId,
+					Name:    tc.userData.Name,
+					Age:     tc.userData.Age,
+					Profile: tc.userData.Profile,
+				}, tc.repoResponse)
+			res, err := service.UpdateUser(ctx, tc.request(tc.userData.UserId, tc.userData.Name, tc.userData.Pwd, tc.userData.Profile, tc.userData.Age))
+			tc.checkResponse(t, tc.userData.UserId, res, err)
+		})
+	}
+}
+
+func TestUpdateUserFields(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	testCases := []struct {
+		testName      string
+		request       UpdateUserRequest
+		checkRepo     func(t *testing.T, user repository.User, fields []string)
+		checkResponse func(t *testing.T, resError error)
+	}{
+		{
+			testName: "no mask writes non-empty fields",
+			request: UpdateUserRequest{UserId: userId, Name: "javier", Profile: Profile{
+				Locale:   "es-MX",
+				Metadata: map[string]string{"team": "core", "add_info": "info"},
+			}},
+			checkRepo: func(t *testing.T, user repository.User, fields []string) {
+				assert.Equal(t, []string{
+					repository.FieldName,
+					repository.FieldLocale,
+					repository.FieldMetadata + ".add_info",
+					repository.FieldMetadata + ".team",
+				}, fields)
+				assert.Equal(t, "es-MX", user.Profile.Locale)
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "clear age",
+			request:  UpdateUserRequest{UserId: userId, Fields: []string{FieldUserAge}},
+			checkRepo: func(t *testing.T, user repository.User, fields []string) {
+				assert.Equal(t, []string{repository.FieldAge}, fields)
+				assert.Equal(t, uint32(0), user.Age)
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "clear profile",
+			request:  UpdateUserRequest{UserId: userId, Name: "ignored", Fields: []string{FieldProfile}},
+			checkRepo: func(t *testing.T, user repository.User, fields []string) {
+				assert.Equal(t, []string{repository.FieldProfile}, fields)
+				assert.Equal(t, Profile{}, user.Profile)
+				assert.Empty(t, user.Name)
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "profile members",
+			request: UpdateUserRequest{UserId: userId, Profile: Profile{Email: "javier@example.com"}, Fields: []string{
+				FieldEmail, FieldTimezone, FieldMetadata + ".team",
+			}},
+			checkRepo: func(t *testing.T, user repository.User, fields []string) {
+				assert.Equal(t, []string{repository.FieldEmail, repository.FieldTimezone, repository.FieldMetadata + ".team"}, fields)
+				assert.Equal(t, "javier@example.com", user.Profile.Email)
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "empty metadata key in mask",
+			request:  UpdateUserRequest{UserId: userId, Fields: []string{FieldMetadata + "."}},
+			checkResponse: func(t *testing.T, resError error) {
+				res, ok := resError.(*erro.ErrInvalidArgument)
+				assert.True(t, ok)
+				assert.Equal(t, erro.ErrUnknownUpdateField(FieldMetadata+"."), res.Err.Error())
+			},
+		},
+		{
+			testName: "invalid profile",
+			request:  UpdateUserRequest{UserId: userId, Profile: Profile{Timezone: "Mars/Olympus"}},
+			checkResponse: func(t *testing.T, resError error) {
+				res, ok := resError.(*erro.ErrInvalidArgument)
+				assert.True(t, ok)
+				assert.Equal(t, erro.ErrInvalidTimezone, res.Err.Error())
+			},
+		},
+		{
+			testName: "user_name cannot be cleared",
+			request:  UpdateUserRequest{UserId: userId, Fields: []string{FieldUserName}},
+			checkResponse: func(t *testing.T, resError error) {
+				res, ok := resError.(*erro.ErrInvalidArgument)
+				assert.True(t, ok)
+				assert.Equal(t, erro.ErrRequiredFields(FieldUserName), res.Err.Error())
+			},
+		},
+		{
+			testName: "federated user name",
+			request:  UpdateUserRequest{UserId: userId, Name: FederatedNamePrefix + "javier"},
+			checkResponse: func(t *testing.T, resError error) {
+				res, ok := resError.(*erro.ErrInvalidArgument)
+				assert.True(t, ok)
+				assert.Equal(t, erro.ErrReservedName, res.Err.Error())
+			},
+		},
+		{
+			testName: "password cannot be cleared",
+			request:  UpdateUserRequest{UserId: userId, Fields: []string{FieldPassword}},
+			checkResponse: func(t *testing.T, resError error) {
+				res, ok := resError.(*erro.ErrInvalidArgument)
+				assert.True(t, ok)
+				assert.Equal(t, erro.ErrRequiredFields(FieldPassword), res.Err.Error())
+			},
+		},
+		{
+			testName: "unknown field in mask",
+			request:  UpdateUserRequest{UserId: userId, Fields: []string{"user_id"}},
+			checkResponse: func(t *testing.T, resError error) {
+				res, ok := resError.(*erro.ErrInvalidArgument)
+				assert.True(t, ok)
+				assert.Equal(t, erro.ErrUnknownUpdateField("user_id"), res.Err.Error())
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			ctx := context.Background()
+			repoSvc := new(repoMock)
+			service := NewService(repoSvc, gracePeriod, EmailConfig{}, TOTPConfig{}, logger)
+
+			repoSvc.On("UpdateUser", ctx, mock.AnythingOfType("repository.User"), mock.Anything, mock.Anything).
+				Return(repository.User{UserId: userId}, nil).
+				Run(func(args mock.Arguments) {
+					tc.checkRepo(t, args.Get(1).(repository.User), args.Get(2).([]string))
+				})
+
+			_, err := service.UpdateUser(ctx, tc.request)
 			tc.checkResponse(t, err)
 		})
 	}
 }
 
+func TestUpdateUserValidatesMergedProfile(t *testing.T) {
+	ctx := context.Background()
+	repoSvc := new(repoMock)
+	service := NewService(repoSvc, gracePeriod, EmailConfig{}, TOTPConfig{}, log.NewNopLogger())
+
+	merged := repository.Profile{Metadata: map[string]string{}}
+	for i := 0; i <= MaxMetadataEntries; i++ {
+		merged.Metadata[fmt.Sprintf("key-%d", i)] = "value"
+	}
+	repoSvc.On("UpdateUser", ctx, mock.AnythingOfType("repository.User"), mock.Anything, mock.Anything).
+		Return(repository.User{}, nil).
+		Run(func(args mock.Arguments) {
+			validate, ok := args.Get(3).(func(repository.Profile) error)
+			require.True(t, ok)
+			assert.IsType(t, &erro.ErrInvalidArgument{}, validate(merged))
+		})
+
+	_, err := service.UpdateUser(ctx, UpdateUserRequest{UserId: "u1", Profile: Profile{Metadata: map[string]string{"team": "core"}}})
+	require.NoError(t, err)
+	repoSvc.AssertExpectations(t)
+}
+
 func TestGetUser(t *testing.T) {
 	var logger log.Logger
 	{
@@ -439,7 +849,7 @@ func TestGetUser(t *testing.T) {
 
 	repoSvc := new(repoMock)
 
-	service := NewService(repoSvc, logger)
+	service := NewService(repoSvc, gracePeriod, EmailConfig{}, TOTPConfig{}, logger)
 
 	testCases := []struct {
 		testName      string
@@ -452,14 +862,19 @@ func TestGetUser(t *testing.T) {
 			userId:   utils.RandomString(12),
 			repoResponse: func(userId string) (repository.User, error) {
 				return repository.User{
-					UserId:  userId,
-					Age:     45,
-					Name:    "javier",
-					AddInfo: sql.NullString{String: "additional info"},
+					UserId:    userId,
+					Age:       45,
+					Name:      "javier",
+					Profile:   Profile{DisplayName: "Javier B", Email: "javier@example.com"},
+					CreatedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+					UpdatedAt: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
 				}, nil
 			},
 			checkResponse: func(t *testing.T, userId string, response GetUserResponse, resError error) {
 				assert.Equal(t, response.UserId, userId)
+				assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), response.CreatedAt)
+				assert.Equal(t, time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), response.UpdatedAt)
+				assert.True(t, response.LastLoginAt.IsZero())
 				assert.NoError(t, resError)
 			},
 		},
@@ -489,3 +904,234 @@ func TestGetUser(t *testing.T) {
 		})
 	}
 }
+
+func TestGetAuditLog(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	entries := func(ids ...int64) []repository.AuditEntry {
+		var entries []repository.AuditEntry
+		for _, id := range ids {
+			entries = append(entries, repository.AuditEntry{
+				Id:        id,
+				Actor:     "rest-service",
+				Action:    repository.ActionUpdateUser,
+				Target:    userId,
+				Changes:   map[string]string{repository.FieldAge: "38"},
+				CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			})
+		}
+		return entries
+	}
+
+	testCases := []struct {
+		testName      string
+		request       GetAuditLogRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, response GetAuditLogResponse, resError error)
+	}{
+		{
+			testName: "last page",
+			request:  GetAuditLogRequest{UserId: userId, PageSize: 3},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetAuditLog", mock.Anything, userId, int64(0), 4).Return(entries(2, 1), nil)
+			},
+			checkResponse: func(t *testing.T, response GetAuditLogResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Len(t, response.Entries, 2)
+				assert.Equal(t, int64(2), response.Entries[0].Id)
+				assert.Equal(t, map[string]string{repository.FieldAge: "38"}, response.Entries[0].Changes)
+				assert.Empty(t, response.NextPageToken)
+			},
+		},
+		{
+			testName: "next page token",
+			request:  GetAuditLogRequest{UserId: userId, PageSize: 2},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetAuditLog", mock.Anything, userId, int64(0), 3).Return(entries(5, 4, 3), nil)
+			},
+			checkResponse: func(t *testing.T, response GetAuditLogResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Len(t, response.Entries, 2)
+				assert.Equal(t, encodePageToken(4), response.NextPageToken)
+			},
+		},
+		{
+			testName: "page token",
+			request:  GetAuditLogRequest{UserId: userId, PageToken: encodePageToken(4)},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetAuditLog", mock.Anything, userId, int64(4), DefaultAuditPageSize+1).Return(entries(3), nil)
+			},
+			checkResponse: func(t *testing.T, response GetAuditLogResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Len(t, response.Entries, 1)
+			},
+		},
+		{
+			testName: "page size capped",
+			request:  GetAuditLogRequest{UserId: userId, PageSize: 1000},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetAuditLog", mock.Anything, userId, int64(0), MaxAuditPageSize+1).Return(nil, nil)
+			},
+			checkResponse: func(t *testing.T, response GetAuditLogResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Empty(t, response.Entries)
+			},
+		},
+		{
+			testName:   "negative page size",
+			request:    GetAuditLogRequest{UserId: userId, PageSize: -1},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, response GetAuditLogResponse, resError error) {
+				res, ok := resError.(*erro.ErrInvalidArgument)
+				assert.EqualValues(t, true, ok)
+				assert.Equal(t, erro.ErrInvalidPageSize, res.Err.Error())
+			},
+		},
+		{
+			testName:   "invalid page token",
+			request:    GetAuditLogRequest{UserId: userId, PageToken: "not a token"},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, response GetAuditLogResponse, resError error) {
+				res, ok := resError.(*erro.ErrInvalidArgument)
+				assert.EqualValues(t, true, ok)
+				assert.Equal(t, erro.ErrInvalidPageToken, res.Err.Error())
+			},
+		},
+		{
+			testName:   "empty userId",
+			request:    GetAuditLogRequest{},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, response GetAuditLogResponse, resError error) {
+				res, ok := resError.(*erro.ErrInvalidArgument)
+				assert.EqualValues(t, true, ok)
+				assert.Equal(t, erro.ErrRequiredFields("userId"), res.Err.Error())
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := NewService(repo, gracePeriod, EmailConfig{}, TOTPConfig{}, logger).GetAuditLog(context.Background(), tc.request)
+			tc.checkResponse(t, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, resError error)
+	}{
+		{
+			testName: "user deleted",
+			userId:   userId,
+			buildStubs: func(repo *repoMock) {
+				repo.On("DeleteUser", mock.Anything, userId).Return(nil)
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "user not found",
+			userId:   userId,
+			buildStubs: func(repo *repoMock) {
+				repo.On("DeleteUser", mock.Anything, userId).Return(erro.NewErrNotFound())
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.IsType(t, &erro.ErrNotFound{}, resError)
+			},
+		},
+		{
+			testName:   "empty userId",
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, resError error) {
+				res, ok := resError.(*erro.ErrInvalidArgument)
+				assert.EqualValues(t, true, ok)
+				assert.Equal(t, erro.ErrRequiredFields("userId"), res.Err.Error())
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			err := NewService(repo, gracePeriod, EmailConfig{}, TOTPConfig{}, logger).DeleteUser(context.Background(), tc.userId)
+			tc.checkResponse(t, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, response GetUserResponse, resError error)
+	}{
+		{
+			testName: "user restored",
+			userId:   userId,
+			buildStubs: func(repo *repoMock) {
+				repo.On("RestoreUser", mock.Anything, userId, gracePeriod).Return(nil)
+				repo.On("GetUser", mock.Anything, userId).Return(repository.User{UserId: userId, Name: "javier", Version: 3}, nil)
+			},
+			checkResponse: func(t *testing.T, response GetUserResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, userId, response.UserId)
+				assert.Equal(t, int64(3), response.Version)
+			},
+		},
+		{
+			testName: "grace period expired",
+			userId:   userId,
+			buildStubs: func(repo *repoMock) {
+				repo.On("RestoreUser", mock.Anything, userId, gracePeriod).
+					Return(erro.NewErrFailedPrecondition(erro.ErrGracePeriodExpired))
+			},
+			checkResponse: func(t *testing.T, response GetUserResponse, resError error) {
+				assert.Empty(t, response)
+				assert.IsType(t, &erro.ErrFailedPrecondition{}, resError)
+			},
+		},
+		{
+			testName:   "empty userId",
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, response GetUserResponse, resError error) {
+				res, ok := resError.(*erro.ErrInvalidArgument)
+				assert.EqualValues(t, true, ok)
+				assert.Equal(t, erro.ErrRequiredFields("userId"), res.Err.Error())
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := NewService(repo, gracePeriod, EmailConfig{}, TOTPConfig{}, logger).RestoreUser(context.Background(), tc.userId)
+			tc.checkResponse(t, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/repository"
+)
+
+// Scopes an API key can be granted.
+const (
+	ScopeRead  = "users:read"
+	ScopeWrite = "users:write"
+)
+
+var apiKeyScopes = []string{ScopeRead, ScopeWrite}
+
+const (
+	// apiKeyPrefix starts every API key, so leaked keys are easy to spot.
+	apiKeyPrefix = "fpk_"
+	// MaxAPIKeyNameLength is the maximum length of an API key's name.
+	MaxAPIKeyNameLength = 100
+)
+
+// APIKey describes an API key, without the key itself.
+type APIKey struct {
+	Id         string
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+}
+
+type CreateAPIKeyRequest struct {
+	UserId string
+	Name   string
+	Scopes []string
+	// ExpiresAt is zero for keys that do not expire.
+	ExpiresAt time.Time
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey APIKey
+	// Key is only ever returned here.
+	Key string
+}
+
+type AuthenticateAPIKeyResponse struct {
+	KeyId  string
+	UserId string
+	Scopes []string
+}
+
+// newAPIKey returns a key of the form "fpk_<id>_<secret>", its id and the
+// hash it is stored as.
+func newAPIKey() (id, key, hash string, err error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	secret, _, err := newToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	id = strings.ToLower(recoveryEncoding.EncodeToString(b))
+	key = apiKeyPrefix + id + "_" + secret
+	return id, key, hashToken(key), nil
+}
+
+// apiKeyId returns the id of key, or false when key is not shaped like one.
+func apiKeyId(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// parseScopes checks that scopes are known and returns them without
+// duplicates, in the order of apiKeyScopes.
+func parseScopes(scopes []string) ([]string, error) {
+	requested := map[string]bool{}
+	for _, scope := range scopes {
+		requested[scope] = true
+	}
+	var parsed []string
+	for _, scope := range apiKeyScopes {
+		if requested[scope] {
+			parsed = append(parsed, scope)
+			delete(requested, scope)
+		}
+	}
+	for _, scope := range scopes {
+		if requested[scope] {
+			return nil, erro.NewErrInvalidArgument(erro.ErrUnknownScope(scope))
+		}
+	}
+	return parsed, nil
+}
+
+func apiKeyFromRepo(key repository.APIKey) APIKey {
+	return APIKey{
+		Id:         key.Id,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
+
+// CreateAPIKey issues a new key for the user. Only its hash is stored, so the
+// key can't be shown again.
+func (s service) CreateAPIKey(ctx context.Context, req CreateAPIKeyRequest) (CreateAPIKeyResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "CreateAPIKey")
+
+	if req.UserId == "" || req.Name == "" || len(req.Scopes) == 0 {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId", "name", "scopes"))
+		return CreateAPIKeyResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId", "name", "scopes"))
+	}
+	if len(req.Name) > MaxAPIKeyNameLength {
+		level.Error(logger).Log("err", erro.ErrTooLong("name", MaxAPIKeyNameLength))
+		return CreateAPIKeyResponse{}, erro.NewErrInvalidArgument(erro.ErrTooLong("name", MaxAPIKeyNameLength))
+	}
+	scopes, err := parseScopes(req.Scopes)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return CreateAPIKeyResponse{}, err
+	}
+	if !req.ExpiresAt.IsZero() && !req.ExpiresAt.After(s.now()) {
+		level.Error(logger).Log("err", erro.ErrExpiresAtInPast)
+		return CreateAPIKeyResponse{}, erro.NewErrInvalidArgument(erro.ErrExpiresAtInPast)
+	}
+
+	if _, err := s.repository.GetUser(ctx, req.UserId); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return CreateAPIKeyResponse{}, err
+	}
+
+	id, key, hash, err := newAPIKey()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return CreateAPIKeyResponse{}, err
+	}
+	created, err := s.repository.CreateAPIKey(ctx, repository.APIKey{
+		Id:        id,
+		UserId:    req.UserId,
+		Name:      req.Name,
+		KeyHash:   hash,
+		Scopes:    scopes,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return CreateAPIKeyResponse{}, err
+	}
+
+	return CreateAPIKeyResponse{APIKey: apiKeyFromRepo(created), Key: key}, nil
+}
+
+func (s service) ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "ListAPIKeys")
+
+	if userId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return nil, erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId"))
+	}
+
+	if _, err := s.repository.GetUser(ctx, userId); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return nil, err
+	}
+
+	keys, err := s.repository.ListAPIKeys(ctx, userId)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return nil, err
+	}
+
+	var res []APIKey
+	for _, key := range keys {
+		res = append(res, apiKeyFromRepo(key))
+	}
+	return res, nil
+}
+
+func (s service) RevokeAPIKey(ctx context.Context, userId, keyId string) error {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "RevokeAPIKey")
+
+	if userId == "" || keyId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId", "keyId"))
+		return erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId", "keyId"))
+	}
+
+	if err := s.repository.RevokeAPIKey(ctx, userId, keyId); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// AuthenticateAPIKey returns the user who owns key and the scopes it was
+// granted. Unknown, expired and revoked keys, and keys of deleted users, all
+// fail with the same ErrPermissionDenied.
+func (s service) AuthenticateAPIKey(ctx context.Context, key string) (AuthenticateAPIKeyResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "AuthenticateAPIKey")
+
+	if key == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("key"))
+		return AuthenticateAPIKeyResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("key"))
+	}
+
+	id, ok := apiKeyId(key)
+	if !ok {
+		level.Error(logger).Log("err", erro.ErrInvalidAPIKey)
+		return AuthenticateAPIKeyResponse{}, erro.NewErrPermissionDenied(erro.ErrInvalidAPIKey)
+	}
+
+	stored, err := s.repository.GetAPIKey(ctx, id)
+	if _, notFound := err.(*erro.ErrNotFound); notFound {
+		level.Error(logger).Log("err", erro.ErrInvalidAPIKey, "keyId", id)
+		return AuthenticateAPIKeyResponse{}, erro.NewErrPermissionDenied(erro.ErrInvalidAPIKey)
+	}
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthenticateAPIKeyResponse{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(key)), []byte(stored.KeyHash)) != 1 {
+		level.Error(logger).Log("err", erro.ErrInvalidAPIKey, "keyId", id)
+		return AuthenticateAPIKeyResponse{}, erro.NewErrPermissionDenied(erro.ErrInvalidAPIKey)
+	}
+	if !stored.ExpiresAt.IsZero() && !s.now().Before(stored.ExpiresAt) {
+		level.Error(logger).Log("err", erro.ErrInvalidAPIKey, "keyId", id, "expiresAt", stored.ExpiresAt)
+		return AuthenticateAPIKeyResponse{}, erro.NewErrPermissionDenied(erro.ErrInvalidAPIKey)
+	}
+
+	if err := s.repository.RecordAPIKeyUse(ctx, id); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthenticateAPIKeyResponse{}, err
+	}
+
+	return AuthenticateAPIKeyResponse{KeyId: id, UserId: stored.UserId, Scopes: stored.Scopes}, nil
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/repository"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	expiresAt := today.Add(30 * 24 * time.Hour)
+
+	testCases := []struct {
+		testName      string
+		request       CreateAPIKeyRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, repo *repoMock, response CreateAPIKeyResponse, resError error)
+	}{
+		{
+			testName: "key created",
+			request:  CreateAPIKeyRequest{UserId: "u1", Name: "batch", Scopes: []string{ScopeWrite, ScopeRead, ScopeWrite}, ExpiresAt: expiresAt},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1"}, nil)
+				repo.On("CreateAPIKey", mock.Anything, mock.AnythingOfType("repository.APIKey")).
+					Return(func(_ context.Context, key repository.APIKey) repository.APIKey {
+						key.CreatedAt = today
+						return key
+					}, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response CreateAPIKeyResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Regexp(t, regexp.MustCompile(`^fpk_[a-z2-7]{13}_[A-Za-z0-9_-]{43}$`), response.Key)
+
+				stored := repo.Calls[1].Arguments.Get(1).(repository.APIKey)
+				assert.Equal(t, hashToken(response.Key), stored.KeyHash, "only the hash of the key is stored")
+				id, ok := apiKeyId(response.Key)
+				require.True(t, ok)
+				assert.Equal(t, id, stored.Id)
+
+				assert.Equal(t, APIKey{
+					Id:        id,
+					Name:      "batch",
+					Scopes:    []string{ScopeRead, ScopeWrite},
+					CreatedAt: today,
+					ExpiresAt: expiresAt,
+				}, response.APIKey)
+			},
+		},
+		{
+			testName:   "unknown scope",
+			request:    CreateAPIKeyRequest{UserId: "u1", Name: "batch", Scopes: []string{ScopeRead, "admin"}},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, repo *repoMock, response CreateAPIKeyResponse, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+				assert.EqualError(t, resError, erro.ErrUnknownScope("admin"))
+			},
+		},
+		{
+			testName:   "already expired",
+			request:    CreateAPIKeyRequest{UserId: "u1", Name: "batch", Scopes: []string{ScopeRead}, ExpiresAt: today},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, repo *repoMock, response CreateAPIKeyResponse, resError error) {
+				assert.EqualError(t, resError, erro.ErrExpiresAtInPast)
+			},
+		},
+		{
+			testName:   "no scopes",
+			request:    CreateAPIKeyRequest{UserId: "u1", Name: "batch"},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, repo *repoMock, response CreateAPIKeyResponse, resError error) {
+				assert.EqualError(t, resError, erro.ErrRequiredFields("userId", "name", "scopes"))
+			},
+		},
+		{
+			testName: "user not found",
+			request:  CreateAPIKeyRequest{UserId: "u2", Name: "batch", Scopes: []string{ScopeRead}},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetUser", mock.Anything, "u2").Return(repository.User{}, erro.NewErrNotFound())
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response CreateAPIKeyResponse, resError error) {
+				assert.Empty(t, response)
+				assert.IsType(t, &erro.ErrNotFound{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := newTestService(repo).CreateAPIKey(context.Background(), tc.request)
+			tc.checkResponse(t, repo, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAPIKeyId(t *testing.T) {
+	id, ok := apiKeyId("fpk_abcdefghijklm_sec_ret")
+	assert.True(t, ok)
+	assert.Equal(t, "abcdefghijklm", id)
+
+	for _, key := range []string{"", "fpk_", "fpk_abc", "fpk__secret", "fpk_abc_", "abc_secret"} {
+		_, ok := apiKeyId(key)
+		assert.False(t, ok, key)
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	const key = "fpk_abcdefghijklm_secret"
+	stored := repository.APIKey{Id: "abcdefghijklm", UserId: "u1", KeyHash: hashToken(key), Scopes: []string{ScopeRead}}
+
+	testCases := []struct {
+		testName      string
+		key           string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, response AuthenticateAPIKeyResponse, resError error)
+	}{
+		{
+			testName: "authenticated",
+			key:      key,
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetAPIKey", mock.Anything, "abcdefghijklm").Return(stored, nil)
+				repo.On("RecordAPIKeyUse", mock.Anything, "abcdefghijklm").Return(nil)
+			},
+			checkResponse: func(t *testing.T, response AuthenticateAPIKeyResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthenticateAPIKeyResponse{KeyId: "abcdefghijklm", UserId: "u1", Scopes: []string{ScopeRead}}, response)
+			},
+		},
+		{
+			testName: "wrong secret",
+			key:      "fpk_abcdefghijklm_other",
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetAPIKey", mock.Anything, "abcdefghijklm").Return(stored, nil)
+			},
+			checkResponse: func(t *testing.T, response AuthenticateAPIKeyResponse, resError error) {
+				assert.Empty(t, response)
+				assert.IsType(t, &erro.ErrPermissionDenied{}, resError)
+				assert.EqualError(t, resError, erro.ErrInvalidAPIKey)
+			},
+		},
+		{
+			testName: "expired",
+			key:      key,
+			buildStubs: func(repo *repoMock) {
+				expired := stored
+				expired.ExpiresAt = today
+				repo.On("GetAPIKey", mock.Anything, "abcdefghijklm").Return(expired, nil)
+			},
+			checkResponse: func(t *testing.T, response AuthenticateAPIKeyResponse, resError error) {
+				assert.IsType(t, &erro.ErrPermissionDenied{}, resError)
+			},
+		},
+		{
+			testName: "revoked",
+			key:      key,
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetAPIKey", mock.Anything, "abcdefghijklm").Return(repository.APIKey{}, &erro.ErrNotFound{})
+			},
+			checkResponse: func(t *testing.T, response AuthenticateAPIKeyResponse, resError error) {
+				assert.IsType(t, &erro.ErrPermissionDenied{}, resError)
+				assert.EqualError(t, resError, erro.ErrInvalidAPIKey)
+			},
+		},
+		{
+			testName:   "malformed",
+			key:        "secret",
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, response AuthenticateAPIKeyResponse, resError error) {
+				assert.IsType(t, &erro.ErrPermissionDenied{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := newTestService(repo).AuthenticateAPIKey(context.Background(), tc.key)
+			tc.checkResponse(t, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListAPIKeys(t *testing.T) {
+	repo := new(repoMock)
+	repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1"}, nil)
+	repo.On("ListAPIKeys", mock.Anything, "u1").Return([]repository.APIKey{
+		{Id: "k1", UserId: "u1", Name: "batch", Scopes: []string{ScopeRead}, CreatedAt: today},
+	}, nil)
+
+	keys, err := newTestService(repo).ListAPIKeys(context.Background(), "u1")
+	require.NoError(t, err)
+	assert.Equal(t, []APIKey{{Id: "k1", Name: "batch", Scopes: []string{ScopeRead}, CreatedAt: today}}, keys)
+	repo.AssertExpectations(t)
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"time"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/repository"
+)
+
+// MaxAge is the oldest age a birth date may give.
+const MaxAge = 150
+
+// FieldBirthDate is the update mask path of the birth date.
+const FieldBirthDate = "birth_date"
+
+// parseBirthDate reads a birth date in repository.DateLayout, checking it is
+// neither in the future nor more than MaxAge years before today. An empty
+// value is the zero time.
+func parseBirthDate(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	date, err := time.Parse(repository.DateLayout, value)
+	if err != nil {
+		return time.Time{}, erro.NewErrInvalidArgument(erro.ErrInvalidBirthDate)
+	}
+
+	today := now.UTC().Format(repository.DateLayout)
+	if value > today || repository.Age(date, now.UTC()) > MaxAge {
+		return time.Time{}, erro.NewErrInvalidArgument(erro.ErrImplausibleBirthDate(MaxAge))
+	}
+	return date, nil
+}
+
+// age is the user's age today, computed from the birth date when it is
+// known, or else the age stored before birth dates were.
+func (s service) age(user repository.User) uint32 {
+	if user.BirthDate.IsZero() {
+		return user.Age
+	}
+	return repository.Age(user.BirthDate, s.now().UTC())
+}
+
+func formatBirthDate(date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(repository.DateLayout)
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/repository"
+)
+
+var today = time.Date(2026, 7, 9, 12, 0, 0, 0, time.UTC)
+
+func newTestService(repo repository.Repository) *service {
+	s := NewService(repo, gracePeriod, EmailConfig{}, TOTPConfig{}, log.NewNopLogger()).(*service)
+	s.now = func() time.Time { return today }
+	return s
+}
+
+func TestParseBirthDate(t *testing.T) {
+	testCases := []struct {
+		testName string
+		value    string
+		date     time.Time
+		err      string
+	}{
+		{testName: "empty", value: ""},
+		{testName: "valid", value: "1988-07-09", date: time.Date(1988, 7, 9, 0, 0, 0, 0, time.UTC)},
+		{testName: "today", value: "2026-07-09", date: time.Date(2026, 7, 9, 0, 0, 0, 0, time.UTC)},
+		{testName: "the oldest age", value: "1875-07-10", date: time.Date(1875, 7, 10, 0, 0, 0, 0, time.UTC)},
+		{testName: "too old", value: "1875-07-09", err: erro.ErrImplausibleBirthDate(MaxAge)},
+		{testName: "in the future", value: "2026-07-10", err: erro.ErrImplausibleBirthDate(MaxAge)},
+		{testName: "not a date", value: "1988-02-30", err: erro.ErrInvalidBirthDate},
+		{testName: "with a time", value: "1988-07-09T00:00:00Z", err: erro.ErrInvalidBirthDate},
+		{testName: "other format", value: "09/07/1988", err: erro.ErrInvalidBirthDate},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			date, err := parseBirthDate(tc.value, today)
+			if tc.err == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.date, date)
+				return
+			}
+			assert.IsType(t, &erro.ErrInvalidArgument{}, err)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestGetUserAge(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		user          repository.User
+		checkResponse func(t *testing.T, response GetUserResponse)
+	}{
+		{
+			testName: "computed from the birth date",
+			user:     repository.User{UserId: "u1", Age: 30, BirthDate: time.Date(1988, 7, 10, 0, 0, 0, 0, time.UTC)},
+			checkResponse: func(t *testing.T, response GetUserResponse) {
+				assert.Equal(t, uint32(37), response.Age, "the stored age is ignored")
+				assert.Equal(t, "1988-07-10", response.BirthDate)
+			},
+		},
+		{
+			testName: "stored age when the birth date is unknown",
+			user:     repository.User{UserId: "u1", Age: 30},
+			checkResponse: func(t *testing.T, response GetUserResponse) {
+				assert.Equal(t, uint32(30), response.Age)
+				assert.Empty(t, response.BirthDate)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			repo.On("GetUser", mock.Anything, "u1").Return(tc.user, nil)
+
+			response, err := newTestService(repo).GetUser(context.Background(), "u1")
+			require.NoError(t, err)
+			tc.checkResponse(t, response)
+		})
+	}
+}
+
+func TestCreateUserBirthDate(t *testing.T) {
+	repo := new(repoMock)
+	repo.On("CreateUser", mock.Anything, mock.MatchedBy(func(user repository.User) bool {
+		return user.BirthDate.Equal(time.Date(2000, 2, 29, 0, 0, 0, 0, time.UTC))
+	})).Return(nil)
+
+	s := newTestService(repo)
+	response, err := s.CreateUser(context.Background(), CreateUserRequest{Name: "javier", Pwd: "javier123", BirthDate: "2000-02-29"})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(26), response.Age)
+	assert.Equal(t, "2000-02-29", response.BirthDate)
+
+	_, err = s.CreateUser(context.Background(), CreateUserRequest{Name: "javier", Pwd: "javier123", BirthDate: "2100-01-01"})
+	assert.EqualError(t, err, erro.ErrImplausibleBirthDate(MaxAge))
+	repo.AssertNumberOfCalls(t, "CreateUser", 1)
+}
+
+func TestUpdateUserBirthDate(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		req           UpdateUserRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, response UpdateUserResponse, resError error)
+	}{
+		{
+			testName: "set",
+			req:      UpdateUserRequest{UserId: "u1", BirthDate: "1988-07-09"},
+			buildStubs: func(repo *repoMock) {
+				repo.On("UpdateUser", mock.Anything, repository.User{UserId: "u1", BirthDate: time.Date(1988, 7, 9, 0, 0, 0, 0, time.UTC)}, []string{repository.FieldBirthDate}, mock.Anything).Return(repository.User{UserId: "u1", BirthDate: time.Date(1988, 7, 9, 0, 0, 0, 0, time.UTC)}, nil)
+			},
+			checkResponse: func(t *testing.T, response UpdateUserResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, uint32(38), response.Age)
+				assert.Equal(t, "1988-07-09", response.BirthDate)
+			},
+		},
+		{
+			testName: "cleared with a mask",
+			req:      UpdateUserRequest{UserId: "u1", Fields: []string{FieldBirthDate}},
+			buildStubs: func(repo *repoMock) {
+				repo.On("UpdateUser", mock.Anything, repository.User{UserId: "u1"}, []string{repository.FieldBirthDate}, mock.Anything).Return(repository.User{UserId: "u1", Age: 30}, nil)
+			},
+			checkResponse: func(t *testing.T, response UpdateUserResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, uint32(30), response.Age)
+				assert.Empty(t, response.BirthDate)
+			},
+		},
+		{
+			testName:   "invalid",
+			req:        UpdateUserRequest{UserId: "u1", BirthDate: "yesterday"},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, response UpdateUserResponse, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+				assert.EqualError(t, resError, erro.ErrInvalidBirthDate)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := newTestService(repo).UpdateUser(context.Background(), tc.req)
+			tc.checkResponse(t, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/notify"
+)
+
+// EmailConfig configures email address verification.
+type EmailConfig struct {
+	Notifier notify.Notifier
+	// VerificationTTL is how long a verification token is valid.
+	VerificationTTL time.Duration
+	// VerifyURL, when set, is sent as a link with the token appended as the
+	// token query parameter. Otherwise only the token is sent.
+	VerifyURL string
+}
+
+const verificationSubject = "Verify your email address"
+
+// newToken returns a random token, for email verifications and login
+// challenges, and the hash it is stored as.
+func newToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c EmailConfig) verificationMessage(to, token string) notify.Message {
+	body := fmt.Sprintf("Use this code to verify your email address: %s\n", token)
+	if c.VerifyURL != "" {
+		link, err := url.Parse(c.VerifyURL)
+		if err == nil {
+			query := link.Query()
+			query.Set("token", token)
+			link.RawQuery = query.Encode()
+			body = fmt.Sprintf("Open this link to verify your email address:\n\n%s\n", link)
+		}
+	}
+	body += "\nIf you did not ask for it, ignore this message.\n"
+
+	return notify.Message{To: to, Subject: verificationSubject, Body: body}
+}
+
+// isEmail tells whether a login name is an email address rather than a
+// user name.
+func isEmail(name string) bool {
+	address, err := mail.ParseAddress(name)
+	return err == nil && address.Address == name
+}
+
+// SendVerification sends a token to the user's email address that verifies
+// it through VerifyEmail.
+func (s service) SendVerification(ctx context.Context, userId string) error {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "SendVerification")
+
+	if userId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId"))
+	}
+
+	user, err := s.repository.GetUser(ctx, userId)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	if user.Profile.Email == "" {
+		level.Error(logger).Log("err", erro.ErrNoEmail, "userId", userId)
+		return erro.NewErrFailedPrecondition(erro.ErrNoEmail)
+	}
+	if user.EmailVerified {
+		level.Error(logger).Log("err", erro.ErrEmailAlreadyVerified, "userId", userId)
+		return erro.NewErrFailedPrecondition(erro.ErrEmailAlreadyVerified)
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+	if err := s.repository.CreateEmailVerification(ctx, userId, user.Profile.Email, hash, s.email.VerificationTTL); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	if err := s.email.Notifier.Notify(ctx, s.email.verificationMessage(user.Profile.Email, token)); err != nil {
+		// Mail servers may quote the rejected message back.
+		err = errors.New(strings.ReplaceAll(err.Error(), token, logging.Redacted))
+		level.Error(logger).Log("err", err.Error(), "userId", userId)
+		return err
+	}
+
+	return nil
+}
+
+// VerifyEmail verifies the email address a token was sent to and returns
+// its user.
+func (s service) VerifyEmail(ctx context.Context, token string) (GetUserResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "VerifyEmail")
+
+	if token == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("token"))
+		return GetUserResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("token"))
+	}
+
+	userId, err := s.repository.VerifyEmail(ctx, hashToken(token))
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return GetUserResponse{}, err
+	}
+
+	return s.GetUser(ctx, userId)
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/notify"
+	"github.com/javibauza/final-project/grpc-service/repository"
+)
+
+const verificationTTL = 24 * time.Hour
+
+type notifierMock struct {
+	mock.Mock
+}
+
+func (m *notifierMock) Notify(ctx context.Context, msg notify.Message) error {
+	args := m.Called(ctx, msg)
+
+	return args.Error(0)
+}
+
+// tokenFrom extracts the token from the link in a verification message.
+func tokenFrom(t *testing.T, msg notify.Message) string {
+	for _, line := range strings.Split(msg.Body, "\n") {
+		if strings.HasPrefix(line, "https://") {
+			link, err := url.Parse(line)
+			require.NoError(t, err)
+			return link.Query().Get("token")
+		}
+	}
+	t.Fatalf("no link in %q", msg.Body)
+	return ""
+}
+
+func TestSendVerification(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		userId        string
+		buildStubs    func(repo *repoMock, notifier *notifierMock)
+		checkResponse func(t *testing.T, repo *repoMock, notifier *notifierMock, resError error)
+	}{
+		{
+			testName: "verification sent",
+			userId:   "u1",
+			buildStubs: func(repo *repoMock, notifier *notifierMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1", Profile: Profile{Email: "javier@example.com"}}, nil)
+				repo.On("CreateEmailVerification", mock.Anything, "u1", "javier@example.com", mock.AnythingOfType("string"), verificationTTL).Return(nil)
+				notifier.On("Notify", mock.Anything, mock.AnythingOfType("notify.Message")).Return(nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, notifier *notifierMock, resError error) {
+				require.NoError(t, resError)
+
+				msg := notifier.Calls[0].Arguments.Get(1).(notify.Message)
+				assert.Equal(t, "javier@example.com", msg.To)
+				assert.Equal(t, verificationSubject, msg.Subject)
+				assert.Contains(t, msg.Body, "https://users.example.com/verify?lang=es&token=")
+
+				token := tokenFrom(t, msg)
+				hash := repo.Calls[1].Arguments.String(3)
+				assert.Equal(t, hashToken(token), hash, "only the hash of the token is stored")
+				assert.NotEqual(t, token, hash)
+			},
+		},
+		{
+			testName: "user has no email",
+			userId:   "u1",
+			buildStubs: func(repo *repoMock, notifier *notifierMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1"}, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, notifier *notifierMock, resError error) {
+				assert.IsType(t, &erro.ErrFailedPrecondition{}, resError)
+				assert.EqualError(t, resError, erro.ErrNoEmail)
+			},
+		},
+		{
+			testName: "email already verified",
+			userId:   "u1",
+			buildStubs: func(repo *repoMock, notifier *notifierMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1", Profile: Profile{Email: "javier@example.com"}, EmailVerified: true}, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, notifier *notifierMock, resError error) {
+				assert.IsType(t, &erro.ErrFailedPrecondition{}, resError)
+				assert.EqualError(t, resError, erro.ErrEmailAlreadyVerified)
+			},
+		},
+		{
+			testName: "notifier fails",
+			userId:   "u1",
+			buildStubs: func(repo *repoMock, notifier *notifierMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1", Profile: Profile{Email: "javier@example.com"}}, nil)
+				repo.On("CreateEmailVerification", mock.Anything, "u1", "javier@example.com", mock.AnythingOfType("string"), verificationTTL).Return(nil)
+				notifier.On("Notify", mock.Anything, mock.AnythingOfType("notify.Message")).Return(errors.New("connection refused"))
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, notifier *notifierMock, resError error) {
+				assert.EqualError(t, resError, "connection refused")
+			},
+		},
+		{
+			testName:   "empty userId",
+			buildStubs: func(repo *repoMock, notifier *notifierMock) {},
+			checkResponse: func(t *testing.T, repo *repoMock, notifier *notifierMock, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			notifier := new(notifierMock)
+			tc.buildStubs(repo, notifier)
+
+			s := NewService(repo, gracePeriod, EmailConfig{
+				Notifier:        notifier,
+				VerificationTTL: verificationTTL,
+				VerifyURL:       "https://users.example.com/verify?lang=es",
+			}, TOTPConfig{}, log.NewNopLogger())
+			err := s.SendVerification(context.Background(), tc.userId)
+			tc.checkResponse(t, repo, notifier, err)
+			repo.AssertExpectations(t)
+			notifier.AssertExpectations(t)
+		})
+	}
+}
+
+func TestVerificationMessageWithoutURL(t *testing.T) {
+	msg := EmailConfig{}.verificationMessage("javier@example.com", "the-token")
+	assert.Contains(t, msg.Body, "code to verify your email address: the-token\n")
+}
+
+func TestVerifyEmail(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		token         string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, response GetUserResponse, resError error)
+	}{
+		{
+			testName: "email verified",
+			token:    "the-token",
+			buildStubs: func(repo *repoMock) {
+				repo.On("VerifyEmail", mock.Anything, hashToken("the-token")).Return("u1", nil)
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1", EmailVerified: true}, nil)
+			},
+			checkResponse: func(t *testing.T, response GetUserResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, "u1", response.UserId)
+				assert.True(t, response.EmailVerified)
+			},
+		},
+		{
+			testName: "invalid token",
+			token:    "other",
+			buildStubs: func(repo *repoMock) {
+				repo.On("VerifyEmail", mock.Anything, hashToken("other")).
+					Return("", erro.NewErrInvalidArgument(erro.ErrInvalidVerificationToken))
+			},
+			checkResponse: func(t *testing.T, response GetUserResponse, resError error) {
+				assert.Empty(t, response)
+				assert.EqualError(t, resError, erro.ErrInvalidVerificationToken)
+			},
+		},
+		{
+			testName:   "empty token",
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, response GetUserResponse, resError error) {
+				assert.EqualError(t, resError, erro.ErrRequiredFields("token"))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := NewService(repo, gracePeriod, EmailConfig{}, TOTPConfig{}, log.NewNopLogger()).VerifyEmail(context.Background(), tc.token)
+			tc.checkResponse(t, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticateByEmail(t *testing.T) {
+	// bcrypt hash of javier123.
+	const pwdHash = "$2a$12$RXSLrffQZDUGljSPdQAPI.W4txkPKkeASl0qSM/tbx7mgMMqnDhui"
+
+	testCases := []struct {
+		testName      string
+		name          string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, userId string, resError error)
+	}{
+		{
+			testName: "verified email",
+			name:     "javier@example.com",
+			buildStubs: func(repo *repoMock) {
+				repo.On("Authenticate", mock.Anything, "javier@example.com").Return(nil, erro.NewErrNotFound())
+				repo.On("AuthenticateByEmail", mock.Anything, "javier@example.com").Return(repository.User{UserId: "u1", PwdHash: pwdHash}, nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{}, &erro.ErrNotFound{})
+				repo.On("RecordLogin", mock.Anything, "u1").Return(nil)
+			},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, "u1", userId)
+			},
+		},
+		{
+			testName: "user name that looks like an email",
+			name:     "javier@example.com",
+			buildStubs: func(repo *repoMock) {
+				repo.On("Authenticate", mock.Anything, "javier@example.com").Return(repository.User{UserId: "u2", PwdHash: pwdHash}, nil)
+				repo.On("GetTOTP", mock.Anything, "u2").Return(repository.TOTP{}, &erro.ErrNotFound{})
+				repo.On("RecordLogin", mock.Anything, "u2").Return(nil)
+			},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, "u2", userId, "user names take precedence")
+			},
+		},
+		{
+			testName: "unverified or unknown email",
+			name:     "javier@example.com",
+			buildStubs: func(repo *repoMock) {
+				repo.On("Authenticate", mock.Anything, "javier@example.com").Return(nil, erro.NewErrNotFound())
+				repo.On("AuthenticateByEmail", mock.Anything, "javier@example.com").Return(repository.User{}, erro.NewErrNotFound())
+			},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				assert.Empty(t, userId)
+				assert.IsType(t, &erro.ErrNotFound{}, resError)
+			},
+		},
+		{
+			testName: "name that is not an email",
+			name:     "javier",
+			buildStubs: func(repo *repoMock) {
+				repo.On("Authenticate", mock.Anything, "javier").Return(nil, erro.NewErrNotFound())
+			},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				assert.IsType(t, &erro.ErrNotFound{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			res, err := NewService(repo, gracePeriod, EmailConfig{}, TOTPConfig{}, log.NewNopLogger()).Authenticate(context.Background(), AuthRequest{Name: tc.name, Pwd: "javier123"})
+			tc.checkResponse(t, res.UserId, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
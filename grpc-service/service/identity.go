@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/repository"
+	"github.com/javibauza/final-project/grpc-service/utils"
+)
+
+// MaxSubjectLength is the maximum length of an external identity's subject,
+// as OpenID Connect limits it.
+const MaxSubjectLength = 255
+
+// FederatedNamePrefix starts the names of the users created for external
+// identities. Local users can't take such names, so the accounts of a
+// provider never collide with or shadow password logins.
+const FederatedNamePrefix = "federated:"
+
+// LinkIdentityRequest links the account Subject of the external OpenID
+// Connect provider Issuer to the user UserId.
+type LinkIdentityRequest struct {
+	UserId  string
+	Issuer  string
+	Subject string
+}
+
+// ProvisionIdentityRequest describes the account Subject of the external
+// OpenID Connect provider Issuer, as its ID token does. Email is only used
+// when the provider verified it.
+type ProvisionIdentityRequest struct {
+	Issuer        string
+	Subject       string
+	DisplayName   string
+	Email         string
+	EmailVerified bool
+}
+
+func validateName(name string) error {
+	if strings.HasPrefix(strings.ToLower(name), FederatedNamePrefix) {
+		return erro.NewErrInvalidArgument(erro.ErrReservedName)
+	}
+	return nil
+}
+
+func validateIdentity(issuer, subject string) error {
+	if issuer == "" || subject == "" {
+		return erro.NewErrInvalidArgument(erro.ErrRequiredFields("issuer", "subject"))
+	}
+	if len(subject) > MaxSubjectLength {
+		return erro.NewErrInvalidArgument(erro.ErrTooLong("subject", MaxSubjectLength))
+	}
+	return nil
+}
+
+// LinkIdentity lets a user log in with an external identity. Each identity
+// can only be linked to one user.
+func (s service) LinkIdentity(ctx context.Context, req LinkIdentityRequest) error {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "LinkIdentity")
+
+	if req.UserId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId"))
+	}
+	if err := validateIdentity(req.Issuer, req.Subject); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	_, err := s.repository.LinkIdentity(ctx, repository.LinkedIdentity{
+		Issuer:  req.Issuer,
+		Subject: req.Subject,
+		UserId:  req.UserId,
+	})
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// ProvisionIdentity links an external identity that is not linked yet and
+// returns the id of its user. The identity is linked to the user with the
+// same verified email address, or else to a new user with a generated name
+// and no usable password. An address of another user that the service can't
+// link to, such as one who enabled two-factor authentication, which the
+// provider can't stand in for, fails with ErrAlreadyExists.
+func (s service) ProvisionIdentity(ctx context.Context, req ProvisionIdentityRequest) (string, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "ProvisionIdentity")
+
+	if err := validateIdentity(req.Issuer, req.Subject); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+	profile := repository.Profile{DisplayName: req.DisplayName}
+	// Unverified addresses could claim someone else's.
+	if req.EmailVerified {
+		profile.Email = req.Email
+	}
+
+	// The password is never handed out, so only the provider logs the user in.
+	pwd, _, err := newToken()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+	// Built as CreateUser builds users, but created with its link in one
+	// transaction, so a failed link leaves no user behind.
+	user, err := s.newUser(CreateUserRequest{
+		Name:    FederatedNamePrefix + utils.RandomString(12),
+		Pwd:     pwd,
+		Profile: profile,
+	})
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+	identity, err := s.repository.ProvisionIdentity(ctx, user, repository.LinkedIdentity{
+		Issuer:  req.Issuer,
+		Subject: req.Subject,
+	})
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+
+	return identity.UserId, nil
+}
+
+// AuthenticateIdentity logs in the user an external identity is linked to.
+// The identity provider only stands in for the password: users with
+// two-factor authentication enabled are challenged as by Authenticate. It
+// fails with ErrNotFound when the identity is not linked and with
+// ErrPermissionDenied when its user has been deleted.
+func (s service) AuthenticateIdentity(ctx context.Context, issuer, subject string) (AuthResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "AuthenticateIdentity")
+
+	if err := validateIdentity(issuer, subject); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, err
+	}
+
+	identity, err := s.repository.GetLinkedIdentity(ctx, issuer, subject)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, err
+	}
+
+	factor, err := s.repository.GetTOTP(ctx, identity.UserId)
+	if _, notFound := err.(*erro.ErrNotFound); !notFound && err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, err
+	}
+	if factor.Confirmed {
+		// Deleted users keep their factor until they are purged.
+		if _, err := s.repository.GetUser(ctx, identity.UserId); err != nil {
+			return AuthResponse{}, linkedUserError(logger, identity.UserId, err)
+		}
+		challenge, err := s.challenge(ctx, identity.UserId)
+		if err != nil {
+			level.Error(logger).Log("err", err.Error())
+		}
+		return challenge, err
+	}
+
+	if err := s.repository.RecordLogin(ctx, identity.UserId); err != nil {
+		return AuthResponse{}, linkedUserError(logger, identity.UserId, err)
+	}
+
+	return AuthResponse{UserId: identity.UserId}, nil
+}
+
+// linkedUserError logs err, and reports a linked user that was not found as
+// deleted.
+func linkedUserError(logger log.Logger, userId string, err error) error {
+	if _, notFound := err.(*erro.ErrNotFound); notFound {
+		level.Error(logger).Log("err", erro.ErrLinkedUserDeleted, "userId", userId)
+		return erro.NewErrPermissionDenied(erro.ErrLinkedUserDeleted)
+	}
+	level.Error(logger).Log("err", err.Error())
+	return err
+}
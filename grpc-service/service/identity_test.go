@@ -0,0 +1,304 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/repository"
+)
+
+const testIssuer = "https://idp.example.com"
+
+func TestLinkIdentity(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		request       LinkIdentityRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, resError error)
+	}{
+		{
+			testName: "identity linked",
+			request:  LinkIdentityRequest{UserId: "u1", Issuer: testIssuer, Subject: "s1"},
+			buildStubs: func(repo *repoMock) {
+				identity := repository.LinkedIdentity{Issuer: testIssuer, Subject: "s1", UserId: "u1"}
+				repo.On("LinkIdentity", mock.Anything, identity).Return(identity, nil)
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "already linked",
+			request:  LinkIdentityRequest{UserId: "u1", Issuer: testIssuer, Subject: "s1"},
+			buildStubs: func(repo *repoMock) {
+				repo.On("LinkIdentity", mock.Anything, mock.AnythingOfType("repository.LinkedIdentity")).
+					Return(repository.LinkedIdentity{}, erro.NewErrAlreadyExists(erro.ErrIdentityLinked))
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.IsType(t, &erro.ErrAlreadyExists{}, resError)
+			},
+		},
+		{
+			testName:   "missing subject",
+			request:    LinkIdentityRequest{UserId: "u1", Issuer: testIssuer},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+				assert.EqualError(t, resError, erro.ErrRequiredFields("issuer", "subject"))
+			},
+		},
+		{
+			testName:   "subject too long",
+			request:    LinkIdentityRequest{UserId: "u1", Issuer: testIssuer, Subject: strings.Repeat("s", MaxSubjectLength+1)},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			err := newTestService(repo).LinkIdentity(context.Background(), tc.request)
+			tc.checkResponse(t, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticateIdentity(t *testing.T) {
+	identity := repository.LinkedIdentity{Issuer: testIssuer, Subject: "s1", UserId: "u1"}
+
+	testCases := []struct {
+		testName      string
+		subject       string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, response AuthResponse, resError error)
+	}{
+		{
+			testName: "logged in",
+			subject:  "s1",
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetLinkedIdentity", mock.Anything, testIssuer, "s1").Return(identity, nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{}, &erro.ErrNotFound{Err: errors.New(erro.ErrTOTPNotEnrolled)})
+				repo.On("RecordLogin", mock.Anything, "u1").Return(nil)
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthResponse{UserId: "u1"}, response)
+			},
+		},
+		{
+			testName: "not linked",
+			subject:  "s2",
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetLinkedIdentity", mock.Anything, testIssuer, "s2").
+					Return(repository.LinkedIdentity{}, erro.NewErrNotFound())
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				assert.IsType(t, &erro.ErrNotFound{}, resError)
+			},
+		},
+		{
+			testName: "user deleted",
+			subject:  "s1",
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetLinkedIdentity", mock.Anything, testIssuer, "s1").Return(identity, nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{}, &erro.ErrNotFound{Err: errors.New(erro.ErrTOTPNotEnrolled)})
+				repo.On("RecordLogin", mock.Anything, "u1").Return(erro.NewErrNotFound())
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				assert.IsType(t, &erro.ErrPermissionDenied{}, resError)
+				assert.EqualError(t, resError, erro.ErrLinkedUserDeleted)
+			},
+		},
+		{
+			testName: "two-factor user is challenged",
+			subject:  "s1",
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetLinkedIdentity", mock.Anything, testIssuer, "s1").Return(identity, nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{Secret: totpSecret, Confirmed: true}, nil)
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1"}, nil)
+				repo.On("CreateAuthChallenge", mock.Anything, "u1", mock.AnythingOfType("string"), mock.Anything).Return(nil)
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Empty(t, response.UserId, "the user is not logged in yet")
+				assert.NotEmpty(t, response.Challenge)
+			},
+		},
+		{
+			testName: "unconfirmed two-factor secret is not required",
+			subject:  "s1",
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetLinkedIdentity", mock.Anything, testIssuer, "s1").Return(identity, nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{Secret: totpSecret}, nil)
+				repo.On("RecordLogin", mock.Anything, "u1").Return(nil)
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthResponse{UserId: "u1"}, response)
+			},
+		},
+		{
+			testName: "deleted two-factor user",
+			subject:  "s1",
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetLinkedIdentity", mock.Anything, testIssuer, "s1").Return(identity, nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{Secret: totpSecret, Confirmed: true}, nil)
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{}, erro.NewErrNotFound())
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				assert.Empty(t, response.Challenge)
+				assert.EqualError(t, resError, erro.ErrLinkedUserDeleted)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := newTestService(repo).AuthenticateIdentity(context.Background(), testIssuer, tc.subject)
+			tc.checkResponse(t, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestProvisionIdentity(t *testing.T) {
+	identity := repository.LinkedIdentity{Issuer: testIssuer, Subject: "s1"}
+	newUser := func(profile repository.Profile) interface{} {
+		return mock.MatchedBy(func(user repository.User) bool {
+			return strings.HasPrefix(user.Name, FederatedNamePrefix) && len(user.Name) > len(FederatedNamePrefix) &&
+				user.UserId != "" && user.PwdHash != "" && user.Profile.DisplayName == profile.DisplayName && user.Profile.Email == profile.Email
+		})
+	}
+
+	testCases := []struct {
+		testName      string
+		request       ProvisionIdentityRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, userId string, resError error)
+	}{
+		{
+			testName: "verified email",
+			request:  ProvisionIdentityRequest{Issuer: testIssuer, Subject: "s1", DisplayName: "Javier", Email: "javier@example.com", EmailVerified: true},
+			buildStubs: func(repo *repoMock) {
+				linked := identity
+				linked.UserId = "u1"
+				repo.On("ProvisionIdentity", mock.Anything, newUser(repository.Profile{DisplayName: "Javier", Email: "javier@example.com"}), identity).Return(linked, nil)
+			},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, "u1", userId)
+			},
+		},
+		{
+			testName: "unverified email is dropped",
+			request:  ProvisionIdentityRequest{Issuer: testIssuer, Subject: "s1", DisplayName: "Javier", Email: "javier@example.com"},
+			buildStubs: func(repo *repoMock) {
+				linked := identity
+				linked.UserId = "u2"
+				repo.On("ProvisionIdentity", mock.Anything, newUser(repository.Profile{DisplayName: "Javier"}), identity).Return(linked, nil)
+			},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, "u2", userId)
+			},
+		},
+		{
+			testName: "email of an account that can't be linked",
+			request:  ProvisionIdentityRequest{Issuer: testIssuer, Subject: "s1", Email: "ana@example.com", EmailVerified: true},
+			buildStubs: func(repo *repoMock) {
+				repo.On("ProvisionIdentity", mock.Anything, newUser(repository.Profile{Email: "ana@example.com"}), identity).
+					Return(repository.LinkedIdentity{}, erro.NewErrAlreadyExists(erro.ErrEmailNotLinkable))
+			},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				assert.IsType(t, &erro.ErrAlreadyExists{}, resError)
+			},
+		},
+		{
+			testName:   "missing subject",
+			request:    ProvisionIdentityRequest{Issuer: testIssuer},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+			},
+		},
+		{
+			testName:   "invalid email",
+			request:    ProvisionIdentityRequest{Issuer: testIssuer, Subject: "s1", Email: "not an email", EmailVerified: true},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			userId, err := newTestService(repo).ProvisionIdentity(context.Background(), tc.request)
+			tc.checkResponse(t, userId, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestProvisionIdentityCreatesLikeCreateUser(t *testing.T) {
+	testCases := []struct {
+		testName string
+		profile  repository.Profile
+	}{
+		{testName: "valid", profile: repository.Profile{DisplayName: "Javier", Email: "javier@example.com"}},
+		{testName: "invalid email", profile: repository.Profile{Email: "not an email"}},
+		{testName: "display name too long", profile: repository.Profile{DisplayName: strings.Repeat("a", MaxDisplayNameLength+1)}},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			var created, provisioned repository.User
+			repo := new(repoMock)
+			repo.On("CreateUser", mock.Anything, mock.AnythingOfType("repository.User")).
+				Run(func(args mock.Arguments) { created = args.Get(1).(repository.User) }).
+				Return(nil).Maybe()
+			repo.On("ProvisionIdentity", mock.Anything, mock.AnythingOfType("repository.User"), mock.Anything).
+				Run(func(args mock.Arguments) { provisioned = args.Get(1).(repository.User) }).
+				Return(repository.LinkedIdentity{UserId: "u1"}, nil).Maybe()
+			svc := newTestService(repo)
+
+			_, createErr := svc.CreateUser(context.Background(), CreateUserRequest{Name: "javier", Pwd: "secret", Profile: tc.profile})
+			_, provisionErr := svc.ProvisionIdentity(context.Background(),
+				ProvisionIdentityRequest{Issuer: testIssuer, Subject: "s1", DisplayName: tc.profile.DisplayName, Email: tc.profile.Email, EmailVerified: true})
+			assert.Equal(t, createErr, provisionErr)
+			if createErr != nil {
+				repo.AssertNotCalled(t, "ProvisionIdentity", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+
+			assert.Len(t, provisioned.UserId, len(created.UserId))
+			assert.Equal(t, created.Profile, provisioned.Profile)
+			assert.Equal(t, created.BirthDate, provisioned.BirthDate)
+			assert.Error(t, bcrypt.CompareHashAndPassword([]byte(provisioned.PwdHash), []byte("")), "federated users have no usable password")
+		})
+	}
+}
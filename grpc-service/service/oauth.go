@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/repository"
+)
+
+const (
+	// AuthorizationCodeTTL is how long a client has to exchange an
+	// authorization code for tokens.
+	AuthorizationCodeTTL = time.Minute
+	// MaxOAuthClientNameLength is the maximum length of an OAuth client's name.
+	MaxOAuthClientNameLength = 100
+)
+
+// OAuthClient describes an application registered with the OpenID Connect
+// provider, without its secret.
+type OAuthClient struct {
+	Id           string
+	Name         string
+	RedirectURIs []string
+	// Confidential clients authenticate with a secret when exchanging codes;
+	// public ones with PKCE alone.
+	Confidential bool
+	CreatedAt    time.Time
+}
+
+type CreateOAuthClientRequest struct {
+	Name         string
+	RedirectURIs []string
+	Confidential bool
+}
+
+type CreateOAuthClientResponse struct {
+	Client OAuthClient
+	// Secret is only set for confidential clients, and only ever returned
+	// here.
+	Secret string
+}
+
+type CreateAuthorizationCodeRequest struct {
+	ClientId    string
+	UserId      string
+	RedirectURI string
+	Scope       string
+	Nonce       string
+	// CodeChallenge is the S256 PKCE challenge the code is bound to.
+	CodeChallenge string
+}
+
+type ExchangeAuthorizationCodeRequest struct {
+	ClientId     string
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+}
+
+type ExchangeAuthorizationCodeResponse struct {
+	UserId string
+	Scope  string
+	Nonce  string
+}
+
+// validRedirectURI reports whether uri is an absolute https URL, or an http
+// one on a loopback host for native apps, without a fragment.
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.Fragment != "" || strings.HasSuffix(uri, "#") {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		if u.Hostname() == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(u.Hostname())
+		return ip != nil && ip.IsLoopback()
+	}
+	return false
+}
+
+// pkceVerified reports whether verifier matches the S256 challenge.
+func pkceVerified(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func oauthClientFromRepo(client repository.OAuthClient) OAuthClient {
+	return OAuthClient{
+		Id:           client.Id,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+		Confidential: client.SecretHash != "",
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+func registered(client repository.OAuthClient, redirectURI string) bool {
+	for _, uri := range client.RedirectURIs {
+		if uri == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateOAuthClient registers an application that delegates its users' login
+// to the OpenID Connect provider.
+func (s service) CreateOAuthClient(ctx context.Context, req CreateOAuthClientRequest) (CreateOAuthClientResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "CreateOAuthClient")
+
+	if req.Name == "" || len(req.RedirectURIs) == 0 {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("name", "redirectUris"))
+		return CreateOAuthClientResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("name", "redirectUris"))
+	}
+	if len(req.Name) > MaxOAuthClientNameLength {
+		level.Error(logger).Log("err", erro.ErrTooLong("name", MaxOAuthClientNameLength))
+		return CreateOAuthClientResponse{}, erro.NewErrInvalidArgument(erro.ErrTooLong("name", MaxOAuthClientNameLength))
+	}
+	for _, uri := range req.RedirectURIs {
+		if !validRedirectURI(uri) {
+			level.Error(logger).Log("err", erro.ErrInvalidRedirectURI(uri))
+			return CreateOAuthClientResponse{}, erro.NewErrInvalidArgument(erro.ErrInvalidRedirectURI(uri))
+		}
+	}
+
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return CreateOAuthClientResponse{}, err
+	}
+	client := repository.OAuthClient{
+		Id:           strings.ToLower(recoveryEncoding.EncodeToString(b)),
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+	}
+	var secret string
+	if req.Confidential {
+		var err error
+		if secret, client.SecretHash, err = newToken(); err != nil {
+			level.Error(logger).Log("err", err.Error())
+			return CreateOAuthClientResponse{}, err
+		}
+	}
+
+	created, err := s.repository.CreateOAuthClient(ctx, client)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return CreateOAuthClientResponse{}, err
+	}
+
+	return CreateOAuthClientResponse{Client: oauthClientFromRepo(created), Secret: secret}, nil
+}
+
+func (s service) GetOAuthClient(ctx context.Context, clientId string) (OAuthClient, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "GetOAuthClient")
+
+	if clientId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("clientId"))
+		return OAuthClient{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("clientId"))
+	}
+
+	client, err := s.repository.GetOAuthClient(ctx, clientId)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return OAuthClient{}, err
+	}
+
+	return oauthClientFromRepo(client), nil
+}
+
+// CreateAuthorizationCode issues a code the client exchanges for the logged
+// in user's tokens. Only its hash is stored.
+func (s service) CreateAuthorizationCode(ctx context.Context, req CreateAuthorizationCodeRequest) (string, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "CreateAuthorizationCode")
+
+	if req.ClientId == "" || req.UserId == "" || req.RedirectURI == "" || req.CodeChallenge == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("clientId", "userId", "redirectUri", "codeChallenge"))
+		return "", erro.NewErrInvalidArgument(erro.ErrRequiredFields("clientId", "userId", "redirectUri", "codeChallenge"))
+	}
+
+	client, err := s.repository.GetOAuthClient(ctx, req.ClientId)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+	if !registered(client, req.RedirectURI) {
+		level.Error(logger).Log("err", erro.ErrRedirectURINotRegistered, "clientId", req.ClientId)
+		return "", erro.NewErrInvalidArgument(erro.ErrRedirectURINotRegistered)
+	}
+	if _, err := s.repository.GetUser(ctx, req.UserId); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+
+	code, hash, err := newToken()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+	err = s.repository.CreateAuthorizationCode(ctx, repository.AuthorizationCode{
+		CodeHash:      hash,
+		ClientId:      req.ClientId,
+		UserId:        req.UserId,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+	}, AuthorizationCodeTTL)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ExchangeAuthorizationCode redeems a code for the user it was issued for.
+// Confidential clients must present their secret, failing with
+// ErrPermissionDenied otherwise. The code is spent even when the exchange
+// fails, and unknown, expired or mismatched codes all fail with the same
+// ErrInvalidArgument.
+func (s service) ExchangeAuthorizationCode(ctx context.Context, req ExchangeAuthorizationCodeRequest) (ExchangeAuthorizationCodeResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "ExchangeAuthorizationCode")
+
+	if req.ClientId == "" || req.Code == "" || req.RedirectURI == "" || req.CodeVerifier == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("clientId", "code", "redirectUri", "codeVerifier"))
+		return ExchangeAuthorizationCodeResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("clientId", "code", "redirectUri", "codeVerifier"))
+	}
+
+	client, err := s.repository.GetOAuthClient(ctx, req.ClientId)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return ExchangeAuthorizationCodeResponse{}, err
+	}
+	if client.SecretHash != "" && subtle.ConstantTimeCompare([]byte(hashToken(req.ClientSecret)), []byte(client.SecretHash)) != 1 {
+		level.Error(logger).Log("err", erro.ErrInvalidClientSecret, "clientId", req.ClientId)
+		return ExchangeAuthorizationCodeResponse{}, erro.NewErrPermissionDenied(erro.ErrInvalidClientSecret)
+	}
+
+	code, err := s.repository.ConsumeAuthorizationCode(ctx, hashToken(req.Code))
+	if _, notFound := err.(*erro.ErrNotFound); notFound {
+		level.Error(logger).Log("err", erro.ErrInvalidAuthorizationCode, "clientId", req.ClientId)
+		return ExchangeAuthorizationCodeResponse{}, erro.NewErrInvalidArgument(erro.ErrInvalidAuthorizationCode)
+	}
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return ExchangeAuthorizationCodeResponse{}, err
+	}
+	if code.ClientId != req.ClientId || code.RedirectURI != req.RedirectURI ||
+		!s.now().Before(code.ExpiresAt) || !pkceVerified(req.CodeVerifier, code.CodeChallenge) {
+		level.Error(logger).Log("err", erro.ErrInvalidAuthorizationCode, "clientId", req.ClientId)
+		return ExchangeAuthorizationCodeResponse{}, erro.NewErrInvalidArgument(erro.ErrInvalidAuthorizationCode)
+	}
+
+	if _, err := s.repository.GetUser(ctx, code.UserId); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		if _, notFound := err.(*erro.ErrNotFound); notFound {
+			return ExchangeAuthorizationCodeResponse{}, erro.NewErrInvalidArgument(erro.ErrInvalidAuthorizationCode)
+		}
+		return ExchangeAuthorizationCodeResponse{}, err
+	}
+
+	return ExchangeAuthorizationCodeResponse{UserId: code.UserId, Scope: code.Scope, Nonce: code.Nonce}, nil
+}
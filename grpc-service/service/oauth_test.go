@@ -0,0 +1,347 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/repository"
+)
+
+// RFC 7636 appendix B.
+const (
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestValidRedirectURI(t *testing.T) {
+	for _, uri := range []string{"https://wiki.example.com/cb", "https://wiki.example.com/cb?tenant=1", "http://localhost:3000/cb", "http://127.0.0.1/cb", "http://[::1]:8080/cb"} {
+		assert.True(t, validRedirectURI(uri), uri)
+	}
+	for _, uri := range []string{"", "/cb", "http://wiki.example.com/cb", "https://wiki.example.com/cb#top", "https://wiki.example.com/cb#", "javascript:alert(1)", "https:///cb"} {
+		assert.False(t, validRedirectURI(uri), uri)
+	}
+}
+
+func TestPKCEVerified(t *testing.T) {
+	assert.True(t, pkceVerified(testVerifier, testChallenge))
+	assert.False(t, pkceVerified(testVerifier+"x", testChallenge))
+	assert.False(t, pkceVerified(testChallenge, testChallenge))
+}
+
+func TestCreateOAuthClient(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		request       CreateOAuthClientRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, repo *repoMock, response CreateOAuthClientResponse, resError error)
+	}{
+		{
+			testName: "confidential client",
+			request:  CreateOAuthClientRequest{Name: "crm", RedirectURIs: []string{"https://crm.example.com/cb"}, Confidential: true},
+			buildStubs: func(repo *repoMock) {
+				repo.On("CreateOAuthClient", mock.Anything, mock.AnythingOfType("repository.OAuthClient")).
+					Return(func(_ context.Context, client repository.OAuthClient) repository.OAuthClient {
+						client.CreatedAt = today
+						return client
+					}, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response CreateOAuthClientResponse, resError error) {
+				require.NoError(t, resError)
+				assert.NotEmpty(t, response.Secret)
+
+				stored := repo.Calls[0].Arguments.Get(1).(repository.OAuthClient)
+				assert.Equal(t, hashToken(response.Secret), stored.SecretHash, "only the hash of the secret is stored")
+				assert.Regexp(t, regexp.MustCompile(`^[a-z2-7]{16}$`), stored.Id)
+				assert.Equal(t, OAuthClient{
+					Id:           stored.Id,
+					Name:         "crm",
+					RedirectURIs: []string{"https://crm.example.com/cb"},
+					Confidential: true,
+					CreatedAt:    today,
+				}, response.Client)
+			},
+		},
+		{
+			testName: "public client",
+			request:  CreateOAuthClientRequest{Name: "cli", RedirectURIs: []string{"http://127.0.0.1/cb"}},
+			buildStubs: func(repo *repoMock) {
+				repo.On("CreateOAuthClient", mock.Anything, mock.AnythingOfType("repository.OAuthClient")).
+					Return(func(_ context.Context, client repository.OAuthClient) repository.OAuthClient {
+						return client
+					}, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response CreateOAuthClientResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Empty(t, response.Secret)
+				assert.False(t, response.Client.Confidential)
+				assert.Empty(t, repo.Calls[0].Arguments.Get(1).(repository.OAuthClient).SecretHash)
+			},
+		},
+		{
+			testName:   "invalid redirect uri",
+			request:    CreateOAuthClientRequest{Name: "crm", RedirectURIs: []string{"https://crm.example.com/cb", "http://crm.example.com/cb"}},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, repo *repoMock, response CreateOAuthClientResponse, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+				assert.EqualError(t, resError, erro.ErrInvalidRedirectURI("http://crm.example.com/cb"))
+			},
+		},
+		{
+			testName:   "no redirect uris",
+			request:    CreateOAuthClientRequest{Name: "crm"},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, repo *repoMock, response CreateOAuthClientResponse, resError error) {
+				assert.EqualError(t, resError, erro.ErrRequiredFields("name", "redirectUris"))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := newTestService(repo).CreateOAuthClient(context.Background(), tc.request)
+			tc.checkResponse(t, repo, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateAuthorizationCode(t *testing.T) {
+	client := repository.OAuthClient{Id: "c1", RedirectURIs: []string{"https://wiki.example.com/cb"}}
+	request := CreateAuthorizationCodeRequest{ClientId: "c1", UserId: "u1", RedirectURI: "https://wiki.example.com/cb", Scope: "openid", Nonce: "n", CodeChallenge: testChallenge}
+
+	testCases := []struct {
+		testName      string
+		request       CreateAuthorizationCodeRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, repo *repoMock, code string, resError error)
+	}{
+		{
+			testName: "code created",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(client, nil)
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1"}, nil)
+				repo.On("CreateAuthorizationCode", mock.Anything, mock.AnythingOfType("repository.AuthorizationCode"), AuthorizationCodeTTL).Return(nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, code string, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, repository.AuthorizationCode{
+					CodeHash:      hashToken(code),
+					ClientId:      "c1",
+					UserId:        "u1",
+					RedirectURI:   "https://wiki.example.com/cb",
+					Scope:         "openid",
+					Nonce:         "n",
+					CodeChallenge: testChallenge,
+				}, repo.Calls[2].Arguments.Get(1), "only the hash of the code is stored")
+			},
+		},
+		{
+			testName: "unregistered redirect uri",
+			request: func() CreateAuthorizationCodeRequest {
+				req := request
+				req.RedirectURI = "https://evil.example.com/cb"
+				return req
+			}(),
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(client, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, code string, resError error) {
+				assert.Empty(t, code)
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+				assert.EqualError(t, resError, erro.ErrRedirectURINotRegistered)
+			},
+		},
+		{
+			testName: "unknown client",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(repository.OAuthClient{}, erro.NewErrNotFound())
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, code string, resError error) {
+				assert.IsType(t, &erro.ErrNotFound{}, resError)
+			},
+		},
+		{
+			testName: "no code challenge",
+			request: func() CreateAuthorizationCodeRequest {
+				req := request
+				req.CodeChallenge = ""
+				return req
+			}(),
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, repo *repoMock, code string, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			code, err := newTestService(repo).CreateAuthorizationCode(context.Background(), tc.request)
+			tc.checkResponse(t, repo, code, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestExchangeAuthorizationCode(t *testing.T) {
+	const code = "the-code"
+	public := repository.OAuthClient{Id: "c1", RedirectURIs: []string{"https://wiki.example.com/cb"}}
+	confidential := repository.OAuthClient{Id: "c1", SecretHash: hashToken("secret"), RedirectURIs: []string{"https://wiki.example.com/cb"}}
+	stored := repository.AuthorizationCode{
+		CodeHash:      hashToken(code),
+		ClientId:      "c1",
+		UserId:        "u1",
+		RedirectURI:   "https://wiki.example.com/cb",
+		Scope:         "openid email",
+		Nonce:         "n",
+		CodeChallenge: testChallenge,
+		ExpiresAt:     today.Add(AuthorizationCodeTTL),
+	}
+	request := ExchangeAuthorizationCodeRequest{ClientId: "c1", Code: code, RedirectURI: "https://wiki.example.com/cb", CodeVerifier: testVerifier}
+
+	testCases := []struct {
+		testName      string
+		request       ExchangeAuthorizationCodeRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, response ExchangeAuthorizationCodeResponse, resError error)
+	}{
+		{
+			testName: "exchanged",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(public, nil)
+				repo.On("ConsumeAuthorizationCode", mock.Anything, hashToken(code)).Return(stored, nil)
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1"}, nil)
+			},
+			checkResponse: func(t *testing.T, response ExchangeAuthorizationCodeResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, ExchangeAuthorizationCodeResponse{UserId: "u1", Scope: "openid email", Nonce: "n"}, response)
+			},
+		},
+		{
+			testName: "confidential client with secret",
+			request: func() ExchangeAuthorizationCodeRequest {
+				req := request
+				req.ClientSecret = "secret"
+				return req
+			}(),
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(confidential, nil)
+				repo.On("ConsumeAuthorizationCode", mock.Anything, hashToken(code)).Return(stored, nil)
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1"}, nil)
+			},
+			checkResponse: func(t *testing.T, response ExchangeAuthorizationCodeResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, "u1", response.UserId)
+			},
+		},
+		{
+			testName: "confidential client without secret",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(confidential, nil)
+			},
+			checkResponse: func(t *testing.T, response ExchangeAuthorizationCodeResponse, resError error) {
+				assert.IsType(t, &erro.ErrPermissionDenied{}, resError)
+				assert.EqualError(t, resError, erro.ErrInvalidClientSecret)
+			},
+		},
+		{
+			testName: "wrong verifier",
+			request: func() ExchangeAuthorizationCodeRequest {
+				req := request
+				req.CodeVerifier = "guess"
+				return req
+			}(),
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(public, nil)
+				repo.On("ConsumeAuthorizationCode", mock.Anything, hashToken(code)).Return(stored, nil)
+			},
+			checkResponse: func(t *testing.T, response ExchangeAuthorizationCodeResponse, resError error) {
+				assert.Empty(t, response)
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+				assert.EqualError(t, resError, erro.ErrInvalidAuthorizationCode)
+			},
+		},
+		{
+			testName: "other redirect uri",
+			request: func() ExchangeAuthorizationCodeRequest {
+				req := request
+				req.RedirectURI = "https://wiki.example.com/other"
+				return req
+			}(),
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(public, nil)
+				repo.On("ConsumeAuthorizationCode", mock.Anything, hashToken(code)).Return(stored, nil)
+			},
+			checkResponse: func(t *testing.T, response ExchangeAuthorizationCodeResponse, resError error) {
+				assert.EqualError(t, resError, erro.ErrInvalidAuthorizationCode)
+			},
+		},
+		{
+			testName: "expired",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				expired := stored
+				expired.ExpiresAt = today
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(public, nil)
+				repo.On("ConsumeAuthorizationCode", mock.Anything, hashToken(code)).Return(expired, nil)
+			},
+			checkResponse: func(t *testing.T, response ExchangeAuthorizationCodeResponse, resError error) {
+				assert.EqualError(t, resError, erro.ErrInvalidAuthorizationCode)
+			},
+		},
+		{
+			testName: "already used",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(public, nil)
+				repo.On("ConsumeAuthorizationCode", mock.Anything, hashToken(code)).Return(repository.AuthorizationCode{}, &erro.ErrNotFound{})
+			},
+			checkResponse: func(t *testing.T, response ExchangeAuthorizationCodeResponse, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+				assert.EqualError(t, resError, erro.ErrInvalidAuthorizationCode)
+			},
+		},
+		{
+			testName: "user deleted since",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(public, nil)
+				repo.On("ConsumeAuthorizationCode", mock.Anything, hashToken(code)).Return(stored, nil)
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{}, erro.NewErrNotFound())
+			},
+			checkResponse: func(t *testing.T, response ExchangeAuthorizationCodeResponse, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := newTestService(repo).ExchangeAuthorizationCode(context.Background(), tc.request)
+			tc.checkResponse(t, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	// Validate time zones the same way whatever tzdata the host has.
+	_ "time/tzdata"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/repository"
+)
+
+// Profile is the user's structured profile.
+type Profile = repository.Profile
+
+// Profile limits.
+const (
+	MaxEmailLength         = 254
+	MaxDisplayNameLength   = 100
+	MaxMetadataEntries     = 32
+	MaxMetadataKeyLength   = 64
+	MaxMetadataValueLength = 1024
+)
+
+// Update mask paths of the profile, named after the Profile proto fields.
+// FieldMetadata followed by "." and a key sets or removes a single key.
+const (
+	FieldProfile     = "profile"
+	FieldEmail       = "profile.email"
+	FieldDisplayName = "profile.display_name"
+	FieldLocale      = "profile.locale"
+	FieldTimezone    = "profile.timezone"
+	FieldMetadata    = "profile.metadata"
+)
+
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+// validateProfile checks the members of profile that are set.
+func validateProfile(profile repository.Profile) error {
+	if profile.Email != "" {
+		addr, err := mail.ParseAddress(profile.Email)
+		if err != nil || addr.Address != profile.Email || len(profile.Email) > MaxEmailLength {
+			return erro.NewErrInvalidArgument(erro.ErrInvalidEmail)
+		}
+	}
+	if utf8.RuneCountInString(profile.DisplayName) > MaxDisplayNameLength {
+		return erro.NewErrInvalidArgument(erro.ErrTooLong(FieldDisplayName, MaxDisplayNameLength))
+	}
+	if profile.Locale != "" && !localePattern.MatchString(profile.Locale) {
+		return erro.NewErrInvalidArgument(erro.ErrInvalidLocale)
+	}
+	if profile.Timezone != "" {
+		// LoadLocation also accepts "Local", the host's zone.
+		if _, err := time.LoadLocation(profile.Timezone); err != nil || profile.Timezone == "Local" {
+			return erro.NewErrInvalidArgument(erro.ErrInvalidTimezone)
+		}
+	}
+	if len(profile.Metadata) > MaxMetadataEntries {
+		return erro.NewErrInvalidArgument(erro.ErrTooManyMetadataEntries(MaxMetadataEntries))
+	}
+	for key, value := range profile.Metadata {
+		if key == "" {
+			return erro.NewErrInvalidArgument(erro.ErrEmptyMetadataKey)
+		}
+		if utf8.RuneCountInString(key) > MaxMetadataKeyLength {
+			return erro.NewErrInvalidArgument(erro.ErrTooLong(FieldMetadata+" key "+key, MaxMetadataKeyLength))
+		}
+		if utf8.RuneCountInString(value) > MaxMetadataValueLength {
+			return erro.NewErrInvalidArgument(erro.ErrTooLong(FieldMetadata+"."+key, MaxMetadataValueLength))
+		}
+	}
+	return nil
+}
+
+// profileField maps a profile update mask path to the repository field it
+// writes.
+func profileField(path string) (string, bool) {
+	switch path {
+	case FieldProfile:
+		return repository.FieldProfile, true
+	case FieldEmail:
+		return repository.FieldEmail, true
+	case FieldDisplayName:
+		return repository.FieldDisplayName, true
+	case FieldLocale:
+		return repository.FieldLocale, true
+	case FieldTimezone:
+		return repository.FieldTimezone, true
+	case FieldMetadata:
+		return repository.FieldMetadata, true
+	}
+	if key := strings.TrimPrefix(path, FieldMetadata+"."); key != path && key != "" {
+		return repository.FieldMetadata + "." + key, true
+	}
+	return "", false
+}
+
+// nonEmptyProfileFields lists the set members of profile, each metadata key
+// separately so that keys not in the request are kept.
+func nonEmptyProfileFields(profile repository.Profile) []string {
+	var paths []string
+	if profile.Email != "" {
+		paths = append(paths, FieldEmail)
+	}
+	if profile.DisplayName != "" {
+		paths = append(paths, FieldDisplayName)
+	}
+	if profile.Locale != "" {
+		paths = append(paths, FieldLocale)
+	}
+	if profile.Timezone != "" {
+		paths = append(paths, FieldTimezone)
+	}
+	keys := make([]string, 0, len(profile.Metadata))
+	for key := range profile.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		paths = append(paths, FieldMetadata+"."+key)
+	}
+	return paths
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+)
+
+func TestValidateProfile(t *testing.T) {
+	manyEntries := map[string]string{}
+	for i := 0; i <= MaxMetadataEntries; i++ {
+		manyEntries[strings.Repeat("k", i+1)] = "v"
+	}
+
+	testCases := []struct {
+		testName string
+		profile  Profile
+		err      string
+	}{
+		{
+			testName: "empty profile",
+		},
+		{
+			testName: "complete profile",
+			profile: Profile{
+				Email:       "javier@example.com",
+				DisplayName: "Javier Bauza",
+				Locale:      "es-MX",
+				Timezone:    "America/Mexico_City",
+				Metadata:    map[string]string{"add_info": "likes go"},
+			},
+		},
+		{
+			testName: "email with a display name",
+			profile:  Profile{Email: "Javier <javier@example.com>"},
+			err:      erro.ErrInvalidEmail,
+		},
+		{
+			testName: "email without a domain",
+			profile:  Profile{Email: "javier"},
+			err:      erro.ErrInvalidEmail,
+		},
+		{
+			testName: "display name too long",
+			profile:  Profile{DisplayName: strings.Repeat("j", MaxDisplayNameLength+1)},
+			err:      erro.ErrTooLong(FieldDisplayName, MaxDisplayNameLength),
+		},
+		{
+			testName: "locale not a language tag",
+			profile:  Profile{Locale: "spanish please"},
+			err:      erro.ErrInvalidLocale,
+		},
+		{
+			testName: "unknown time zone",
+			profile:  Profile{Timezone: "Mars/Olympus"},
+			err:      erro.ErrInvalidTimezone,
+		},
+		{
+			testName: "host time zone",
+			profile:  Profile{Timezone: "Local"},
+			err:      erro.ErrInvalidTimezone,
+		},
+		{
+			testName: "too many metadata entries",
+			profile:  Profile{Metadata: manyEntries},
+			err:      erro.ErrTooManyMetadataEntries(MaxMetadataEntries),
+		},
+		{
+			testName: "empty metadata key",
+			profile:  Profile{Metadata: map[string]string{"": "v"}},
+			err:      erro.ErrEmptyMetadataKey,
+		},
+		{
+			testName: "metadata value too long",
+			profile:  Profile{Metadata: map[string]string{"k": strings.Repeat("v", MaxMetadataValueLength+1)}},
+			err:      erro.ErrTooLong(FieldMetadata+".k", MaxMetadataValueLength),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			err := validateProfile(tc.profile)
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.IsType(t, &erro.ErrInvalidArgument{}, err)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
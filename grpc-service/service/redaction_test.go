@@ -0,0 +1,313 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/notify"
+	"github.com/javibauza/final-project/grpc-service/repository"
+	"github.com/javibauza/final-project/grpc-service/totp"
+)
+
+const (
+	leakedPwd  = "s3cr3t-passw0rd"
+	leakedHash = "$2a$12$Lhdc.gbeLbQbm8uz3H1T4.EPaxqclyblPeM1N1rxhNCth1/sZkCwC"
+)
+
+var leakyErr = errors.New("constraint failed: pwd_hash=" + leakedHash + " password=" + leakedPwd)
+
+// leakyNotifier fails quoting the message back, as some mail servers do.
+type leakyNotifier struct {
+	sent []notify.Message
+}
+
+func (n *leakyNotifier) Notify(ctx context.Context, msg notify.Message) error {
+	n.sent = append(n.sent, msg)
+	return errors.New("550 message rejected: " + msg.Body)
+}
+
+const (
+	leakedChallenge    = "Xq3vY8bN2kLm9pR4sT7wZ1aC5dF6gH0j"
+	leakedRecoveryCode = "k7m2p-q9x4w"
+	leakedAPIKey       = "fpk_abcdefghijklm_Zm9vYmFyYmF6cXV4cXV1eA"
+	leakedToken        = "q2Vh9sK1mN4bT7xL0pR3wY6zA8cE5fG2jU1iO4nM7kQ"
+	leakedCode         = "Hn3kP8vQ2xW5zR7tY1uB4cD6fG9jL0mN2pS5wX8aE3g"
+	leakedVerifier     = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	leakedClientSecret = "Vb7nM2kQ9xP4wR1tY6uZ3cF8gH5jL0aS2dE4fG7hJ9k"
+)
+
+func TestLogRedaction(t *testing.T) {
+	testCases := []struct {
+		testName string
+		// call returns the credentials it handled besides leakedPwd and
+		// leakedHash, which must not be logged either.
+		call func(ctx context.Context, s Service, repo *repoMock) []string
+	}{
+		{
+			testName: "Authenticate repository error",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("Authenticate", ctx, "javier").Return(nil, leakyErr)
+				s.Authenticate(ctx, AuthRequest{Name: "javier", Pwd: leakedPwd})
+				return nil
+			},
+		},
+		{
+			testName: "Authenticate wrong password",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("Authenticate", ctx, "javier").Return(repository.User{UserId: "u1", PwdHash: leakedHash}, nil)
+				s.Authenticate(ctx, AuthRequest{Name: "javier", Pwd: leakedPwd})
+				return nil
+			},
+		},
+		{
+			testName: "CreateUser",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("CreateUser", ctx, mock.AnythingOfType("repository.User")).Return(leakyErr)
+				s.CreateUser(ctx, CreateUserRequest{Name: "javier", Pwd: leakedPwd})
+				return nil
+			},
+		},
+		{
+			testName: "UpdateUser",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("UpdateUser", ctx, mock.AnythingOfType("repository.User"), mock.Anything, mock.Anything).Return(repository.User{}, leakyErr)
+				s.UpdateUser(ctx, UpdateUserRequest{UserId: "u1", Pwd: leakedPwd})
+				return nil
+			},
+		},
+		{
+			testName: "GetUser",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("GetUser", ctx, "u1").Return(repository.User{}, leakyErr)
+				s.GetUser(ctx, "u1")
+				return nil
+			},
+		},
+		{
+			testName: "GetAuditLog",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("GetAuditLog", ctx, "u1", int64(0), DefaultAuditPageSize+1).Return(nil, leakyErr)
+				s.GetAuditLog(ctx, GetAuditLogRequest{UserId: "u1"})
+				return nil
+			},
+		},
+		{
+			testName: "EnrollTOTP",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				var secret string
+				repo.On("GetUser", ctx, "u1").Return(repository.User{UserId: "u1", Name: "javier"}, nil)
+				repo.On("EnrollTOTP", ctx, "u1", mock.Anything).Run(func(args mock.Arguments) {
+					secret = args.String(2)
+				}).Return(errors.New("constraint failed: secret=" + totpSecret))
+				s.EnrollTOTP(ctx, "u1")
+				return []string{secret, totpSecret}
+			},
+		},
+		{
+			testName: "ConfirmTOTP wrong code",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("GetTOTP", ctx, "u1").Return(repository.TOTP{Secret: totpSecret}, nil)
+				s.ConfirmTOTP(ctx, ConfirmTOTPRequest{UserId: "u1", Code: leakedRecoveryCode})
+				return []string{totpSecret, leakedRecoveryCode}
+			},
+		},
+		{
+			testName: "ConfirmTOTP repository error",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				var hashes []string
+				code, _ := totp.Code(totpSecret, totp.Step(time.Now()))
+				repo.On("GetTOTP", ctx, "u1").Return(repository.TOTP{Secret: totpSecret}, nil)
+				repo.On("ConfirmTOTP", ctx, "u1", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+					hashes = args.Get(3).([]string)
+				}).Return(leakyErr)
+				s.ConfirmTOTP(ctx, ConfirmTOTPRequest{UserId: "u1", Code: code})
+				return append([]string{totpSecret}, hashes...)
+			},
+		},
+		{
+			testName: "CompleteAuthChallenge wrong recovery code",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				challengeHash, codeHash := hashToken(leakedChallenge), hashRecoveryCode(leakedRecoveryCode)
+				repo.On("AttemptAuthChallenge", ctx, challengeHash, MaxChallengeAttempts).Return("u1", nil)
+				repo.On("GetTOTP", ctx, "u1").Return(repository.TOTP{Secret: totpSecret, Confirmed: true}, nil)
+				repo.On("UseRecoveryCode", ctx, "u1", codeHash).Return(erro.NewErrPermissionDenied(erro.ErrWrongCode))
+				repo.On("RecordTOTPFailure", ctx, "u1", MaxTOTPFailures, TOTPLockout).Return(errors.New("database is locked: token_hash='" + challengeHash + "'"))
+				s.CompleteAuthChallenge(ctx, CompleteAuthChallengeRequest{Challenge: leakedChallenge, Code: leakedRecoveryCode})
+				return []string{leakedChallenge, leakedRecoveryCode, totpSecret, challengeHash, codeHash}
+			},
+		},
+		{
+			testName: "CompleteAuthChallenge repository error",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				challengeHash := hashToken(leakedChallenge)
+				repo.On("AttemptAuthChallenge", ctx, challengeHash, MaxChallengeAttempts).Return("", errors.New("database is locked: token="+challengeHash))
+				s.CompleteAuthChallenge(ctx, CompleteAuthChallengeRequest{Challenge: leakedChallenge, Code: leakedRecoveryCode})
+				return []string{leakedChallenge, leakedRecoveryCode, challengeHash}
+			},
+		},
+		{
+			testName: "CreateAPIKey",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				var keyHash string
+				repo.On("GetUser", ctx, "u1").Return(repository.User{UserId: "u1"}, nil)
+				repo.On("CreateAPIKey", ctx, mock.AnythingOfType("repository.APIKey")).Run(func(args mock.Arguments) {
+					keyHash = args.Get(1).(repository.APIKey).KeyHash
+				}).Return(repository.APIKey{}, errors.New("constraint failed: key_hash="+hashToken(leakedAPIKey)))
+				s.CreateAPIKey(ctx, CreateAPIKeyRequest{UserId: "u1", Name: "ci", Scopes: []string{"users:read"}})
+				return []string{keyHash, hashToken(leakedAPIKey)}
+			},
+		},
+		{
+			testName: "AuthenticateAPIKey wrong key",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("GetAPIKey", ctx, "abcdefghijklm").Return(repository.APIKey{Id: "abcdefghijklm", UserId: "u1", KeyHash: hashToken("other")}, nil)
+				s.AuthenticateAPIKey(ctx, leakedAPIKey)
+				return []string{leakedAPIKey, "Zm9vYmFyYmF6cXV4cXV1eA", hashToken(leakedAPIKey), hashToken("other")}
+			},
+		},
+		{
+			testName: "AuthenticateAPIKey repository error",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("GetAPIKey", ctx, "abcdefghijklm").Return(repository.APIKey{}, errors.New("database is locked: api_key="+leakedAPIKey))
+				s.AuthenticateAPIKey(ctx, leakedAPIKey)
+				return []string{leakedAPIKey, "Zm9vYmFyYmF6cXV4cXV1eA"}
+			},
+		},
+		{
+			testName: "SendVerification repository error",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				var tokenHash string
+				repo.On("GetUser", ctx, "u1").Return(repository.User{UserId: "u1", Profile: repository.Profile{Email: "javier@example.com"}}, nil)
+				repo.On("CreateEmailVerification", ctx, "u1", "javier@example.com", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+					tokenHash = args.String(3)
+				}).Return(leakyErr)
+				s.SendVerification(ctx, "u1")
+				return []string{tokenHash}
+			},
+		},
+		{
+			testName: "SendVerification notifier error",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				var tokenHash string
+				repo.On("GetUser", ctx, "u1").Return(repository.User{UserId: "u1", Profile: repository.Profile{Email: "javier@example.com"}}, nil)
+				repo.On("CreateEmailVerification", ctx, "u1", "javier@example.com", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+					tokenHash = args.String(3)
+				}).Return(nil)
+				s.SendVerification(ctx, "u1")
+				return []string{tokenHash}
+			},
+		},
+		{
+			testName: "VerifyEmail",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("VerifyEmail", ctx, hashToken(leakedToken)).Return("", errors.New("database is locked: token_hash="+hashToken(leakedToken)))
+				s.VerifyEmail(ctx, leakedToken)
+				return []string{leakedToken, hashToken(leakedToken)}
+			},
+		},
+		{
+			testName: "CreateOAuthClient",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				var secretHash string
+				repo.On("CreateOAuthClient", ctx, mock.AnythingOfType("repository.OAuthClient")).Run(func(args mock.Arguments) {
+					secretHash = args.Get(1).(repository.OAuthClient).SecretHash
+				}).Return(repository.OAuthClient{}, leakyErr)
+				s.CreateOAuthClient(ctx, CreateOAuthClientRequest{Name: "app", RedirectURIs: []string{"https://app.example.com/cb"}, Confidential: true})
+				return []string{secretHash}
+			},
+		},
+		{
+			testName: "GetOAuthClient",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("GetOAuthClient", ctx, "c1").Return(repository.OAuthClient{}, errors.New("scan failed: secret_hash="+hashToken(leakedClientSecret)))
+				s.GetOAuthClient(ctx, "c1")
+				return []string{hashToken(leakedClientSecret)}
+			},
+		},
+		{
+			testName: "CreateAuthorizationCode",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				var codeHash string
+				repo.On("GetOAuthClient", ctx, "c1").Return(repository.OAuthClient{Id: "c1", RedirectURIs: []string{"https://app.example.com/cb"}}, nil)
+				repo.On("GetUser", ctx, "u1").Return(repository.User{UserId: "u1"}, nil)
+				repo.On("CreateAuthorizationCode", ctx, mock.AnythingOfType("repository.AuthorizationCode"), AuthorizationCodeTTL).Run(func(args mock.Arguments) {
+					codeHash = args.Get(1).(repository.AuthorizationCode).CodeHash
+				}).Return(errors.New("constraint failed: code_hash=" + hashToken(leakedCode)))
+				s.CreateAuthorizationCode(ctx, CreateAuthorizationCodeRequest{ClientId: "c1", UserId: "u1", RedirectURI: "https://app.example.com/cb", CodeChallenge: "challenge"})
+				return []string{codeHash, hashToken(leakedCode)}
+			},
+		},
+		{
+			testName: "ExchangeAuthorizationCode wrong client secret",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("GetOAuthClient", ctx, "c1").Return(repository.OAuthClient{Id: "c1", SecretHash: hashToken("other")}, nil)
+				s.ExchangeAuthorizationCode(ctx, ExchangeAuthorizationCodeRequest{
+					ClientId: "c1", ClientSecret: leakedClientSecret, Code: leakedCode, RedirectURI: "https://app.example.com/cb", CodeVerifier: leakedVerifier,
+				})
+				return []string{leakedClientSecret, leakedCode, leakedVerifier, hashToken("other")}
+			},
+		},
+		{
+			testName: "ExchangeAuthorizationCode wrong verifier",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("GetOAuthClient", ctx, "c1").Return(repository.OAuthClient{Id: "c1"}, nil)
+				repo.On("ConsumeAuthorizationCode", ctx, hashToken(leakedCode)).Return(repository.AuthorizationCode{
+					ClientId: "c1", UserId: "u1", RedirectURI: "https://app.example.com/cb", CodeChallenge: "challenge", ExpiresAt: time.Now().Add(time.Minute),
+				}, nil)
+				s.ExchangeAuthorizationCode(ctx, ExchangeAuthorizationCodeRequest{
+					ClientId: "c1", Code: leakedCode, RedirectURI: "https://app.example.com/cb", CodeVerifier: leakedVerifier,
+				})
+				return []string{leakedCode, leakedVerifier, hashToken(leakedCode)}
+			},
+		},
+		{
+			testName: "ExchangeAuthorizationCode repository error",
+			call: func(ctx context.Context, s Service, repo *repoMock) []string {
+				repo.On("GetOAuthClient", ctx, "c1").Return(repository.OAuthClient{Id: "c1"}, nil)
+				repo.On("ConsumeAuthorizationCode", ctx, hashToken(leakedCode)).Return(repository.AuthorizationCode{}, errors.New("database is locked: code_hash="+hashToken(leakedCode)))
+				s.ExchangeAuthorizationCode(ctx, ExchangeAuthorizationCodeRequest{
+					ClientId: "c1", Code: leakedCode, RedirectURI: "https://app.example.com/cb", CodeVerifier: leakedVerifier,
+				})
+				return []string{leakedCode, leakedVerifier, hashToken(leakedCode)}
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := logging.NewRedactor(log.NewJSONLogger(&buf))
+
+			repo := new(repoMock)
+			notifier := new(leakyNotifier)
+			secrets := tc.call(context.Background(), NewService(repo, gracePeriod, EmailConfig{Notifier: notifier}, TOTPConfig{}, logger), repo)
+			for _, msg := range notifier.sent {
+				// Without a VerifyURL the token follows the address.
+				words := strings.Fields(msg.Body)
+				for i, word := range words {
+					if word == "address:" && i+1 < len(words) {
+						secrets = append(secrets, words[i+1])
+					}
+				}
+			}
+
+			assert.NotEmpty(t, buf.String())
+			for _, secret := range append(secrets, leakedPwd, leakedHash) {
+				require.NotEmpty(t, secret)
+				assert.NotContains(t, buf.String(), secret)
+			}
+		})
+	}
+}
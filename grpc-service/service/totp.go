@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/logging"
+	"github.com/javibauza/final-project/grpc-service/totp"
+)
+
+// TOTPConfig configures two-factor authentication.
+type TOTPConfig struct {
+	// Issuer names the service in authenticator apps.
+	Issuer string
+	// ChallengeTTL is how long a login challenge can be completed.
+	ChallengeTTL time.Duration
+}
+
+const (
+	// MaxChallengeAttempts is how many codes can be tried against a login
+	// challenge before it has to be started over.
+	MaxChallengeAttempts = 5
+	// MaxTOTPFailures wrong codes in a row, whatever the challenges they
+	// were tried against, lock the user's codes for TOTPLockout.
+	MaxTOTPFailures = 10
+	TOTPLockout     = 15 * time.Minute
+	// RecoveryCodes is how many recovery codes ConfirmTOTP hands out.
+	RecoveryCodes = 10
+)
+
+type CompleteAuthChallengeRequest struct {
+	Challenge string
+	// Code is a TOTP code or one of the user's recovery codes.
+	Code string
+}
+
+type EnrollTOTPResponse struct {
+	Secret string
+	URI    string
+}
+
+type ConfirmTOTPRequest struct {
+	UserId string
+	Code   string
+}
+
+type ConfirmTOTPResponse struct {
+	RecoveryCodes []string
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns n random codes, formatted as "xxxxx-xxxxx", and
+// the hashes they are stored as.
+func newRecoveryCodes(n int) (codes, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case, dashes and spaces, which users may or may
+// not type.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(code)
+}
+
+// EnrollTOTP generates a new secret for the user, which is required at login
+// once ConfirmTOTP proves the user's authenticator app has it.
+func (s service) EnrollTOTP(ctx context.Context, userId string) (EnrollTOTPResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "EnrollTOTP")
+
+	if userId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return EnrollTOTPResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId"))
+	}
+
+	user, err := s.repository.GetUser(ctx, userId)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return EnrollTOTPResponse{}, err
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return EnrollTOTPResponse{}, err
+	}
+	if err := s.repository.EnrollTOTP(ctx, userId, secret); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return EnrollTOTPResponse{}, err
+	}
+
+	return EnrollTOTPResponse{
+		Secret: secret,
+		URI:    totp.URI(s.totp.Issuer, user.Name, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication with a code of the enrolled
+// secret and returns the user's recovery codes, which are only shown once.
+func (s service) ConfirmTOTP(ctx context.Context, req ConfirmTOTPRequest) (ConfirmTOTPResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "ConfirmTOTP")
+
+	if req.UserId == "" || req.Code == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId", "code"))
+		return ConfirmTOTPResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("userId", "code"))
+	}
+
+	factor, err := s.repository.GetTOTP(ctx, req.UserId)
+	if _, notFound := err.(*erro.ErrNotFound); notFound {
+		level.Error(logger).Log("err", erro.ErrTOTPNotEnrolled, "userId", req.UserId)
+		return ConfirmTOTPResponse{}, erro.NewErrFailedPrecondition(erro.ErrTOTPNotEnrolled)
+	}
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return ConfirmTOTPResponse{}, err
+	}
+	if factor.Confirmed {
+		level.Error(logger).Log("err", erro.ErrTOTPEnabled, "userId", req.UserId)
+		return ConfirmTOTPResponse{}, erro.NewErrFailedPrecondition(erro.ErrTOTPEnabled)
+	}
+
+	step, ok := totp.Validate(factor.Secret, req.Code, s.now())
+	if !ok {
+		level.Error(logger).Log("err", erro.ErrWrongCode, "userId", req.UserId)
+		return ConfirmTOTPResponse{}, erro.NewErrPermissionDenied(erro.ErrWrongCode)
+	}
+
+	codes, hashes, err := newRecoveryCodes(RecoveryCodes)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return ConfirmTOTPResponse{}, err
+	}
+	if err := s.repository.ConfirmTOTP(ctx, req.UserId, step, hashes); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return ConfirmTOTPResponse{}, err
+	}
+
+	return ConfirmTOTPResponse{RecoveryCodes: codes}, nil
+}
+
+// challenge starts the second step of the user's login.
+func (s service) challenge(ctx context.Context, userId string) (AuthResponse, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return AuthResponse{}, err
+	}
+	if err := s.repository.CreateAuthChallenge(ctx, userId, hash, s.totp.ChallengeTTL); err != nil {
+		return AuthResponse{}, err
+	}
+	return AuthResponse{Challenge: token}, nil
+}
+
+// CompleteAuthChallenge logs in the user Authenticate challenged with a TOTP
+// code or an unused recovery code. Too many wrong codes lock the user out
+// for a while, however many challenges they were spread over.
+func (s service) CompleteAuthChallenge(ctx context.Context, req CompleteAuthChallengeRequest) (AuthResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "CompleteAuthChallenge")
+
+	if req.Challenge == "" || req.Code == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("challenge", "code"))
+		return AuthResponse{}, erro.NewErrInvalidArgument(erro.ErrRequiredFields("challenge", "code"))
+	}
+
+	challengeHash := hashToken(req.Challenge)
+	userId, err := s.repository.AttemptAuthChallenge(ctx, challengeHash, MaxChallengeAttempts)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, err
+	}
+
+	factor, err := s.repository.GetTOTP(ctx, userId)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, err
+	}
+	if s.now().Before(factor.LockedUntil) {
+		level.Error(logger).Log("err", erro.ErrTOTPLocked, "userId", userId)
+		return AuthResponse{}, erro.NewErrPermissionDenied(erro.ErrTOTPLocked)
+	}
+
+	if step, ok := totp.Validate(factor.Secret, req.Code, s.now()); ok {
+		err = s.repository.UseTOTPStep(ctx, userId, step)
+	} else if len(req.Code) == totp.Digits {
+		err = erro.NewErrPermissionDenied(erro.ErrWrongCode)
+	} else {
+		err = s.repository.UseRecoveryCode(ctx, userId, hashRecoveryCode(req.Code))
+	}
+	if _, wrong := err.(*erro.ErrPermissionDenied); wrong {
+		if failErr := s.repository.RecordTOTPFailure(ctx, userId, MaxTOTPFailures, TOTPLockout); failErr != nil {
+			level.Error(logger).Log("err", failErr.Error(), "userId", userId)
+			return AuthResponse{}, failErr
+		}
+	}
+	if err != nil {
+		level.Error(logger).Log("err", err.Error(), "userId", userId)
+		return AuthResponse{}, err
+	}
+
+	if err := s.repository.DeleteAuthChallenge(ctx, challengeHash); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, err
+	}
+	if err := s.repository.RecordLogin(ctx, userId); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, err
+	}
+
+	return AuthResponse{UserId: userId}, nil
+}
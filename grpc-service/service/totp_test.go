@@ -0,0 +1,379 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/grpc-service/errors"
+	"github.com/javibauza/final-project/grpc-service/repository"
+	"github.com/javibauza/final-project/grpc-service/totp"
+)
+
+const (
+	totpSecret   = "JBSWY3DPEHPK3PXP"
+	challengeTTL = 5 * time.Minute
+)
+
+func newTOTPService(repo repository.Repository) *service {
+	s := newTestService(repo)
+	s.totp = TOTPConfig{Issuer: "Final Project", ChallengeTTL: challengeTTL}
+	return s
+}
+
+func totpCode(t *testing.T, step int64) string {
+	code, err := totp.Code(totpSecret, step)
+	require.NoError(t, err)
+	return code
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		userId        string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, response EnrollTOTPResponse, resError error)
+	}{
+		{
+			testName: "enrolled",
+			userId:   "u1",
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1", Name: "javier"}, nil)
+				repo.On("EnrollTOTP", mock.Anything, "u1", mock.AnythingOfType("string")).Return(nil)
+			},
+			checkResponse: func(t *testing.T, response EnrollTOTPResponse, resError error) {
+				require.NoError(t, resError)
+				_, err := totp.Code(response.Secret, 1)
+				assert.NoError(t, err)
+
+				uri, err := url.Parse(response.URI)
+				require.NoError(t, err)
+				assert.Equal(t, "/Final Project:javier", uri.Path)
+				assert.Equal(t, response.Secret, uri.Query().Get("secret"))
+			},
+		},
+		{
+			testName: "already enabled",
+			userId:   "u1",
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1", Name: "javier"}, nil)
+				repo.On("EnrollTOTP", mock.Anything, "u1", mock.AnythingOfType("string")).Return(erro.NewErrFailedPrecondition(erro.ErrTOTPEnabled))
+			},
+			checkResponse: func(t *testing.T, response EnrollTOTPResponse, resError error) {
+				assert.IsType(t, &erro.ErrFailedPrecondition{}, resError)
+				assert.Empty(t, response.Secret)
+			},
+		},
+		{
+			testName: "user not found",
+			userId:   "u1",
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{}, erro.NewErrNotFound())
+			},
+			checkResponse: func(t *testing.T, response EnrollTOTPResponse, resError error) {
+				assert.IsType(t, &erro.ErrNotFound{}, resError)
+			},
+		},
+		{
+			testName:   "user id empty",
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, response EnrollTOTPResponse, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := newTOTPService(repo).EnrollTOTP(context.Background(), tc.userId)
+			tc.checkResponse(t, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestConfirmTOTP(t *testing.T) {
+	step := totp.Step(today)
+
+	testCases := []struct {
+		testName      string
+		req           ConfirmTOTPRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, response ConfirmTOTPResponse, resError error)
+	}{
+		{
+			testName: "confirmed",
+			req:      ConfirmTOTPRequest{UserId: "u1", Code: totpCode(t, step-1)},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{Secret: totpSecret}, nil)
+				repo.On("ConfirmTOTP", mock.Anything, "u1", step-1, mock.AnythingOfType("[]string")).Return(nil)
+			},
+			checkResponse: func(t *testing.T, response ConfirmTOTPResponse, resError error) {
+				require.NoError(t, resError)
+				require.Len(t, response.RecoveryCodes, RecoveryCodes)
+				seen := map[string]bool{}
+				for _, code := range response.RecoveryCodes {
+					assert.Regexp(t, regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`), code)
+					assert.False(t, seen[code], "codes are unique")
+					seen[code] = true
+				}
+			},
+		},
+		{
+			testName: "wrong code",
+			req:      ConfirmTOTPRequest{UserId: "u1", Code: totpCode(t, step-2)},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{Secret: totpSecret}, nil)
+			},
+			checkResponse: func(t *testing.T, response ConfirmTOTPResponse, resError error) {
+				assert.IsType(t, &erro.ErrPermissionDenied{}, resError)
+				assert.EqualError(t, resError, erro.ErrWrongCode)
+			},
+		},
+		{
+			testName: "not enrolled",
+			req:      ConfirmTOTPRequest{UserId: "u1", Code: "123456"},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{}, &erro.ErrNotFound{})
+			},
+			checkResponse: func(t *testing.T, response ConfirmTOTPResponse, resError error) {
+				assert.IsType(t, &erro.ErrFailedPrecondition{}, resError)
+				assert.EqualError(t, resError, erro.ErrTOTPNotEnrolled)
+			},
+		},
+		{
+			testName: "already enabled",
+			req:      ConfirmTOTPRequest{UserId: "u1", Code: totpCode(t, step)},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{Secret: totpSecret, Confirmed: true}, nil)
+			},
+			checkResponse: func(t *testing.T, response ConfirmTOTPResponse, resError error) {
+				assert.IsType(t, &erro.ErrFailedPrecondition{}, resError)
+				assert.EqualError(t, resError, erro.ErrTOTPEnabled)
+			},
+		},
+		{
+			testName:   "code empty",
+			req:        ConfirmTOTPRequest{UserId: "u1"},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, response ConfirmTOTPResponse, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := newTOTPService(repo).ConfirmTOTP(context.Background(), tc.req)
+			tc.checkResponse(t, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRecoveryCodeHashes(t *testing.T) {
+	repo := new(repoMock)
+	var hashes []string
+	repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{Secret: totpSecret}, nil)
+	repo.On("ConfirmTOTP", mock.Anything, "u1", mock.Anything, mock.AnythingOfType("[]string")).
+		Run(func(args mock.Arguments) { hashes = args.Get(3).([]string) }).Return(nil)
+
+	response, err := newTOTPService(repo).ConfirmTOTP(context.Background(), ConfirmTOTPRequest{UserId: "u1", Code: totpCode(t, totp.Step(today))})
+	require.NoError(t, err)
+
+	require.Len(t, hashes, RecoveryCodes)
+	for i, code := range response.RecoveryCodes {
+		assert.NotContains(t, hashes, code, "codes are stored hashed")
+		assert.Equal(t, hashes[i], hashRecoveryCode(code))
+	}
+	code := response.RecoveryCodes[0]
+	assert.Equal(t, hashes[0], hashRecoveryCode(" "+code[:5]+code[6:]+" "), "dashes and spaces are ignored")
+}
+
+func TestAuthenticateChallenge(t *testing.T) {
+	const pwdHash = "$2a$12$RXSLrffQZDUGljSPdQAPI.W4txkPKkeASl0qSM/tbx7mgMMqnDhui"
+
+	repo := new(repoMock)
+	repo.On("Authenticate", mock.Anything, "javier").Return(repository.User{UserId: "u1", PwdHash: pwdHash}, nil)
+	repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{Secret: totpSecret, Confirmed: true}, nil)
+	var challengeHash string
+	repo.On("CreateAuthChallenge", mock.Anything, "u1", mock.AnythingOfType("string"), challengeTTL).
+		Run(func(args mock.Arguments) { challengeHash = args.String(2) }).Return(nil)
+
+	response, err := newTOTPService(repo).Authenticate(context.Background(), AuthRequest{Name: "javier", Pwd: "javier123"})
+	require.NoError(t, err)
+	assert.Empty(t, response.UserId, "the user is not logged in yet")
+	assert.NotEmpty(t, response.Challenge)
+	assert.Equal(t, hashToken(response.Challenge), challengeHash, "only the hash of the challenge is stored")
+	repo.AssertNotCalled(t, "RecordLogin", mock.Anything, mock.Anything)
+
+	repo = new(repoMock)
+	repo.On("Authenticate", mock.Anything, "javier").Return(repository.User{UserId: "u1", PwdHash: pwdHash}, nil)
+	repo.On("GetTOTP", mock.Anything, "u1").Return(repository.TOTP{Secret: totpSecret}, nil)
+	repo.On("RecordLogin", mock.Anything, "u1").Return(nil)
+
+	response, err = newTOTPService(repo).Authenticate(context.Background(), AuthRequest{Name: "javier", Pwd: "javier123"})
+	require.NoError(t, err)
+	assert.Equal(t, AuthResponse{UserId: "u1"}, response, "unconfirmed secrets are not required")
+}
+
+func TestCompleteAuthChallenge(t *testing.T) {
+	step := totp.Step(today)
+	challengeHash := hashToken("the-challenge")
+	factor := repository.TOTP{Secret: totpSecret, Confirmed: true}
+
+	testCases := []struct {
+		testName      string
+		req           CompleteAuthChallengeRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, response AuthResponse, resError error)
+	}{
+		{
+			testName: "totp code",
+			req:      CompleteAuthChallengeRequest{Challenge: "the-challenge", Code: totpCode(t, step+1)},
+			buildStubs: func(repo *repoMock) {
+				repo.On("AttemptAuthChallenge", mock.Anything, challengeHash, MaxChallengeAttempts).Return("u1", nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(factor, nil)
+				repo.On("UseTOTPStep", mock.Anything, "u1", step+1).Return(nil)
+				repo.On("DeleteAuthChallenge", mock.Anything, challengeHash).Return(nil)
+				repo.On("RecordLogin", mock.Anything, "u1").Return(nil)
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthResponse{UserId: "u1"}, response)
+			},
+		},
+		{
+			testName: "recovery code",
+			req:      CompleteAuthChallengeRequest{Challenge: "the-challenge", Code: "ABCDE-FGHIJ"},
+			buildStubs: func(repo *repoMock) {
+				repo.On("AttemptAuthChallenge", mock.Anything, challengeHash, MaxChallengeAttempts).Return("u1", nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(factor, nil)
+				repo.On("UseRecoveryCode", mock.Anything, "u1", hashRecoveryCode("abcdefghij")).Return(nil)
+				repo.On("DeleteAuthChallenge", mock.Anything, challengeHash).Return(nil)
+				repo.On("RecordLogin", mock.Anything, "u1").Return(nil)
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthResponse{UserId: "u1"}, response)
+			},
+		},
+		{
+			testName: "reused totp code",
+			req:      CompleteAuthChallengeRequest{Challenge: "the-challenge", Code: totpCode(t, step)},
+			buildStubs: func(repo *repoMock) {
+				repo.On("AttemptAuthChallenge", mock.Anything, challengeHash, MaxChallengeAttempts).Return("u1", nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(factor, nil)
+				repo.On("UseTOTPStep", mock.Anything, "u1", step).Return(erro.NewErrPermissionDenied(erro.ErrWrongCode))
+				repo.On("RecordTOTPFailure", mock.Anything, "u1", MaxTOTPFailures, TOTPLockout).Return(nil)
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				assert.IsType(t, &erro.ErrPermissionDenied{}, resError)
+				assert.Empty(t, response.UserId)
+			},
+		},
+		{
+			testName: "wrong totp code",
+			req:      CompleteAuthChallengeRequest{Challenge: "the-challenge", Code: totpCode(t, step-2)},
+			buildStubs: func(repo *repoMock) {
+				repo.On("AttemptAuthChallenge", mock.Anything, challengeHash, MaxChallengeAttempts).Return("u1", nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(factor, nil)
+				repo.On("RecordTOTPFailure", mock.Anything, "u1", MaxTOTPFailures, TOTPLockout).Return(nil)
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				assert.IsType(t, &erro.ErrPermissionDenied{}, resError)
+				assert.EqualError(t, resError, erro.ErrWrongCode)
+			},
+		},
+		{
+			testName: "wrong recovery code",
+			req:      CompleteAuthChallengeRequest{Challenge: "the-challenge", Code: "ABCDE-FGHIJ"},
+			buildStubs: func(repo *repoMock) {
+				repo.On("AttemptAuthChallenge", mock.Anything, challengeHash, MaxChallengeAttempts).Return("u1", nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(factor, nil)
+				repo.On("UseRecoveryCode", mock.Anything, "u1", hashRecoveryCode("abcdefghij")).Return(erro.NewErrPermissionDenied(erro.ErrWrongCode))
+				repo.On("RecordTOTPFailure", mock.Anything, "u1", MaxTOTPFailures, TOTPLockout).Return(nil)
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				assert.IsType(t, &erro.ErrPermissionDenied{}, resError)
+			},
+		},
+		{
+			testName: "locked out",
+			req:      CompleteAuthChallengeRequest{Challenge: "the-challenge", Code: totpCode(t, step+1)},
+			buildStubs: func(repo *repoMock) {
+				locked := factor
+				locked.LockedUntil = today.Add(time.Minute)
+				repo.On("AttemptAuthChallenge", mock.Anything, challengeHash, MaxChallengeAttempts).Return("u1", nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(locked, nil)
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				assert.IsType(t, &erro.ErrPermissionDenied{}, resError)
+				assert.EqualError(t, resError, erro.ErrTOTPLocked, "even the right code is refused")
+			},
+		},
+		{
+			testName: "lockout over",
+			req:      CompleteAuthChallengeRequest{Challenge: "the-challenge", Code: totpCode(t, step+1)},
+			buildStubs: func(repo *repoMock) {
+				unlocked := factor
+				unlocked.LockedUntil = today
+				repo.On("AttemptAuthChallenge", mock.Anything, challengeHash, MaxChallengeAttempts).Return("u1", nil)
+				repo.On("GetTOTP", mock.Anything, "u1").Return(unlocked, nil)
+				repo.On("UseTOTPStep", mock.Anything, "u1", step+1).Return(nil)
+				repo.On("DeleteAuthChallenge", mock.Anything, challengeHash).Return(nil)
+				repo.On("RecordLogin", mock.Anything, "u1").Return(nil)
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthResponse{UserId: "u1"}, response)
+			},
+		},
+		{
+			testName: "invalid challenge",
+			req:      CompleteAuthChallengeRequest{Challenge: "the-challenge", Code: totpCode(t, step)},
+			buildStubs: func(repo *repoMock) {
+				repo.On("AttemptAuthChallenge", mock.Anything, challengeHash, MaxChallengeAttempts).Return("", erro.NewErrPermissionDenied(erro.ErrInvalidChallenge))
+			},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				assert.IsType(t, &erro.ErrPermissionDenied{}, resError)
+				assert.EqualError(t, resError, erro.ErrInvalidChallenge)
+			},
+		},
+		{
+			testName:   "code empty",
+			req:        CompleteAuthChallengeRequest{Challenge: "the-challenge"},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, response AuthResponse, resError error) {
+				assert.IsType(t, &erro.ErrInvalidArgument{}, resError)
+				assert.EqualError(t, resError, erro.ErrRequiredFields("challenge", "code"))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := newTOTPService(repo).CompleteAuthChallenge(context.Background(), tc.req)
+			tc.checkResponse(t, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+type Middleware func(Service) Service
+
+type tracingMiddleware struct {
+	next Service
+}
+
+func TracingMiddleware() Middleware {
+	return func(next Service) Service {
+		return &tracingMiddleware{next: next}
+	}
+}
+
+func (mw *tracingMiddleware) Authenticate(ctx context.Context, req AuthRequest) (AuthResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.Authenticate")
+	res, err := mw.next.Authenticate(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) CreateUser(ctx context.Context, req CreateUserRequest) (CreateUserResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.CreateUser")
+	res, err := mw.next.CreateUser(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) UpdateUser(ctx context.Context, req UpdateUserRequest) (UpdateUserResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.UpdateUser")
+	res, err := mw.next.UpdateUser(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) GetUser(ctx context.Context, userId string) (GetUserResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.GetUser")
+	res, err := mw.next.GetUser(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) GetAuditLog(ctx context.Context, req GetAuditLogRequest) (GetAuditLogResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.GetAuditLog")
+	res, err := mw.next.GetAuditLog(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) DeleteUser(ctx context.Context, userId string) error {
+	ctx, span := tracing.StartSpan(ctx, "service.DeleteUser")
+	err := mw.next.DeleteUser(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) RestoreUser(ctx context.Context, userId string) (GetUserResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.RestoreUser")
+	res, err := mw.next.RestoreUser(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) SendVerification(ctx context.Context, userId string) error {
+	ctx, span := tracing.StartSpan(ctx, "service.SendVerification")
+	err := mw.next.SendVerification(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) VerifyEmail(ctx context.Context, token string) (GetUserResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.VerifyEmail")
+	res, err := mw.next.VerifyEmail(ctx, token)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) CompleteAuthChallenge(ctx context.Context, req CompleteAuthChallengeRequest) (AuthResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.CompleteAuthChallenge")
+	res, err := mw.next.CompleteAuthChallenge(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) EnrollTOTP(ctx context.Context, userId string) (EnrollTOTPResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.EnrollTOTP")
+	res, err := mw.next.EnrollTOTP(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) ConfirmTOTP(ctx context.Context, req ConfirmTOTPRequest) (ConfirmTOTPResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.ConfirmTOTP")
+	res, err := mw.next.ConfirmTOTP(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) CreateAPIKey(ctx context.Context, req CreateAPIKeyRequest) (CreateAPIKeyResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.CreateAPIKey")
+	res, err := mw.next.CreateAPIKey(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.ListAPIKeys")
+	res, err := mw.next.ListAPIKeys(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) RevokeAPIKey(ctx context.Context, userId, keyId string) error {
+	ctx, span := tracing.StartSpan(ctx, "service.RevokeAPIKey")
+	err := mw.next.RevokeAPIKey(ctx, userId, keyId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) AuthenticateAPIKey(ctx context.Context, key string) (AuthenticateAPIKeyResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.AuthenticateAPIKey")
+	res, err := mw.next.AuthenticateAPIKey(ctx, key)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) CreateOAuthClient(ctx context.Context, req CreateOAuthClientRequest) (CreateOAuthClientResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.CreateOAuthClient")
+	res, err := mw.next.CreateOAuthClient(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) GetOAuthClient(ctx context.Context, clientId string) (OAuthClient, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.GetOAuthClient")
+	res, err := mw.next.GetOAuthClient(ctx, clientId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) CreateAuthorizationCode(ctx context.Context, req CreateAuthorizationCodeRequest) (string, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.CreateAuthorizationCode")
+	res, err := mw.next.CreateAuthorizationCode(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) ExchangeAuthorizationCode(ctx context.Context, req ExchangeAuthorizationCodeRequest) (ExchangeAuthorizationCodeResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.ExchangeAuthorizationCode")
+	res, err := mw.next.ExchangeAuthorizationCode(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) LinkIdentity(ctx context.Context, req LinkIdentityRequest) error {
+	ctx, span := tracing.StartSpan(ctx, "service.LinkIdentity")
+	err := mw.next.LinkIdentity(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) ProvisionIdentity(ctx context.Context, req ProvisionIdentityRequest) (string, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.ProvisionIdentity")
+	userId, err := mw.next.ProvisionIdentity(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return userId, err
+}
+
+func (mw *tracingMiddleware) AuthenticateIdentity(ctx context.Context, issuer, subject string) (AuthResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.AuthenticateIdentity")
+	res, err := mw.next.AuthenticateIdentity(ctx, issuer, subject)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
@@ -0,0 +1,148 @@
+package svcauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	APIKeyMetadataKey        = "x-api-key"
+	AuthorizationMetadataKey = "authorization"
+	BearerPrefix             = "Bearer "
+	// PrincipalMetadataKey carries the end user or API key a client service
+	// calls for, as "user:<id>" or "api_key:<id>".
+	PrincipalMetadataKey = "x-principal"
+	maxPrincipalLength   = 128
+)
+
+type Config struct {
+	// APIKeys maps a client name to its API key.
+	APIKeys map[string]string
+	// TokenSecrets maps a client name to the secret its tokens are signed with.
+	TokenSecrets map[string]string
+	// Allowlist maps a client name to the full method names it may call.
+	// "*" allows every method and "/pb.UserService/*" every method of a service.
+	Allowlist map[string][]string
+}
+
+type Authenticator struct {
+	cfg Config
+	now func() time.Time
+}
+
+func NewAuthenticator(cfg Config) *Authenticator {
+	return &Authenticator{cfg: cfg, now: time.Now}
+}
+
+type clientKey struct{}
+
+func ClientFromContext(ctx context.Context) string {
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the principal the client service called for,
+// empty when it sent none.
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+func validPrincipal(principal string) bool {
+	if principal == "" || len(principal) > maxPrincipalLength {
+		return false
+	}
+	for _, c := range principal {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// withPrincipal reads the PrincipalMetadataKey into the context. Invalid
+// principals are ignored.
+func withPrincipal(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(PrincipalMetadataKey); len(values) > 0 && validPrincipal(values[0]) {
+		return context.WithValue(ctx, principalKey{}, values[0])
+	}
+	return ctx
+}
+
+func (a *Authenticator) Authenticate(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if values := md.Get(APIKeyMetadataKey); len(values) > 0 {
+		for client, key := range a.cfg.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(values[0])) == 1 {
+				return client, nil
+			}
+		}
+		return "", status.Error(codes.Unauthenticated, "invalid api key")
+	}
+
+	if values := md.Get(AuthorizationMetadataKey); len(values) > 0 && strings.HasPrefix(values[0], BearerPrefix) {
+		token := strings.TrimPrefix(values[0], BearerPrefix)
+		claims, _, _, err := ParseToken(token)
+		if err != nil {
+			return "", status.Error(codes.Unauthenticated, err.Error())
+		}
+		secret, ok := a.cfg.TokenSecrets[claims.Subject]
+		if !ok {
+			return "", status.Error(codes.Unauthenticated, "unknown service token subject")
+		}
+		if _, err := VerifyToken(token, []byte(secret), a.now()); err != nil {
+			return "", status.Error(codes.Unauthenticated, err.Error())
+		}
+		return claims.Subject, nil
+	}
+
+	return "", status.Error(codes.Unauthenticated, "missing service credentials")
+}
+
+func (a *Authenticator) Allowed(client, fullMethod string) bool {
+	for _, pattern := range a.cfg.Allowlist[client] {
+		switch {
+		case pattern == "*", pattern == fullMethod:
+			return true
+		case strings.HasSuffix(pattern, "/*") && strings.HasPrefix(fullMethod, strings.TrimSuffix(pattern, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize authenticates the caller and checks fullMethod against its
+// allowlist, returning a context carrying the client name and the principal
+// it called for. Principals are only trusted from authenticated clients, so
+// without service authentication there are none.
+func (a *Authenticator) Authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	client, err := a.Authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !a.Allowed(client, fullMethod) {
+		return nil, status.Errorf(codes.PermissionDenied, "client %q may not call %s", client, fullMethod)
+	}
+	return withPrincipal(context.WithValue(ctx, clientKey{}, client)), nil
+}
+
+func (a *Authenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.Authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,173 @@
+package svcauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const restSecret = "0123456789abcdef0123456789abcdef"
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	now := time.Date(2021, 12, 1, 12, 0, 0, 0, time.UTC)
+
+	authenticator := NewAuthenticator(Config{
+		APIKeys:      map[string]string{"batch": "batch-key", "rest-service": "rest-key"},
+		TokenSecrets: map[string]string{"rest-service": restSecret},
+		Allowlist: map[string][]string{
+			"rest-service": {"/pb.UserService/*"},
+			"batch":        {"/pb.UserService/GetUser"},
+		},
+	})
+	authenticator.now = func() time.Time { return now }
+
+	validToken, _ := NewToken("rest-service", []byte(restSecret), now, time.Minute)
+	expiredToken, _ := NewToken("rest-service", []byte(restSecret), now.Add(-time.Hour), time.Minute)
+	forgedToken, _ := NewToken("rest-service", []byte("not the secret"), now, time.Minute)
+	unknownToken, _ := NewToken("someone", []byte(restSecret), now, time.Minute)
+
+	testCases := []struct {
+		testName      string
+		md            metadata.MD
+		method        string
+		checkResponse func(t *testing.T, client string, err error)
+	}{
+		{
+			testName: "valid api key",
+			md:       metadata.Pairs(APIKeyMetadataKey, "rest-key"),
+			method:   "/pb.UserService/CreateUser",
+			checkResponse: func(t *testing.T, client string, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "rest-service", client)
+			},
+		},
+		{
+			testName: "invalid api key",
+			md:       metadata.Pairs(APIKeyMetadataKey, "guess"),
+			method:   "/pb.UserService/GetUser",
+			checkResponse: func(t *testing.T, client string, err error) {
+				assert.Equal(t, codes.Unauthenticated, status.Code(err))
+			},
+		},
+		{
+			testName: "method not in allowlist",
+			md:       metadata.Pairs(APIKeyMetadataKey, "batch-key"),
+			method:   "/pb.UserService/UpdateUser",
+			checkResponse: func(t *testing.T, client string, err error) {
+				assert.Equal(t, codes.PermissionDenied, status.Code(err))
+			},
+		},
+		{
+			testName: "valid service token",
+			md:       metadata.Pairs(AuthorizationMetadataKey, "Bearer "+validToken),
+			method:   "/pb.UserService/UpdateUser",
+			checkResponse: func(t *testing.T, client string, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "rest-service", client)
+			},
+		},
+		{
+			testName: "expired service token",
+			md:       metadata.Pairs(AuthorizationMetadataKey, "Bearer "+expiredToken),
+			method:   "/pb.UserService/GetUser",
+			checkResponse: func(t *testing.T, client string, err error) {
+				assert.Equal(t, codes.Unauthenticated, status.Code(err))
+			},
+		},
+		{
+			testName: "forged service token",
+			md:       metadata.Pairs(AuthorizationMetadataKey, "Bearer "+forgedToken),
+			method:   "/pb.UserService/GetUser",
+			checkResponse: func(t *testing.T, client string, err error) {
+				assert.Equal(t, codes.Unauthenticated, status.Code(err))
+			},
+		},
+		{
+			testName: "unknown token subject",
+			md:       metadata.Pairs(AuthorizationMetadataKey, "Bearer "+unknownToken),
+			method:   "/pb.UserService/GetUser",
+			checkResponse: func(t *testing.T, client string, err error) {
+				assert.Equal(t, codes.Unauthenticated, status.Code(err))
+			},
+		},
+		{
+			testName: "no credentials",
+			md:       metadata.MD{},
+			method:   "/pb.UserService/GetUser",
+			checkResponse: func(t *testing.T, client string, err error) {
+				assert.Equal(t, codes.Unauthenticated, status.Code(err))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tc.md)
+
+			var client string
+			_, err := authenticator.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					client = ClientFromContext(ctx)
+					return nil, nil
+				},
+			)
+			tc.checkResponse(t, client, err)
+		})
+	}
+}
+
+func TestAuthorizePrincipal(t *testing.T) {
+	authenticator := NewAuthenticator(Config{
+		APIKeys:   map[string]string{"rest-service": "rest-key"},
+		Allowlist: map[string][]string{"rest-service": {"*"}},
+	})
+
+	testCases := []struct {
+		testName  string
+		md        metadata.MD
+		principal string
+	}{
+		{
+			testName:  "user",
+			md:        metadata.Pairs(APIKeyMetadataKey, "rest-key", PrincipalMetadataKey, "user:u1"),
+			principal: "user:u1",
+		},
+		{
+			testName:  "api key",
+			md:        metadata.Pairs(APIKeyMetadataKey, "rest-key", PrincipalMetadataKey, "api_key:abcdefghijklm"),
+			principal: "api_key:abcdefghijklm",
+		},
+		{
+			testName: "none",
+			md:       metadata.Pairs(APIKeyMetadataKey, "rest-key"),
+		},
+		{
+			testName: "control characters",
+			md:       metadata.Pairs(APIKeyMetadataKey, "rest-key", PrincipalMetadataKey, "user:u1\nuser:u2"),
+		},
+		{
+			testName: "too long",
+			md:       metadata.Pairs(APIKeyMetadataKey, "rest-key", PrincipalMetadataKey, "user:"+strings.Repeat("x", maxPrincipalLength)),
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tc.md)
+
+			ctx, err := authenticator.Authorize(ctx, "/pb.UserService/UpdateUser")
+			assert.NoError(t, err)
+			assert.Equal(t, "rest-service", ClientFromContext(ctx))
+			assert.Equal(t, tc.principal, PrincipalFromContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package svcauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken = errors.New("malformed service token")
+	ErrBadSignature   = errors.New("invalid service token signature")
+	ErrTokenExpired   = errors.New("service token expired")
+)
+
+type Claims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// NewToken returns a service token for client, valid until now+ttl, in the
+// form base64url(claims).base64url(HMAC-SHA256(secret, claims)).
+func NewToken(client string, secret []byte, now time.Time, ttl time.Duration) (string, error) {
+	payload, err := json.Marshal(Claims{Subject: client, ExpiresAt: now.Add(ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(sign(secret, payload)), nil
+}
+
+// ParseToken returns the unverified claims of token, to find the secret it
+// must be verified with.
+func ParseToken(token string) (Claims, []byte, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return Claims{}, nil, nil, ErrMalformedToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Claims{}, nil, nil, ErrMalformedToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, nil, nil, ErrMalformedToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Subject == "" {
+		return Claims{}, nil, nil, ErrMalformedToken
+	}
+	return claims, payload, signature, nil
+}
+
+func VerifyToken(token string, secret []byte, now time.Time) (Claims, error) {
+	claims, payload, signature, err := ParseToken(token)
+	if err != nil {
+		return Claims{}, err
+	}
+	if !hmac.Equal(signature, sign(secret, payload)) {
+		return Claims{}, ErrBadSignature
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return Claims{}, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+func sign(secret, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,49 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// ServerConfig returns a TLS config serving the reloader's certificate. When
+// requireClientCert is set, clients must present a certificate signed by the
+// reloader's CA bundle and, if allowedSubjects is not empty, whose subject
+// common name is in the list.
+func ServerConfig(r *Reloader, requireClientCert bool, allowedSubjects []string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: r.GetCertificate,
+				NextProtos:     []string{"h2"},
+			}
+			if requireClientCert {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = r.CAPool()
+				cfg.VerifyPeerCertificate = verifySubject(allowedSubjects)
+			}
+			return cfg, nil
+		},
+	}
+}
+
+func verifySubject(allowed []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, chains [][]*x509.Certificate) error {
+		if len(allowed) == 0 {
+			return nil
+		}
+		if len(chains) == 0 || len(chains[0]) == 0 {
+			return errors.New("no verified client certificate")
+		}
+		cn := chains[0][0].Subject.CommonName
+		for _, subject := range allowed {
+			if subject == cn {
+				return nil
+			}
+		}
+		return fmt.Errorf("client certificate subject %q is not allowed", cn)
+	}
+}
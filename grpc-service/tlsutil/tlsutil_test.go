@@ -0,0 +1,246 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) issue(t *testing.T, cn string, serial int64) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	for name, data := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+	}
+}
+
+func handshake(serverCfg, clientCfg *tls.Config) (*tls.ConnectionState, error) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		err = conn.(*tls.Conn).Handshake()
+		if err == nil {
+			_, err = conn.Write([]byte("ok"))
+		}
+		serverErr <- err
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// with TLS 1.3 the client only learns about a rejected certificate on
+	// its first read
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	if err := <-serverErr; err != nil {
+		return nil, err
+	}
+
+	state := conn.ConnectionState()
+	return &state, nil
+}
+
+func TestServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t)
+	serverCert, serverKey := ca.issue(t, "localhost", 2)
+	writeFiles(t, dir, map[string][]byte{"server.crt": serverCert, "server.key": serverKey, "ca.crt": ca.pem})
+
+	reloader, err := NewReloader(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.crt"))
+	assert.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+
+	clientCert := func(cn string) []tls.Certificate {
+		certPEM, keyPEM := ca.issue(t, cn, 3)
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		assert.NoError(t, err)
+		return []tls.Certificate{cert}
+	}
+
+	testCases := []struct {
+		testName          string
+		requireClientCert bool
+		allowedSubjects   []string
+		clientCerts       []tls.Certificate
+		checkResponse     func(t *testing.T, err error)
+	}{
+		{
+			testName: "server TLS only",
+			checkResponse: func(t *testing.T, err error) {
+				assert.NoError(t, err)
+			},
+		},
+		{
+			testName:          "mTLS allowed subject",
+			requireClientCert: true,
+			allowedSubjects:   []string{"rest-service"},
+			clientCerts:       clientCert("rest-service"),
+			checkResponse: func(t *testing.T, err error) {
+				assert.NoError(t, err)
+			},
+		},
+		{
+			testName:          "mTLS subject not allowed",
+			requireClientCert: true,
+			allowedSubjects:   []string{"rest-service"},
+			clientCerts:       clientCert("intruder"),
+			checkResponse: func(t *testing.T, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			testName:          "mTLS without client certificate",
+			requireClientCert: true,
+			checkResponse: func(t *testing.T, err error) {
+				assert.Error(t, err)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			serverCfg := ServerConfig(reloader, tc.requireClientCert, tc.allowedSubjects)
+			clientCfg := &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: tc.clientCerts}
+
+			_, err := handshake(serverCfg, clientCfg)
+			tc.checkResponse(t, err)
+		})
+	}
+}
+
+func TestReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t)
+	certPEM, keyPEM := ca.issue(t, "localhost", 10)
+	writeFiles(t, dir, map[string][]byte{"server.crt": certPEM, "server.key": keyPEM})
+
+	reloader, err := NewReloader(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), "")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10*time.Millisecond, func(err error) { t.Log(err) })
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	clientCfg := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+
+	state, err := handshake(ServerConfig(reloader, false, nil), clientCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), state.PeerCertificates[0].SerialNumber.Int64())
+
+	certPEM, keyPEM = ca.issue(t, "localhost", 11)
+	writeFiles(t, dir, map[string][]byte{"server.crt": certPEM, "server.key": keyPEM})
+	future := time.Now().Add(time.Second)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "server.crt"), future, future))
+
+	assert.Eventually(t, func() bool {
+		state, err := handshake(ServerConfig(reloader, false, nil), clientCfg)
+		return err == nil && state.PeerCertificates[0].SerialNumber.Int64() == 11
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// A restored backup is older than the files it replaces.
+	certPEM, keyPEM = ca.issue(t, "localhost", 12)
+	writeFiles(t, dir, map[string][]byte{"server.crt": certPEM, "server.key": keyPEM})
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"server.crt", "server.key"} {
+		assert.NoError(t, os.Chtimes(filepath.Join(dir, name), past, past))
+	}
+
+	assert.Eventually(t, func() bool {
+		state, err := handshake(ServerConfig(reloader, false, nil), clientCfg)
+		return err == nil && state.PeerCertificates[0].SerialNumber.Int64() == 12
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// Only one file goes back in time, so the newest time doesn't change.
+	certPEM, keyPEM = ca.issue(t, "localhost", 13)
+	writeFiles(t, dir, map[string][]byte{"server.crt": certPEM, "server.key": keyPEM})
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "server.key"), past, past))
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "server.crt"), past.Add(-time.Hour), past.Add(-time.Hour)))
+
+	assert.Eventually(t, func() bool {
+		state, err := handshake(ServerConfig(reloader, false, nil), clientCfg)
+		return err == nil && state.PeerCertificates[0].SerialNumber.Int64() == 13
+	}, 2*time.Second, 20*time.Millisecond)
+}
@@ -0,0 +1,113 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the key of the RFC 4226 and RFC 6238 test vectors.
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226 appendix D.
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		assert.Equal(t, code, hotp([]byte("12345678901234567890"), uint64(counter), 6), "counter %d", counter)
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA1.
+	testCases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tc := range testCases {
+		step := Step(time.Unix(tc.unix, 0))
+		assert.Equal(t, tc.code, hotp([]byte("12345678901234567890"), uint64(step), 8), "time %d", tc.unix)
+
+		code, err := Code(rfcSecret, step)
+		require.NoError(t, err)
+		assert.Equal(t, tc.code[2:], code, "time %d", tc.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		require.NoError(t, err)
+		return c
+	}
+
+	testCases := []struct {
+		testName string
+		code     string
+		step     int64
+		ok       bool
+	}{
+		{testName: "current step", code: code(step), step: step, ok: true},
+		{testName: "previous step", code: code(step - 1), step: step - 1, ok: true},
+		{testName: "next step", code: code(step + 1), step: step + 1, ok: true},
+		{testName: "too old", code: code(step - 2)},
+		{testName: "too new", code: code(step + 2)},
+		{testName: "wrong length", code: "1234567"},
+		{testName: "empty", code: ""},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tc.code, now)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.step, step)
+		})
+	}
+
+	_, ok := Validate("not base32!", code(step), now)
+	assert.False(t, ok)
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	other, err := NewSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+
+	_, err = Code(secret, 1)
+	assert.NoError(t, err)
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Final Project", "javier@example.com", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/Final Project:javier@example.com", uri.Path)
+	assert.Equal(t, url.Values{
+		"secret":    {"JBSWY3DPEHPK3PXP"},
+		"issuer":    {"Final Project"},
+		"algorithm": {"SHA1"},
+		"digits":    {"6"},
+		"period":    {"30"},
+	}, uri.Query())
+
+	assert.Equal(t, "otpauth://totp/javier?algorithm=SHA1&digits=6&period=30&secret=JBSWY3DPEHPK3PXP", URI("", "javier", "JBSWY3DPEHPK3PXP"))
+}
@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type collector struct {
+	collectortrace.UnimplementedTraceServiceServer
+	mu    sync.Mutex
+	names []string
+}
+
+func (c *collector) Export(_ context.Context, req *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ils := range rs.InstrumentationLibrarySpans {
+			for _, s := range ils.Spans {
+				c.names = append(c.names, s.Name)
+			}
+		}
+	}
+	return &collectortrace.ExportTraceServiceResponse{}, nil
+}
+
+func TestOTLPExporter(t *testing.T) {
+	ctx := context.Background()
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	c := &collector{}
+	collectortrace.RegisterTraceServiceServer(server, c)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+	)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	tp, err := NewProvider(ctx, Config{ServiceName: "test", Exporter: ExporterOTLP, OTLPConn: conn})
+	assert.NoError(t, err)
+
+	_, span := StartSpan(ctx, "test-span")
+	span.End()
+
+	assert.NoError(t, tp.Shutdown(ctx))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	assert.Equal(t, []string{"test-span"}, c.names)
+}
+
+func TestUnknownExporter(t *testing.T) {
+	_, err := NewProvider(context.Background(), Config{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+
+	md := metadata.Pairs("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+
+	interceptor := UnaryServerInterceptor()
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/pb.UserService/GetUser"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			_, span := StartDBSpan(ctx, "SELECT", "SELECT 1")
+			span.End()
+			return nil, nil
+		},
+	)
+	assert.NoError(t, err)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+
+	dbSpan, serverSpan := spans[0], spans[1]
+	assert.Equal(t, "/pb.UserService/GetUser", serverSpan.Name())
+	assert.Equal(t, traceID, serverSpan.SpanContext().TraceID())
+	assert.Equal(t, spanID, serverSpan.Parent().SpanID())
+	assert.Equal(t, "db.SELECT", dbSpan.Name())
+	assert.Equal(t, serverSpan.SpanContext().SpanID(), dbSpan.Parent().SpanID())
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "team", "core")
+	var sent metadata.MD
+	err := UnaryClientInterceptor()(ctx, "/pb.UserService/GetUser", nil, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			sent, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		},
+	)
+	assert.NoError(t, err)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "/pb.UserService/GetUser", spans[0].Name())
+	assert.Equal(t, trace.SpanKindClient, spans[0].SpanKind())
+	assert.Equal(t,
+		"00-"+spans[0].SpanContext().TraceID().String()+"-"+spans[0].SpanContext().SpanID().String()+"-01",
+		sent.Get("traceparent")[0],
+	)
+	assert.Equal(t, []string{"core"}, sent.Get("team"), "other metadata is kept")
+}
@@ -3,20 +3,42 @@ package transport
 import (
 	"context"
 	"fmt"
+	"time"
 
 	gt "github.com/go-kit/kit/transport/grpc"
 	"github.com/go-kit/log"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/javibauza/final-project/grpc-service/endpoints"
 	erro "github.com/javibauza/final-project/grpc-service/errors"
 	"github.com/javibauza/final-project/grpc-service/pb"
+	"github.com/javibauza/final-project/grpc-service/service"
 )
 
 type gRPCServer struct {
-	auth       gt.Handler
-	createUser gt.Handler
-	updateUser gt.Handler
-	getUser    gt.Handler
+	auth         gt.Handler
+	createUser   gt.Handler
+	updateUser   gt.Handler
+	getUser      gt.Handler
+	auditLog     gt.Handler
+	deleteUser   gt.Handler
+	restore      gt.Handler
+	sendVerify   gt.Handler
+	verify       gt.Handler
+	completeAuth gt.Handler
+	enrollTOTP   gt.Handler
+	confirmTOTP  gt.Handler
+	createKey    gt.Handler
+	listKeys     gt.Handler
+	revokeKey    gt.Handler
+	authKey      gt.Handler
+	createClient gt.Handler
+	getClient    gt.Handler
+	createCode   gt.Handler
+	exchangeCode gt.Handler
+	linkIdentity gt.Handler
+	provIdentity gt.Handler
+	authIdentity gt.Handler
 	pb.UnimplementedUserServiceServer
 }
 
@@ -42,6 +64,101 @@ func NewGRPCServer(endpoints endpoints.Endpoints, logger log.Logger) pb.UserServ
 			decodeGetUserRequest,
 			encodeGetUserResponse,
 		),
+		auditLog: gt.NewServer(
+			endpoints.GetAuditLog,
+			decodeGetAuditLogRequest,
+			encodeGetAuditLogResponse,
+		),
+		deleteUser: gt.NewServer(
+			endpoints.DeleteUser,
+			decodeDeleteUserRequest,
+			encodeDeleteUserResponse,
+		),
+		restore: gt.NewServer(
+			endpoints.RestoreUser,
+			decodeRestoreUserRequest,
+			encodeRestoreUserResponse,
+		),
+		sendVerify: gt.NewServer(
+			endpoints.SendVerification,
+			decodeSendVerificationRequest,
+			encodeSendVerificationResponse,
+		),
+		verify: gt.NewServer(
+			endpoints.VerifyEmail,
+			decodeVerifyEmailRequest,
+			encodeVerifyEmailResponse,
+		),
+		completeAuth: gt.NewServer(
+			endpoints.CompleteAuth,
+			decodeCompleteAuthChallengeRequest,
+			encodeAuthResponse,
+		),
+		enrollTOTP: gt.NewServer(
+			endpoints.EnrollTOTP,
+			decodeEnrollTOTPRequest,
+			encodeEnrollTOTPResponse,
+		),
+		confirmTOTP: gt.NewServer(
+			endpoints.ConfirmTOTP,
+			decodeConfirmTOTPRequest,
+			encodeConfirmTOTPResponse,
+		),
+		createKey: gt.NewServer(
+			endpoints.CreateAPIKey,
+			decodeCreateAPIKeyRequest,
+			encodeCreateAPIKeyResponse,
+		),
+		listKeys: gt.NewServer(
+			endpoints.ListAPIKeys,
+			decodeListAPIKeysRequest,
+			encodeListAPIKeysResponse,
+		),
+		revokeKey: gt.NewServer(
+			endpoints.RevokeAPIKey,
+			decodeRevokeAPIKeyRequest,
+			encodeRevokeAPIKeyResponse,
+		),
+		authKey: gt.NewServer(
+			endpoints.AuthAPIKey,
+			decodeAuthAPIKeyRequest,
+			encodeAuthAPIKeyResponse,
+		),
+		createClient: gt.NewServer(
+			endpoints.CreateOAuthClient,
+			decodeCreateOAuthClientRequest,
+			encodeCreateOAuthClientResponse,
+		),
+		getClient: gt.NewServer(
+			endpoints.GetOAuthClient,
+			decodeGetOAuthClientRequest,
+			encodeGetOAuthClientResponse,
+		),
+		createCode: gt.NewServer(
+			endpoints.CreateAuthCode,
+			decodeCreateAuthCodeRequest,
+			encodeCreateAuthCodeResponse,
+		),
+		exchangeCode: gt.NewServer(
+			endpoints.ExchangeAuthCode,
+			decodeExchangeAuthCodeRequest,
+			encodeExchangeAuthCodeResponse,
+		),
+		linkIdentity: gt.NewServer(
+			endpoints.LinkIdentity,
+			decodeLinkIdentityRequest,
+			encodeLinkIdentityResponse,
+		),
+		provIdentity: gt.NewServer(
+			endpoints.ProvisionIdentity,
+			decodeProvisionIdentityRequest,
+			encodeProvisionIdentityResponse,
+		),
+		authIdentity: gt.NewServer(
+			endpoints.AuthIdentity,
+			decodeAuthIdentityRequest,
+			encodeAuthIdentityResponse,
+		),
 	}
 }
 
@@ -57,7 +174,7 @@ func (s *gRPCServer) Authenticate(ctx context.Context, req *pb.AuthRequest) (*pb
 			*erro.ErrPermissionDenied:
 			status = resolveStatus(r)
 		default:
-			status.Code = 3
+			status.Code = 2
 			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
 		}
 
@@ -89,8 +206,9 @@ func encodeAuthResponse(_ context.Context, response interface{}) (interface{}, e
 		status.Code = 0
 		status.Message = "ok"
 		authResponse.UserId = r.UserId
+		authResponse.Challenge = r.Challenge
 	default:
-		status.Code = 3
+		status.Code = 2
 		status.Message = "unexpected error"
 	}
 
@@ -98,6 +216,42 @@ func encodeAuthResponse(_ context.Context, response interface{}) (interface{}, e
 	return authResponse, nil
 }
 
+func (s *gRPCServer) CompleteAuthChallenge(ctx context.Context, req *pb.CompleteAuthChallengeRequest) (*pb.AuthResponse, error) {
+	_, resp, err := s.completeAuth.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var authResponse = &pb.AuthResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound,
+			*erro.ErrPermissionDenied:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		authResponse.Status = status
+		return authResponse, nil
+	}
+
+	authResp, ok := resp.(*pb.AuthResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return authResp, nil
+}
+
+func decodeCompleteAuthChallengeRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.CompleteAuthChallengeRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.CompleteAuthChallengeRequest{Challenge: req.Challenge, Code: req.Code}, nil
+}
+
 func (s *gRPCServer) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.CreateUserResponse, error) {
 	_, res, err := s.createUser.ServeGRPC(ctx, req)
 	if err != nil {
@@ -107,10 +261,11 @@ func (s *gRPCServer) CreateUser(ctx context.Context, req *pb.CreateUserRequest)
 		switch r := err.(type) {
 		case *erro.ErrInvalidArgument,
 			*erro.ErrNotFound,
-			*erro.ErrPermissionDenied:
+			*erro.ErrPermissionDenied,
+			*erro.ErrAlreadyExists:
 			status = resolveStatus(r)
 		default:
-			status.Code = 3
+			status.Code = 2
 			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
 		}
 
@@ -132,10 +287,11 @@ func decodeCreateUserRequest(_ context.Context, request interface{}) (interface{
 		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
 	}
 	return endpoints.CreateUserRequest{
-		Name:    req.UserName,
-		Pwd:     req.Password,
-		Age:     req.UserAge,
-		AddInfo: req.AddInfo,
+		Name:      req.UserName,
+		Pwd:       req.Password,
+		Age:       req.UserAge,
+		BirthDate: req.BirthDate,
+		Profile:   profileFromPB(req.Profile),
 	}, nil
 }
 
@@ -147,8 +303,16 @@ func encodeCreateUserResponse(_ context.Context, response interface{}) (interfac
 		status.Code = 0
 		status.Message = "ok"
 		createUserResponse.UserId = r.UserId
+		createUserResponse.User = &pb.User{
+			UserId:    r.UserId,
+			UserName:  r.Name,
+			UserAge:   r.Age,
+			BirthDate: r.BirthDate,
+			Profile:   profileToPB(r.Profile),
+			Version:   r.Version,
+		}
 	default:
-		status.Code = 3
+		status.Code = 2
 		status.Message = "unexpected error"
 	}
 
@@ -165,10 +329,12 @@ func (s *gRPCServer) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest)
 		switch r := err.(type) {
 		case *erro.ErrInvalidArgument,
 			*erro.ErrNotFound,
-			*erro.ErrPermissionDenied:
+			*erro.ErrPermissionDenied,
+			*erro.ErrFailedPrecondition,
+			*erro.ErrAlreadyExists:
 			status = resolveStatus(r)
 		default:
-			status.Code = 3
+			status.Code = 2
 			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
 		}
 
@@ -190,23 +356,35 @@ func decodeUpdateUserRequest(_ context.Context, request interface{}) (interface{
 		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
 	}
 	return endpoints.UpdateUserRequest{
-		UserId:  req.UserId,
-		Name:    req.UserName,
-		Pwd:     req.Password,
-		Age:     req.UserAge,
-		AddInfo: req.AddInfo,
+		UserId:          req.UserId,
+		Name:            req.UserName,
+		Pwd:             req.Password,
+		Age:             req.UserAge,
+		BirthDate:       req.BirthDate,
+		Profile:         profileFromPB(req.Profile),
+		ExpectedVersion: req.ExpectedVersion,
+		Fields:          req.GetUpdateMask().GetPaths(),
 	}, nil
 }
 
 func encodeUpdateUserResponse(_ context.Context, response interface{}) (interface{}, error) {
 	var status = &pb.Status{}
 	var updateUserResponse = &pb.UpdateUserResponse{}
-	switch response.(type) {
-	case nil:
+	switch r := response.(type) {
+	case endpoints.UpdateUserResponse:
 		status.Code = 0
 		status.Message = "ok"
+		updateUserResponse.User = &pb.User{
+			UserId:        r.UserId,
+			UserName:      r.Name,
+			UserAge:       r.Age,
+			BirthDate:     r.BirthDate,
+			Profile:       profileToPB(r.Profile),
+			EmailVerified: r.EmailVerified,
+			Version:       r.Version,
+		}
 	default:
-		status.Code = 3
+		status.Code = 2
 		status.Message = "unexpected error"
 	}
 
@@ -226,7 +404,7 @@ func (s *gRPCServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.G
 			*erro.ErrPermissionDenied:
 			status = resolveStatus(r)
 		default:
-			status.Code = 3
+			status.Code = 2
 			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
 		}
 
@@ -262,9 +440,15 @@ func encodeGetUserResponse(_ context.Context, response interface{}) (interface{}
 		getUserResponse.UserId = r.UserId
 		getUserResponse.UserName = r.Name
 		getUserResponse.UserAge = r.Age
-		getUserResponse.AddInfo = r.AddInfo
+		getUserResponse.BirthDate = r.BirthDate
+		getUserResponse.Profile = profileToPB(r.Profile)
+		getUserResponse.EmailVerified = r.EmailVerified
+		getUserResponse.Version = r.Version
+		getUserResponse.CreatedAt = timestamp(r.CreatedAt)
+		getUserResponse.UpdatedAt = timestamp(r.UpdatedAt)
+		getUserResponse.LastLoginAt = timestamp(r.LastLoginAt)
 	default:
-		status.Code = 3
+		status.Code = 2
 		status.Message = "unexpected error"
 	}
 
@@ -272,22 +456,1116 @@ func encodeGetUserResponse(_ context.Context, response interface{}) (interface{}
 	return getUserResponse, nil
 }
 
-func resolveStatus(response interface{}) *pb.Status {
-	var status pb.Status
+func (s *gRPCServer) GetAuditLog(ctx context.Context, req *pb.GetAuditLogRequest) (*pb.GetAuditLogResponse, error) {
+	_, res, err := s.auditLog.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var auditLogResponse = &pb.GetAuditLogResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound,
+			*erro.ErrPermissionDenied:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		auditLogResponse.Status = status
+		return auditLogResponse, nil
+	}
+
+	response, ok := res.(*pb.GetAuditLogResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeGetAuditLogRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.GetAuditLogRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.GetAuditLogRequest{
+		UserId:    req.UserId,
+		PageSize:  req.PageSize,
+		PageToken: req.PageToken,
+	}, nil
+}
+
+func encodeGetAuditLogResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var auditLogResponse = &pb.GetAuditLogResponse{}
 	switch r := response.(type) {
-	case *erro.ErrInvalidArgument:
-		status.Code = 3
-		status.Message = r.Err.Error()
-	case *erro.ErrNotFound:
-		status.Code = 5
-		status.Message = r.Err.Error()
-	case *erro.ErrPermissionDenied:
-		status.Code = 7
-		status.Message = r.Err.Error()
+	case endpoints.GetAuditLogResponse:
+		status.Code = 0
+		status.Message = "ok"
+		for _, entry := range r.Entries {
+			auditLogResponse.Entries = append(auditLogResponse.Entries, &pb.AuditEntry{
+				Id:        entry.Id,
+				Actor:     entry.Actor,
+				Action:    entry.Action,
+				Target:    entry.Target,
+				Changes:   entry.Changes,
+				RequestId: entry.RequestId,
+				Service:   entry.Service,
+				CreatedAt: timestamppb.New(entry.CreatedAt),
+			})
+		}
+		auditLogResponse.NextPageToken = r.NextPageToken
 	default:
-		status.Code = 3
+		status.Code = 2
 		status.Message = "unexpected error"
 	}
 
-	return &status
+	auditLogResponse.Status = status
+	return auditLogResponse, nil
+}
+
+func (s *gRPCServer) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	_, res, err := s.deleteUser.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var deleteUserResponse = &pb.DeleteUserResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound,
+			*erro.ErrPermissionDenied:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		deleteUserResponse.Status = status
+		return deleteUserResponse, nil
+	}
+
+	response, ok := res.(*pb.DeleteUserResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeDeleteUserRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.DeleteUserRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.DeleteUserRequest{
+		UserId: req.UserId,
+	}, nil
+}
+
+func encodeDeleteUserResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	switch response.(type) {
+	case endpoints.DeleteUserResponse:
+		status.Code = 0
+		status.Message = "ok"
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	return &pb.DeleteUserResponse{Status: status}, nil
+}
+
+func (s *gRPCServer) RestoreUser(ctx context.Context, req *pb.RestoreUserRequest) (*pb.RestoreUserResponse, error) {
+	_, res, err := s.restore.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var restoreUserResponse = &pb.RestoreUserResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound,
+			*erro.ErrPermissionDenied,
+			*erro.ErrFailedPrecondition:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		restoreUserResponse.Status = status
+		return restoreUserResponse, nil
+	}
+
+	response, ok := res.(*pb.RestoreUserResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeRestoreUserRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.RestoreUserRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.RestoreUserRequest{
+		UserId: req.UserId,
+	}, nil
+}
+
+func encodeRestoreUserResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var restoreUserResponse = &pb.RestoreUserResponse{}
+	switch r := response.(type) {
+	case endpoints.GetUserResponse:
+		status.Code = 0
+		status.Message = "ok"
+		restoreUserResponse.User = userToPB(r)
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	restoreUserResponse.Status = status
+	return restoreUserResponse, nil
+}
+
+func (s *gRPCServer) SendVerification(ctx context.Context, req *pb.SendVerificationRequest) (*pb.SendVerificationResponse, error) {
+	_, res, err := s.sendVerify.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var sendVerificationResponse = &pb.SendVerificationResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound,
+			*erro.ErrPermissionDenied,
+			*erro.ErrFailedPrecondition:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		sendVerificationResponse.Status = status
+		return sendVerificationResponse, nil
+	}
+
+	response, ok := res.(*pb.SendVerificationResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeSendVerificationRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.SendVerificationRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.SendVerificationRequest{
+		UserId: req.UserId,
+	}, nil
+}
+
+func encodeSendVerificationResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	switch response.(type) {
+	case endpoints.SendVerificationResponse:
+		status.Code = 0
+		status.Message = "ok"
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	return &pb.SendVerificationResponse{Status: status}, nil
+}
+
+func (s *gRPCServer) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (*pb.VerifyEmailResponse, error) {
+	_, res, err := s.verify.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var verifyEmailResponse = &pb.VerifyEmailResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound,
+			*erro.ErrPermissionDenied:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		verifyEmailResponse.Status = status
+		return verifyEmailResponse, nil
+	}
+
+	response, ok := res.(*pb.VerifyEmailResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeVerifyEmailRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.VerifyEmailRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.VerifyEmailRequest{
+		Token: req.Token,
+	}, nil
+}
+
+func encodeVerifyEmailResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var verifyEmailResponse = &pb.VerifyEmailResponse{}
+	switch r := response.(type) {
+	case endpoints.GetUserResponse:
+		status.Code = 0
+		status.Message = "ok"
+		verifyEmailResponse.User = userToPB(r)
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	verifyEmailResponse.Status = status
+	return verifyEmailResponse, nil
+}
+
+func (s *gRPCServer) EnrollTOTP(ctx context.Context, req *pb.EnrollTOTPRequest) (*pb.EnrollTOTPResponse, error) {
+	_, res, err := s.enrollTOTP.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var enrollTOTPResponse = &pb.EnrollTOTPResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound,
+			*erro.ErrFailedPrecondition:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		enrollTOTPResponse.Status = status
+		return enrollTOTPResponse, nil
+	}
+
+	response, ok := res.(*pb.EnrollTOTPResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeEnrollTOTPRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.EnrollTOTPRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.EnrollTOTPRequest{
+		UserId: req.UserId,
+	}, nil
+}
+
+func encodeEnrollTOTPResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var enrollTOTPResponse = &pb.EnrollTOTPResponse{}
+	switch r := response.(type) {
+	case endpoints.EnrollTOTPResponse:
+		status.Code = 0
+		status.Message = "ok"
+		enrollTOTPResponse.Secret = r.Secret
+		enrollTOTPResponse.Uri = r.URI
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	enrollTOTPResponse.Status = status
+	return enrollTOTPResponse, nil
+}
+
+func (s *gRPCServer) ConfirmTOTP(ctx context.Context, req *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPResponse, error) {
+	_, res, err := s.confirmTOTP.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var confirmTOTPResponse = &pb.ConfirmTOTPResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound,
+			*erro.ErrPermissionDenied,
+			*erro.ErrFailedPrecondition:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		confirmTOTPResponse.Status = status
+		return confirmTOTPResponse, nil
+	}
+
+	response, ok := res.(*pb.ConfirmTOTPResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeConfirmTOTPRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.ConfirmTOTPRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.ConfirmTOTPRequest{
+		UserId: req.UserId,
+		Code:   req.Code,
+	}, nil
+}
+
+func encodeConfirmTOTPResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var confirmTOTPResponse = &pb.ConfirmTOTPResponse{}
+	switch r := response.(type) {
+	case endpoints.ConfirmTOTPResponse:
+		status.Code = 0
+		status.Message = "ok"
+		confirmTOTPResponse.RecoveryCodes = r.RecoveryCodes
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	confirmTOTPResponse.Status = status
+	return confirmTOTPResponse, nil
+}
+
+func (s *gRPCServer) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	_, res, err := s.createKey.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var createAPIKeyResponse = &pb.CreateAPIKeyResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		createAPIKeyResponse.Status = status
+		return createAPIKeyResponse, nil
+	}
+
+	response, ok := res.(*pb.CreateAPIKeyResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeCreateAPIKeyRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.CreateAPIKeyRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		expiresAt = req.ExpiresAt.AsTime()
+	}
+	return endpoints.CreateAPIKeyRequest{
+		UserId:    req.UserId,
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func encodeCreateAPIKeyResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var createAPIKeyResponse = &pb.CreateAPIKeyResponse{}
+	switch r := response.(type) {
+	case endpoints.CreateAPIKeyResponse:
+		status.Code = 0
+		status.Message = "ok"
+		createAPIKeyResponse.ApiKey = apiKeyToPB(r.APIKey)
+		createAPIKeyResponse.Key = r.Key
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	createAPIKeyResponse.Status = status
+	return createAPIKeyResponse, nil
+}
+
+func (s *gRPCServer) ListAPIKeys(ctx context.Context, req *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	_, res, err := s.listKeys.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var listAPIKeysResponse = &pb.ListAPIKeysResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		listAPIKeysResponse.Status = status
+		return listAPIKeysResponse, nil
+	}
+
+	response, ok := res.(*pb.ListAPIKeysResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeListAPIKeysRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.ListAPIKeysRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.ListAPIKeysRequest{
+		UserId: req.UserId,
+	}, nil
+}
+
+func encodeListAPIKeysResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var listAPIKeysResponse = &pb.ListAPIKeysResponse{}
+	switch r := response.(type) {
+	case endpoints.ListAPIKeysResponse:
+		status.Code = 0
+		status.Message = "ok"
+		for _, key := range r.APIKeys {
+			listAPIKeysResponse.ApiKeys = append(listAPIKeysResponse.ApiKeys, apiKeyToPB(key))
+		}
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	listAPIKeysResponse.Status = status
+	return listAPIKeysResponse, nil
+}
+
+func (s *gRPCServer) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	_, res, err := s.revokeKey.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var revokeAPIKeyResponse = &pb.RevokeAPIKeyResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		revokeAPIKeyResponse.Status = status
+		return revokeAPIKeyResponse, nil
+	}
+
+	response, ok := res.(*pb.RevokeAPIKeyResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeRevokeAPIKeyRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.RevokeAPIKeyRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.RevokeAPIKeyRequest{
+		UserId: req.UserId,
+		KeyId:  req.KeyId,
+	}, nil
+}
+
+func encodeRevokeAPIKeyResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	switch response.(type) {
+	case endpoints.RevokeAPIKeyResponse:
+		status.Code = 0
+		status.Message = "ok"
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	return &pb.RevokeAPIKeyResponse{Status: status}, nil
+}
+
+func (s *gRPCServer) AuthenticateAPIKey(ctx context.Context, req *pb.AuthenticateAPIKeyRequest) (*pb.AuthenticateAPIKeyResponse, error) {
+	_, res, err := s.authKey.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var authAPIKeyResponse = &pb.AuthenticateAPIKeyResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrPermissionDenied:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		authAPIKeyResponse.Status = status
+		return authAPIKeyResponse, nil
+	}
+
+	response, ok := res.(*pb.AuthenticateAPIKeyResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeAuthAPIKeyRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.AuthenticateAPIKeyRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.AuthAPIKeyRequest{
+		Key: req.Key,
+	}, nil
+}
+
+func encodeAuthAPIKeyResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var authAPIKeyResponse = &pb.AuthenticateAPIKeyResponse{}
+	switch r := response.(type) {
+	case endpoints.AuthAPIKeyResponse:
+		status.Code = 0
+		status.Message = "ok"
+		authAPIKeyResponse.KeyId = r.KeyId
+		authAPIKeyResponse.UserId = r.UserId
+		authAPIKeyResponse.Scopes = r.Scopes
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	authAPIKeyResponse.Status = status
+	return authAPIKeyResponse, nil
+}
+
+func (s *gRPCServer) CreateOAuthClient(ctx context.Context, req *pb.CreateOAuthClientRequest) (*pb.CreateOAuthClientResponse, error) {
+	_, res, err := s.createClient.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var createOAuthClientResponse = &pb.CreateOAuthClientResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		createOAuthClientResponse.Status = status
+		return createOAuthClientResponse, nil
+	}
+
+	response, ok := res.(*pb.CreateOAuthClientResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeCreateOAuthClientRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.CreateOAuthClientRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.CreateOAuthClientRequest{
+		Name:         req.Name,
+		RedirectURIs: req.RedirectUris,
+		Confidential: req.Confidential,
+	}, nil
+}
+
+func encodeCreateOAuthClientResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var createOAuthClientResponse = &pb.CreateOAuthClientResponse{}
+	switch r := response.(type) {
+	case endpoints.CreateOAuthClientResponse:
+		status.Code = 0
+		status.Message = "ok"
+		createOAuthClientResponse.Client = oauthClientToPB(r.Client)
+		createOAuthClientResponse.ClientSecret = r.Secret
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	createOAuthClientResponse.Status = status
+	return createOAuthClientResponse, nil
+}
+
+func (s *gRPCServer) GetOAuthClient(ctx context.Context, req *pb.GetOAuthClientRequest) (*pb.GetOAuthClientResponse, error) {
+	_, res, err := s.getClient.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var getOAuthClientResponse = &pb.GetOAuthClientResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		getOAuthClientResponse.Status = status
+		return getOAuthClientResponse, nil
+	}
+
+	response, ok := res.(*pb.GetOAuthClientResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeGetOAuthClientRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.GetOAuthClientRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.GetOAuthClientRequest{
+		ClientId: req.ClientId,
+	}, nil
+}
+
+func encodeGetOAuthClientResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var getOAuthClientResponse = &pb.GetOAuthClientResponse{}
+	switch r := response.(type) {
+	case endpoints.GetOAuthClientResponse:
+		status.Code = 0
+		status.Message = "ok"
+		getOAuthClientResponse.Client = oauthClientToPB(r.Client)
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	getOAuthClientResponse.Status = status
+	return getOAuthClientResponse, nil
+}
+
+func (s *gRPCServer) CreateAuthorizationCode(ctx context.Context, req *pb.CreateAuthorizationCodeRequest) (*pb.CreateAuthorizationCodeResponse, error) {
+	_, res, err := s.createCode.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var createAuthCodeResponse = &pb.CreateAuthorizationCodeResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		createAuthCodeResponse.Status = status
+		return createAuthCodeResponse, nil
+	}
+
+	response, ok := res.(*pb.CreateAuthorizationCodeResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeCreateAuthCodeRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.CreateAuthorizationCodeRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.CreateAuthCodeRequest{
+		ClientId:      req.ClientId,
+		UserId:        req.UserId,
+		RedirectURI:   req.RedirectUri,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+	}, nil
+}
+
+func encodeCreateAuthCodeResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var createAuthCodeResponse = &pb.CreateAuthorizationCodeResponse{}
+	switch r := response.(type) {
+	case endpoints.CreateAuthCodeResponse:
+		status.Code = 0
+		status.Message = "ok"
+		createAuthCodeResponse.Code = r.Code
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	createAuthCodeResponse.Status = status
+	return createAuthCodeResponse, nil
+}
+
+func (s *gRPCServer) ExchangeAuthorizationCode(ctx context.Context, req *pb.ExchangeAuthorizationCodeRequest) (*pb.ExchangeAuthorizationCodeResponse, error) {
+	_, res, err := s.exchangeCode.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var exchangeAuthCodeResponse = &pb.ExchangeAuthorizationCodeResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound,
+			*erro.ErrPermissionDenied:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		exchangeAuthCodeResponse.Status = status
+		return exchangeAuthCodeResponse, nil
+	}
+
+	response, ok := res.(*pb.ExchangeAuthorizationCodeResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeExchangeAuthCodeRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.ExchangeAuthorizationCodeRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.ExchangeAuthCodeRequest{
+		ClientId:     req.ClientId,
+		ClientSecret: req.ClientSecret,
+		Code:         req.Code,
+		RedirectURI:  req.RedirectUri,
+		CodeVerifier: req.CodeVerifier,
+	}, nil
+}
+
+func encodeExchangeAuthCodeResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var exchangeAuthCodeResponse = &pb.ExchangeAuthorizationCodeResponse{}
+	switch r := response.(type) {
+	case endpoints.ExchangeAuthCodeResponse:
+		status.Code = 0
+		status.Message = "ok"
+		exchangeAuthCodeResponse.UserId = r.UserId
+		exchangeAuthCodeResponse.Scope = r.Scope
+		exchangeAuthCodeResponse.Nonce = r.Nonce
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	exchangeAuthCodeResponse.Status = status
+	return exchangeAuthCodeResponse, nil
+}
+
+func apiKeyToPB(key service.APIKey) *pb.APIKey {
+	return &pb.APIKey{
+		KeyId:      key.Id,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		CreatedAt:  timestamp(key.CreatedAt),
+		ExpiresAt:  timestamp(key.ExpiresAt),
+		LastUsedAt: timestamp(key.LastUsedAt),
+	}
+}
+
+func oauthClientToPB(client service.OAuthClient) *pb.OAuthClient {
+	return &pb.OAuthClient{
+		ClientId:     client.Id,
+		Name:         client.Name,
+		RedirectUris: client.RedirectURIs,
+		Confidential: client.Confidential,
+		CreatedAt:    timestamp(client.CreatedAt),
+	}
+}
+
+func userToPB(user endpoints.GetUserResponse) *pb.User {
+	return &pb.User{
+		UserId:        user.UserId,
+		UserName:      user.Name,
+		UserAge:       user.Age,
+		BirthDate:     user.BirthDate,
+		Profile:       profileToPB(user.Profile),
+		EmailVerified: user.EmailVerified,
+		Version:       user.Version,
+	}
+}
+
+// profileToPB leaves an empty profile unset.
+func profileToPB(profile service.Profile) *pb.Profile {
+	if profile.Email == "" && profile.DisplayName == "" && profile.Locale == "" && profile.Timezone == "" && len(profile.Metadata) == 0 {
+		return nil
+	}
+	return &pb.Profile{
+		Email:       profile.Email,
+		DisplayName: profile.DisplayName,
+		Locale:      profile.Locale,
+		Timezone:    profile.Timezone,
+		Metadata:    profile.Metadata,
+	}
+}
+
+func profileFromPB(profile *pb.Profile) service.Profile {
+	return service.Profile{
+		Email:       profile.GetEmail(),
+		DisplayName: profile.GetDisplayName(),
+		Locale:      profile.GetLocale(),
+		Timezone:    profile.GetTimezone(),
+		Metadata:    profile.GetMetadata(),
+	}
+}
+
+// timestamp leaves unknown times unset rather than sending the zero time.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func resolveStatus(response interface{}) *pb.Status {
+	var status pb.Status
+	switch r := response.(type) {
+	case *erro.ErrInvalidArgument:
+		status.Code = 3
+		status.Message = r.Err.Error()
+	case *erro.ErrNotFound:
+		status.Code = 5
+		status.Message = r.Err.Error()
+	case *erro.ErrAlreadyExists:
+		status.Code = 6
+		status.Message = r.Err.Error()
+	case *erro.ErrPermissionDenied:
+		status.Code = 7
+		status.Message = r.Err.Error()
+	case *erro.ErrFailedPrecondition:
+		status.Code = 9
+		status.Message = r.Err.Error()
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	return &status
+}
+
+func (s *gRPCServer) LinkIdentity(ctx context.Context, req *pb.LinkIdentityRequest) (*pb.LinkIdentityResponse, error) {
+	_, res, err := s.linkIdentity.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var linkIdentityResponse = &pb.LinkIdentityResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound,
+			*erro.ErrAlreadyExists:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		linkIdentityResponse.Status = status
+		return linkIdentityResponse, nil
+	}
+
+	response, ok := res.(*pb.LinkIdentityResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeLinkIdentityRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.LinkIdentityRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.LinkIdentityRequest{
+		UserId:  req.UserId,
+		Issuer:  req.Issuer,
+		Subject: req.Subject,
+	}, nil
+}
+
+func encodeLinkIdentityResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	switch response.(type) {
+	case endpoints.LinkIdentityResponse:
+		status.Code = 0
+		status.Message = "ok"
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	return &pb.LinkIdentityResponse{Status: status}, nil
+}
+
+func (s *gRPCServer) ProvisionIdentity(ctx context.Context, req *pb.ProvisionIdentityRequest) (*pb.ProvisionIdentityResponse, error) {
+	_, res, err := s.provIdentity.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var provIdentityResponse = &pb.ProvisionIdentityResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrAlreadyExists:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		provIdentityResponse.Status = status
+		return provIdentityResponse, nil
+	}
+
+	response, ok := res.(*pb.ProvisionIdentityResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeProvisionIdentityRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.ProvisionIdentityRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.ProvisionIdentityRequest{
+		Issuer:        req.Issuer,
+		Subject:       req.Subject,
+		DisplayName:   req.DisplayName,
+		Email:         req.Email,
+		EmailVerified: req.EmailVerified,
+	}, nil
+}
+
+func encodeProvisionIdentityResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var provIdentityResponse = &pb.ProvisionIdentityResponse{}
+	switch r := response.(type) {
+	case endpoints.ProvisionIdentityResponse:
+		status.Code = 0
+		status.Message = "ok"
+		provIdentityResponse.UserId = r.UserId
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	provIdentityResponse.Status = status
+	return provIdentityResponse, nil
+}
+
+func (s *gRPCServer) AuthenticateIdentity(ctx context.Context, req *pb.AuthenticateIdentityRequest) (*pb.AuthenticateIdentityResponse, error) {
+	_, res, err := s.authIdentity.ServeGRPC(ctx, req)
+	if err != nil {
+		var status = &pb.Status{}
+		var authIdentityResponse = &pb.AuthenticateIdentityResponse{}
+
+		switch r := err.(type) {
+		case *erro.ErrInvalidArgument,
+			*erro.ErrNotFound,
+			*erro.ErrPermissionDenied:
+			status = resolveStatus(r)
+		default:
+			status.Code = 2
+			status.Message = fmt.Sprintf("unexpected error: %s", err.Error())
+		}
+
+		authIdentityResponse.Status = status
+		return authIdentityResponse, nil
+	}
+
+	response, ok := res.(*pb.AuthenticateIdentityResponse)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+
+	return response, nil
+}
+
+func decodeAuthIdentityRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req, ok := request.(*pb.AuthenticateIdentityRequest)
+	if !ok {
+		return nil, erro.NewErrInvalidArgument(erro.ErrInvalidRequestType)
+	}
+	return endpoints.AuthIdentityRequest{
+		Issuer:  req.Issuer,
+		Subject: req.Subject,
+	}, nil
+}
+
+func encodeAuthIdentityResponse(_ context.Context, response interface{}) (interface{}, error) {
+	var status = &pb.Status{}
+	var authIdentityResponse = &pb.AuthenticateIdentityResponse{}
+	switch r := response.(type) {
+	case endpoints.AuthIdentityResponse:
+		status.Code = 0
+		status.Message = "ok"
+		authIdentityResponse.UserId = r.UserId
+		authIdentityResponse.Challenge = r.Challenge
+	default:
+		status.Code = 2
+		status.Message = "unexpected error"
+	}
+
+	authIdentityResponse.Status = status
+	return authIdentityResponse, nil
 }
@@ -1,40 +1,72 @@
 package main
 
 import (
+	"context"
+	"crypto/rsa"
 	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
+	"github.com/javibauza/final-project/grpc-service/tracing"
+	"github.com/javibauza/final-project/rest-service/config"
 	"github.com/javibauza/final-project/rest-service/endpoints"
+	"github.com/javibauza/final-project/rest-service/logging"
+	"github.com/javibauza/final-project/rest-service/oidc"
+	"github.com/javibauza/final-project/rest-service/principal"
 	"github.com/javibauza/final-project/rest-service/repository"
+	"github.com/javibauza/final-project/rest-service/requestid"
 	"github.com/javibauza/final-project/rest-service/service"
+	"github.com/javibauza/final-project/rest-service/tlsutil"
 	"github.com/javibauza/final-project/rest-service/transport"
 )
 
 func main() {
-	var grpcUserServiceAddr *string
-
-	env := os.Getenv("ENV")
-	if env == "cluster" {
-		grpcUserServiceAddr = flag.String("addr", "user-grpc-service-service.default.svc.cluster.local:50051", "The gprcUserServer address in the format of host:port")
-	} else {
-		grpcUserServiceAddr = flag.String("addr", "localhost:50051", "The gprcUserServer address in the format of host:port")
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		if err := config.Print(os.Stdout, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+		return
 	}
 
-	var (
-		httpAddr = flag.String("http", ":8080", "http listen address")
-	)
 	var logger log.Logger
 	{
-		logger = log.NewLogfmtLogger(os.Stderr)
-		logger = log.NewSyncLogger(logger)
+		var closer io.Closer
+		var err error
+
+		logger, closer, err = logging.New(logging.Config{
+			Format: cfg.Log.Format,
+			Level:  cfg.Log.Level,
+			Output: cfg.Log.Output,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(-1)
+		}
+		defer closer.Close()
+
 		logger = log.With(logger,
 			"service", "httpService",
 			"time:", log.DefaultTimestampUTC,
@@ -45,24 +77,88 @@ func main() {
 	level.Info(logger).Log("msg", "http service started")
 	defer level.Info(logger).Log("msg", "http service ended")
 
-	flag.Parse()
+	{
+		tp, err := tracing.NewProvider(context.Background(), tracing.Config{
+			ServiceName:  "httpService",
+			Exporter:     cfg.Trace.Exporter,
+			OTLPEndpoint: cfg.Trace.OTLPEndpoint,
+		})
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+		defer tp.Shutdown(context.Background())
+	}
 
-	var err error
 	var grpcUserServiceConn *grpc.ClientConn
 	{
 		var opts []grpc.DialOption
-		opts = append(opts, grpc.WithInsecure())
-		grpcUserServiceConn, err = grpc.Dial(*grpcUserServiceAddr, opts...)
+		if cfg.UserServiceTLS.Enabled {
+			tlsCfg := cfg.UserServiceTLS
+			reloader, err := tlsutil.NewReloader(tlsCfg.CertFile, tlsCfg.KeyFile, tlsCfg.CAFile)
+			if err != nil {
+				level.Error(logger).Log("exit", err)
+				os.Exit(-1)
+			}
+			go reloader.Watch(context.Background(), cfg.TLSReloadInterval, func(err error) {
+				level.Error(logger).Log("msg", "certificate reload failed", "err", err)
+			})
+			opts = append(opts, grpc.WithTransportCredentials(
+				credentials.NewTLS(tlsutil.ClientConfig(reloader, tlsCfg.ServerName)),
+			))
+		} else {
+			opts = append(opts, grpc.WithInsecure())
+		}
+		interceptors := []grpc.UnaryClientInterceptor{
+			tracing.UnaryClientInterceptor(),
+			requestid.UnaryClientInterceptor(),
+			principal.UnaryClientInterceptor(),
+		}
+		switch authCfg := cfg.UserServiceAuth; {
+		case authCfg.APIKey != "":
+			interceptors = append(interceptors, repository.APIKeyInterceptor(authCfg.APIKey))
+		case authCfg.TokenSecret != "":
+			interceptors = append(interceptors, repository.ServiceTokenInterceptor(authCfg.ClientName, authCfg.TokenSecret, authCfg.TokenTTL))
+		}
+		opts = append(opts, grpc.WithChainUnaryInterceptor(interceptors...))
+		grpcUserServiceConn, err = grpc.Dial(cfg.UserServiceAddr, opts...)
 		if err != nil {
 			level.Error(logger).Log("exit", err)
 			os.Exit(-1)
 		}
 	}
 
+	var signingKey *rsa.PrivateKey
+	if cfg.OIDC.SigningKeyFile != "" {
+		signingKey, err = oidc.LoadKey(cfg.OIDC.SigningKeyFile)
+	} else {
+		level.Warn(logger).Log("msg", "no OIDC signing key configured, tokens will not survive a restart")
+		signingKey, err = oidc.GenerateKey()
+	}
+	if err != nil {
+		level.Error(logger).Log("exit", err)
+		os.Exit(-1)
+	}
+
 	var srv service.Service
+	var oidcSrv service.OIDCService
+	var federationSrv service.FederationService
 	{
-		repository := repository.NewUserRepo(grpcUserServiceConn, logger)
-		srv = service.NewService(repository, logger)
+		repo := repository.NewUserRepo(grpcUserServiceConn, logger)
+		repo = repository.TracingMiddleware()(repo)
+		srv = service.NewService(repo, logger)
+		srv = service.TracingMiddleware()(srv)
+		oidcSrv = service.NewOIDCService(repo, oidc.NewSigner(signingKey), service.OIDCConfig{
+			Issuer:   cfg.OIDC.Issuer,
+			TokenTTL: cfg.OIDC.TokenTTL,
+		}, logger)
+		oidcSrv = service.OIDCTracingMiddleware()(oidcSrv)
+		keys := oidc.NewKeySet(cfg.Federation.JWKSURL, &http.Client{Timeout: 10 * time.Second}, cfg.Federation.JWKSRefreshInterval)
+		federationSrv = service.NewFederationService(repo, keys, service.FederationConfig{
+			Issuer:   cfg.Federation.Issuer,
+			ClientId: cfg.Federation.ClientId,
+		}, logger)
+		federationSrv = service.FederationTracingMiddleware()(federationSrv)
 	}
 
 	errChan := make(chan error)
@@ -72,10 +168,38 @@ func main() {
 		errChan <- fmt.Errorf("%s", <-c)
 	}()
 
-	endpoints := endpoints.MakeEndpoints(srv)
+	gateway, err := transport.NewGateway(context.Background(), grpcUserServiceConn, logger)
+	if err != nil {
+		level.Error(logger).Log("exit", err)
+		os.Exit(-1)
+	}
+
+	endpoints := endpoints.MakeEndpoints(srv, oidcSrv, federationSrv)
+	httpServer := &http.Server{
+		Addr: cfg.HTTPAddr,
+		Handler: transport.NewHTTPServer(endpoints, gateway, transport.Config{
+			MaxBodyBytes:     cfg.API.MaxBodyBytes,
+			LegacyFieldNames: cfg.API.LegacyFieldNames,
+		}, logger),
+	}
+	if cfg.HTTPTLS.Enabled {
+		reloader, err := tlsutil.NewReloader(cfg.HTTPTLS.CertFile, cfg.HTTPTLS.KeyFile, "")
+		if err != nil {
+			level.Error(logger).Log("exit", err)
+			os.Exit(-1)
+		}
+		go reloader.Watch(context.Background(), cfg.TLSReloadInterval, func(err error) {
+			level.Error(logger).Log("msg", "certificate reload failed", "err", err)
+		})
+		httpServer.TLSConfig = tlsutil.ServerConfig(reloader)
+	}
+
 	go func() {
-		httpHandler := transport.NewHTTPServer(endpoints, logger)
-		errChan <- http.ListenAndServe(*httpAddr, httpHandler)
+		if httpServer.TLSConfig != nil {
+			errChan <- httpServer.ListenAndServeTLS("", "")
+			return
+		}
+		errChan <- httpServer.ListenAndServe()
 	}()
 
 	level.Error(logger).Log("exit", <-errChan)
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/javibauza/final-project/grpc-service/tracing"
+	"github.com/javibauza/final-project/rest-service/logging"
+)
+
+const EnvPrefix = "USER_REST_"
+
+type Config struct {
+	ConfigFile  string `yaml:"-" env:"CONFIG" flag:"config" usage:"path to a YAML config file"`
+	PrintConfig bool   `yaml:"-" flag:"print-config" usage:"print the effective configuration and exit"`
+
+	HTTPAddr        string `yaml:"http_addr" env:"HTTP_ADDR" flag:"http" usage:"http listen address"`
+	UserServiceAddr string `yaml:"user_service_addr" env:"USER_SERVICE_ADDR" flag:"addr" usage:"the grpcUserService address in the format of host:port"`
+
+	API   APIConfig   `yaml:"api"`
+	Log   LogConfig   `yaml:"log"`
+	Trace TraceConfig `yaml:"trace"`
+	OIDC  OIDCConfig  `yaml:"oidc"`
+
+	Federation FederationConfig `yaml:"federation"`
+
+	HTTPTLS           HTTPTLSConfig         `yaml:"http_tls"`
+	UserServiceTLS    UserServiceTLSConfig  `yaml:"user_service_tls"`
+	UserServiceAuth   UserServiceAuthConfig `yaml:"user_service_auth"`
+	TLSReloadInterval time.Duration         `yaml:"tls_reload_interval" env:"TLS_RELOAD_INTERVAL" flag:"tls-reload-interval" usage:"how often certificate files are checked for changes"`
+}
+
+type APIConfig struct {
+	MaxBodyBytes     int64 `yaml:"max_body_bytes" env:"API_MAX_BODY_BYTES" flag:"max-body-bytes" usage:"largest request body accepted"`
+	LegacyFieldNames bool  `yaml:"legacy_field_names" env:"API_LEGACY_FIELD_NAMES" flag:"legacy-field-names" usage:"also accept the old capitalised JSON field names in request bodies"`
+}
+
+type LogConfig struct {
+	Format string `yaml:"format" env:"LOG_FORMAT" flag:"log-format" usage:"log format: logfmt or json"`
+	Level  string `yaml:"level" env:"LOG_LEVEL" flag:"log-level" usage:"minimum log level: debug, info, warn or error"`
+	Output string `yaml:"output" env:"LOG_OUTPUT" flag:"log-output" usage:"log output: stderr, stdout or a file path"`
+}
+
+type TraceConfig struct {
+	Exporter     string `yaml:"exporter" env:"TRACE_EXPORTER" flag:"trace-exporter" usage:"trace exporter: none, stdout or otlp"`
+	OTLPEndpoint string `yaml:"otlp_endpoint" env:"OTLP_ENDPOINT" flag:"otlp-endpoint" usage:"OTLP collector address in the format of host:port"`
+}
+
+type HTTPTLSConfig struct {
+	Enabled  bool   `yaml:"enabled" env:"HTTP_TLS_ENABLED" flag:"http-tls" usage:"serve HTTPS"`
+	CertFile string `yaml:"cert_file" env:"HTTP_TLS_CERT_FILE" flag:"http-tls-cert" usage:"HTTPS certificate file"`
+	KeyFile  string `yaml:"key_file" env:"HTTP_TLS_KEY_FILE" flag:"http-tls-key" usage:"HTTPS private key file"`
+}
+
+type UserServiceTLSConfig struct {
+	Enabled    bool   `yaml:"enabled" env:"USER_SERVICE_TLS_ENABLED" flag:"addr-tls" usage:"connect to the grpcUserService over TLS"`
+	CAFile     string `yaml:"ca_file" env:"USER_SERVICE_TLS_CA_FILE" flag:"addr-tls-ca" usage:"CA bundle used to verify the grpcUserService certificate"`
+	CertFile   string `yaml:"cert_file" env:"USER_SERVICE_TLS_CERT_FILE" flag:"addr-tls-cert" usage:"client certificate file for mTLS"`
+	KeyFile    string `yaml:"key_file" env:"USER_SERVICE_TLS_KEY_FILE" flag:"addr-tls-key" usage:"client private key file for mTLS"`
+	ServerName string `yaml:"server_name" env:"USER_SERVICE_TLS_SERVER_NAME" flag:"addr-tls-server-name" usage:"expected server name, defaults to the host of addr"`
+}
+
+type UserServiceAuthConfig struct {
+	ClientName  string        `yaml:"client_name" env:"USER_SERVICE_AUTH_CLIENT" flag:"addr-auth-client" usage:"client name presented to the grpcUserService"`
+	APIKey      string        `yaml:"api_key" env:"USER_SERVICE_AUTH_API_KEY" secret:"true" usage:"API key presented to the grpcUserService"`
+	TokenSecret string        `yaml:"token_secret" env:"USER_SERVICE_AUTH_TOKEN_SECRET" secret:"true" usage:"secret used to sign service tokens for the grpcUserService"`
+	TokenTTL    time.Duration `yaml:"token_ttl" env:"USER_SERVICE_AUTH_TOKEN_TTL" flag:"addr-auth-token-ttl" usage:"lifetime of signed service tokens"`
+}
+
+type OIDCConfig struct {
+	Issuer         string        `yaml:"issuer" env:"OIDC_ISSUER" flag:"oidc-issuer" usage:"public base URL of the OpenID Connect provider"`
+	SigningKeyFile string        `yaml:"signing_key_file" env:"OIDC_SIGNING_KEY_FILE" flag:"oidc-signing-key" usage:"PEM RSA key ID and access tokens are signed with, generated at startup when empty"`
+	TokenTTL       time.Duration `yaml:"token_ttl" env:"OIDC_TOKEN_TTL" flag:"oidc-token-ttl" usage:"lifetime of issued ID and access tokens"`
+}
+
+// FederationConfig is the external OpenID Connect provider users may log in
+// with. Federated login is disabled when Issuer is empty.
+type FederationConfig struct {
+	Issuer              string        `yaml:"issuer" env:"FEDERATION_ISSUER" flag:"federation-issuer" usage:"issuer of the external OpenID Connect provider, empty to disable federated login"`
+	ClientId            string        `yaml:"client_id" env:"FEDERATION_CLIENT_ID" flag:"federation-client-id" usage:"client ID the provider issues ID tokens for"`
+	JWKSURL             string        `yaml:"jwks_url" env:"FEDERATION_JWKS_URL" flag:"federation-jwks-url" usage:"URL of the provider's JWK set"`
+	JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval" env:"FEDERATION_JWKS_REFRESH_INTERVAL" flag:"federation-jwks-refresh" usage:"shortest time between fetches of the provider's JWK set"`
+}
+
+func Default() Config {
+	return Config{
+		HTTPAddr:        ":8080",
+		UserServiceAddr: "localhost:50051",
+		API: APIConfig{
+			MaxBodyBytes: 1 << 20,
+		},
+		Log: LogConfig{
+			Format: logging.FormatLogfmt,
+			Level:  "info",
+			Output: "stderr",
+		},
+		Trace: TraceConfig{
+			Exporter:     tracing.ExporterNone,
+			OTLPEndpoint: "localhost:4317",
+		},
+		OIDC: OIDCConfig{
+			Issuer:   "http://localhost:8080",
+			TokenTTL: time.Hour,
+		},
+		Federation: FederationConfig{
+			JWKSRefreshInterval: 5 * time.Minute,
+		},
+		TLSReloadInterval: 30 * time.Second,
+		UserServiceAuth: UserServiceAuthConfig{
+			ClientName: "rest-service",
+			TokenTTL:   time.Minute,
+		},
+	}
+}
+
+func (c Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.HTTPAddr); err != nil {
+		return fmt.Errorf("http_addr: %w", err)
+	}
+	if _, _, err := net.SplitHostPort(c.UserServiceAddr); err != nil {
+		return fmt.Errorf("user_service_addr: %w", err)
+	}
+	if c.API.MaxBodyBytes <= 0 {
+		return fmt.Errorf("api.max_body_bytes must be positive")
+	}
+	if err := c.Log.validate(); err != nil {
+		return err
+	}
+	if err := c.Trace.validate(); err != nil {
+		return err
+	}
+	if err := c.OIDC.validate(); err != nil {
+		return err
+	}
+	if err := c.Federation.validate(); err != nil {
+		return err
+	}
+	if err := c.HTTPTLS.validate(); err != nil {
+		return err
+	}
+	if err := c.UserServiceTLS.validate(); err != nil {
+		return err
+	}
+	if err := c.UserServiceAuth.validate(); err != nil {
+		return err
+	}
+	if (c.HTTPTLS.Enabled || c.UserServiceTLS.Enabled) && c.TLSReloadInterval <= 0 {
+		return fmt.Errorf("tls_reload_interval must be positive")
+	}
+	return nil
+}
+
+func (c LogConfig) validate() error {
+	switch c.Format {
+	case logging.FormatLogfmt, logging.FormatJSON:
+	default:
+		return fmt.Errorf("log.format: unknown format %q", c.Format)
+	}
+	switch c.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("log.level: unknown level %q", c.Level)
+	}
+	if c.Output == "" {
+		return fmt.Errorf("log.output is required")
+	}
+	return nil
+}
+
+func (c TraceConfig) validate() error {
+	switch c.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		if _, _, err := net.SplitHostPort(c.OTLPEndpoint); err != nil {
+			return fmt.Errorf("trace.otlp_endpoint: %w", err)
+		}
+	default:
+		return fmt.Errorf("trace.exporter: unknown exporter %q", c.Exporter)
+	}
+	return nil
+}
+
+func (c OIDCConfig) validate() error {
+	u, err := url.Parse(c.Issuer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("oidc.issuer must be an http or https URL without a query or fragment")
+	}
+	if c.TokenTTL <= 0 {
+		return fmt.Errorf("oidc.token_ttl must be positive")
+	}
+	return nil
+}
+
+func (c FederationConfig) validate() error {
+	if c.Issuer == "" {
+		return nil
+	}
+	if c.ClientId == "" {
+		return fmt.Errorf("federation.client_id is required with federation.issuer")
+	}
+	u, err := url.Parse(c.JWKSURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("federation.jwks_url must be an http or https URL")
+	}
+	if c.JWKSRefreshInterval <= 0 {
+		return fmt.Errorf("federation.jwks_refresh_interval must be positive")
+	}
+	return nil
+}
+
+func (c HTTPTLSConfig) validate() error {
+	if c.Enabled && (c.CertFile == "" || c.KeyFile == "") {
+		return fmt.Errorf("http_tls.cert_file and http_tls.key_file are required when http_tls is enabled")
+	}
+	return nil
+}
+
+func (c UserServiceTLSConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("user_service_tls.cert_file and user_service_tls.key_file must be set together")
+	}
+	return nil
+}
+
+func (c UserServiceAuthConfig) validate() error {
+	if c.APIKey != "" && c.TokenSecret != "" {
+		return fmt.Errorf("user_service_auth.api_key and user_service_auth.token_secret are mutually exclusive")
+	}
+	if c.TokenSecret == "" {
+		return nil
+	}
+	if c.ClientName == "" {
+		return fmt.Errorf("user_service_auth.client_name is required with a token_secret")
+	}
+	if len(c.TokenSecret) < 32 {
+		return fmt.Errorf("user_service_auth.token_secret must be at least 32 bytes")
+	}
+	if c.TokenTTL <= 0 {
+		return fmt.Errorf("user_service_auth.token_ttl must be positive")
+	}
+	return nil
+}
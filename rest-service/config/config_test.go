@@ -0,0 +1,155 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(file, []byte("http_addr: \":6000\"\nuser_service_addr: users:50051\nlog:\n  format: json\n  level: debug\n"), 0o600)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		testName      string
+		args          []string
+		env           map[string]string
+		checkResponse func(t *testing.T, cfg Config, err error)
+	}{
+		{
+			testName: "defaults",
+			checkResponse: func(t *testing.T, cfg Config, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, Default(), cfg)
+			},
+		},
+		{
+			testName: "file overrides defaults",
+			args:     []string{"-config", file},
+			checkResponse: func(t *testing.T, cfg Config, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, ":6000", cfg.HTTPAddr)
+				assert.Equal(t, "users:50051", cfg.UserServiceAddr)
+				assert.Equal(t, "json", cfg.Log.Format)
+				assert.Equal(t, "stderr", cfg.Log.Output)
+			},
+		},
+		{
+			testName: "env overrides file",
+			args:     []string{"-config", file},
+			env:      map[string]string{"USER_REST_USER_SERVICE_ADDR": "env:50051", "USER_REST_LOG_LEVEL": "warn"},
+			checkResponse: func(t *testing.T, cfg Config, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, ":6000", cfg.HTTPAddr)
+				assert.Equal(t, "env:50051", cfg.UserServiceAddr)
+				assert.Equal(t, "warn", cfg.Log.Level)
+			},
+		},
+		{
+			testName: "flags override env",
+			args:     []string{"-addr", "flag:50051", "-print-config"},
+			env:      map[string]string{"USER_REST_CONFIG": file, "USER_REST_USER_SERVICE_ADDR": "env:50051"},
+			checkResponse: func(t *testing.T, cfg Config, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, ":6000", cfg.HTTPAddr)
+				assert.Equal(t, "flag:50051", cfg.UserServiceAddr)
+				assert.True(t, cfg.PrintConfig)
+			},
+		},
+		{
+			testName: "unknown flag",
+			args:     []string{"-nope"},
+			checkResponse: func(t *testing.T, cfg Config, err error) {
+				assert.Error(t, err)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := Load(tc.args)
+			tc.checkResponse(t, cfg, err)
+		})
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("http: \":6000\"\n"), 0o600))
+
+	_, err := Load([]string{"-config", file})
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	assert.NoError(t, cfg.Validate())
+
+	cfg.UserServiceAddr = "users"
+	assert.Error(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.Log.Level = "verbose"
+	assert.Error(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.Trace.Exporter = "otlp"
+	cfg.Trace.OTLPEndpoint = ""
+	assert.Error(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.UserServiceAuth.TokenSecret = "short"
+	assert.Error(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.OIDC.Issuer = "localhost:8080"
+	assert.Error(t, cfg.Validate())
+
+	cfg = Default()
+	cfg.OIDC.TokenTTL = 0
+	assert.Error(t, cfg.Validate())
+
+	federated := func() Config {
+		cfg := Default()
+		cfg.Federation.Issuer = "https://idp.example.com"
+		cfg.Federation.ClientId = "user-api"
+		cfg.Federation.JWKSURL = "https://idp.example.com/jwks"
+		return cfg
+	}
+	assert.NoError(t, federated().Validate())
+
+	cfg = federated()
+	cfg.Federation.ClientId = ""
+	assert.Error(t, cfg.Validate())
+
+	cfg = federated()
+	cfg.Federation.JWKSURL = "/jwks"
+	assert.Error(t, cfg.Validate())
+
+	cfg = federated()
+	cfg.Federation.JWKSRefreshInterval = 0
+	assert.Error(t, cfg.Validate())
+}
+
+func TestPrint(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, Print(&buf, Default()))
+	assert.Contains(t, buf.String(), "http_addr: :8080\n")
+	assert.Contains(t, buf.String(), "user_service_addr: localhost:50051\n")
+	assert.NotContains(t, buf.String(), "print")
+
+	cfg := Default()
+	cfg.UserServiceAuth.APIKey = "super-secret-key"
+	buf.Reset()
+	assert.NoError(t, Print(&buf, cfg))
+	assert.NotContains(t, buf.String(), "super-secret-key")
+}
@@ -2,10 +2,12 @@ package endpoints
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/javibauza/final-project/rest-service/service"
 
+	"github.com/javibauza/final-project/grpc-service/tracing"
 	erro "github.com/javibauza/final-project/rest-service/errors"
 )
 
@@ -14,57 +16,271 @@ type Endpoints struct {
 	CreateUser   endpoint.Endpoint
 	UpdateUser   endpoint.Endpoint
 	GetUser      endpoint.Endpoint
+	GetAuditLog  endpoint.Endpoint
+	DeleteUser   endpoint.Endpoint
+	RestoreUser  endpoint.Endpoint
+
+	SendVerification endpoint.Endpoint
+	VerifyEmail      endpoint.Endpoint
+
+	CompleteAuth  endpoint.Endpoint
+	FederatedAuth endpoint.Endpoint
+	EnrollTOTP    endpoint.Endpoint
+	ConfirmTOTP   endpoint.Endpoint
+
+	CreateAPIKey endpoint.Endpoint
+	ListAPIKeys  endpoint.Endpoint
+	RevokeAPIKey endpoint.Endpoint
+	AuthAPIKey   endpoint.Endpoint
+	AuthSession  endpoint.Endpoint
+
+	Discovery endpoint.Endpoint
+	JWKS      endpoint.Endpoint
+	Authorize endpoint.Endpoint
+	Token     endpoint.Endpoint
+	UserInfo  endpoint.Endpoint
+}
+
+// Profile is the user's structured profile. Every member is optional.
+type Profile struct {
+	Email       string            `json:"email,omitempty"`
+	DisplayName string            `json:"display_name,omitempty"`
+	Locale      string            `json:"locale,omitempty"`
+	Timezone    string            `json:"timezone,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
 }
 
 type AuthRequest struct {
-	Pwd  string
-	Name string
+	Pwd  string `json:"password"`
+	Name string `json:"user_name"`
 }
 
+// AuthResponse has either the UserId of the logged in user and their
+// SessionToken or, for users with two-factor authentication, a Challenge to
+// complete with a code.
 type AuthResponse struct {
-	UserId string
+	UserId       string `json:"user_id,omitempty"`
+	SessionToken string `json:"session_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	Challenge    string `json:"challenge,omitempty"`
 }
 
 type CreateUserRequest struct {
-	Name    string
-	Pwd     string
-	Age     uint32
-	AddInfo string
+	Name      string  `json:"user_name"`
+	Pwd       string  `json:"password"`
+	Age       uint32  `json:"age"`
+	BirthDate string  `json:"birth_date"`
+	Profile   Profile `json:"profile"`
 }
 
 type CreateUserResponse struct {
-	UserId string
+	UserId    string  `json:"user_id"`
+	Name      string  `json:"user_name"`
+	Age       uint32  `json:"age"`
+	BirthDate string  `json:"birth_date,omitempty"`
+	Profile   Profile `json:"profile"`
+	Version   int64   `json:"-"`
 }
 
 type UpdateUserRequest struct {
-	UserId  string
-	Name    string
-	Pwd     string
-	Age     uint32
-	AddInfo string
+	UserId          string   `json:"-"`
+	Name            string   `json:"user_name"`
+	Pwd             string   `json:"password"`
+	Age             uint32   `json:"age"`
+	BirthDate       string   `json:"birth_date"`
+	Profile         Profile  `json:"profile"`
+	ExpectedVersion int64    `json:"-"`
+	Fields          []string `json:"-"`
+}
+
+type UpdateUserResponse struct {
+	UserId        string  `json:"user_id"`
+	Name          string  `json:"user_name"`
+	Age           uint32  `json:"age"`
+	BirthDate     string  `json:"birth_date,omitempty"`
+	Profile       Profile `json:"profile"`
+	EmailVerified bool    `json:"email_verified"`
+	Version       int64   `json:"-"`
 }
 
 type GetUserRequest struct {
-	UserId string
+	UserId string `json:"-"`
 }
 
 type GetUserResponse struct {
-	UserId  string
-	Name    string
-	Age     uint32
-	AddInfo string
+	UserId        string  `json:"user_id"`
+	Name          string  `json:"user_name"`
+	Age           uint32  `json:"age"`
+	BirthDate     string  `json:"birth_date,omitempty"`
+	Profile       Profile `json:"profile"`
+	EmailVerified bool    `json:"email_verified"`
+	Version       int64   `json:"-"`
+	// RFC 3339 timestamps, omitted when unknown.
+	CreatedAt   string `json:"created_at,omitempty"`
+	UpdatedAt   string `json:"updated_at,omitempty"`
+	LastLoginAt string `json:"last_login_at,omitempty"`
+}
+
+type GetAuditLogRequest struct {
+	UserId    string `json:"-"`
+	PageSize  int32  `json:"-"`
+	PageToken string `json:"-"`
+}
+
+type AuditEntry struct {
+	Id        int64             `json:"id"`
+	Actor     string            `json:"actor"`
+	Action    string            `json:"action"`
+	Target    string            `json:"target"`
+	Changes   map[string]string `json:"changes"`
+	RequestId string            `json:"request_id,omitempty"`
+	Service   string            `json:"service,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type GetAuditLogResponse struct {
+	Entries       []AuditEntry `json:"entries"`
+	NextPageToken string       `json:"next_page_token,omitempty"`
+}
+
+type DeleteUserRequest struct {
+	UserId string `json:"-"`
+}
+
+type DeleteUserResponse struct{}
+
+type RestoreUserRequest struct {
+	UserId string `json:"-"`
+}
+
+type SendVerificationRequest struct {
+	UserId string `json:"-"`
+}
+
+type SendVerificationResponse struct{}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type CompleteAuthChallengeRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// FederatedAuthRequest has an ID token issued by the configured external
+// provider.
+type FederatedAuthRequest struct {
+	IDToken string `json:"id_token"`
+}
+
+type EnrollTOTPRequest struct {
+	UserId string `json:"-"`
+}
+
+type EnrollTOTPResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type ConfirmTOTPRequest struct {
+	UserId string `json:"-"`
+	Code   string `json:"code"`
+}
+
+type ConfirmTOTPResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type APIKey struct {
+	KeyId      string   `json:"key_id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at,omitempty"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
 }
 
-func MakeEndpoints(s service.Service) Endpoints {
+type CreateAPIKeyRequest struct {
+	UserId string   `json:"-"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is zero for keys that do not expire.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse is the only response that carries the key itself.
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type ListAPIKeysRequest struct {
+	UserId string
+}
+
+type ListAPIKeysResponse struct {
+	APIKeys []APIKey `json:"api_keys"`
+}
+
+type RevokeAPIKeyRequest struct {
+	UserId string
+	KeyId  string
+}
+
+type RevokeAPIKeyResponse struct{}
+
+type AuthAPIKeyRequest struct {
+	Key string
+}
+
+type AuthSessionRequest struct {
+	Token string
+}
+
+type AuthSessionResponse struct {
+	UserId string
+}
+
+type AuthAPIKeyResponse struct {
+	KeyId  string
+	UserId string
+	Scopes []string
+}
+
+func MakeEndpoints(s service.Service, o service.OIDCService, f service.FederationService) Endpoints {
 	return Endpoints{
-		Authenticate: makeAuthEndpoint(s),
-		CreateUser:   makeCreateUserEndpoint(s),
-		UpdateUser:   makeUpdateUserEndpoint(s),
-		GetUser:      makeGetUserEndpoint(s),
+		Authenticate: tracing.EndpointMiddleware("Authenticate")(makeAuthEndpoint(s, o)),
+		CreateUser:   tracing.EndpointMiddleware("CreateUser")(makeCreateUserEndpoint(s)),
+		UpdateUser:   tracing.EndpointMiddleware("UpdateUser")(makeUpdateUserEndpoint(s)),
+		GetUser:      tracing.EndpointMiddleware("GetUser")(makeGetUserEndpoint(s)),
+		GetAuditLog:  tracing.EndpointMiddleware("GetAuditLog")(makeGetAuditLogEndpoint(s)),
+		DeleteUser:   tracing.EndpointMiddleware("DeleteUser")(makeDeleteUserEndpoint(s)),
+		RestoreUser:  tracing.EndpointMiddleware("RestoreUser")(makeRestoreUserEndpoint(s)),
+
+		SendVerification: tracing.EndpointMiddleware("SendVerification")(makeSendVerificationEndpoint(s)),
+		VerifyEmail:      tracing.EndpointMiddleware("VerifyEmail")(makeVerifyEmailEndpoint(s)),
+
+		CompleteAuth:  tracing.EndpointMiddleware("CompleteAuth")(makeCompleteAuthEndpoint(s, o)),
+		FederatedAuth: tracing.EndpointMiddleware("FederatedAuth")(makeFederatedAuthEndpoint(f, o)),
+		EnrollTOTP:    tracing.EndpointMiddleware("EnrollTOTP")(makeEnrollTOTPEndpoint(s)),
+		ConfirmTOTP:   tracing.EndpointMiddleware("ConfirmTOTP")(makeConfirmTOTPEndpoint(s)),
+
+		CreateAPIKey: tracing.EndpointMiddleware("CreateAPIKey")(makeCreateAPIKeyEndpoint(s)),
+		ListAPIKeys:  tracing.EndpointMiddleware("ListAPIKeys")(makeListAPIKeysEndpoint(s)),
+		RevokeAPIKey: tracing.EndpointMiddleware("RevokeAPIKey")(makeRevokeAPIKeyEndpoint(s)),
+		AuthAPIKey:   tracing.EndpointMiddleware("AuthenticateAPIKey")(makeAuthAPIKeyEndpoint(s)),
+		AuthSession:  tracing.EndpointMiddleware("AuthenticateSession")(makeAuthSessionEndpoint(o)),
+
+		Discovery: tracing.EndpointMiddleware("Discovery")(makeDiscoveryEndpoint(o)),
+		JWKS:      tracing.EndpointMiddleware("JWKS")(makeJWKSEndpoint(o)),
+		Authorize: tracing.EndpointMiddleware("Authorize")(makeAuthorizeEndpoint(o)),
+		Token:     tracing.EndpointMiddleware("Token")(makeTokenEndpoint(o)),
+		UserInfo:  tracing.EndpointMiddleware("UserInfo")(makeUserInfoEndpoint(o)),
 	}
 }
 
-func makeAuthEndpoint(s service.Service) endpoint.Endpoint {
+func makeAuthEndpoint(s service.Service, o service.OIDCService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req, ok := request.(AuthRequest)
 		if !ok {
@@ -76,10 +292,25 @@ func makeAuthEndpoint(s service.Service) endpoint.Endpoint {
 			return AuthResponse{}, err
 		}
 
-		return AuthResponse{
-			UserId: res.UserId,
-		}, nil
+		return withSession(ctx, o, AuthResponse{
+			UserId:    res.UserId,
+			Challenge: res.Challenge,
+		})
+	}
+}
+
+// withSession starts a session for the user res logged in, unless they still
+// have to complete a two-factor challenge.
+func withSession(ctx context.Context, o service.OIDCService, res AuthResponse) (AuthResponse, error) {
+	if res.UserId == "" {
+		return res, nil
+	}
+	session, err := o.CreateSession(ctx, res.UserId)
+	if err != nil {
+		return AuthResponse{}, err
 	}
+	res.SessionToken, res.ExpiresIn = session.Token, session.ExpiresIn
+	return res, nil
 }
 
 func makeCreateUserEndpoint(s service.Service) endpoint.Endpoint {
@@ -90,17 +321,23 @@ func makeCreateUserEndpoint(s service.Service) endpoint.Endpoint {
 		}
 
 		res, err := s.CreateUser(ctx, service.CreateUserRequest{
-			Name:    req.Name,
-			Pwd:     req.Pwd,
-			Age:     req.Age,
-			AddInfo: req.AddInfo,
+			Name:      req.Name,
+			Pwd:       req.Pwd,
+			Age:       req.Age,
+			BirthDate: req.BirthDate,
+			Profile:   req.Profile.toService(),
 		})
 		if err != nil {
 			return nil, err
 		}
 
 		return CreateUserResponse{
-			UserId: res.UserId,
+			UserId:    res.UserId,
+			Name:      res.Name,
+			Age:       res.Age,
+			BirthDate: res.BirthDate,
+			Profile:   profileFrom(res.Profile),
+			Version:   res.Version,
 		}, nil
 	}
 }
@@ -112,18 +349,29 @@ func makeUpdateUserEndpoint(s service.Service) endpoint.Endpoint {
 			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
 		}
 
-		err := s.UpdateUser(ctx, service.UpdateUserRequest{
-			UserId:  req.UserId,
-			Name:    req.Name,
-			Pwd:     req.Pwd,
-			Age:     req.Age,
-			AddInfo: req.AddInfo,
+		res, err := s.UpdateUser(ctx, service.UpdateUserRequest{
+			UserId:          req.UserId,
+			Name:            req.Name,
+			Pwd:             req.Pwd,
+			Age:             req.Age,
+			BirthDate:       req.BirthDate,
+			Profile:         req.Profile.toService(),
+			ExpectedVersion: req.ExpectedVersion,
+			Fields:          req.Fields,
 		})
 		if err != nil {
 			return nil, err
 		}
 
-		return nil, nil
+		return UpdateUserResponse{
+			UserId:        res.UserId,
+			Name:          res.Name,
+			Age:           res.Age,
+			BirthDate:     res.BirthDate,
+			Profile:       profileFrom(res.Profile),
+			EmailVerified: res.EmailVerified,
+			Version:       res.Version,
+		}, nil
 	}
 }
 
@@ -138,11 +386,513 @@ func makeGetUserEndpoint(s service.Service) endpoint.Endpoint {
 			return nil, err
 		}
 
-		return GetUserResponse{
-			UserId:  user.UserId,
-			Name:    user.Name,
-			Age:     user.Age,
-			AddInfo: user.AddInfo,
+		return getUserResponse(user), nil
+	}
+}
+
+func makeGetAuditLogEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(GetAuditLogRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+		res, err := s.GetAuditLog(ctx, service.GetAuditLogRequest{
+			UserId:    req.UserId,
+			PageSize:  req.PageSize,
+			PageToken: req.PageToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		entries := make([]AuditEntry, 0, len(res.Entries))
+		for _, entry := range res.Entries {
+			entries = append(entries, AuditEntry{
+				Id:        entry.Id,
+				Actor:     entry.Actor,
+				Action:    entry.Action,
+				Target:    entry.Target,
+				Changes:   entry.Changes,
+				RequestId: entry.RequestId,
+				Service:   entry.Service,
+				CreatedAt: entry.CreatedAt.UTC(),
+			})
+		}
+
+		return GetAuditLogResponse{
+			Entries:       entries,
+			NextPageToken: res.NextPageToken,
 		}, nil
 	}
 }
+
+func makeDeleteUserEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(DeleteUserRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+		if err := s.DeleteUser(ctx, req.UserId); err != nil {
+			return nil, err
+		}
+
+		return DeleteUserResponse{}, nil
+	}
+}
+
+func makeRestoreUserEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(RestoreUserRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+		user, err := s.RestoreUser(ctx, req.UserId)
+		if err != nil {
+			return nil, err
+		}
+
+		return getUserResponse(user), nil
+	}
+}
+
+func makeSendVerificationEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(SendVerificationRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+		if err := s.SendVerification(ctx, req.UserId); err != nil {
+			return nil, err
+		}
+
+		return SendVerificationResponse{}, nil
+	}
+}
+
+func makeVerifyEmailEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(VerifyEmailRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+		user, err := s.VerifyEmail(ctx, req.Token)
+		if err != nil {
+			return nil, err
+		}
+
+		return getUserResponse(user), nil
+	}
+}
+
+func makeCompleteAuthEndpoint(s service.Service, o service.OIDCService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(CompleteAuthChallengeRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+
+		res, err := s.CompleteAuthChallenge(ctx, service.CompleteAuthChallengeRequest{Challenge: req.Challenge, Code: req.Code})
+		if err != nil {
+			return AuthResponse{}, err
+		}
+
+		return withSession(ctx, o, AuthResponse{UserId: res.UserId})
+	}
+}
+
+func makeFederatedAuthEndpoint(f service.FederationService, o service.OIDCService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(FederatedAuthRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+
+		res, err := f.Login(ctx, req.IDToken)
+		if err != nil {
+			return AuthResponse{}, err
+		}
+
+		return withSession(ctx, o, AuthResponse{
+			UserId:    res.UserId,
+			Challenge: res.Challenge,
+		})
+	}
+}
+
+func makeEnrollTOTPEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(EnrollTOTPRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+
+		res, err := s.EnrollTOTP(ctx, req.UserId)
+		if err != nil {
+			return nil, err
+		}
+
+		return EnrollTOTPResponse{Secret: res.Secret, URI: res.URI}, nil
+	}
+}
+
+func makeConfirmTOTPEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(ConfirmTOTPRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+
+		res, err := s.ConfirmTOTP(ctx, service.ConfirmTOTPRequest{UserId: req.UserId, Code: req.Code})
+		if err != nil {
+			return nil, err
+		}
+
+		return ConfirmTOTPResponse{RecoveryCodes: res.RecoveryCodes}, nil
+	}
+}
+
+func makeCreateAPIKeyEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(CreateAPIKeyRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+
+		res, err := s.CreateAPIKey(ctx, service.CreateAPIKeyRequest{
+			UserId:    req.UserId,
+			Name:      req.Name,
+			Scopes:    req.Scopes,
+			ExpiresAt: req.ExpiresAt,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return CreateAPIKeyResponse{APIKey: apiKeyFrom(res.APIKey), Key: res.Key}, nil
+	}
+}
+
+func makeListAPIKeysEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(ListAPIKeysRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+
+		keys, err := s.ListAPIKeys(ctx, req.UserId)
+		if err != nil {
+			return nil, err
+		}
+
+		res := ListAPIKeysResponse{APIKeys: []APIKey{}}
+		for _, key := range keys {
+			res.APIKeys = append(res.APIKeys, apiKeyFrom(key))
+		}
+		return res, nil
+	}
+}
+
+func makeRevokeAPIKeyEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(RevokeAPIKeyRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+
+		if err := s.RevokeAPIKey(ctx, req.UserId, req.KeyId); err != nil {
+			return nil, err
+		}
+
+		return RevokeAPIKeyResponse{}, nil
+	}
+}
+
+func makeAuthAPIKeyEndpoint(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(AuthAPIKeyRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+
+		owner, err := s.AuthenticateAPIKey(ctx, req.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		return AuthAPIKeyResponse{KeyId: owner.KeyId, UserId: owner.UserId, Scopes: owner.Scopes}, nil
+	}
+}
+
+func makeAuthSessionEndpoint(o service.OIDCService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(AuthSessionRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+
+		userId, err := o.AuthenticateSession(ctx, req.Token)
+		if err != nil {
+			return nil, err
+		}
+
+		return AuthSessionResponse{UserId: userId}, nil
+	}
+}
+
+func apiKeyFrom(key service.APIKey) APIKey {
+	return APIKey{
+		KeyId:      key.Id,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		CreatedAt:  formatTime(key.CreatedAt),
+		ExpiresAt:  formatTime(key.ExpiresAt),
+		LastUsedAt: formatTime(key.LastUsedAt),
+	}
+}
+
+func getUserResponse(user service.GetUserResponse) GetUserResponse {
+	return GetUserResponse{
+		UserId:        user.UserId,
+		Name:          user.Name,
+		Age:           user.Age,
+		BirthDate:     user.BirthDate,
+		Profile:       profileFrom(user.Profile),
+		EmailVerified: user.EmailVerified,
+		Version:       user.Version,
+		CreatedAt:     formatTime(user.CreatedAt),
+		UpdatedAt:     formatTime(user.UpdatedAt),
+		LastLoginAt:   formatTime(user.LastLoginAt),
+	}
+}
+
+func (p Profile) toService() service.Profile {
+	return service.Profile{
+		Email:       p.Email,
+		DisplayName: p.DisplayName,
+		Locale:      p.Locale,
+		Timezone:    p.Timezone,
+		Metadata:    p.Metadata,
+	}
+}
+
+func profileFrom(p service.Profile) Profile {
+	return Profile{
+		Email:       p.Email,
+		DisplayName: p.DisplayName,
+		Locale:      p.Locale,
+		Timezone:    p.Timezone,
+		Metadata:    p.Metadata,
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+type DiscoveryRequest struct{}
+
+// DiscoveryResponse is the OpenID Connect provider metadata.
+type DiscoveryResponse struct {
+	Issuer                   string   `json:"issuer"`
+	AuthorizationEndpoint    string   `json:"authorization_endpoint"`
+	TokenEndpoint            string   `json:"token_endpoint"`
+	UserInfoEndpoint         string   `json:"userinfo_endpoint"`
+	JWKSURI                  string   `json:"jwks_uri"`
+	ScopesSupported          []string `json:"scopes_supported"`
+	ResponseTypes            []string `json:"response_types_supported"`
+	GrantTypes               []string `json:"grant_types_supported"`
+	SubjectTypes             []string `json:"subject_types_supported"`
+	SigningAlgs              []string `json:"id_token_signing_alg_values_supported"`
+	CodeChallengeMethods     []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+	ClaimsSupported          []string `json:"claims_supported"`
+}
+
+type JWKSRequest struct{}
+
+// AuthorizeRequest is decoded from the query of the authorization request or
+// from the login form, which resends its parameters.
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientId            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Login               bool
+	Name                string
+	Pwd                 string
+	Code                string
+}
+
+// AuthorizeResponse either redirects to RedirectURI or shows the login form
+// for ClientName, carrying the parameters of Request.
+type AuthorizeResponse struct {
+	RedirectURI string
+	UserId      string
+	ClientName  string
+	LoginError  string
+	Request     AuthorizeRequest
+}
+
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	ClientId     string
+	ClientSecret string
+	CodeVerifier string
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+type UserInfoRequest struct {
+	AccessToken string
+}
+
+type UserInfoResponse struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Birthdate         string `json:"birthdate,omitempty"`
+	Locale            string `json:"locale,omitempty"`
+	Zoneinfo          string `json:"zoneinfo,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+func makeDiscoveryEndpoint(s service.OIDCService) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		d, err := s.Discovery(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return DiscoveryResponse{
+			Issuer:                   d.Issuer,
+			AuthorizationEndpoint:    d.AuthorizationEndpoint,
+			TokenEndpoint:            d.TokenEndpoint,
+			UserInfoEndpoint:         d.UserInfoEndpoint,
+			JWKSURI:                  d.JWKSURI,
+			ScopesSupported:          d.ScopesSupported,
+			ResponseTypes:            d.ResponseTypes,
+			GrantTypes:               d.GrantTypes,
+			SubjectTypes:             []string{"public"},
+			SigningAlgs:              d.SigningAlgs,
+			CodeChallengeMethods:     d.CodeChallengeMethods,
+			TokenEndpointAuthMethods: d.TokenEndpointAuthMethods,
+			ClaimsSupported:          d.ClaimsSupported,
+		}, nil
+	}
+}
+
+func makeJWKSEndpoint(s service.OIDCService) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return s.JWKS(ctx)
+	}
+}
+
+func makeAuthorizeEndpoint(s service.OIDCService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(AuthorizeRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+
+		res, err := s.Authorize(ctx, service.AuthorizeRequest{
+			ResponseType:        req.ResponseType,
+			ClientId:            req.ClientId,
+			RedirectURI:         req.RedirectURI,
+			Scope:               req.Scope,
+			State:               req.State,
+			Nonce:               req.Nonce,
+			CodeChallenge:       req.CodeChallenge,
+			CodeChallengeMethod: req.CodeChallengeMethod,
+			Login:               req.Login,
+			Name:                req.Name,
+			Pwd:                 req.Pwd,
+			Code:                req.Code,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// The login form is shown again without the credentials.
+		req.Pwd, req.Code = "", ""
+		return AuthorizeResponse{
+			RedirectURI: res.RedirectURI,
+			UserId:      res.UserId,
+			ClientName:  res.ClientName,
+			LoginError:  res.LoginError,
+			Request:     req,
+		}, nil
+	}
+}
+
+func makeTokenEndpoint(s service.OIDCService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(TokenRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+
+		res, err := s.Token(ctx, service.TokenRequest{
+			GrantType:    req.GrantType,
+			Code:         req.Code,
+			RedirectURI:  req.RedirectURI,
+			ClientId:     req.ClientId,
+			ClientSecret: req.ClientSecret,
+			CodeVerifier: req.CodeVerifier,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return TokenResponse{
+			AccessToken: res.AccessToken,
+			IDToken:     res.IDToken,
+			TokenType:   res.TokenType,
+			ExpiresIn:   res.ExpiresIn,
+			Scope:       res.Scope,
+		}, nil
+	}
+}
+
+func makeUserInfoEndpoint(s service.OIDCService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(UserInfoRequest)
+		if !ok {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidInputType)
+		}
+
+		info, err := s.UserInfo(ctx, req.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+
+		res := UserInfoResponse{
+			Subject:           info.Subject,
+			Name:              info.Name,
+			PreferredUsername: info.PreferredUsername,
+			Birthdate:         info.Birthdate,
+			Locale:            info.Locale,
+			Zoneinfo:          info.Zoneinfo,
+			Email:             info.Email,
+		}
+		if info.Email != "" {
+			res.EmailVerified = &info.EmailVerified
+		}
+		return res, nil
+	}
+}
@@ -9,6 +9,42 @@ import (
 const ErrNoFieldsForUpdate = "no fields for update"
 const ErrUnexpected = "unexpected error"
 const ErrInvalidInputType = "invalid input type"
+const ErrVersionMismatch = "user version does not match"
+const ErrInvalidIfMatch = "If-Match must be * or a single strong entity tag"
+const ErrInvalidPageSize = "page_size must be an integer"
+const ErrInvalidAPIKey = "invalid, expired or revoked API key"
+const ErrAPIKeyScope = "API key lacks the %s scope"
+const ErrAPIKeyOtherUser = "API key can only act on its own user"
+const ErrAPIKeyManagement = "API keys can't be managed with an API key"
+const ErrAuthenticationRequired = "authentication required: send an API key or a session token"
+const ErrInvalidSession = "invalid or expired session token"
+const ErrSessionOtherUser = "a session can only act on its own user"
+const ErrRedirectURINotRegistered = "redirect_uri is not registered for the client"
+const ErrUnsupportedResponseType = "response_type must be code"
+const ErrOpenIDScope = "scope must include openid"
+const ErrPKCERequired = "a code_challenge with the S256 code_challenge_method is required"
+const ErrUnsupportedGrantType = "grant_type must be authorization_code"
+const ErrLoginFailed = "invalid user name or password"
+const ErrInvalidTOTPCode = "invalid two-factor code"
+const ErrTOTPCodeRequired = "enter the code from your authenticator app"
+const ErrInvalidAccessToken = "invalid or expired access token"
+const ErrInvalidClientAuth = "client credentials must be sent either in the Authorization header or in the body"
+const ErrRepeatedParameter = "parameter %s must not be repeated"
+const ErrInvalidForm = "request body must be a valid form"
+const ErrInvalidIDToken = "invalid or expired ID token"
+const ErrFederationDisabled = "federated login is not configured"
+const ErrIdPUnavailable = "the identity provider can't be reached, try again later"
+
+// OAuth 2.0 error codes, as registered in RFC 6749 and RFC 6750.
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthInvalidToken            = "invalid_token"
+)
 
 type ErrInternal struct {
 	Err error
@@ -19,9 +55,34 @@ type ErrBadRequest struct {
 type ErrNotFound struct {
 	Err error
 }
+type ErrUnauthorized struct {
+	Err error
+}
 type ErrForbidden struct {
 	Err error
 }
+type ErrUnsupportedMediaType struct {
+	Err error
+}
+type ErrPayloadTooLarge struct {
+	Err error
+}
+type ErrPreconditionFailed struct {
+	Err error
+}
+type ErrConflict struct {
+	Err error
+}
+type ErrUnavailable struct {
+	Err error
+}
+
+// ErrOAuth is reported to OAuth clients in the format of RFC 6749 rather
+// than as a problem, with Code one of the OAuth error codes.
+type ErrOAuth struct {
+	Code string
+	Err  error
+}
 
 func (r ErrInternal) Error() string {
 	return fmt.Sprintf("%v", r.Err)
@@ -38,10 +99,41 @@ func (r ErrNotFound) Error() string {
 	return fmt.Sprintf("%v", r.Err)
 }
 
+func (r ErrUnauthorized) Error() string {
+	return fmt.Sprintf("%v", r.Err)
+}
+
 func (r ErrForbidden) Error() string {
 	return fmt.Sprintf("%v", r.Err)
 }
 
+func (r ErrUnsupportedMediaType) Error() string {
+	return fmt.Sprintf("%v", r.Err)
+}
+
+func (r ErrPayloadTooLarge) Error() string {
+	return fmt.Sprintf("%v", r.Err)
+}
+
+func (r ErrPreconditionFailed) Error() string {
+	return fmt.Sprintf("%v", r.Err)
+}
+
+func (r ErrConflict) Error() string {
+	return fmt.Sprintf("%v", r.Err)
+}
+
+func (r ErrUnavailable) Error() string {
+	return fmt.Sprintf("%v", r.Err)
+}
+
+func (r ErrOAuth) Error() string {
+	return fmt.Sprintf("%v", r.Err)
+}
+func NewErrOAuth(code, message string) ErrOAuth {
+	return ErrOAuth{Code: code, Err: errors.New(message)}
+}
+
 var ErrRequiredFields = func(fields ...string) string {
 	if len(fields) > 1 {
 		return strings.Join(fields, ", ") + " are required"
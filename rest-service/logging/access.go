@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gorilla/mux"
+)
+
+type accessKey struct{}
+
+type accessEntry struct {
+	user string
+}
+
+// SetUser records the user a request acted on or authenticated as, for the access log.
+func SetUser(ctx context.Context, user string) {
+	if entry, ok := ctx.Value(accessKey{}).(*accessEntry); ok {
+		entry.user = user
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func AccessLogMiddleware(logger log.Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			begin := time.Now()
+
+			route := r.URL.Path
+			if current := mux.CurrentRoute(r); current != nil {
+				if tpl, err := current.GetPathTemplate(); err == nil {
+					route = tpl
+				}
+			}
+
+			entry := &accessEntry{user: mux.Vars(r)["userId"]}
+			ctx := context.WithValue(r.Context(), accessKey{}, entry)
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			level.Info(WithContext(ctx, logger)).Log(
+				"msg", "access",
+				"method", r.Method,
+				"route", route,
+				"status", rec.status,
+				"latency", time.Since(begin),
+				"user", entry.user,
+			)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+type Config struct {
+	Format string
+	Level  string
+	// Output is "stderr", "stdout" or a file path the logs are appended to.
+	Output string
+}
+
+func New(cfg Config) (log.Logger, io.Closer, error) {
+	w, closer, err := output(cfg.Output)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var logger log.Logger
+	switch cfg.Format {
+	case "", FormatLogfmt:
+		logger = log.NewLogfmtLogger(w)
+	case FormatJSON:
+		logger = log.NewJSONLogger(w)
+	default:
+		closer.Close()
+		return nil, nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	logger = log.NewSyncLogger(logger)
+
+	option, err := levelOption(cfg.Level)
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+	logger = level.NewFilter(logger, option)
+
+	return NewRedactor(logger), closer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func output(name string) (io.Writer, io.Closer, error) {
+	switch name {
+	case "", "stderr":
+		return os.Stderr, nopCloser{}, nil
+	case "stdout":
+		return os.Stdout, nopCloser{}, nil
+	default:
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, f, nil
+	}
+}
+
+func levelOption(name string) (level.Option, error) {
+	switch name {
+	case "debug":
+		return level.AllowDebug(), nil
+	case "", "info":
+		return level.AllowInfo(), nil
+	case "warn":
+		return level.AllowWarn(), nil
+	case "error":
+		return level.AllowError(), nil
+	default:
+		return nil, fmt.Errorf("unknown log level %q", name)
+	}
+}
@@ -0,0 +1,20 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/go-kit/log"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/javibauza/final-project/rest-service/requestid"
+)
+
+func WithContext(ctx context.Context, logger log.Logger) log.Logger {
+	if id := requestid.FromContext(ctx); id != "" {
+		logger = log.With(logger, "request_id", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		logger = log.With(logger, "trace_id", sc.TraceID().String())
+	}
+	return logger
+}
@@ -0,0 +1,112 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// keyBits is the size of the keys GenerateKey generates.
+const keyBits = 2048
+
+// JWK is an RSA public key as a JSON Web Key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is a JSON Web Key set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK returns key as a signing JWK, identified by its RFC 7638
+// thumbprint.
+func NewJWK(key *rsa.PublicKey) JWK {
+	enc := base64.RawURLEncoding
+	jwk := JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: algRS256,
+		N:   enc.EncodeToString(key.N.Bytes()),
+		E:   enc.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+	// The members must be in lexicographic order, without whitespace.
+	thumbprint := sha256.Sum256([]byte(fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)))
+	jwk.Kid = enc.EncodeToString(thumbprint[:])
+	return jwk
+}
+
+// PublicKey returns the RSA key k describes.
+func (k JWK) PublicKey() (*rsa.PublicKey, error) {
+	if k.Kty != "RSA" {
+		return nil, ErrUnsupportedToken
+	}
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil || len(n) == 0 {
+		return nil, ErrUnknownKey
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, ErrUnknownKey
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// key returns the signing key with kid, or the only key of the set when kid
+// is empty.
+func (s JWKS) key(kid string) (*rsa.PublicKey, error) {
+	var candidates []JWK
+	for _, k := range s.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if kid == "" || k.Kid == kid {
+			candidates = append(candidates, k)
+		}
+	}
+	if len(candidates) != 1 {
+		return nil, ErrUnknownKey
+	}
+	return candidates[0].PublicKey()
+}
+
+// GenerateKey returns a new RSA signing key.
+func GenerateKey() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, keyBits)
+}
+
+// LoadKey reads a PEM encoded PKCS #1 or PKCS #8 RSA private key.
+func LoadKey(path string) (*rsa.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM encoded key found", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New(path + ": not an RSA key")
+	}
+	return key, nil
+}
@@ -0,0 +1,190 @@
+// Package oidc signs and verifies the RS256 JSON Web Tokens of OpenID
+// Connect, and publishes the keys they are verified with as a JWK set.
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Token types, set as the typ header so that a token of one type can't be
+// replayed as another.
+const (
+	TypeIDToken      = "JWT"
+	TypeAccessToken  = "at+jwt"
+	TypeSessionToken = "session+jwt"
+)
+
+const algRS256 = "RS256"
+
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrUnsupportedToken = errors.New("unsupported token algorithm or type")
+	ErrUnknownKey       = errors.New("token signed with an unknown key")
+	ErrBadSignature     = errors.New("invalid token signature")
+	ErrWrongIssuer      = errors.New("token issued by another issuer")
+	ErrWrongAudience    = errors.New("token issued for another audience")
+	ErrTokenExpired     = errors.New("token expired")
+	// ErrKeysUnavailable is returned when a provider's keys can't be
+	// fetched, so the token could not be verified either way.
+	ErrKeysUnavailable = errors.New("provider keys are unavailable")
+)
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// Claims are the registered claims of the tokens, plus those of ID tokens
+// and access tokens this service uses.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	Nonce     string   `json:"nonce,omitempty"`
+	// Scope and ClientId are only set on access tokens.
+	Scope    string `json:"scope,omitempty"`
+	ClientId string `json:"client_id,omitempty"`
+}
+
+// Audience is a single audience or a list of them. It is marshaled as a
+// string when there is only one, as most clients expect.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+func (a Audience) Contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the claims were issued by issuer for audience and
+// have not expired at now.
+func (c Claims) Validate(issuer, audience string, now time.Time) error {
+	if c.Issuer != issuer {
+		return ErrWrongIssuer
+	}
+	if !c.Audience.Contains(audience) {
+		return ErrWrongAudience
+	}
+	if now.Unix() >= c.ExpiresAt {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+// Signer signs tokens with an RSA key.
+type Signer struct {
+	key *rsa.PrivateKey
+	jwk JWK
+}
+
+func NewSigner(key *rsa.PrivateKey) *Signer {
+	return &Signer{key: key, jwk: NewJWK(&key.PublicKey)}
+}
+
+// JWKS returns the key set tokens signed by s are verified with.
+func (s *Signer) JWKS() JWKS {
+	return JWKS{Keys: []JWK{s.jwk}}
+}
+
+// Sign returns claims as a token of type typ, in the compact
+// base64url(header).base64url(claims).base64url(signature) form.
+func (s *Signer) Sign(typ string, claims interface{}) (string, error) {
+	h, err := json.Marshal(header{Alg: algRS256, Typ: typ, Kid: s.jwk.Kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(h) + "." + enc.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(signature), nil
+}
+
+// Verify checks that token is an RS256 token of type typ signed by one of
+// keys, and decodes its claims into claims. Tokens without a kid are only
+// accepted when keys holds a single key. The claims themselves are left to
+// the caller to validate.
+func Verify(token, typ string, keys JWKS, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrMalformedToken
+	}
+	enc := base64.RawURLEncoding
+	rawHeader, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return ErrMalformedToken
+	}
+	var h header
+	if err := json.Unmarshal(rawHeader, &h); err != nil {
+		return ErrMalformedToken
+	}
+	// Tokens without a typ are accepted as ID tokens, which many providers
+	// don't type.
+	if h.Alg != algRS256 || (h.Typ != typ && !(h.Typ == "" && typ == TypeIDToken)) {
+		return ErrUnsupportedToken
+	}
+
+	key, err := keys.key(h.Kid)
+	if err != nil {
+		return err
+	}
+	signature, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return ErrMalformedToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return ErrBadSignature
+	}
+
+	payload, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return ErrMalformedToken
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
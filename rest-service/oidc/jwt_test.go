@@ -0,0 +1,202 @@
+package oidc
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigner(t *testing.T) *Signer {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	return NewSigner(key)
+}
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	signer := newTestSigner(t)
+	other := newTestSigner(t)
+
+	claims := Claims{Issuer: "https://id.example.com", Subject: "u1", Audience: Audience{"c1"}, ExpiresAt: now.Add(time.Hour).Unix(), IssuedAt: now.Unix(), Nonce: "n"}
+	idToken, err := signer.Sign(TypeIDToken, claims)
+	require.NoError(t, err)
+	accessToken, err := signer.Sign(TypeAccessToken, claims)
+	require.NoError(t, err)
+	forged, err := other.Sign(TypeIDToken, claims)
+	require.NoError(t, err)
+	parts := strings.Split(idToken, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"u2"}`)) + "." + parts[2]
+
+	testCases := []struct {
+		testName      string
+		token         string
+		typ           string
+		keys          JWKS
+		checkResponse func(t *testing.T, claims Claims, err error)
+	}{
+		{
+			testName: "id token",
+			token:    idToken,
+			typ:      TypeIDToken,
+			keys:     signer.JWKS(),
+			checkResponse: func(t *testing.T, verified Claims, err error) {
+				require.NoError(t, err)
+				assert.Equal(t, claims, verified)
+				assert.NoError(t, verified.Validate("https://id.example.com", "c1", now))
+			},
+		},
+		{
+			testName: "key found by kid",
+			token:    idToken,
+			typ:      TypeIDToken,
+			keys:     JWKS{Keys: append(other.JWKS().Keys, signer.JWKS().Keys...)},
+			checkResponse: func(t *testing.T, verified Claims, err error) {
+				assert.NoError(t, err)
+			},
+		},
+		{
+			testName: "access token as id token",
+			token:    accessToken,
+			typ:      TypeIDToken,
+			keys:     signer.JWKS(),
+			checkResponse: func(t *testing.T, verified Claims, err error) {
+				assert.Equal(t, ErrUnsupportedToken, err)
+			},
+		},
+		{
+			testName: "id token as access token",
+			token:    idToken,
+			typ:      TypeAccessToken,
+			keys:     signer.JWKS(),
+			checkResponse: func(t *testing.T, verified Claims, err error) {
+				assert.Equal(t, ErrUnsupportedToken, err)
+			},
+		},
+		{
+			testName: "unknown key",
+			token:    forged,
+			typ:      TypeIDToken,
+			keys:     signer.JWKS(),
+			checkResponse: func(t *testing.T, verified Claims, err error) {
+				assert.Equal(t, ErrUnknownKey, err)
+			},
+		},
+		{
+			testName: "forged with a known kid",
+			token:    strings.Split(idToken, ".")[0] + "." + strings.SplitN(forged, ".", 2)[1],
+			typ:      TypeIDToken,
+			keys:     signer.JWKS(),
+			checkResponse: func(t *testing.T, verified Claims, err error) {
+				assert.Equal(t, ErrBadSignature, err)
+			},
+		},
+		{
+			testName: "tampered claims",
+			token:    tampered,
+			typ:      TypeIDToken,
+			keys:     signer.JWKS(),
+			checkResponse: func(t *testing.T, verified Claims, err error) {
+				assert.Equal(t, ErrBadSignature, err)
+			},
+		},
+		{
+			testName: "unsigned",
+			token:    base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + ".",
+			typ:      TypeIDToken,
+			keys:     signer.JWKS(),
+			checkResponse: func(t *testing.T, verified Claims, err error) {
+				assert.Equal(t, ErrUnsupportedToken, err)
+			},
+		},
+		{
+			testName: "malformed",
+			token:    "not-a-token",
+			typ:      TypeIDToken,
+			keys:     signer.JWKS(),
+			checkResponse: func(t *testing.T, verified Claims, err error) {
+				assert.Equal(t, ErrMalformedToken, err)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			var verified Claims
+			err := Verify(tc.token, tc.typ, tc.keys, &verified)
+			tc.checkResponse(t, verified, err)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	claims := Claims{Issuer: "https://id.example.com", Audience: Audience{"c1", "c2"}, ExpiresAt: now.Add(time.Minute).Unix()}
+
+	assert.NoError(t, claims.Validate("https://id.example.com", "c2", now))
+	assert.Equal(t, ErrWrongIssuer, claims.Validate("https://other.example.com", "c1", now))
+	assert.Equal(t, ErrWrongAudience, claims.Validate("https://id.example.com", "c3", now))
+	assert.Equal(t, ErrTokenExpired, claims.Validate("https://id.example.com", "c1", now.Add(time.Minute)))
+}
+
+func TestAudienceJSON(t *testing.T) {
+	b, err := json.Marshal(Audience{"c1"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `"c1"`, string(b))
+
+	var aud Audience
+	require.NoError(t, json.Unmarshal([]byte(`["c1","c2"]`), &aud))
+	assert.Equal(t, Audience{"c1", "c2"}, aud)
+	require.NoError(t, json.Unmarshal([]byte(`"c1"`), &aud))
+	assert.Equal(t, Audience{"c1"}, aud)
+}
+
+func TestNewJWK(t *testing.T) {
+	// RFC 7638 section 3.1.
+	n, _ := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	e, _ := base64.RawURLEncoding.DecodeString("AQAB")
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	jwk := NewJWK(key)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", jwk.Kid)
+	assert.Equal(t, "AQAB", jwk.E)
+
+	parsed, err := jwk.PublicKey()
+	require.NoError(t, err)
+	assert.True(t, key.Equal(parsed))
+}
+
+func TestLoadKey(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	for name, block := range map[string]*pem.Block{
+		"pkcs1.pem": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		"pkcs8.pem": {Type: "PRIVATE KEY", Bytes: pkcs8},
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+		loaded, err := LoadKey(path)
+		require.NoError(t, err, name)
+		assert.True(t, key.Equal(loaded), name)
+	}
+
+	path := filepath.Join(dir, "empty.pem")
+	require.NoError(t, os.WriteFile(path, nil, 0600))
+	_, err = LoadKey(path)
+	assert.Error(t, err)
+}
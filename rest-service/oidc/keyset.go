@@ -0,0 +1,93 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxJWKSBytes bounds the JWK set fetched from a provider.
+const maxJWKSBytes = 1 << 20
+
+// KeySet is the JWK set of an external provider, fetched from its JWKS URL.
+// The keys are cached, and fetched again when a token is signed with a key
+// the set doesn't know, as after a key rotation, but at most once every
+// refreshInterval.
+type KeySet struct {
+	url             string
+	client          *http.Client
+	refreshInterval time.Duration
+	now             func() time.Time
+
+	mu      sync.Mutex
+	keys    JWKS
+	fetched time.Time
+}
+
+// NewKeySet returns the key set served at url, fetched with client.
+func NewKeySet(url string, client *http.Client, refreshInterval time.Duration) *KeySet {
+	return &KeySet{url: url, client: client, refreshInterval: refreshInterval, now: time.Now}
+}
+
+// Verify is Verify with the provider's keys. It fails with an error wrapping
+// ErrKeysUnavailable when the keys can't be fetched.
+func (s *KeySet) Verify(ctx context.Context, token, typ string, claims interface{}) error {
+	keys, err := s.cached(ctx, false)
+	if err != nil {
+		return err
+	}
+	err = Verify(token, typ, keys, claims)
+	if !errors.Is(err, ErrUnknownKey) {
+		return err
+	}
+
+	refreshed, err := s.cached(ctx, true)
+	if err != nil {
+		return err
+	}
+	return Verify(token, typ, refreshed, claims)
+}
+
+// cached returns the cached keys, fetching them when there are none or, if
+// refresh is set, when they are older than the refresh interval.
+func (s *KeySet) cached(ctx context.Context, refresh bool) (JWKS, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.fetched.IsZero() && (!refresh || s.now().Sub(s.fetched) < s.refreshInterval) {
+		return s.keys, nil
+	}
+	keys, err := s.fetch(ctx)
+	if err != nil {
+		return JWKS{}, fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+	s.keys, s.fetched = keys, s.now()
+	return keys, nil
+}
+
+func (s *KeySet) fetch(ctx context.Context) (JWKS, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return JWKS{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return JWKS{}, fmt.Errorf("fetching JWKS: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return JWKS{}, fmt.Errorf("fetching JWKS: %s", res.Status)
+	}
+
+	var keys JWKS
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxJWKSBytes)).Decode(&keys); err != nil {
+		return JWKS{}, fmt.Errorf("decoding JWKS: %w", err)
+	}
+	return keys, nil
+}
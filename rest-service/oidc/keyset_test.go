@@ -0,0 +1,68 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySet(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	old := newTestSigner(t)
+	rotated := newTestSigner(t)
+
+	var fetches int32
+	current := old
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(current.JWKS())
+	}))
+	defer srv.Close()
+
+	keys := NewKeySet(srv.URL, srv.Client(), time.Minute)
+	keys.now = func() time.Time { return now }
+	claims := Claims{Issuer: "https://idp.example.com", Subject: "s1", Audience: Audience{"c1"}, ExpiresAt: now.Add(time.Hour).Unix()}
+
+	token, err := old.Sign(TypeIDToken, claims)
+	require.NoError(t, err)
+	var verified Claims
+	require.NoError(t, keys.Verify(ctx, token, TypeIDToken, &verified))
+	assert.Equal(t, claims, verified)
+	require.NoError(t, keys.Verify(ctx, token, TypeIDToken, &verified))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&fetches), "keys are cached")
+
+	current = rotated
+	token, err = rotated.Sign(TypeIDToken, claims)
+	require.NoError(t, err)
+	assert.ErrorIs(t, keys.Verify(ctx, token, TypeIDToken, &verified), ErrUnknownKey, "keys are refetched at most once per interval")
+	assert.EqualValues(t, 1, atomic.LoadInt32(&fetches))
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, keys.Verify(ctx, token, TypeIDToken, &verified), "unknown keys are fetched after a rotation")
+	assert.EqualValues(t, 2, atomic.LoadInt32(&fetches))
+
+	forged, err := newTestSigner(t).Sign(TypeIDToken, claims)
+	require.NoError(t, err)
+	assert.ErrorIs(t, keys.Verify(ctx, forged, TypeIDToken, &verified), ErrUnknownKey)
+}
+
+func TestKeySetUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	token, err := newTestSigner(t).Sign(TypeIDToken, Claims{})
+	require.NoError(t, err)
+	err = NewKeySet(srv.URL, srv.Client(), time.Minute).Verify(context.Background(), token, TypeIDToken, &Claims{})
+	assert.ErrorIs(t, err, ErrKeysUnavailable)
+	assert.EqualError(t, err, "provider keys are unavailable: fetching JWKS: 503 Service Unavailable")
+}
@@ -0,0 +1,28 @@
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+var (
+	//go:embed openapi.json
+	Spec []byte
+
+	//go:embed docs.html
+	docs []byte
+)
+
+func SpecHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(Spec)
+	})
+}
+
+func DocsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(docs)
+	})
+}
@@ -0,0 +1,43 @@
+// Package principal carries the user or API key a request is authenticated
+// as to the grpcUserService, which records it as the actor of the changes
+// the request makes.
+package principal
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/javibauza/final-project/grpc-service/svcauth"
+)
+
+type contextKey struct{}
+
+// User is the principal of a request authenticated as the user.
+func User(userId string) string {
+	return "user:" + userId
+}
+
+// APIKey is the principal of a request authenticated with the API key.
+func APIKey(keyId string) string {
+	return "api_key:" + keyId
+}
+
+func NewContext(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+func FromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(contextKey{}).(string)
+	return principal
+}
+
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if principal := FromContext(ctx); principal != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, svcauth.PrincipalMetadataKey, principal)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
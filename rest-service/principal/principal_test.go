@@ -0,0 +1,55 @@
+package principal
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/javibauza/final-project/grpc-service/pb"
+	"github.com/javibauza/final-project/grpc-service/svcauth"
+)
+
+type userServer struct {
+	pb.UnimplementedUserServiceServer
+	principals []string
+}
+
+func (s *userServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.principals = md.Get(svcauth.PrincipalMetadataKey)
+	return &pb.GetUserResponse{Status: &pb.Status{}}, nil
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	upstream := &userServer{}
+	pb.RegisterUserServiceServer(server, upstream)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor()),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+	)
+	assert.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewUserServiceClient(conn)
+
+	ctx := NewContext(context.Background(), APIKey("abcdefghijklm"))
+	_, err = client.GetUser(ctx, &pb.GetUserRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"api_key:abcdefghijklm"}, upstream.principals)
+
+	_, err = client.GetUser(context.Background(), &pb.GetUserRequest{})
+	assert.NoError(t, err)
+	assert.Empty(t, upstream.principals, "anonymous requests send no principal")
+}
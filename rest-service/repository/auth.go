@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/javibauza/final-project/grpc-service/svcauth"
+)
+
+// APIKeyInterceptor attaches a static API key to every outgoing call to the
+// grpcUserService.
+func APIKeyInterceptor(key string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, svcauth.APIKeyMetadataKey, key)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// ServiceTokenInterceptor signs a short lived service token for client on
+// every outgoing call to the grpcUserService.
+func ServiceTokenInterceptor(client, secret string, ttl time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		token, err := svcauth.NewToken(client, []byte(secret), time.Now(), ttl)
+		if err != nil {
+			return err
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, svcauth.AuthorizationMetadataKey, svcauth.BearerPrefix+token)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
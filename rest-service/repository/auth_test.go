@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/javibauza/final-project/grpc-service/pb"
+	"github.com/javibauza/final-project/grpc-service/svcauth"
+)
+
+type authGRPCService struct {
+	pb.UnimplementedUserServiceServer
+}
+
+func (authGRPCService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	return &pb.GetUserResponse{UserId: req.UserId, UserName: svcauth.ClientFromContext(ctx)}, nil
+}
+
+func TestServiceAuthInterceptors(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+
+	authenticator := svcauth.NewAuthenticator(svcauth.Config{
+		APIKeys:      map[string]string{"rest-service": "rest-key"},
+		TokenSecrets: map[string]string{"rest-service": secret},
+		Allowlist:    map[string][]string{"rest-service": {"/pb.UserService/GetUser"}},
+	})
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer(grpc.UnaryInterceptor(authenticator.UnaryServerInterceptor()))
+	pb.RegisterUserServiceServer(s, authGRPCService{})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	testCases := []struct {
+		testName      string
+		interceptor   grpc.UnaryClientInterceptor
+		checkResponse func(t *testing.T, res *pb.GetUserResponse, err error)
+	}{
+		{
+			testName:    "api key",
+			interceptor: APIKeyInterceptor("rest-key"),
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "rest-service", res.UserName)
+			},
+		},
+		{
+			testName:    "service token",
+			interceptor: ServiceTokenInterceptor("rest-service", secret, time.Minute),
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "rest-service", res.UserName)
+			},
+		},
+		{
+			testName:    "wrong api key",
+			interceptor: APIKeyInterceptor("other-key"),
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error) {
+				assert.Equal(t, codes.Unauthenticated, status.Code(err))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			conn, err := grpc.DialContext(context.Background(), "bufnet",
+				grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+				grpc.WithInsecure(),
+				grpc.WithUnaryInterceptor(tc.interceptor),
+			)
+			assert.NoError(t, err)
+			defer conn.Close()
+
+			res, err := pb.NewUserServiceClient(conn).GetUser(context.Background(), &pb.GetUserRequest{UserId: "1"})
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
@@ -3,14 +3,18 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/javibauza/final-project/grpc-service/pb"
 
 	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/logging"
 )
 
 type UserRepo struct {
@@ -20,9 +24,27 @@ type UserRepo struct {
 
 type UserRepository interface {
 	Authenticate(ctx context.Context, user User) (User, error)
-	CreateUser(ctx context.Context, user User) (string, error)
-	UpdateUser(ctx context.Context, user User) error
+	CreateUser(ctx context.Context, user User) (User, error)
+	UpdateUser(ctx context.Context, user User, fields []string) (User, error)
 	GetUser(ctx context.Context, userId string) (User, error)
+	GetAuditLog(ctx context.Context, userId string, pageSize int32, pageToken string) (AuditLog, error)
+	DeleteUser(ctx context.Context, userId string) error
+	RestoreUser(ctx context.Context, userId string) (User, error)
+	SendVerification(ctx context.Context, userId string) error
+	VerifyEmail(ctx context.Context, token string) (User, error)
+	CompleteAuthChallenge(ctx context.Context, challenge, code string) (User, error)
+	EnrollTOTP(ctx context.Context, userId string) (TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userId, code string) ([]string, error)
+	CreateAPIKey(ctx context.Context, userId string, key APIKey) (APIKey, string, error)
+	ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, userId, keyId string) error
+	AuthenticateAPIKey(ctx context.Context, key string) (APIKeyOwner, error)
+	GetOAuthClient(ctx context.Context, clientId string) (OAuthClient, error)
+	CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) (string, error)
+	ExchangeAuthorizationCode(ctx context.Context, exchange CodeExchange) (AuthorizationCode, error)
+	LinkIdentity(ctx context.Context, userId string, identity Identity) error
+	ProvisionIdentity(ctx context.Context, identity Identity, claims IdentityClaims) (User, error)
+	AuthenticateIdentity(ctx context.Context, identity Identity) (User, error)
 }
 
 type User struct {
@@ -30,7 +52,49 @@ type User struct {
 	Name     string
 	Password string
 	Age      uint32
-	AddInfo  string
+	// BirthDate is a YYYY-MM-DD date, empty when unknown. When set, Age is
+	// computed from it.
+	BirthDate string
+	Profile   Profile
+	Version   int64
+	// EmailVerified tells whether Profile.Email has been verified.
+	EmailVerified bool
+	// Challenge is returned by Authenticate instead of UserId when the user
+	// still has to enter a two-factor code.
+	Challenge string
+	// Zero when unknown.
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+// Profile is the user's structured profile.
+type Profile struct {
+	Email       string
+	DisplayName string
+	Locale      string
+	Timezone    string
+	Metadata    map[string]string
+}
+
+func (p Profile) IsZero() bool {
+	return p.Email == "" && p.DisplayName == "" && p.Locale == "" && p.Timezone == "" && len(p.Metadata) == 0
+}
+
+type AuditEntry struct {
+	Id        int64
+	Actor     string
+	Action    string
+	Target    string
+	Changes   map[string]string
+	RequestId string
+	Service   string
+	CreatedAt time.Time
+}
+
+type AuditLog struct {
+	Entries       []AuditEntry
+	NextPageToken string
 }
 
 func NewUserRepo(conn *grpc.ClientConn, logger log.Logger) UserRepository {
@@ -41,7 +105,7 @@ func NewUserRepo(conn *grpc.ClientConn, logger log.Logger) UserRepository {
 }
 
 func (r *UserRepo) Authenticate(ctx context.Context, user User) (User, error) {
-	logger := log.With(r.logger, "method", "Authenticate")
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "Authenticate")
 
 	request := &pb.AuthRequest{
 		UserName: user.Name,
@@ -57,7 +121,7 @@ func (r *UserRepo) Authenticate(ctx context.Context, user User) (User, error) {
 	}
 
 	if grpcResponse.Status.Code == 0 {
-		return User{UserId: grpcResponse.UserId}, nil
+		return User{UserId: grpcResponse.UserId, Challenge: grpcResponse.Challenge}, nil
 	} else {
 		code := grpcResponse.Status.Code
 		message := grpcResponse.Status.Message
@@ -66,57 +130,66 @@ func (r *UserRepo) Authenticate(ctx context.Context, user User) (User, error) {
 	}
 }
 
-func (r *UserRepo) CreateUser(ctx context.Context, user User) (string, error) {
-	logger := log.With(r.logger, "method", "CreateUser")
+func (r *UserRepo) CreateUser(ctx context.Context, user User) (User, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "CreateUser")
 
 	request := pb.CreateUserRequest{
-		UserName: user.Name,
-		Password: user.Password,
-		UserAge:  user.Age,
-		AddInfo:  user.AddInfo,
+		UserName:  user.Name,
+		Password:  user.Password,
+		UserAge:   user.Age,
+		BirthDate: user.BirthDate,
+		Profile:   profileToPB(user.Profile),
 	}
 
 	client := pb.NewUserServiceClient(r.conn)
 	grpcResponse, err := client.CreateUser(ctx, &request)
 	if err != nil {
 		level.Error(logger).Log("err", err)
-		return "", err
+		return User{}, err
 	}
 
 	if grpcResponse.Status.Code == 0 {
-		return grpcResponse.UserId, nil
+		return userFromPB(grpcResponse.User), nil
 	} else {
-		return "", grpcErrorHandler(grpcResponse.Status.Code, grpcResponse.Status.Message)
+		return User{}, grpcErrorHandler(grpcResponse.Status.Code, grpcResponse.Status.Message)
 	}
 }
 
-func (r *UserRepo) UpdateUser(ctx context.Context, user User) error {
-	logger := log.With(r.logger, "method", "UpdateUser")
+// UpdateUser writes the fields of user named in fields, which are
+// UpdateUserRequest update mask paths. With no fields only the non-empty
+// ones are written.
+func (r *UserRepo) UpdateUser(ctx context.Context, user User, fields []string) (User, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "UpdateUser")
 
 	request := pb.UpdateUserRequest{
-		UserId:   user.UserId,
-		UserName: user.Name,
-		Password: user.Password,
-		UserAge:  user.Age,
-		AddInfo:  user.AddInfo,
+		UserId:          user.UserId,
+		UserName:        user.Name,
+		Password:        user.Password,
+		UserAge:         user.Age,
+		BirthDate:       user.BirthDate,
+		Profile:         profileToPB(user.Profile),
+		ExpectedVersion: user.Version,
+	}
+	if len(fields) > 0 {
+		request.UpdateMask = &fieldmaskpb.FieldMask{Paths: fields}
 	}
 
 	client := pb.NewUserServiceClient(r.conn)
 	grpcResponse, err := client.UpdateUser(ctx, &request)
 	if err != nil {
 		level.Error(logger).Log("err", err)
-		return err
+		return User{}, err
 	}
 
 	if grpcResponse.Status.Code == 0 {
-		return nil
+		return userFromPB(grpcResponse.User), nil
 	} else {
-		return grpcErrorHandler(grpcResponse.Status.Code, grpcResponse.Status.Message)
+		return User{}, grpcErrorHandler(grpcResponse.Status.Code, grpcResponse.Status.Message)
 	}
 }
 
 func (r *UserRepo) GetUser(ctx context.Context, userId string) (User, error) {
-	logger := log.With(r.logger, "method", "UpdateUser")
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "UpdateUser")
 
 	request := pb.GetUserRequest{
 		UserId: userId,
@@ -133,10 +206,18 @@ func (r *UserRepo) GetUser(ctx context.Context, userId string) (User, error) {
 	resMessage := grpcResponse.Status.Message
 	if resCode == 0 {
 		return User{
-			UserId:  grpcResponse.UserId,
-			Name:    grpcResponse.UserName,
-			Age:     grpcResponse.UserAge,
-			AddInfo: grpcResponse.AddInfo,
+			UserId:    grpcResponse.UserId,
+			Name:      grpcResponse.UserName,
+			Age:       grpcResponse.UserAge,
+			BirthDate: grpcResponse.BirthDate,
+			Profile:   profileFromPB(grpcResponse.Profile),
+			Version:   grpcResponse.Version,
+
+			EmailVerified: grpcResponse.EmailVerified,
+
+			CreatedAt:   timeFromPB(grpcResponse.CreatedAt),
+			UpdatedAt:   timeFromPB(grpcResponse.UpdatedAt),
+			LastLoginAt: timeFromPB(grpcResponse.LastLoginAt),
 		}, nil
 	} else {
 		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
@@ -144,6 +225,620 @@ func (r *UserRepo) GetUser(ctx context.Context, userId string) (User, error) {
 	}
 }
 
+func (r *UserRepo) GetAuditLog(ctx context.Context, userId string, pageSize int32, pageToken string) (AuditLog, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "GetAuditLog")
+
+	request := pb.GetAuditLogRequest{
+		UserId:    userId,
+		PageSize:  pageSize,
+		PageToken: pageToken,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.GetAuditLog(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return AuditLog{}, err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
+		return AuditLog{}, grpcErrorHandler(resCode, resMessage)
+	}
+
+	auditLog := AuditLog{NextPageToken: grpcResponse.NextPageToken}
+	for _, entry := range grpcResponse.Entries {
+		auditLog.Entries = append(auditLog.Entries, AuditEntry{
+			Id:        entry.GetId(),
+			Actor:     entry.GetActor(),
+			Action:    entry.GetAction(),
+			Target:    entry.GetTarget(),
+			Changes:   entry.GetChanges(),
+			RequestId: entry.GetRequestId(),
+			Service:   entry.GetService(),
+			CreatedAt: entry.GetCreatedAt().AsTime(),
+		})
+	}
+	return auditLog, nil
+}
+
+func (r *UserRepo) DeleteUser(ctx context.Context, userId string) error {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "DeleteUser")
+
+	request := pb.DeleteUserRequest{
+		UserId: userId,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.DeleteUser(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
+		return grpcErrorHandler(resCode, resMessage)
+	}
+	return nil
+}
+
+func (r *UserRepo) RestoreUser(ctx context.Context, userId string) (User, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "RestoreUser")
+
+	request := pb.RestoreUserRequest{
+		UserId: userId,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.RestoreUser(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return User{}, err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
+		return User{}, grpcErrorHandler(resCode, resMessage)
+	}
+	return userFromPB(grpcResponse.User), nil
+}
+
+// SendVerification asks the grpcUserService to email a verification token
+// to the user's address.
+func (r *UserRepo) SendVerification(ctx context.Context, userId string) error {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "SendVerification")
+
+	request := pb.SendVerificationRequest{
+		UserId: userId,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.SendVerification(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
+		return grpcErrorHandler(resCode, resMessage)
+	}
+	return nil
+}
+
+func (r *UserRepo) VerifyEmail(ctx context.Context, token string) (User, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "VerifyEmail")
+
+	request := pb.VerifyEmailRequest{
+		Token: token,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.VerifyEmail(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return User{}, err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
+		return User{}, grpcErrorHandler(resCode, resMessage)
+	}
+	return userFromPB(grpcResponse.User), nil
+}
+
+// TOTPEnrollment is a new TOTP secret and the otpauth:// URI authenticator
+// apps import it from.
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+func (r *UserRepo) CompleteAuthChallenge(ctx context.Context, challenge, code string) (User, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "CompleteAuthChallenge")
+
+	request := pb.CompleteAuthChallengeRequest{
+		Challenge: challenge,
+		Code:      code,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.CompleteAuthChallenge(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return User{}, err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Info(logger).Log("grpc response code", resCode, "grpc response message", resMessage)
+		return User{}, grpcErrorHandler(resCode, resMessage)
+	}
+	return User{UserId: grpcResponse.UserId}, nil
+}
+
+func (r *UserRepo) EnrollTOTP(ctx context.Context, userId string) (TOTPEnrollment, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "EnrollTOTP")
+
+	request := pb.EnrollTOTPRequest{
+		UserId: userId,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.EnrollTOTP(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return TOTPEnrollment{}, err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
+		return TOTPEnrollment{}, grpcErrorHandler(resCode, resMessage)
+	}
+	return TOTPEnrollment{Secret: grpcResponse.Secret, URI: grpcResponse.Uri}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication and returns the user's
+// recovery codes.
+func (r *UserRepo) ConfirmTOTP(ctx context.Context, userId, code string) ([]string, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "ConfirmTOTP")
+
+	request := pb.ConfirmTOTPRequest{
+		UserId: userId,
+		Code:   code,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.ConfirmTOTP(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
+		return nil, grpcErrorHandler(resCode, resMessage)
+	}
+	return grpcResponse.RecoveryCodes, nil
+}
+
+// APIKey describes one of a user's API keys. ExpiresAt is zero for keys that
+// do not expire, and LastUsedAt for keys never used.
+type APIKey struct {
+	Id         string
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+}
+
+// APIKeyOwner is the user an API key authenticates as, and what it may do.
+type APIKeyOwner struct {
+	KeyId  string
+	UserId string
+	Scopes []string
+}
+
+// CreateAPIKey issues a key for the user and returns it with the key itself,
+// which can't be retrieved again.
+func (r *UserRepo) CreateAPIKey(ctx context.Context, userId string, key APIKey) (APIKey, string, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "CreateAPIKey")
+
+	request := pb.CreateAPIKeyRequest{
+		UserId: userId,
+		Name:   key.Name,
+		Scopes: key.Scopes,
+	}
+	if !key.ExpiresAt.IsZero() {
+		request.ExpiresAt = timestamppb.New(key.ExpiresAt)
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.CreateAPIKey(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return APIKey{}, "", err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
+		return APIKey{}, "", grpcErrorHandler(resCode, resMessage)
+	}
+	return apiKeyFromPB(grpcResponse.ApiKey), grpcResponse.Key, nil
+}
+
+func (r *UserRepo) ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "ListAPIKeys")
+
+	request := pb.ListAPIKeysRequest{
+		UserId: userId,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.ListAPIKeys(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return nil, err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
+		return nil, grpcErrorHandler(resCode, resMessage)
+	}
+
+	var keys []APIKey
+	for _, key := range grpcResponse.ApiKeys {
+		keys = append(keys, apiKeyFromPB(key))
+	}
+	return keys, nil
+}
+
+func (r *UserRepo) RevokeAPIKey(ctx context.Context, userId, keyId string) error {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "RevokeAPIKey")
+
+	request := pb.RevokeAPIKeyRequest{
+		UserId: userId,
+		KeyId:  keyId,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.RevokeAPIKey(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
+		return grpcErrorHandler(resCode, resMessage)
+	}
+	return nil
+}
+
+func (r *UserRepo) AuthenticateAPIKey(ctx context.Context, key string) (APIKeyOwner, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "AuthenticateAPIKey")
+
+	request := pb.AuthenticateAPIKeyRequest{
+		Key: key,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.AuthenticateAPIKey(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return APIKeyOwner{}, err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Info(logger).Log("grpc response code", resCode, "grpc response message", resMessage)
+		return APIKeyOwner{}, grpcErrorHandler(resCode, resMessage)
+	}
+	return APIKeyOwner{KeyId: grpcResponse.KeyId, UserId: grpcResponse.UserId, Scopes: grpcResponse.Scopes}, nil
+}
+
+// OAuthClient is an application that delegates its users' login to the
+// OpenID Connect provider.
+type OAuthClient struct {
+	Id           string
+	Name         string
+	RedirectURIs []string
+	Confidential bool
+}
+
+// AuthorizationCode is what an authorization code is issued for. Scope is
+// space separated and CodeChallenge the S256 PKCE challenge.
+type AuthorizationCode struct {
+	ClientId      string
+	UserId        string
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string
+}
+
+// CodeExchange is a client's request to redeem an authorization code.
+type CodeExchange struct {
+	ClientId     string
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+}
+
+func (r *UserRepo) GetOAuthClient(ctx context.Context, clientId string) (OAuthClient, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "GetOAuthClient")
+
+	request := pb.GetOAuthClientRequest{
+		ClientId: clientId,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.GetOAuthClient(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return OAuthClient{}, err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
+		return OAuthClient{}, grpcErrorHandler(resCode, resMessage)
+	}
+	return OAuthClient{
+		Id:           grpcResponse.Client.GetClientId(),
+		Name:         grpcResponse.Client.GetName(),
+		RedirectURIs: grpcResponse.Client.GetRedirectUris(),
+		Confidential: grpcResponse.Client.GetConfidential(),
+	}, nil
+}
+
+// CreateAuthorizationCode issues a single use code the client exchanges for
+// the user's tokens.
+func (r *UserRepo) CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) (string, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "CreateAuthorizationCode")
+
+	request := pb.CreateAuthorizationCodeRequest{
+		ClientId:      code.ClientId,
+		UserId:        code.UserId,
+		RedirectUri:   code.RedirectURI,
+		Scope:         code.Scope,
+		Nonce:         code.Nonce,
+		CodeChallenge: code.CodeChallenge,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.CreateAuthorizationCode(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return "", err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Error(logger).Log("grpc status code", resCode, "grpc status message", resMessage)
+		return "", grpcErrorHandler(resCode, resMessage)
+	}
+	return grpcResponse.Code, nil
+}
+
+// ExchangeAuthorizationCode redeems a code, returning what it was issued for.
+func (r *UserRepo) ExchangeAuthorizationCode(ctx context.Context, exchange CodeExchange) (AuthorizationCode, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "ExchangeAuthorizationCode")
+
+	request := pb.ExchangeAuthorizationCodeRequest{
+		ClientId:     exchange.ClientId,
+		ClientSecret: exchange.ClientSecret,
+		Code:         exchange.Code,
+		RedirectUri:  exchange.RedirectURI,
+		CodeVerifier: exchange.CodeVerifier,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.ExchangeAuthorizationCode(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return AuthorizationCode{}, err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Info(logger).Log("grpc response code", resCode, "grpc response message", resMessage)
+		return AuthorizationCode{}, grpcErrorHandler(resCode, resMessage)
+	}
+	return AuthorizationCode{
+		ClientId:    exchange.ClientId,
+		UserId:      grpcResponse.UserId,
+		RedirectURI: exchange.RedirectURI,
+		Scope:       grpcResponse.Scope,
+		Nonce:       grpcResponse.Nonce,
+	}, nil
+}
+
+// Identity is an account of an external OpenID Connect provider, identified
+// by the provider's Issuer and the account's Subject.
+type Identity struct {
+	Issuer  string
+	Subject string
+}
+
+// IdentityClaims describe the account of an identity as its provider does.
+type IdentityClaims struct {
+	DisplayName   string
+	Email         string
+	EmailVerified bool
+}
+
+func (r *UserRepo) LinkIdentity(ctx context.Context, userId string, identity Identity) error {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "LinkIdentity")
+
+	request := pb.LinkIdentityRequest{
+		UserId:  userId,
+		Issuer:  identity.Issuer,
+		Subject: identity.Subject,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.LinkIdentity(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Info(logger).Log("grpc response code", resCode, "grpc response message", resMessage)
+		return grpcErrorHandler(resCode, resMessage)
+	}
+	return nil
+}
+
+// ProvisionIdentity links identity, which is not linked yet, to the user with
+// its verified email address or to a new user, failing with ErrConflict when
+// the address belongs to a user it can't be linked to.
+func (r *UserRepo) ProvisionIdentity(ctx context.Context, identity Identity, claims IdentityClaims) (User, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "ProvisionIdentity")
+
+	request := pb.ProvisionIdentityRequest{
+		Issuer:        identity.Issuer,
+		Subject:       identity.Subject,
+		DisplayName:   claims.DisplayName,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.ProvisionIdentity(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return User{}, err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Info(logger).Log("grpc response code", resCode, "grpc response message", resMessage)
+		return User{}, grpcErrorHandler(resCode, resMessage)
+	}
+	return User{UserId: grpcResponse.UserId}, nil
+}
+
+// AuthenticateIdentity logs in the user identity is linked to, or returns a
+// Challenge for users with two-factor authentication, failing with
+// ErrNotFound when it is not linked.
+func (r *UserRepo) AuthenticateIdentity(ctx context.Context, identity Identity) (User, error) {
+	logger := log.With(logging.WithContext(ctx, r.logger), "method", "AuthenticateIdentity")
+
+	request := pb.AuthenticateIdentityRequest{
+		Issuer:  identity.Issuer,
+		Subject: identity.Subject,
+	}
+
+	client := pb.NewUserServiceClient(r.conn)
+	grpcResponse, err := client.AuthenticateIdentity(ctx, &request)
+	if err != nil {
+		level.Error(logger).Log("err", err)
+		return User{}, err
+	}
+
+	resCode := grpcResponse.Status.Code
+	resMessage := grpcResponse.Status.Message
+	if resCode != 0 {
+		level.Info(logger).Log("grpc response code", resCode, "grpc response message", resMessage)
+		return User{}, grpcErrorHandler(resCode, resMessage)
+	}
+	return User{UserId: grpcResponse.UserId, Challenge: grpcResponse.Challenge}, nil
+}
+
+func apiKeyFromPB(key *pb.APIKey) APIKey {
+	return APIKey{
+		Id:         key.GetKeyId(),
+		Name:       key.GetName(),
+		Scopes:     key.GetScopes(),
+		CreatedAt:  timeFromPB(key.GetCreatedAt()),
+		ExpiresAt:  timeFromPB(key.GetExpiresAt()),
+		LastUsedAt: timeFromPB(key.GetLastUsedAt()),
+	}
+}
+
+func userFromPB(user *pb.User) User {
+	return User{
+		UserId:        user.GetUserId(),
+		Name:          user.GetUserName(),
+		Age:           user.GetUserAge(),
+		BirthDate:     user.GetBirthDate(),
+		Profile:       profileFromPB(user.GetProfile()),
+		Version:       user.GetVersion(),
+		EmailVerified: user.GetEmailVerified(),
+	}
+}
+
+// profileToPB leaves an empty profile unset.
+func profileToPB(profile Profile) *pb.Profile {
+	if profile.IsZero() {
+		return nil
+	}
+	return &pb.Profile{
+		Email:       profile.Email,
+		DisplayName: profile.DisplayName,
+		Locale:      profile.Locale,
+		Timezone:    profile.Timezone,
+		Metadata:    profile.Metadata,
+	}
+}
+
+func profileFromPB(profile *pb.Profile) Profile {
+	return Profile{
+		Email:       profile.GetEmail(),
+		DisplayName: profile.GetDisplayName(),
+		Locale:      profile.GetLocale(),
+		Timezone:    profile.GetTimezone(),
+		Metadata:    profile.GetMetadata(),
+	}
+}
+
+// timeFromPB maps an unset timestamp to the zero time.
+func timeFromPB(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+// StatusError is the error of a non-zero application status, as the
+// methods of UserRepo return it.
+func StatusError(status *pb.Status) error {
+	return grpcErrorHandler(status.GetCode(), status.GetMessage())
+}
+
 func grpcErrorHandler(code int32, message string) error {
 	err := errors.New(message)
 	switch code {
@@ -153,8 +848,12 @@ func grpcErrorHandler(code int32, message string) error {
 		return erro.ErrBadRequest{Err: err}
 	case 5:
 		return erro.ErrNotFound{Err: err}
+	case 6:
+		return erro.ErrConflict{Err: err}
 	case 7:
 		return erro.ErrForbidden{Err: err}
+	case 9:
+		return erro.ErrPreconditionFailed{Err: err}
 	default:
 		return erro.ErrInternal{Err: errors.New(erro.ErrUnexpected)}
 	}
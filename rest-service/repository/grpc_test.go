@@ -6,14 +6,18 @@ import (
 	"net"
 	"os"
 	"testing"
+	"time"
 
 	gokitLog "github.com/go-kit/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/javibauza/final-project/grpc-service/pb"
+	erro "github.com/javibauza/final-project/rest-service/errors"
 	"github.com/javibauza/final-project/rest-service/utils"
 )
 
@@ -62,6 +66,186 @@ func (m *mockGRPCService) GetUser(ctx context.Context, req *pb.GetUserRequest) (
 	return args.Get(0).(*pb.GetUserResponse), args.Error(1)
 }
 
+func (m *mockGRPCService) GetAuditLog(ctx context.Context, req *pb.GetAuditLogRequest) (*pb.GetAuditLogResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.GetAuditLogResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.DeleteUserResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) RestoreUser(ctx context.Context, req *pb.RestoreUserRequest) (*pb.RestoreUserResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.RestoreUserResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) SendVerification(ctx context.Context, req *pb.SendVerificationRequest) (*pb.SendVerificationResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.SendVerificationResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (*pb.VerifyEmailResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.VerifyEmailResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) CompleteAuthChallenge(ctx context.Context, req *pb.CompleteAuthChallengeRequest) (*pb.AuthResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.AuthResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) EnrollTOTP(ctx context.Context, req *pb.EnrollTOTPRequest) (*pb.EnrollTOTPResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.EnrollTOTPResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) ConfirmTOTP(ctx context.Context, req *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.ConfirmTOTPResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.CreateAPIKeyResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) ListAPIKeys(ctx context.Context, req *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.ListAPIKeysResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.RevokeAPIKeyResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) AuthenticateAPIKey(ctx context.Context, req *pb.AuthenticateAPIKeyRequest) (*pb.AuthenticateAPIKeyResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.AuthenticateAPIKeyResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) GetOAuthClient(ctx context.Context, req *pb.GetOAuthClientRequest) (*pb.GetOAuthClientResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.GetOAuthClientResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) CreateAuthorizationCode(ctx context.Context, req *pb.CreateAuthorizationCodeRequest) (*pb.CreateAuthorizationCodeResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.CreateAuthorizationCodeResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) ExchangeAuthorizationCode(ctx context.Context, req *pb.ExchangeAuthorizationCodeRequest) (*pb.ExchangeAuthorizationCodeResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.ExchangeAuthorizationCodeResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) LinkIdentity(ctx context.Context, req *pb.LinkIdentityRequest) (*pb.LinkIdentityResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.LinkIdentityResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) ProvisionIdentity(ctx context.Context, req *pb.ProvisionIdentityRequest) (*pb.ProvisionIdentityResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.ProvisionIdentityResponse), args.Error(1)
+}
+
+func (m *mockGRPCService) AuthenticateIdentity(ctx context.Context, req *pb.AuthenticateIdentityRequest) (*pb.AuthenticateIdentityResponse, error) {
+	args := m.Called(ctx, req)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*pb.AuthenticateIdentityResponse), args.Error(1)
+}
+
 func dialer(m *mockGRPCService) func(context.Context, string) (net.Conn, error) {
 	listener := bufconn.Listen(1024 * 1024)
 	server := grpc.NewServer()
@@ -136,6 +320,29 @@ func TestAuthenticate(t *testing.T) {
 				assert.NoError(t, resError)
 			},
 		},
+		{
+			testName: "two-factor challenge",
+			request: User{
+				Name:     "javi",
+				Password: "javier123",
+			},
+			grpcRequest: func(req User) *pb.AuthRequest {
+				return &pb.AuthRequest{
+					UserName: req.Name,
+					Password: req.Password,
+				}
+			},
+			grpcResponse: func(userId string) (*pb.AuthResponse, error) {
+				return &pb.AuthResponse{
+					Challenge: "the-challenge",
+					Status:    &pb.Status{Code: 0, Message: "ok"},
+				}, nil
+			},
+			checkResponse: func(t *testing.T, userId string, response User, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, User{Challenge: "the-challenge"}, response)
+			},
+		},
 		{
 			testName: "user not found",
 			request: User{
@@ -234,7 +441,7 @@ func TestCreateUser(t *testing.T) {
 		request       User
 		grpcRequest   func(req User) *pb.CreateUserRequest
 		grpcResponse  func(userId string) (*pb.CreateUserResponse, error)
-		checkResponse func(t *testing.T, userId, response string, resError error)
+		checkResponse func(t *testing.T, userId string, response User, resError error)
 	}{
 		{
 			testName: "user created",
@@ -242,7 +449,6 @@ func TestCreateUser(t *testing.T) {
 				Name:     "javier",
 				Password: "javier123",
 				Age:      45,
-				AddInfo:  "",
 			},
 			userId: utils.RandomString(12),
 			grpcRequest: func(req User) *pb.CreateUserRequest {
@@ -250,7 +456,7 @@ func TestCreateUser(t *testing.T) {
 					UserName: req.Name,
 					Password: req.Password,
 					UserAge:  req.Age,
-					AddInfo:  req.AddInfo,
+					Profile:  profileToPB(req.Profile),
 				}
 			},
 			grpcResponse: func(userId string) (*pb.CreateUserResponse, error) {
@@ -261,10 +467,15 @@ func TestCreateUser(t *testing.T) {
 				return &pb.CreateUserResponse{
 					UserId: userId,
 					Status: status,
+					User: &pb.User{
+						UserId:   userId,
+						UserName: "javier",
+						UserAge:  45,
+					},
 				}, nil
 			},
-			checkResponse: func(t *testing.T, userId, response string, resError error) {
-				assert.Equal(t, userId, response)
+			checkResponse: func(t *testing.T, userId string, response User, resError error) {
+				assert.Equal(t, User{UserId: userId, Name: "javier", Age: 45}, response)
 				assert.NoError(t, resError)
 			},
 		},
@@ -311,9 +522,10 @@ func TestUpdateUser(t *testing.T) {
 	testCases := []struct {
 		testName      string
 		request       User
+		fields        []string
 		grpcRequest   func(req User) *pb.UpdateUserRequest
 		grpcResponse  func() (*pb.UpdateUserResponse, error)
-		checkResponse func(t *testing.T, resError error)
+		checkResponse func(t *testing.T, response User, resError error)
 	}{
 		{
 			testName: "user updated",
@@ -321,14 +533,13 @@ func TestUpdateUser(t *testing.T) {
 				Name:     "javier",
 				Password: "javier123",
 				Age:      45,
-				AddInfo:  "",
 			},
 			grpcRequest: func(req User) *pb.UpdateUserRequest {
 				return &pb.UpdateUserRequest{
 					UserName: req.Name,
 					Password: req.Password,
 					UserAge:  req.Age,
-					AddInfo:  req.AddInfo,
+					Profile:  profileToPB(req.Profile),
 				}
 			},
 			grpcResponse: func() (*pb.UpdateUserResponse, error) {
@@ -338,9 +549,15 @@ func TestUpdateUser(t *testing.T) {
 				}
 				return &pb.UpdateUserResponse{
 					Status: status,
+					User: &pb.User{
+						UserId:   "u1",
+						UserName: "javier",
+						UserAge:  45,
+					},
 				}, nil
 			},
-			checkResponse: func(t *testing.T, resError error) {
+			checkResponse: func(t *testing.T, response User, resError error) {
+				assert.Equal(t, User{UserId: "u1", Name: "javier", Age: 45}, response)
 				assert.NoError(t, resError)
 			},
 		},
@@ -350,14 +567,14 @@ func TestUpdateUser(t *testing.T) {
 				Name:     "reivaj",
 				Password: "javier123",
 				Age:      37,
-				AddInfo:  "Some test additional info",
+				Profile:  Profile{Locale: "es-MX", Metadata: map[string]string{"add_info": "info"}},
 			},
 			grpcRequest: func(req User) *pb.UpdateUserRequest {
 				return &pb.UpdateUserRequest{
 					UserName: req.Name,
 					Password: req.Password,
 					UserAge:  req.Age,
-					AddInfo:  req.AddInfo,
+					Profile:  profileToPB(req.Profile),
 				}
 			},
 			grpcResponse: func() (*pb.UpdateUserResponse, error) {
@@ -369,10 +586,61 @@ func TestUpdateUser(t *testing.T) {
 					Status: status,
 				}, nil
 			},
-			checkResponse: func(t *testing.T, resError error) {
+			checkResponse: func(t *testing.T, response User, resError error) {
+				assert.Empty(t, response)
 				assert.EqualError(t, resError, "user not found")
 			},
 		},
+		{
+			testName: "fields sent as update mask",
+			request: User{
+				Name: "cleared",
+			},
+			fields: []string{"user_age", "profile.locale"},
+			grpcRequest: func(req User) *pb.UpdateUserRequest {
+				return &pb.UpdateUserRequest{
+					UserName:   req.Name,
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"user_age", "profile.locale"}},
+				}
+			},
+			grpcResponse: func() (*pb.UpdateUserResponse, error) {
+				return &pb.UpdateUserResponse{
+					Status: &pb.Status{Code: 0, Message: "ok"},
+					User:   &pb.User{UserId: "u2", UserName: "javier"},
+				}, nil
+			},
+			checkResponse: func(t *testing.T, response User, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, User{UserId: "u2", Name: "javier"}, response)
+			},
+		},
+		{
+			testName: "version mismatch",
+			request: User{
+				Name:    "stale",
+				Version: 3,
+			},
+			grpcRequest: func(req User) *pb.UpdateUserRequest {
+				return &pb.UpdateUserRequest{
+					UserName:        req.Name,
+					ExpectedVersion: req.Version,
+				}
+			},
+			grpcResponse: func() (*pb.UpdateUserResponse, error) {
+				status := &pb.Status{
+					Code:    9,
+					Message: "user version does not match",
+				}
+				return &pb.UpdateUserResponse{
+					Status: status,
+				}, nil
+			},
+			checkResponse: func(t *testing.T, response User, resError error) {
+				assert.Empty(t, response)
+				_, ok := resError.(erro.ErrPreconditionFailed)
+				assert.True(t, ok)
+			},
+		},
 	}
 
 	for i := range testCases {
@@ -384,8 +652,8 @@ func TestUpdateUser(t *testing.T) {
 					Return(res, err)
 			}
 
-			err := userRepoSvc.UpdateUser(ctx, tc.request)
-			tc.checkResponse(t, err)
+			res, err := userRepoSvc.UpdateUser(ctx, tc.request, tc.fields)
+			tc.checkResponse(t, res, err)
 		})
 	}
 }
@@ -434,15 +702,18 @@ func TestGetUser(t *testing.T) {
 					Message: "ok",
 				}
 				return &pb.GetUserResponse{
-					Status:   status,
-					UserId:   user.UserId,
-					UserName: user.Name,
-					UserAge:  user.Age,
-					AddInfo:  user.AddInfo,
+					Status:    status,
+					UserId:    user.UserId,
+					UserName:  user.Name,
+					UserAge:   user.Age,
+					Profile:   profileToPB(user.Profile),
+					CreatedAt: timestamppb.New(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)),
 				}, nil
 			},
 			checkResponse: func(t *testing.T, res User, resError error) {
 				assert.NoError(t, resError)
+				assert.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), res.CreatedAt)
+				assert.True(t, res.LastLoginAt.IsZero(), "unset timestamps are zero")
 			},
 		},
 		{
@@ -471,7 +742,7 @@ func TestGetUser(t *testing.T) {
 	user := User{
 		Name:    "javier",
 		Age:     37,
-		AddInfo: "Some test additional info",
+		Profile: Profile{Locale: "es-MX", Metadata: map[string]string{"add_info": "info"}},
 	}
 
 	for i := range testCases {
@@ -486,3 +757,982 @@ func TestGetUser(t *testing.T) {
 		})
 	}
 }
+
+func TestGetAuditLog(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		pageToken     string
+		grpcResponse  *pb.GetAuditLogResponse
+		checkResponse func(t *testing.T, res AuditLog, resError error)
+	}{
+		{
+			testName:  "audit log obtained",
+			userId:    "u1",
+			pageToken: "Mw",
+			grpcResponse: &pb.GetAuditLogResponse{
+				Status: &pb.Status{Code: 0, Message: "ok"},
+				Entries: []*pb.AuditEntry{{
+					Id:        2,
+					Actor:     "rest-service",
+					Action:    "user.update",
+					Target:    "u1",
+					Changes:   map[string]string{"age": "38"},
+					RequestId: "req-1",
+					CreatedAt: timestamppb.New(createdAt),
+				}},
+				NextPageToken: "Mg",
+			},
+			checkResponse: func(t *testing.T, res AuditLog, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, AuditLog{
+					Entries: []AuditEntry{{
+						Id:        2,
+						Actor:     "rest-service",
+						Action:    "user.update",
+						Target:    "u1",
+						Changes:   map[string]string{"age": "38"},
+						RequestId: "req-1",
+						CreatedAt: createdAt,
+					}},
+					NextPageToken: "Mg",
+				}, res)
+			},
+		},
+		{
+			testName:  "invalid page token",
+			userId:    "u2",
+			pageToken: "nope",
+			grpcResponse: &pb.GetAuditLogResponse{
+				Status: &pb.Status{Code: 3, Message: "invalid page_token"},
+			},
+			checkResponse: func(t *testing.T, res AuditLog, resError error) {
+				assert.IsType(t, erro.ErrBadRequest{}, resError)
+				assert.EqualError(t, resError, "invalid page_token")
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("GetAuditLog", mock.Anything, &pb.GetAuditLogRequest{
+				UserId:    tc.userId,
+				PageSize:  10,
+				PageToken: tc.pageToken,
+			}).Return(tc.grpcResponse, nil)
+			res, err := userRepoSvc.GetAuditLog(ctx, tc.userId, 10, tc.pageToken)
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		grpcResponse  *pb.DeleteUserResponse
+		checkResponse func(t *testing.T, resError error)
+	}{
+		{
+			testName: "user deleted",
+			userId:   "u1",
+			grpcResponse: &pb.DeleteUserResponse{
+				Status: &pb.Status{Code: 0, Message: "ok"},
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "user not found",
+			userId:   "u2",
+			grpcResponse: &pb.DeleteUserResponse{
+				Status: &pb.Status{Code: 5, Message: "user not found"},
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.IsType(t, erro.ErrNotFound{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("DeleteUser", mock.Anything, &pb.DeleteUserRequest{UserId: tc.userId}).
+				Return(tc.grpcResponse, nil)
+			err := userRepoSvc.DeleteUser(ctx, tc.userId)
+			tc.checkResponse(t, err)
+		})
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		grpcResponse  *pb.RestoreUserResponse
+		checkResponse func(t *testing.T, res User, resError error)
+	}{
+		{
+			testName: "user restored",
+			userId:   "u1",
+			grpcResponse: &pb.RestoreUserResponse{
+				Status: &pb.Status{Code: 0, Message: "ok"},
+				User:   &pb.User{UserId: "u1", UserName: "javier", UserAge: 37, Version: 3},
+			},
+			checkResponse: func(t *testing.T, res User, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, User{UserId: "u1", Name: "javier", Age: 37, Version: 3}, res)
+			},
+		},
+		{
+			testName: "grace period expired",
+			userId:   "u2",
+			grpcResponse: &pb.RestoreUserResponse{
+				Status: &pb.Status{Code: 9, Message: "restore grace period has expired"},
+			},
+			checkResponse: func(t *testing.T, res User, resError error) {
+				assert.IsType(t, erro.ErrPreconditionFailed{}, resError)
+				assert.EqualError(t, resError, "restore grace period has expired")
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("RestoreUser", mock.Anything, &pb.RestoreUserRequest{UserId: tc.userId}).
+				Return(tc.grpcResponse, nil)
+			res, err := userRepoSvc.RestoreUser(ctx, tc.userId)
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
+
+func TestSendVerification(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		grpcResponse  *pb.SendVerificationResponse
+		checkResponse func(t *testing.T, resError error)
+	}{
+		{
+			testName: "verification sent",
+			userId:   "u1",
+			grpcResponse: &pb.SendVerificationResponse{
+				Status: &pb.Status{Code: 0, Message: "ok"},
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "already verified",
+			userId:   "u2",
+			grpcResponse: &pb.SendVerificationResponse{
+				Status: &pb.Status{Code: 9, Message: "email address is already verified"},
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.IsType(t, erro.ErrPreconditionFailed{}, resError)
+				assert.EqualError(t, resError, "email address is already verified")
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("SendVerification", mock.Anything, &pb.SendVerificationRequest{UserId: tc.userId}).
+				Return(tc.grpcResponse, nil)
+			err := userRepoSvc.SendVerification(ctx, tc.userId)
+			tc.checkResponse(t, err)
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		token         string
+		grpcResponse  *pb.VerifyEmailResponse
+		checkResponse func(t *testing.T, res User, resError error)
+	}{
+		{
+			testName: "email verified",
+			token:    "the-token",
+			grpcResponse: &pb.VerifyEmailResponse{
+				Status: &pb.Status{Code: 0, Message: "ok"},
+				User: &pb.User{
+					UserId:        "u1",
+					UserName:      "javier",
+					Profile:       &pb.Profile{Email: "javier@example.com"},
+					Version:       2,
+					EmailVerified: true,
+				},
+			},
+			checkResponse: func(t *testing.T, res User, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, User{UserId: "u1", Name: "javier", Profile: Profile{Email: "javier@example.com"}, Version: 2, EmailVerified: true}, res)
+			},
+		},
+		{
+			testName: "invalid token",
+			token:    "other",
+			grpcResponse: &pb.VerifyEmailResponse{
+				Status: &pb.Status{Code: 3, Message: "invalid or expired verification token"},
+			},
+			checkResponse: func(t *testing.T, res User, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrBadRequest{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("VerifyEmail", mock.Anything, &pb.VerifyEmailRequest{Token: tc.token}).
+				Return(tc.grpcResponse, nil)
+			res, err := userRepoSvc.VerifyEmail(ctx, tc.token)
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
+
+func TestCompleteAuthChallenge(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		code          string
+		grpcResponse  *pb.AuthResponse
+		checkResponse func(t *testing.T, res User, resError error)
+	}{
+		{
+			testName: "challenge completed",
+			code:     "123456",
+			grpcResponse: &pb.AuthResponse{
+				UserId: "u1",
+				Status: &pb.Status{Code: 0, Message: "ok"},
+			},
+			checkResponse: func(t *testing.T, res User, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, User{UserId: "u1"}, res)
+			},
+		},
+		{
+			testName: "wrong code",
+			code:     "654321",
+			grpcResponse: &pb.AuthResponse{
+				Status: &pb.Status{Code: 7, Message: "wrong or already used two-factor code"},
+			},
+			checkResponse: func(t *testing.T, res User, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrForbidden{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("CompleteAuthChallenge", mock.Anything, &pb.CompleteAuthChallengeRequest{Challenge: "the-challenge", Code: tc.code}).
+				Return(tc.grpcResponse, nil)
+			res, err := userRepoSvc.CompleteAuthChallenge(ctx, "the-challenge", tc.code)
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		grpcResponse  *pb.EnrollTOTPResponse
+		checkResponse func(t *testing.T, res TOTPEnrollment, resError error)
+	}{
+		{
+			testName: "enrolled",
+			userId:   "u1",
+			grpcResponse: &pb.EnrollTOTPResponse{
+				Status: &pb.Status{Code: 0, Message: "ok"},
+				Secret: "SECRET",
+				Uri:    "otpauth://totp/final-project:javier?secret=SECRET",
+			},
+			checkResponse: func(t *testing.T, res TOTPEnrollment, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, TOTPEnrollment{Secret: "SECRET", URI: "otpauth://totp/final-project:javier?secret=SECRET"}, res)
+			},
+		},
+		{
+			testName: "already enabled",
+			userId:   "u2",
+			grpcResponse: &pb.EnrollTOTPResponse{
+				Status: &pb.Status{Code: 9, Message: "two-factor authentication is already enabled"},
+			},
+			checkResponse: func(t *testing.T, res TOTPEnrollment, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrPreconditionFailed{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("EnrollTOTP", mock.Anything, &pb.EnrollTOTPRequest{UserId: tc.userId}).
+				Return(tc.grpcResponse, nil)
+			res, err := userRepoSvc.EnrollTOTP(ctx, tc.userId)
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
+
+func TestConfirmTOTP(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		code          string
+		grpcResponse  *pb.ConfirmTOTPResponse
+		checkResponse func(t *testing.T, res []string, resError error)
+	}{
+		{
+			testName: "confirmed",
+			code:     "123456",
+			grpcResponse: &pb.ConfirmTOTPResponse{
+				Status:        &pb.Status{Code: 0, Message: "ok"},
+				RecoveryCodes: []string{"abcde-fghij", "klmno-pqrst"},
+			},
+			checkResponse: func(t *testing.T, res []string, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, []string{"abcde-fghij", "klmno-pqrst"}, res)
+			},
+		},
+		{
+			testName: "not enrolled",
+			code:     "654321",
+			grpcResponse: &pb.ConfirmTOTPResponse{
+				Status: &pb.Status{Code: 9, Message: "two-factor authentication is not enrolled"},
+			},
+			checkResponse: func(t *testing.T, res []string, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrPreconditionFailed{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("ConfirmTOTP", mock.Anything, &pb.ConfirmTOTPRequest{UserId: "u1", Code: tc.code}).
+				Return(tc.grpcResponse, nil)
+			res, err := userRepoSvc.ConfirmTOTP(ctx, "u1", tc.code)
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	createdAt := time.Date(2026, 7, 9, 12, 0, 0, 0, time.UTC)
+	expiresAt := createdAt.Add(30 * 24 * time.Hour)
+
+	testCases := []struct {
+		testName      string
+		key           APIKey
+		grpcRequest   *pb.CreateAPIKeyRequest
+		grpcResponse  *pb.CreateAPIKeyResponse
+		checkResponse func(t *testing.T, res APIKey, key string, resError error)
+	}{
+		{
+			testName:    "created",
+			key:         APIKey{Name: "batch", Scopes: []string{"users:read"}, ExpiresAt: expiresAt},
+			grpcRequest: &pb.CreateAPIKeyRequest{UserId: "u1", Name: "batch", Scopes: []string{"users:read"}, ExpiresAt: timestamppb.New(expiresAt)},
+			grpcResponse: &pb.CreateAPIKeyResponse{
+				Status: &pb.Status{Code: 0, Message: "ok"},
+				ApiKey: &pb.APIKey{
+					KeyId:     "abcdefghijklm",
+					Name:      "batch",
+					Scopes:    []string{"users:read"},
+					CreatedAt: timestamppb.New(createdAt),
+					ExpiresAt: timestamppb.New(expiresAt),
+				},
+				Key: "fpk_abcdefghijklm_secret",
+			},
+			checkResponse: func(t *testing.T, res APIKey, key string, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, "fpk_abcdefghijklm_secret", key)
+				assert.Equal(t, APIKey{
+					Id:        "abcdefghijklm",
+					Name:      "batch",
+					Scopes:    []string{"users:read"},
+					CreatedAt: createdAt,
+					ExpiresAt: expiresAt,
+				}, res)
+			},
+		},
+		{
+			testName:    "unknown scope",
+			key:         APIKey{Name: "admin", Scopes: []string{"admin"}},
+			grpcRequest: &pb.CreateAPIKeyRequest{UserId: "u1", Name: "admin", Scopes: []string{"admin"}},
+			grpcResponse: &pb.CreateAPIKeyResponse{
+				Status: &pb.Status{Code: 3, Message: `unknown scope "admin"`},
+			},
+			checkResponse: func(t *testing.T, res APIKey, key string, resError error) {
+				assert.Empty(t, key)
+				assert.IsType(t, erro.ErrBadRequest{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("CreateAPIKey", mock.Anything, tc.grpcRequest).
+				Return(tc.grpcResponse, nil)
+			res, key, err := userRepoSvc.CreateAPIKey(ctx, "u1", tc.key)
+			tc.checkResponse(t, res, key, err)
+		})
+	}
+}
+
+func TestListAPIKeys(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	createdAt := time.Date(2026, 7, 9, 12, 0, 0, 0, time.UTC)
+	grpcUserService.On("ListAPIKeys", mock.Anything, &pb.ListAPIKeysRequest{UserId: "u1"}).
+		Return(&pb.ListAPIKeysResponse{
+			Status: &pb.Status{Code: 0, Message: "ok"},
+			ApiKeys: []*pb.APIKey{
+				{KeyId: "k1", Name: "batch", Scopes: []string{"users:read", "users:write"}, CreatedAt: timestamppb.New(createdAt), LastUsedAt: timestamppb.New(createdAt)},
+			},
+		}, nil)
+	grpcUserService.On("ListAPIKeys", mock.Anything, &pb.ListAPIKeysRequest{UserId: "u2"}).
+		Return(&pb.ListAPIKeysResponse{
+			Status: &pb.Status{Code: 5, Message: "user not found"},
+		}, nil)
+
+	keys, err := userRepoSvc.ListAPIKeys(ctx, "u1")
+	assert.NoError(t, err)
+	assert.Equal(t, []APIKey{
+		{Id: "k1", Name: "batch", Scopes: []string{"users:read", "users:write"}, CreatedAt: createdAt, LastUsedAt: createdAt},
+	}, keys)
+
+	_, err = userRepoSvc.ListAPIKeys(ctx, "u2")
+	assert.IsType(t, erro.ErrNotFound{}, err)
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	grpcUserService.On("RevokeAPIKey", mock.Anything, &pb.RevokeAPIKeyRequest{UserId: "u1", KeyId: "k1"}).
+		Return(&pb.RevokeAPIKeyResponse{Status: &pb.Status{Code: 0, Message: "ok"}}, nil)
+	grpcUserService.On("RevokeAPIKey", mock.Anything, &pb.RevokeAPIKeyRequest{UserId: "u1", KeyId: "k2"}).
+		Return(&pb.RevokeAPIKeyResponse{Status: &pb.Status{Code: 5, Message: "API key not found"}}, nil)
+
+	assert.NoError(t, userRepoSvc.RevokeAPIKey(ctx, "u1", "k1"))
+	assert.IsType(t, erro.ErrNotFound{}, userRepoSvc.RevokeAPIKey(ctx, "u1", "k2"))
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		key           string
+		grpcResponse  *pb.AuthenticateAPIKeyResponse
+		checkResponse func(t *testing.T, res APIKeyOwner, resError error)
+	}{
+		{
+			testName: "authenticated",
+			key:      "fpk_abcdefghijklm_secret",
+			grpcResponse: &pb.AuthenticateAPIKeyResponse{
+				Status: &pb.Status{Code: 0, Message: "ok"},
+				UserId: "u1",
+				Scopes: []string{"users:read"},
+			},
+			checkResponse: func(t *testing.T, res APIKeyOwner, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, APIKeyOwner{UserId: "u1", Scopes: []string{"users:read"}}, res)
+			},
+		},
+		{
+			testName: "invalid key",
+			key:      "fpk_abcdefghijklm_other",
+			grpcResponse: &pb.AuthenticateAPIKeyResponse{
+				Status: &pb.Status{Code: 7, Message: "invalid, expired or revoked API key"},
+			},
+			checkResponse: func(t *testing.T, res APIKeyOwner, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrForbidden{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("AuthenticateAPIKey", mock.Anything, &pb.AuthenticateAPIKeyRequest{Key: tc.key}).
+				Return(tc.grpcResponse, nil)
+			res, err := userRepoSvc.AuthenticateAPIKey(ctx, tc.key)
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
+
+func TestGetOAuthClient(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		clientId      string
+		grpcResponse  *pb.GetOAuthClientResponse
+		checkResponse func(t *testing.T, res OAuthClient, resError error)
+	}{
+		{
+			testName: "found",
+			clientId: "c1",
+			grpcResponse: &pb.GetOAuthClientResponse{
+				Status: &pb.Status{Code: 0, Message: "ok"},
+				Client: &pb.OAuthClient{ClientId: "c1", Name: "wiki", RedirectUris: []string{"https://wiki.example.com/cb"}, Confidential: true},
+			},
+			checkResponse: func(t *testing.T, res OAuthClient, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, OAuthClient{Id: "c1", Name: "wiki", RedirectURIs: []string{"https://wiki.example.com/cb"}, Confidential: true}, res)
+			},
+		},
+		{
+			testName: "not found",
+			clientId: "c2",
+			grpcResponse: &pb.GetOAuthClientResponse{
+				Status: &pb.Status{Code: 5, Message: "OAuth client not found"},
+			},
+			checkResponse: func(t *testing.T, res OAuthClient, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrNotFound{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("GetOAuthClient", mock.Anything, &pb.GetOAuthClientRequest{ClientId: tc.clientId}).
+				Return(tc.grpcResponse, nil)
+			res, err := userRepoSvc.GetOAuthClient(ctx, tc.clientId)
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
+
+func TestCreateAuthorizationCode(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	grpcUserService.On("CreateAuthorizationCode", mock.Anything, &pb.CreateAuthorizationCodeRequest{
+		ClientId:      "c1",
+		UserId:        "u1",
+		RedirectUri:   "https://wiki.example.com/cb",
+		Scope:         "openid",
+		Nonce:         "n",
+		CodeChallenge: "challenge",
+	}).Return(&pb.CreateAuthorizationCodeResponse{Status: &pb.Status{Code: 0, Message: "ok"}, Code: "the-code"}, nil)
+
+	code, err := userRepoSvc.CreateAuthorizationCode(ctx, AuthorizationCode{
+		ClientId:      "c1",
+		UserId:        "u1",
+		RedirectURI:   "https://wiki.example.com/cb",
+		Scope:         "openid",
+		Nonce:         "n",
+		CodeChallenge: "challenge",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "the-code", code)
+}
+
+func TestExchangeAuthorizationCode(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		code          string
+		grpcResponse  *pb.ExchangeAuthorizationCodeResponse
+		checkResponse func(t *testing.T, res AuthorizationCode, resError error)
+	}{
+		{
+			testName: "exchanged",
+			code:     "the-code",
+			grpcResponse: &pb.ExchangeAuthorizationCodeResponse{
+				Status: &pb.Status{Code: 0, Message: "ok"},
+				UserId: "u1",
+				Scope:  "openid email",
+				Nonce:  "n",
+			},
+			checkResponse: func(t *testing.T, res AuthorizationCode, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, AuthorizationCode{ClientId: "c1", UserId: "u1", RedirectURI: "https://wiki.example.com/cb", Scope: "openid email", Nonce: "n"}, res)
+			},
+		},
+		{
+			testName: "invalid code",
+			code:     "used-code",
+			grpcResponse: &pb.ExchangeAuthorizationCodeResponse{
+				Status: &pb.Status{Code: 3, Message: "invalid, expired or already used authorization code"},
+			},
+			checkResponse: func(t *testing.T, res AuthorizationCode, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrBadRequest{}, resError)
+			},
+		},
+		{
+			testName: "wrong client secret",
+			code:     "other-code",
+			grpcResponse: &pb.ExchangeAuthorizationCodeResponse{
+				Status: &pb.Status{Code: 7, Message: "invalid client credentials"},
+			},
+			checkResponse: func(t *testing.T, res AuthorizationCode, resError error) {
+				assert.IsType(t, erro.ErrForbidden{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("ExchangeAuthorizationCode", mock.Anything, &pb.ExchangeAuthorizationCodeRequest{
+				ClientId:     "c1",
+				ClientSecret: "secret",
+				Code:         tc.code,
+				RedirectUri:  "https://wiki.example.com/cb",
+				CodeVerifier: "verifier",
+			}).Return(tc.grpcResponse, nil)
+			res, err := userRepoSvc.ExchangeAuthorizationCode(ctx, CodeExchange{
+				ClientId:     "c1",
+				ClientSecret: "secret",
+				Code:         tc.code,
+				RedirectURI:  "https://wiki.example.com/cb",
+				CodeVerifier: "verifier",
+			})
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
+
+func TestLinkIdentity(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		grpcResponse  *pb.LinkIdentityResponse
+		checkResponse func(t *testing.T, resError error)
+	}{
+		{
+			testName:     "linked",
+			userId:       "u1",
+			grpcResponse: &pb.LinkIdentityResponse{Status: &pb.Status{Code: 0, Message: "ok"}},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName:     "already linked",
+			userId:       "u2",
+			grpcResponse: &pb.LinkIdentityResponse{Status: &pb.Status{Code: 6, Message: "identity is already linked to a user"}},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.IsType(t, erro.ErrConflict{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("LinkIdentity", mock.Anything, &pb.LinkIdentityRequest{
+				UserId:  tc.userId,
+				Issuer:  "https://idp.example.com",
+				Subject: "s1",
+			}).Return(tc.grpcResponse, nil)
+			err := userRepoSvc.LinkIdentity(ctx, tc.userId, Identity{Issuer: "https://idp.example.com", Subject: "s1"})
+			tc.checkResponse(t, err)
+		})
+	}
+}
+
+func TestProvisionIdentity(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		subject       string
+		grpcResponse  *pb.ProvisionIdentityResponse
+		checkResponse func(t *testing.T, res User, resError error)
+	}{
+		{
+			testName: "provisioned",
+			subject:  "s1",
+			grpcResponse: &pb.ProvisionIdentityResponse{
+				Status: &pb.Status{Code: 0, Message: "ok"},
+				UserId: "u1",
+			},
+			checkResponse: func(t *testing.T, res User, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, User{UserId: "u1"}, res)
+			},
+		},
+		{
+			testName:     "email not linkable",
+			subject:      "s2",
+			grpcResponse: &pb.ProvisionIdentityResponse{Status: &pb.Status{Code: 6, Message: "email address belongs to another account that can't be linked automatically"}},
+			checkResponse: func(t *testing.T, res User, resError error) {
+				assert.IsType(t, erro.ErrConflict{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("ProvisionIdentity", mock.Anything, &pb.ProvisionIdentityRequest{
+				Issuer:        "https://idp.example.com",
+				Subject:       tc.subject,
+				DisplayName:   "Javier",
+				Email:         "javier@example.com",
+				EmailVerified: true,
+			}).Return(tc.grpcResponse, nil)
+			res, err := userRepoSvc.ProvisionIdentity(ctx,
+				Identity{Issuer: "https://idp.example.com", Subject: tc.subject},
+				IdentityClaims{DisplayName: "Javier", Email: "javier@example.com", EmailVerified: true})
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
+
+func TestAuthenticateIdentity(t *testing.T) {
+	ctx := context.Background()
+
+	grpcUserService := new(mockGRPCService)
+	conn, err := grpc.DialContext(ctx, "", grpc.WithInsecure(), grpc.WithContextDialer(dialer(grpcUserService)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	userRepoSvc := NewUserRepo(conn, gokitLog.NewNopLogger())
+
+	testCases := []struct {
+		testName      string
+		subject       string
+		grpcResponse  *pb.AuthenticateIdentityResponse
+		checkResponse func(t *testing.T, res User, resError error)
+	}{
+		{
+			testName: "logged in",
+			subject:  "s1",
+			grpcResponse: &pb.AuthenticateIdentityResponse{
+				Status: &pb.Status{Code: 0, Message: "ok"},
+				UserId: "u1",
+			},
+			checkResponse: func(t *testing.T, res User, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, User{UserId: "u1"}, res)
+			},
+		},
+		{
+			testName:     "not linked",
+			subject:      "s2",
+			grpcResponse: &pb.AuthenticateIdentityResponse{Status: &pb.Status{Code: 5, Message: "identity is not linked to a user"}},
+			checkResponse: func(t *testing.T, res User, resError error) {
+				assert.IsType(t, erro.ErrNotFound{}, resError)
+			},
+		},
+		{
+			testName:     "user deleted",
+			subject:      "s3",
+			grpcResponse: &pb.AuthenticateIdentityResponse{Status: &pb.Status{Code: 7, Message: "the user linked to this identity is deleted"}},
+			checkResponse: func(t *testing.T, res User, resError error) {
+				assert.IsType(t, erro.ErrForbidden{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			grpcUserService.On("AuthenticateIdentity", mock.Anything, &pb.AuthenticateIdentityRequest{
+				Issuer:  "https://idp.example.com",
+				Subject: tc.subject,
+			}).Return(tc.grpcResponse, nil)
+			res, err := userRepoSvc.AuthenticateIdentity(ctx, Identity{Issuer: "https://idp.example.com", Subject: tc.subject})
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+type Middleware func(UserRepository) UserRepository
+
+type tracingMiddleware struct {
+	next UserRepository
+}
+
+func TracingMiddleware() Middleware {
+	return func(next UserRepository) UserRepository {
+		return &tracingMiddleware{next: next}
+	}
+}
+
+func (mw *tracingMiddleware) Authenticate(ctx context.Context, user User) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.Authenticate")
+	res, err := mw.next.Authenticate(ctx, user)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) CreateUser(ctx context.Context, user User) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.CreateUser")
+	res, err := mw.next.CreateUser(ctx, user)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) UpdateUser(ctx context.Context, user User, fields []string) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.UpdateUser")
+	res, err := mw.next.UpdateUser(ctx, user, fields)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) GetUser(ctx context.Context, userId string) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.GetUser")
+	user, err := mw.next.GetUser(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return user, err
+}
+
+func (mw *tracingMiddleware) GetAuditLog(ctx context.Context, userId string, pageSize int32, pageToken string) (AuditLog, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.GetAuditLog")
+	res, err := mw.next.GetAuditLog(ctx, userId, pageSize, pageToken)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) DeleteUser(ctx context.Context, userId string) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.DeleteUser")
+	err := mw.next.DeleteUser(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) RestoreUser(ctx context.Context, userId string) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.RestoreUser")
+	res, err := mw.next.RestoreUser(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) SendVerification(ctx context.Context, userId string) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.SendVerification")
+	err := mw.next.SendVerification(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) VerifyEmail(ctx context.Context, token string) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.VerifyEmail")
+	res, err := mw.next.VerifyEmail(ctx, token)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) CompleteAuthChallenge(ctx context.Context, challenge, code string) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.CompleteAuthChallenge")
+	res, err := mw.next.CompleteAuthChallenge(ctx, challenge, code)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) EnrollTOTP(ctx context.Context, userId string) (TOTPEnrollment, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.EnrollTOTP")
+	res, err := mw.next.EnrollTOTP(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) ConfirmTOTP(ctx context.Context, userId, code string) ([]string, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.ConfirmTOTP")
+	res, err := mw.next.ConfirmTOTP(ctx, userId, code)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) CreateAPIKey(ctx context.Context, userId string, key APIKey) (APIKey, string, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.CreateAPIKey")
+	res, secret, err := mw.next.CreateAPIKey(ctx, userId, key)
+	tracing.EndSpan(span, err)
+
+	return res, secret, err
+}
+
+func (mw *tracingMiddleware) ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.ListAPIKeys")
+	res, err := mw.next.ListAPIKeys(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) RevokeAPIKey(ctx context.Context, userId, keyId string) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.RevokeAPIKey")
+	err := mw.next.RevokeAPIKey(ctx, userId, keyId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) AuthenticateAPIKey(ctx context.Context, key string) (APIKeyOwner, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.AuthenticateAPIKey")
+	res, err := mw.next.AuthenticateAPIKey(ctx, key)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) GetOAuthClient(ctx context.Context, clientId string) (OAuthClient, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.GetOAuthClient")
+	res, err := mw.next.GetOAuthClient(ctx, clientId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) (string, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.CreateAuthorizationCode")
+	res, err := mw.next.CreateAuthorizationCode(ctx, code)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) ExchangeAuthorizationCode(ctx context.Context, exchange CodeExchange) (AuthorizationCode, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.ExchangeAuthorizationCode")
+	res, err := mw.next.ExchangeAuthorizationCode(ctx, exchange)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) LinkIdentity(ctx context.Context, userId string, identity Identity) error {
+	ctx, span := tracing.StartSpan(ctx, "repository.LinkIdentity")
+	err := mw.next.LinkIdentity(ctx, userId, identity)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) ProvisionIdentity(ctx context.Context, identity Identity, claims IdentityClaims) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.ProvisionIdentity")
+	user, err := mw.next.ProvisionIdentity(ctx, identity, claims)
+	tracing.EndSpan(span, err)
+
+	return user, err
+}
+
+func (mw *tracingMiddleware) AuthenticateIdentity(ctx context.Context, identity Identity) (User, error) {
+	ctx, span := tracing.StartSpan(ctx, "repository.AuthenticateIdentity")
+	user, err := mw.next.AuthenticateIdentity(ctx, identity)
+	tracing.EndSpan(span, err)
+
+	return user, err
+}
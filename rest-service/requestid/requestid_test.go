@@ -0,0 +1,105 @@
+package requestid
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/javibauza/final-project/grpc-service/pb"
+)
+
+type userServer struct {
+	pb.UnimplementedUserServiceServer
+	requestId string
+}
+
+func (s *userServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(MetadataKey); len(values) > 0 {
+		s.requestId = values[0]
+	}
+	return &pb.GetUserResponse{Status: &pb.Status{}}, nil
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		header        string
+		checkResponse func(t *testing.T, ctxId, headerId string)
+	}{
+		{
+			testName: "request id accepted",
+			header:   "abc-123",
+			checkResponse: func(t *testing.T, ctxId, headerId string) {
+				assert.Equal(t, "abc-123", ctxId)
+				assert.Equal(t, "abc-123", headerId)
+			},
+		},
+		{
+			testName: "request id generated",
+			header:   "",
+			checkResponse: func(t *testing.T, ctxId, headerId string) {
+				assert.Len(t, ctxId, 32)
+				assert.Equal(t, ctxId, headerId)
+			},
+		},
+		{
+			testName: "invalid request id replaced",
+			header:   "bad id\n",
+			checkResponse: func(t *testing.T, ctxId, headerId string) {
+				assert.NotEqual(t, "bad id\n", ctxId)
+				assert.Equal(t, ctxId, headerId)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			var ctxId string
+			handler := HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxId = FromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/api/abc", nil)
+			if tc.header != "" {
+				req.Header.Set(Header, tc.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			tc.checkResponse(t, ctxId, rec.Header().Get(Header))
+		})
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	upstream := &userServer{}
+	pb.RegisterUserServiceServer(server, upstream)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor()),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+	)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ctx := NewContext(context.Background(), "abc-123")
+	_, err = pb.NewUserServiceClient(conn).GetUser(ctx, &pb.GetUserRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "abc-123", upstream.requestId)
+}
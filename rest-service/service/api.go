@@ -2,19 +2,33 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 
 	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/logging"
 	"github.com/javibauza/final-project/rest-service/repository"
 )
 
 type Service interface {
 	Authenticate(ctx context.Context, request AuthRequest) (AuthResponse, error)
 	CreateUser(ctx context.Context, request CreateUserRequest) (CreateUserResponse, error)
-	UpdateUser(ctx context.Context, request UpdateUserRequest) error
+	UpdateUser(ctx context.Context, request UpdateUserRequest) (UpdateUserResponse, error)
 	GetUser(ctx context.Context, userId string) (GetUserResponse, error)
+	GetAuditLog(ctx context.Context, request GetAuditLogRequest) (GetAuditLogResponse, error)
+	DeleteUser(ctx context.Context, userId string) error
+	RestoreUser(ctx context.Context, userId string) (GetUserResponse, error)
+	SendVerification(ctx context.Context, userId string) error
+	VerifyEmail(ctx context.Context, token string) (GetUserResponse, error)
+	CompleteAuthChallenge(ctx context.Context, request CompleteAuthChallengeRequest) (AuthResponse, error)
+	EnrollTOTP(ctx context.Context, userId string) (EnrollTOTPResponse, error)
+	ConfirmTOTP(ctx context.Context, request ConfirmTOTPRequest) (ConfirmTOTPResponse, error)
+	CreateAPIKey(ctx context.Context, request CreateAPIKeyRequest) (CreateAPIKeyResponse, error)
+	ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, userId, keyId string) error
+	AuthenticateAPIKey(ctx context.Context, key string) (APIKeyOwner, error)
 }
 
 type service struct {
@@ -22,39 +36,122 @@ type service struct {
 	logger     log.Logger
 }
 
+// Profile is the user's structured profile.
+type Profile = repository.Profile
+
 type AuthRequest struct {
 	Name string
 	Pwd  string
 }
 
+// AuthResponse has the UserId of the logged in user, or a Challenge to
+// complete with a two-factor code.
 type AuthResponse struct {
+	UserId    string
+	Challenge string
+}
+
+type CompleteAuthChallengeRequest struct {
+	Challenge string
+	Code      string
+}
+
+type EnrollTOTPResponse struct {
+	Secret string
+	URI    string
+}
+
+type ConfirmTOTPRequest struct {
+	UserId string
+	Code   string
+}
+
+type ConfirmTOTPResponse struct {
+	RecoveryCodes []string
+}
+
+type APIKey = repository.APIKey
+
+type APIKeyOwner = repository.APIKeyOwner
+
+type CreateAPIKeyRequest struct {
 	UserId string
+	Name   string
+	Scopes []string
+	// ExpiresAt is zero for keys that do not expire.
+	ExpiresAt time.Time
 }
 
+// CreateAPIKeyResponse carries the only copy of Key the user will get.
+type CreateAPIKeyResponse struct {
+	APIKey APIKey
+	Key    string
+}
+
+// BirthDate is a YYYY-MM-DD date, empty when unknown.
+
 type CreateUserRequest struct {
-	Name    string
-	Pwd     string
-	Age     uint32
-	AddInfo string
+	Name      string
+	Pwd       string
+	Age       uint32
+	BirthDate string
+	Profile   Profile
 }
 
 type CreateUserResponse struct {
-	UserId string
+	UserId    string
+	Name      string
+	Age       uint32
+	BirthDate string
+	Profile   Profile
+	Version   int64
 }
 
 type UpdateUserRequest struct {
-	UserId  string
-	Name    string
-	Pwd     string
-	Age     uint32
-	AddInfo string
+	UserId          string
+	Name            string
+	Pwd             string
+	Age             uint32
+	BirthDate       string
+	Profile         Profile
+	ExpectedVersion int64
+	// Fields are the update mask paths to write, zero values included.
+	// When empty only the non-empty fields are written.
+	Fields []string
+}
+
+type UpdateUserResponse struct {
+	UserId        string
+	Name          string
+	Age           uint32
+	BirthDate     string
+	Profile       Profile
+	Version       int64
+	EmailVerified bool
 }
 
 type GetUserResponse struct {
-	UserId  string
-	Name    string
-	Age     uint32
-	AddInfo string
+	UserId        string
+	Name          string
+	Age           uint32
+	BirthDate     string
+	Profile       Profile
+	Version       int64
+	EmailVerified bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	LastLoginAt   time.Time
+}
+
+type GetAuditLogRequest struct {
+	UserId    string
+	PageSize  int32
+	PageToken string
+}
+
+type GetAuditLogResponse struct {
+	Entries       []repository.AuditEntry
+	NextPageToken string
 }
 
 func NewService(rep repository.UserRepository, logger log.Logger) Service {
@@ -65,7 +162,7 @@ func NewService(rep repository.UserRepository, logger log.Logger) Service {
 }
 
 func (s service) Authenticate(ctx context.Context, request AuthRequest) (AuthResponse, error) {
-	logger := log.With(s.logger, "method", "Authenticate")
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "Authenticate")
 
 	if request.Name == "" || request.Pwd == "" {
 		level.Error(logger).Log("err", erro.ErrRequiredFields("name", "password"))
@@ -81,60 +178,94 @@ func (s service) Authenticate(ctx context.Context, request AuthRequest) (AuthRes
 		return AuthResponse{}, err
 	}
 
-	return AuthResponse{UserId: res.UserId}, nil
+	return AuthResponse{UserId: res.UserId, Challenge: res.Challenge}, nil
+}
+
+func (s service) CompleteAuthChallenge(ctx context.Context, request CompleteAuthChallengeRequest) (AuthResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "CompleteAuthChallenge")
 
+	if request.Challenge == "" || request.Code == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("challenge", "code"))
+		return AuthResponse{}, erro.NewErrBadRequest(erro.ErrRequiredFields("challenge", "code"))
+	}
+
+	res, err := s.repository.CompleteAuthChallenge(ctx, request.Challenge, request.Code)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, err
+	}
+
+	return AuthResponse{UserId: res.UserId}, nil
 }
 
 func (s service) CreateUser(ctx context.Context, request CreateUserRequest) (CreateUserResponse, error) {
-	logger := log.With(s.logger, "method", "CreateUser")
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "CreateUser")
 
 	if request.Name == "" || request.Pwd == "" {
 		level.Error(logger).Log("err", erro.ErrRequiredFields("name", "password"))
 		return CreateUserResponse{}, erro.NewErrBadRequest(erro.ErrRequiredFields("name", "password"))
 	}
 
-	userId, err := s.repository.CreateUser(ctx, repository.User{
-		Name:     request.Name,
-		Password: request.Pwd,
-		Age:      request.Age,
-		AddInfo:  request.AddInfo,
+	user, err := s.repository.CreateUser(ctx, repository.User{
+		Name:      request.Name,
+		Password:  request.Pwd,
+		Age:       request.Age,
+		BirthDate: request.BirthDate,
+		Profile:   request.Profile,
 	})
 	if err != nil {
 		level.Error(logger).Log("err", err)
 		return CreateUserResponse{}, err
 	}
 
-	return CreateUserResponse{UserId: userId}, nil
+	return CreateUserResponse{
+		UserId:    user.UserId,
+		Name:      user.Name,
+		Age:       user.Age,
+		BirthDate: user.BirthDate,
+		Profile:   user.Profile,
+		Version:   user.Version,
+	}, nil
 }
 
-func (s service) UpdateUser(ctx context.Context, request UpdateUserRequest) error {
-	logger := log.With(s.logger, "method", "UpdateUser")
+func (s service) UpdateUser(ctx context.Context, request UpdateUserRequest) (UpdateUserResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "UpdateUser")
 
 	if request.UserId == "" {
 		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
-		return erro.NewErrBadRequest(erro.ErrRequiredFields("userId"))
+		return UpdateUserResponse{}, erro.NewErrBadRequest(erro.ErrRequiredFields("userId"))
 	}
-	if request.Pwd == "" && request.Age <= 0 && request.AddInfo == "" && request.Name == "" {
-		return erro.NewErrBadRequest(erro.ErrNoFieldsForUpdate)
+	if len(request.Fields) == 0 && request.Pwd == "" && request.Age <= 0 && request.BirthDate == "" && request.Profile.IsZero() && request.Name == "" {
+		return UpdateUserResponse{}, erro.NewErrBadRequest(erro.ErrNoFieldsForUpdate)
 	}
 
-	err := s.repository.UpdateUser(ctx, repository.User{
-		UserId:   request.UserId,
-		Name:     request.Name,
-		Password: request.Pwd,
-		Age:      request.Age,
-		AddInfo:  request.AddInfo,
-	})
+	user, err := s.repository.UpdateUser(ctx, repository.User{
+		UserId:    request.UserId,
+		Name:      request.Name,
+		Password:  request.Pwd,
+		Age:       request.Age,
+		BirthDate: request.BirthDate,
+		Profile:   request.Profile,
+		Version:   request.ExpectedVersion,
+	}, request.Fields)
 	if err != nil {
 		level.Error(logger).Log("err", err)
-		return err
+		return UpdateUserResponse{}, err
 	}
 
-	return nil
+	return UpdateUserResponse{
+		UserId:        user.UserId,
+		Name:          user.Name,
+		Age:           user.Age,
+		BirthDate:     user.BirthDate,
+		Profile:       user.Profile,
+		Version:       user.Version,
+		EmailVerified: user.EmailVerified,
+	}, nil
 }
 
 func (s service) GetUser(ctx context.Context, userId string) (GetUserResponse, error) {
-	logger := log.With(s.logger, "method", "UpdateUser")
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "GetUser")
 
 	if userId == "" {
 		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
@@ -148,9 +279,221 @@ func (s service) GetUser(ctx context.Context, userId string) (GetUserResponse, e
 	}
 
 	return GetUserResponse{
-		UserId:  user.UserId,
-		Name:    user.Name,
-		Age:     user.Age,
-		AddInfo: user.AddInfo,
+		UserId:        user.UserId,
+		Name:          user.Name,
+		Age:           user.Age,
+		BirthDate:     user.BirthDate,
+		Profile:       user.Profile,
+		Version:       user.Version,
+		EmailVerified: user.EmailVerified,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		LastLoginAt:   user.LastLoginAt,
 	}, nil
 }
+
+func (s service) GetAuditLog(ctx context.Context, request GetAuditLogRequest) (GetAuditLogResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "GetAuditLog")
+
+	if request.UserId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return GetAuditLogResponse{}, erro.NewErrBadRequest(erro.ErrRequiredFields("userId"))
+	}
+
+	auditLog, err := s.repository.GetAuditLog(ctx, request.UserId, request.PageSize, request.PageToken)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return GetAuditLogResponse{}, err
+	}
+
+	return GetAuditLogResponse{
+		Entries:       auditLog.Entries,
+		NextPageToken: auditLog.NextPageToken,
+	}, nil
+}
+
+func (s service) DeleteUser(ctx context.Context, userId string) error {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "DeleteUser")
+
+	if userId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return erro.NewErrBadRequest(erro.ErrRequiredFields("userId"))
+	}
+
+	if err := s.repository.DeleteUser(ctx, userId); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (s service) RestoreUser(ctx context.Context, userId string) (GetUserResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "RestoreUser")
+
+	if userId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return GetUserResponse{}, erro.NewErrBadRequest(erro.ErrRequiredFields("userId"))
+	}
+
+	user, err := s.repository.RestoreUser(ctx, userId)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return GetUserResponse{}, err
+	}
+
+	return getUserResponse(user), nil
+}
+
+func (s service) SendVerification(ctx context.Context, userId string) error {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "SendVerification")
+
+	if userId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return erro.NewErrBadRequest(erro.ErrRequiredFields("userId"))
+	}
+
+	if err := s.repository.SendVerification(ctx, userId); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (s service) VerifyEmail(ctx context.Context, token string) (GetUserResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "VerifyEmail")
+
+	if token == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("token"))
+		return GetUserResponse{}, erro.NewErrBadRequest(erro.ErrRequiredFields("token"))
+	}
+
+	user, err := s.repository.VerifyEmail(ctx, token)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return GetUserResponse{}, err
+	}
+
+	return getUserResponse(user), nil
+}
+
+func (s service) EnrollTOTP(ctx context.Context, userId string) (EnrollTOTPResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "EnrollTOTP")
+
+	if userId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return EnrollTOTPResponse{}, erro.NewErrBadRequest(erro.ErrRequiredFields("userId"))
+	}
+
+	enrollment, err := s.repository.EnrollTOTP(ctx, userId)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return EnrollTOTPResponse{}, err
+	}
+
+	return EnrollTOTPResponse{Secret: enrollment.Secret, URI: enrollment.URI}, nil
+}
+
+func (s service) ConfirmTOTP(ctx context.Context, request ConfirmTOTPRequest) (ConfirmTOTPResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "ConfirmTOTP")
+
+	if request.UserId == "" || request.Code == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId", "code"))
+		return ConfirmTOTPResponse{}, erro.NewErrBadRequest(erro.ErrRequiredFields("userId", "code"))
+	}
+
+	codes, err := s.repository.ConfirmTOTP(ctx, request.UserId, request.Code)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return ConfirmTOTPResponse{}, err
+	}
+
+	return ConfirmTOTPResponse{RecoveryCodes: codes}, nil
+}
+
+func (s service) CreateAPIKey(ctx context.Context, request CreateAPIKeyRequest) (CreateAPIKeyResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "CreateAPIKey")
+
+	if request.UserId == "" || request.Name == "" || len(request.Scopes) == 0 {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId", "name", "scopes"))
+		return CreateAPIKeyResponse{}, erro.NewErrBadRequest(erro.ErrRequiredFields("userId", "name", "scopes"))
+	}
+
+	key, secret, err := s.repository.CreateAPIKey(ctx, request.UserId, APIKey{
+		Name:      request.Name,
+		Scopes:    request.Scopes,
+		ExpiresAt: request.ExpiresAt,
+	})
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return CreateAPIKeyResponse{}, err
+	}
+
+	return CreateAPIKeyResponse{APIKey: key, Key: secret}, nil
+}
+
+func (s service) ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "ListAPIKeys")
+
+	if userId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId"))
+		return nil, erro.NewErrBadRequest(erro.ErrRequiredFields("userId"))
+	}
+
+	keys, err := s.repository.ListAPIKeys(ctx, userId)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (s service) RevokeAPIKey(ctx context.Context, userId, keyId string) error {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "RevokeAPIKey")
+
+	if userId == "" || keyId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("userId", "keyId"))
+		return erro.NewErrBadRequest(erro.ErrRequiredFields("userId", "keyId"))
+	}
+
+	if err := s.repository.RevokeAPIKey(ctx, userId, keyId); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// AuthenticateAPIKey resolves key to the user who owns it.
+func (s service) AuthenticateAPIKey(ctx context.Context, key string) (APIKeyOwner, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "AuthenticateAPIKey")
+
+	if key == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("key"))
+		return APIKeyOwner{}, erro.NewErrBadRequest(erro.ErrRequiredFields("key"))
+	}
+
+	owner, err := s.repository.AuthenticateAPIKey(ctx, key)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return APIKeyOwner{}, err
+	}
+
+	return owner, nil
+}
+
+// getUserResponse maps the users returned by RestoreUser and VerifyEmail,
+// which carry no timestamps.
+func getUserResponse(user repository.User) GetUserResponse {
+	return GetUserResponse{
+		UserId:        user.UserId,
+		Name:          user.Name,
+		Age:           user.Age,
+		BirthDate:     user.BirthDate,
+		Profile:       user.Profile,
+		Version:       user.Version,
+		EmailVerified: user.EmailVerified,
+	}
+}
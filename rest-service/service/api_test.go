@@ -5,6 +5,7 @@ import (
 	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
 
@@ -30,16 +31,16 @@ func (m *repoMock) Authenticate(ctx context.Context, req repository.User) (repos
 	return args.Get(0).(repository.User), args.Error(1)
 }
 
-func (m *repoMock) CreateUser(ctx context.Context, user repository.User) (string, error) {
+func (m *repoMock) CreateUser(ctx context.Context, user repository.User) (repository.User, error) {
 	args := m.Called(ctx, user)
 
-	return args.String(0), args.Error(1)
+	return args.Get(0).(repository.User), args.Error(1)
 }
 
-func (m *repoMock) UpdateUser(ctx context.Context, user repository.User) error {
-	args := m.Called(ctx, user)
+func (m *repoMock) UpdateUser(ctx context.Context, user repository.User, fields []string) (repository.User, error) {
+	args := m.Called(ctx, user, fields)
 
-	return args.Error(0)
+	return args.Get(0).(repository.User), args.Error(1)
 }
 
 func (m *repoMock) GetUser(ctx context.Context, userId string) (repository.User, error) {
@@ -48,6 +49,114 @@ func (m *repoMock) GetUser(ctx context.Context, userId string) (repository.User,
 	return args.Get(0).(repository.User), args.Error(1)
 }
 
+func (m *repoMock) GetAuditLog(ctx context.Context, userId string, pageSize int32, pageToken string) (repository.AuditLog, error) {
+	args := m.Called(ctx, userId, pageSize, pageToken)
+
+	return args.Get(0).(repository.AuditLog), args.Error(1)
+}
+
+func (m *repoMock) DeleteUser(ctx context.Context, userId string) error {
+	args := m.Called(ctx, userId)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) RestoreUser(ctx context.Context, userId string) (repository.User, error) {
+	args := m.Called(ctx, userId)
+
+	return args.Get(0).(repository.User), args.Error(1)
+}
+
+func (m *repoMock) SendVerification(ctx context.Context, userId string) error {
+	args := m.Called(ctx, userId)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) VerifyEmail(ctx context.Context, token string) (repository.User, error) {
+	args := m.Called(ctx, token)
+
+	return args.Get(0).(repository.User), args.Error(1)
+}
+
+func (m *repoMock) CompleteAuthChallenge(ctx context.Context, challenge, code string) (repository.User, error) {
+	args := m.Called(ctx, challenge, code)
+
+	return args.Get(0).(repository.User), args.Error(1)
+}
+
+func (m *repoMock) EnrollTOTP(ctx context.Context, userId string) (repository.TOTPEnrollment, error) {
+	args := m.Called(ctx, userId)
+
+	return args.Get(0).(repository.TOTPEnrollment), args.Error(1)
+}
+
+func (m *repoMock) ConfirmTOTP(ctx context.Context, userId, code string) ([]string, error) {
+	args := m.Called(ctx, userId, code)
+
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *repoMock) CreateAPIKey(ctx context.Context, userId string, key repository.APIKey) (repository.APIKey, string, error) {
+	args := m.Called(ctx, userId, key)
+
+	return args.Get(0).(repository.APIKey), args.String(1), args.Error(2)
+}
+
+func (m *repoMock) ListAPIKeys(ctx context.Context, userId string) ([]repository.APIKey, error) {
+	args := m.Called(ctx, userId)
+
+	return args.Get(0).([]repository.APIKey), args.Error(1)
+}
+
+func (m *repoMock) RevokeAPIKey(ctx context.Context, userId, keyId string) error {
+	args := m.Called(ctx, userId, keyId)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) AuthenticateAPIKey(ctx context.Context, key string) (repository.APIKeyOwner, error) {
+	args := m.Called(ctx, key)
+
+	return args.Get(0).(repository.APIKeyOwner), args.Error(1)
+}
+
+func (m *repoMock) GetOAuthClient(ctx context.Context, clientId string) (repository.OAuthClient, error) {
+	args := m.Called(ctx, clientId)
+
+	return args.Get(0).(repository.OAuthClient), args.Error(1)
+}
+
+func (m *repoMock) CreateAuthorizationCode(ctx context.Context, code repository.AuthorizationCode) (string, error) {
+	args := m.Called(ctx, code)
+
+	return args.String(0), args.Error(1)
+}
+
+func (m *repoMock) ExchangeAuthorizationCode(ctx context.Context, exchange repository.CodeExchange) (repository.AuthorizationCode, error) {
+	args := m.Called(ctx, exchange)
+
+	return args.Get(0).(repository.AuthorizationCode), args.Error(1)
+}
+
+func (m *repoMock) LinkIdentity(ctx context.Context, userId string, identity repository.Identity) error {
+	args := m.Called(ctx, userId, identity)
+
+	return args.Error(0)
+}
+
+func (m *repoMock) ProvisionIdentity(ctx context.Context, identity repository.Identity, claims repository.IdentityClaims) (repository.User, error) {
+	args := m.Called(ctx, identity, claims)
+
+	return args.Get(0).(repository.User), args.Error(1)
+}
+
+func (m *repoMock) AuthenticateIdentity(ctx context.Context, identity repository.Identity) (repository.User, error) {
+	args := m.Called(ctx, identity)
+
+	return args.Get(0).(repository.User), args.Error(1)
+}
+
 func TestAuthenticate(t *testing.T) {
 	var logger log.Logger
 	{
@@ -91,6 +200,21 @@ func TestAuthenticate(t *testing.T) {
 				assert.NoError(t, resError)
 			},
 		},
+		{
+			testName: "two-factor challenge",
+			userName: "javi",
+			userPwd:  "javier123",
+			request: func(name, pwd string) AuthRequest {
+				return AuthRequest{Name: name, Pwd: pwd}
+			},
+			repoResponse: func(userId string, pwdHash []byte) (repository.User, error) {
+				return repository.User{Challenge: "the-challenge"}, nil
+			},
+			checkResponse: func(t *testing.T, userId string, response AuthResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, AuthResponse{Challenge: "the-challenge"}, response)
+			},
+		},
 		{
 			testName: "user not found",
 			userName: "reivaj",
@@ -189,11 +313,11 @@ func TestCreateUser(t *testing.T) {
 			Name    string
 			Pwd     string
 			Age     uint32
-			AddInfo string
+			Profile Profile
 		}
 		userId        string
-		request       func(name, pwd, addInfo string, age uint32) CreateUserRequest
-		repoResponse  func(userId string) (string, error)
+		request       func(name, pwd string, profile Profile, age uint32) CreateUserRequest
+		repoResponse  func(userId string) (repository.User, error)
 		checkResponse func(t *testing.T, userId string, response CreateUserResponse, resError error)
 	}{
 		{
@@ -202,23 +326,23 @@ func TestCreateUser(t *testing.T) {
 				Name    string
 				Pwd     string
 				Age     uint32
-				AddInfo string
+				Profile Profile
 			}{
-				"javier", "javier123", 45, ""},
+				"javier", "javier123", 45, Profile{}},
 			userId: utils.RandomString(12),
-			request: func(name, pwd, addInfo string, age uint32) CreateUserRequest {
+			request: func(name, pwd string, profile Profile, age uint32) CreateUserRequest {
 				return CreateUserRequest{
 					Name:    name,
 					Pwd:     pwd,
 					Age:     age,
-					AddInfo: addInfo,
+					Profile: profile,
 				}
 			},
-			repoResponse: func(userId string) (string, error) {
-				return userId, nil
+			repoResponse: func(userId string) (repository.User, error) {
+				return repository.User{UserId: userId, Name: "javier", Age: 45}, nil
 			},
 			checkResponse: func(t *testing.T, userId string, response CreateUserResponse, resError error) {
-				assert.Equal(t, response.UserId, userId)
+				assert.Equal(t, CreateUserResponse{UserId: userId, Name: "javier", Age: 45}, response)
 				assert.NoError(t, resError)
 			},
 		},
@@ -228,20 +352,20 @@ func TestCreateUser(t *testing.T) {
 				Name    string
 				Pwd     string
 				Age     uint32
-				AddInfo string
+				Profile Profile
 			}{
-				"", "javier123", 45, ""},
+				"", "javier123", 45, Profile{}},
 			userId: utils.RandomString(12),
-			request: func(name, pwd, addInfo string, age uint32) CreateUserRequest {
+			request: func(name, pwd string, profile Profile, age uint32) CreateUserRequest {
 				return CreateUserRequest{
 					Name:    name,
 					Pwd:     pwd,
 					Age:     age,
-					AddInfo: addInfo,
+					Profile: profile,
 				}
 			},
-			repoResponse: func(userId string) (string, error) {
-				return "", nil
+			repoResponse: func(userId string) (repository.User, error) {
+				return repository.User{}, nil
 			},
 			checkResponse: func(t *testing.T, userId string, response CreateUserResponse, resError error) {
 				assert.Empty(t, response)
@@ -254,20 +378,20 @@ func TestCreateUser(t *testing.T) {
 				Name    string
 				Pwd     string
 				Age     uint32
-				AddInfo string
+				Profile Profile
 			}{
-				"javier", "", 45, ""},
+				"javier", "", 45, Profile{}},
 			userId: utils.RandomString(12),
-			request: func(name, pwd, addInfo string, age uint32) CreateUserRequest {
+			request: func(name, pwd string, profile Profile, age uint32) CreateUserRequest {
 				return CreateUserRequest{
 					Name:    name,
 					Pwd:     pwd,
 					Age:     age,
-					AddInfo: addInfo,
+					Profile: profile,
 				}
 			},
-			repoResponse: func(userId string) (string, error) {
-				return "", nil
+			repoResponse: func(userId string) (repository.User, error) {
+				return repository.User{}, nil
 			},
 			checkResponse: func(t *testing.T, userId string, response CreateUserResponse, resError error) {
 				assert.Empty(t, response)
@@ -280,15 +404,15 @@ func TestCreateUser(t *testing.T) {
 		tc := testCases[i]
 		t.Run(tc.testName, func(t *testing.T) {
 			ctx := context.Background()
-			userId, err := tc.repoResponse(tc.userId)
+			user, err := tc.repoResponse(tc.userId)
 			repoSvc.On("CreateUser", ctx, repository.User{
 				Name:     tc.userData.Name,
 				Password: tc.userData.Pwd,
 				Age:      tc.userData.Age,
-				AddInfo:  tc.userData.AddInfo,
+				Profile:  tc.userData.Profile,
 			}).
-				Return(userId, err)
-			res, err := service.CreateUser(ctx, tc.request(tc.userData.Name, tc.userData.Pwd, tc.userData.AddInfo, tc.userData.Age))
+				Return(user, err)
+			res, err := service.CreateUser(ctx, tc.request(tc.userData.Name, tc.userData.Pwd, tc.userData.Profile, tc.userData.Age))
 			tc.checkResponse(t, tc.userId, res, err)
 		})
 	}
@@ -314,7 +438,7 @@ func TestGetUser(t *testing.T) {
 		UserId:  utils.RandomString(12),
 		Name:    "javier",
 		Age:     37,
-		AddInfo: "some additional info",
+		Profile: Profile{Email: "javier@example.com", Metadata: map[string]string{"add_info": "info"}},
 	}
 
 	testCases := []struct {
@@ -330,14 +454,14 @@ func TestGetUser(t *testing.T) {
 					UserId:  userId,
 					Name:    user.Name,
 					Age:     user.Age,
-					AddInfo: user.AddInfo,
+					Profile: user.Profile,
 				}, nil
 			},
 			checkResponse: func(t *testing.T, res GetUserResponse, resError error) {
 				assert.Equal(t, user.UserId, res.UserId)
 				assert.Equal(t, user.Name, res.Name)
 				assert.Equal(t, user.Age, res.Age)
-				assert.Equal(t, user.AddInfo, res.AddInfo)
+				assert.Equal(t, user.Profile, res.Profile)
 				assert.NoError(t, resError)
 			},
 		},
@@ -389,12 +513,12 @@ func TestUpdateUser(t *testing.T) {
 			Name    string
 			Pwd     string
 			Age     uint32
-			AddInfo string
+			Profile Profile
 		}
 		userId        string
-		request       func(userId, name, pwd, addInfo string, age uint32) UpdateUserRequest
+		request       func(userId, name, pwd string, profile Profile, age uint32) UpdateUserRequest
 		repoResponse  func() error
-		checkResponse func(t *testing.T, resError error)
+		checkResponse func(t *testing.T, userId string, response UpdateUserResponse, resError error)
 	}{
 		{
 			testName: "user updated",
@@ -403,22 +527,28 @@ func TestUpdateUser(t *testing.T) {
 				Name    string
 				Pwd     string
 				Age     uint32
-				AddInfo string
+				Profile Profile
 			}{
-				utils.RandomString(12), "javier", "javier123", 45, "Some additional info"},
-			request: func(userId, name, pwd, addInfo string, age uint32) UpdateUserRequest {
+				utils.RandomString(12), "javier", "javier123", 45, Profile{DisplayName: "Javier B", Metadata: map[string]string{"add_info": "info"}}},
+			request: func(userId, name, pwd string, profile Profile, age uint32) UpdateUserRequest {
 				return UpdateUserRequest{
 					UserId:  userId,
 					Name:    name,
 					Pwd:     pwd,
 					Age:     age,
-					AddInfo: addInfo,
+					Profile: profile,
 				}
 			},
 			repoResponse: func() error {
 				return nil
 			},
-			checkResponse: func(t *testing.T, resError error) {
+			checkResponse: func(t *testing.T, userId string, response UpdateUserResponse, resError error) {
+				assert.Equal(t, UpdateUserResponse{
+					UserId:  userId,
+					Name:    "javier",
+					Age:     45,
+					Profile: Profile{DisplayName: "Javier B", Metadata: map[string]string{"add_info": "info"}},
+				}, response)
 				assert.NoError(t, resError)
 			},
 		},
@@ -429,20 +559,21 @@ func TestUpdateUser(t *testing.T) {
 				Name    string
 				Pwd     string
 				Age     uint32
-				AddInfo string
+				Profile Profile
 			}{
-				"", "javier", "javier123", 45, ""},
-			request: func(userId, name, pwd, addInfo string, age uint32) UpdateUserRequest {
+				"", "javier", "javier123", 45, Profile{}},
+			request: func(userId, name, pwd string, profile Profile, age uint32) UpdateUserRequest {
 				return UpdateUserRequest{
 					UserId:  userId,
 					Name:    name,
 					Pwd:     pwd,
 					Age:     age,
-					AddInfo: addInfo,
+					Profile: profile,
 				}
 			},
 			repoResponse: nil,
-			checkResponse: func(t *testing.T, resError error) {
+			checkResponse: func(t *testing.T, userId string, response UpdateUserResponse, resError error) {
+				assert.Empty(t, response)
 				assert.EqualError(t, resError, erro.ErrRequiredFields("userId"))
 			},
 		},
@@ -453,20 +584,21 @@ func TestUpdateUser(t *testing.T) {
 				Name    string
 				Pwd     string
 				Age     uint32
-				AddInfo string
+				Profile Profile
 			}{
-				utils.RandomString(12), "", "", 0, ""},
-			request: func(userId, name, pwd, addInfo string, age uint32) UpdateUserRequest {
+				utils.RandomString(12), "", "", 0, Profile{}},
+			request: func(userId, name, pwd string, profile Profile, age uint32) UpdateUserRequest {
 				return UpdateUserRequest{
 					UserId:  userId,
 					Name:    name,
 					Pwd:     pwd,
 					Age:     age,
-					AddInfo: addInfo,
+					Profile: profile,
 				}
 			},
 			repoResponse: nil,
-			checkResponse: func(t *testing.T, resError error) {
+			checkResponse: func(t *testing.T, userId string, response UpdateUserResponse, resError error) {
+				assert.Empty(t, response)
 				assert.EqualError(t, resError, erro.ErrNoFieldsForUpdate)
 			},
 		},
@@ -483,12 +615,549 @@ func TestUpdateUser(t *testing.T) {
 					Name:     tc.userData.Name,
 					Password: tc.userData.Pwd,
 					Age:      tc.userData.Age,
-					AddInfo:  tc.userData.AddInfo,
-				}).
-					Return(err)
+					Profile:  tc.userData.Profile,
+				}, []string(nil)).
+					Return(repository.User{
+						UserId:  tc.userData.UserId,
+						Name:    tc.userData.Name,
+						Age:     tc.userData.Age,
+						Profile: tc.userData.Profile,
+					}, err)
 			}
-			err := service.UpdateUser(ctx, tc.request(tc.userData.UserId, tc.userData.Name, tc.userData.Pwd, tc.userData.AddInfo, tc.userData.Age))
+			res, err := service.UpdateUser(ctx, tc.request(tc.userData.UserId, tc.userData.Name, tc.userData.Pwd, tc.userData.Profile, tc.userData.Age))
+			tc.checkResponse(t, tc.userData.UserId, res, err)
+		})
+	}
+}
+
+func TestGetAuditLog(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	testCases := []struct {
+		testName      string
+		request       GetAuditLogRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, res GetAuditLogResponse, resError error)
+	}{
+		{
+			testName: "audit log obtained",
+			request:  GetAuditLogRequest{UserId: userId, PageSize: 1, PageToken: "Mg"},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetAuditLog", mock.Anything, userId, int32(1), "Mg").
+					Return(repository.AuditLog{
+						Entries:       []repository.AuditEntry{{Id: 1, Action: "user.create", Target: userId}},
+						NextPageToken: "MQ",
+					}, nil)
+			},
+			checkResponse: func(t *testing.T, res GetAuditLogResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Len(t, res.Entries, 1)
+				assert.Equal(t, "MQ", res.NextPageToken)
+			},
+		},
+		{
+			testName:   "user id empty",
+			request:    GetAuditLogRequest{},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, res GetAuditLogResponse, resError error) {
+				assert.Empty(t, res)
+				assert.EqualError(t, resError, erro.ErrRequiredFields("userId"))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			res, err := NewService(repo, logger).GetAuditLog(context.Background(), tc.request)
+			tc.checkResponse(t, res, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, resError error)
+	}{
+		{
+			testName: "user deleted",
+			userId:   userId,
+			buildStubs: func(repo *repoMock) {
+				repo.On("DeleteUser", mock.Anything, userId).Return(nil)
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "user not found",
+			userId:   userId,
+			buildStubs: func(repo *repoMock) {
+				repo.On("DeleteUser", mock.Anything, userId).Return(erro.ErrNotFound{Err: errors.New("user not found")})
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.IsType(t, erro.ErrNotFound{}, resError)
+			},
+		},
+		{
+			testName:   "user id empty",
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.EqualError(t, resError, erro.ErrRequiredFields("userId"))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			err := NewService(repo, logger).DeleteUser(context.Background(), tc.userId)
+			tc.checkResponse(t, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, res GetUserResponse, resError error)
+	}{
+		{
+			testName: "user restored",
+			userId:   userId,
+			buildStubs: func(repo *repoMock) {
+				repo.On("RestoreUser", mock.Anything, userId).
+					Return(repository.User{UserId: userId, Name: "javier", Version: 3}, nil)
+			},
+			checkResponse: func(t *testing.T, res GetUserResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, GetUserResponse{UserId: userId, Name: "javier", Version: 3}, res)
+			},
+		},
+		{
+			testName: "grace period expired",
+			userId:   userId,
+			buildStubs: func(repo *repoMock) {
+				repo.On("RestoreUser", mock.Anything, userId).
+					Return(repository.User{}, erro.ErrPreconditionFailed{Err: errors.New("restore grace period has expired")})
+			},
+			checkResponse: func(t *testing.T, res GetUserResponse, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrPreconditionFailed{}, resError)
+			},
+		},
+		{
+			testName:   "user id empty",
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, res GetUserResponse, resError error) {
+				assert.Empty(t, res)
+				assert.EqualError(t, resError, erro.ErrRequiredFields("userId"))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			res, err := NewService(repo, logger).RestoreUser(context.Background(), tc.userId)
+			tc.checkResponse(t, res, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSendVerification(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, resError error)
+	}{
+		{
+			testName: "verification sent",
+			userId:   userId,
+			buildStubs: func(repo *repoMock) {
+				repo.On("SendVerification", mock.Anything, userId).Return(nil)
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.NoError(t, resError)
+			},
+		},
+		{
+			testName: "user has no email",
+			userId:   userId,
+			buildStubs: func(repo *repoMock) {
+				repo.On("SendVerification", mock.Anything, userId).Return(erro.ErrPreconditionFailed{Err: errors.New("user has no email address")})
+			},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.IsType(t, erro.ErrPreconditionFailed{}, resError)
+			},
+		},
+		{
+			testName:   "user id empty",
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, resError error) {
+				assert.EqualError(t, resError, erro.ErrRequiredFields("userId"))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			err := NewService(repo, logger).SendVerification(context.Background(), tc.userId)
 			tc.checkResponse(t, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	testCases := []struct {
+		testName      string
+		token         string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, res GetUserResponse, resError error)
+	}{
+		{
+			testName: "email verified",
+			token:    "the-token",
+			buildStubs: func(repo *repoMock) {
+				repo.On("VerifyEmail", mock.Anything, "the-token").
+					Return(repository.User{UserId: userId, Name: "javier", EmailVerified: true, Version: 2}, nil)
+			},
+			checkResponse: func(t *testing.T, res GetUserResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, GetUserResponse{UserId: userId, Name: "javier", EmailVerified: true, Version: 2}, res)
+			},
+		},
+		{
+			testName: "invalid token",
+			token:    "other",
+			buildStubs: func(repo *repoMock) {
+				repo.On("VerifyEmail", mock.Anything, "other").
+					Return(repository.User{}, erro.NewErrBadRequest("invalid or expired verification token"))
+			},
+			checkResponse: func(t *testing.T, res GetUserResponse, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrBadRequest{}, resError)
+			},
+		},
+		{
+			testName:   "token empty",
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, res GetUserResponse, resError error) {
+				assert.Empty(t, res)
+				assert.EqualError(t, resError, erro.ErrRequiredFields("token"))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			res, err := NewService(repo, logger).VerifyEmail(context.Background(), tc.token)
+			tc.checkResponse(t, res, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCompleteAuthChallenge(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	testCases := []struct {
+		testName      string
+		request       CompleteAuthChallengeRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, res AuthResponse, resError error)
+	}{
+		{
+			testName: "challenge completed",
+			request:  CompleteAuthChallengeRequest{Challenge: "the-challenge", Code: "123456"},
+			buildStubs: func(repo *repoMock) {
+				repo.On("CompleteAuthChallenge", mock.Anything, "the-challenge", "123456").Return(repository.User{UserId: userId}, nil)
+			},
+			checkResponse: func(t *testing.T, res AuthResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, AuthResponse{UserId: userId}, res)
+			},
+		},
+		{
+			testName: "wrong code",
+			request:  CompleteAuthChallengeRequest{Challenge: "the-challenge", Code: "654321"},
+			buildStubs: func(repo *repoMock) {
+				repo.On("CompleteAuthChallenge", mock.Anything, "the-challenge", "654321").
+					Return(repository.User{}, erro.ErrForbidden{Err: errors.New("wrong or already used two-factor code")})
+			},
+			checkResponse: func(t *testing.T, res AuthResponse, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrForbidden{}, resError)
+			},
+		},
+		{
+			testName:   "code empty",
+			request:    CompleteAuthChallengeRequest{Challenge: "the-challenge"},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, res AuthResponse, resError error) {
+				assert.EqualError(t, resError, erro.ErrRequiredFields("challenge", "code"))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			res, err := NewService(repo, logger).CompleteAuthChallenge(context.Background(), tc.request)
+			tc.checkResponse(t, res, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestEnrollTOTP(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	testCases := []struct {
+		testName      string
+		userId        string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, res EnrollTOTPResponse, resError error)
+	}{
+		{
+			testName: "enrolled",
+			userId:   userId,
+			buildStubs: func(repo *repoMock) {
+				repo.On("EnrollTOTP", mock.Anything, userId).
+					Return(repository.TOTPEnrollment{Secret: "SECRET", URI: "otpauth://totp/final-project:javier?secret=SECRET"}, nil)
+			},
+			checkResponse: func(t *testing.T, res EnrollTOTPResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, EnrollTOTPResponse{Secret: "SECRET", URI: "otpauth://totp/final-project:javier?secret=SECRET"}, res)
+			},
+		},
+		{
+			testName: "already enabled",
+			userId:   userId,
+			buildStubs: func(repo *repoMock) {
+				repo.On("EnrollTOTP", mock.Anything, userId).
+					Return(repository.TOTPEnrollment{}, erro.ErrPreconditionFailed{Err: errors.New("two-factor authentication is already enabled")})
+			},
+			checkResponse: func(t *testing.T, res EnrollTOTPResponse, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrPreconditionFailed{}, resError)
+			},
+		},
+		{
+			testName:   "user id empty",
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, res EnrollTOTPResponse, resError error) {
+				assert.EqualError(t, resError, erro.ErrRequiredFields("userId"))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			res, err := NewService(repo, logger).EnrollTOTP(context.Background(), tc.userId)
+			tc.checkResponse(t, res, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestConfirmTOTP(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	testCases := []struct {
+		testName      string
+		request       ConfirmTOTPRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, res ConfirmTOTPResponse, resError error)
+	}{
+		{
+			testName: "confirmed",
+			request:  ConfirmTOTPRequest{UserId: userId, Code: "123456"},
+			buildStubs: func(repo *repoMock) {
+				repo.On("ConfirmTOTP", mock.Anything, userId, "123456").Return([]string{"abcde-fghij"}, nil)
+			},
+			checkResponse: func(t *testing.T, res ConfirmTOTPResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, []string{"abcde-fghij"}, res.RecoveryCodes)
+			},
+		},
+		{
+			testName: "wrong code",
+			request:  ConfirmTOTPRequest{UserId: userId, Code: "654321"},
+			buildStubs: func(repo *repoMock) {
+				repo.On("ConfirmTOTP", mock.Anything, userId, "654321").
+					Return([]string(nil), erro.ErrForbidden{Err: errors.New("wrong or already used two-factor code")})
+			},
+			checkResponse: func(t *testing.T, res ConfirmTOTPResponse, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrForbidden{}, resError)
+			},
+		},
+		{
+			testName:   "code empty",
+			request:    ConfirmTOTPRequest{UserId: userId},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, res ConfirmTOTPResponse, resError error) {
+				assert.EqualError(t, resError, erro.ErrRequiredFields("userId", "code"))
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			res, err := NewService(repo, logger).ConfirmTOTP(context.Background(), tc.request)
+			tc.checkResponse(t, res, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+	expiresAt := time.Date(2026, 8, 8, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		testName      string
+		request       CreateAPIKeyRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, res CreateAPIKeyResponse, resError error)
+	}{
+		{
+			testName: "created",
+			request:  CreateAPIKeyRequest{UserId: userId, Name: "batch", Scopes: []string{"users:read"}, ExpiresAt: expiresAt},
+			buildStubs: func(repo *repoMock) {
+				repo.On("CreateAPIKey", mock.Anything, userId, repository.APIKey{Name: "batch", Scopes: []string{"users:read"}, ExpiresAt: expiresAt}).
+					Return(repository.APIKey{Id: "k1", Name: "batch", Scopes: []string{"users:read"}, ExpiresAt: expiresAt}, "fpk_k1_secret", nil)
+			},
+			checkResponse: func(t *testing.T, res CreateAPIKeyResponse, resError error) {
+				assert.NoError(t, resError)
+				assert.Equal(t, "fpk_k1_secret", res.Key)
+				assert.Equal(t, "k1", res.APIKey.Id)
+			},
+		},
+		{
+			testName:   "scopes empty",
+			request:    CreateAPIKeyRequest{UserId: userId, Name: "batch"},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, res CreateAPIKeyResponse, resError error) {
+				assert.IsType(t, erro.ErrBadRequest{}, resError)
+				assert.EqualError(t, resError, erro.ErrRequiredFields("userId", "name", "scopes"))
+			},
+		},
+		{
+			testName: "user not found",
+			request:  CreateAPIKeyRequest{UserId: userId, Name: "batch", Scopes: []string{"users:read"}},
+			buildStubs: func(repo *repoMock) {
+				repo.On("CreateAPIKey", mock.Anything, userId, mock.Anything).
+					Return(repository.APIKey{}, "", erro.ErrNotFound{Err: errors.New("user not found")})
+			},
+			checkResponse: func(t *testing.T, res CreateAPIKeyResponse, resError error) {
+				assert.Empty(t, res)
+				assert.IsType(t, erro.ErrNotFound{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			res, err := NewService(repo, logger).CreateAPIKey(context.Background(), tc.request)
+			tc.checkResponse(t, res, err)
+			repo.AssertExpectations(t)
 		})
 	}
 }
+
+func TestRevokeAPIKey(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	repo := new(repoMock)
+	repo.On("RevokeAPIKey", mock.Anything, userId, "k1").Return(nil)
+	repo.On("RevokeAPIKey", mock.Anything, userId, "k2").Return(erro.ErrNotFound{Err: errors.New("API key not found")})
+	svc := NewService(repo, logger)
+
+	assert.NoError(t, svc.RevokeAPIKey(context.Background(), userId, "k1"))
+	assert.IsType(t, erro.ErrNotFound{}, svc.RevokeAPIKey(context.Background(), userId, "k2"))
+	assert.EqualError(t, svc.RevokeAPIKey(context.Background(), userId, ""), erro.ErrRequiredFields("userId", "keyId"))
+	repo.AssertExpectations(t)
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	logger := log.NewNopLogger()
+	userId := utils.RandomString(12)
+
+	repo := new(repoMock)
+	repo.On("AuthenticateAPIKey", mock.Anything, "fpk_k1_secret").Return(repository.APIKeyOwner{UserId: userId, Scopes: []string{"users:read"}}, nil)
+	repo.On("AuthenticateAPIKey", mock.Anything, "fpk_k1_other").Return(repository.APIKeyOwner{}, erro.ErrForbidden{Err: errors.New("invalid, expired or revoked API key")})
+	svc := NewService(repo, logger)
+
+	owner, err := svc.AuthenticateAPIKey(context.Background(), "fpk_k1_secret")
+	assert.NoError(t, err)
+	assert.Equal(t, APIKeyOwner{UserId: userId, Scopes: []string{"users:read"}}, owner)
+
+	_, err = svc.AuthenticateAPIKey(context.Background(), "fpk_k1_other")
+	assert.IsType(t, erro.ErrForbidden{}, err)
+
+	_, err = svc.AuthenticateAPIKey(context.Background(), "")
+	assert.IsType(t, erro.ErrBadRequest{}, err)
+	repo.AssertExpectations(t)
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/logging"
+	"github.com/javibauza/final-project/rest-service/oidc"
+	"github.com/javibauza/final-project/rest-service/repository"
+)
+
+// FederationService logs users in with an ID token of an external OpenID
+// Connect provider, linking the account of the provider to a local user the
+// first time it logs in.
+type FederationService interface {
+	Login(ctx context.Context, idToken string) (AuthResponse, error)
+}
+
+type FederationConfig struct {
+	// Issuer is the provider's issuer. Federated login is disabled when it
+	// is empty.
+	Issuer string
+	// ClientId is the audience the provider issues ID tokens for.
+	ClientId string
+}
+
+// TokenVerifier verifies tokens with the keys of a provider, as
+// oidc.KeySet does.
+type TokenVerifier interface {
+	Verify(ctx context.Context, token, typ string, claims interface{}) error
+}
+
+type federationService struct {
+	repository repository.UserRepository
+	keys       TokenVerifier
+	cfg        FederationConfig
+	logger     log.Logger
+	now        func() time.Time
+}
+
+// idTokenClaims are the claims of an external provider's ID token the
+// federated user is linked or created with.
+type idTokenClaims struct {
+	oidc.Claims
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// NewFederationService returns a FederationService verifying ID tokens with
+// keys.
+func NewFederationService(rep repository.UserRepository, keys TokenVerifier, cfg FederationConfig, logger log.Logger) FederationService {
+	return &federationService{
+		repository: rep,
+		keys:       keys,
+		cfg:        cfg,
+		logger:     logger,
+		now:        time.Now,
+	}
+}
+
+// Login verifies idToken and logs in the user its subject is linked to. A
+// subject that is not linked yet is linked to the user with its verified
+// email address, or else to a new user; an address of a user it can't be
+// linked to fails with ErrConflict. Invalid tokens fail with ErrUnauthorized,
+// and any token fails with ErrUnavailable while the provider's keys can't be
+// fetched. The provider only stands in for the password: users with
+// two-factor authentication enabled get a Challenge, as from Authenticate.
+func (s federationService) Login(ctx context.Context, idToken string) (AuthResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "FederatedLogin")
+
+	if s.cfg.Issuer == "" {
+		level.Error(logger).Log("err", erro.ErrFederationDisabled)
+		return AuthResponse{}, erro.ErrNotFound{Err: errors.New(erro.ErrFederationDisabled)}
+	}
+	if idToken == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("id_token"))
+		return AuthResponse{}, erro.NewErrBadRequest(erro.ErrRequiredFields("id_token"))
+	}
+
+	var claims idTokenClaims
+	err := s.keys.Verify(ctx, idToken, oidc.TypeIDToken, &claims)
+	if err == nil {
+		err = claims.Validate(s.cfg.Issuer, s.cfg.ClientId, s.now())
+	}
+	if err == nil && claims.Subject == "" {
+		err = oidc.ErrMalformedToken
+	}
+	if errors.Is(err, oidc.ErrKeysUnavailable) {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, erro.ErrUnavailable{Err: errors.New(erro.ErrIdPUnavailable)}
+	}
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, erro.ErrUnauthorized{Err: errors.New(erro.ErrInvalidIDToken)}
+	}
+
+	identity := repository.Identity{Issuer: claims.Issuer, Subject: claims.Subject}
+	user, err := s.repository.AuthenticateIdentity(ctx, identity)
+	if err == nil {
+		return AuthResponse{UserId: user.UserId, Challenge: user.Challenge}, nil
+	}
+	if _, notFound := err.(erro.ErrNotFound); !notFound {
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, err
+	}
+
+	_, provErr := s.repository.ProvisionIdentity(ctx, identity, repository.IdentityClaims{
+		DisplayName:   claims.Name,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	})
+	if _, conflict := provErr.(erro.ErrConflict); provErr != nil && !conflict {
+		level.Error(logger).Log("err", provErr.Error())
+		return AuthResponse{}, provErr
+	}
+	// A conflict may be another login of the same account linking it first,
+	// so the identity is looked up again either way.
+	user, err = s.repository.AuthenticateIdentity(ctx, identity)
+	if err != nil {
+		if provErr != nil {
+			err = provErr
+		}
+		level.Error(logger).Log("err", err.Error())
+		return AuthResponse{}, err
+	}
+	if provErr == nil {
+		level.Info(logger).Log("msg", "federated identity linked", "userId", user.UserId, "issuer", identity.Issuer)
+	}
+
+	return AuthResponse{UserId: user.UserId, Challenge: user.Challenge}, nil
+}
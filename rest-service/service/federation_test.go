@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/oidc"
+	"github.com/javibauza/final-project/rest-service/repository"
+)
+
+const (
+	testIdP         = "https://idp.example.com"
+	testIdPClientId = "user-api"
+)
+
+// newTestIdP returns the signer of a provider whose JWK set is served by a
+// test server, and a key set fetching it.
+func newTestIdP(t *testing.T) (*oidc.Signer, *oidc.KeySet) {
+	key, err := oidc.GenerateKey()
+	require.NoError(t, err)
+	signer := oidc.NewSigner(key)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(signer.JWKS())
+	}))
+	t.Cleanup(server.Close)
+	return signer, oidc.NewKeySet(server.URL, server.Client(), time.Minute)
+}
+
+func TestFederatedLogin(t *testing.T) {
+	signer, keys := newTestIdP(t)
+	claims := idTokenClaims{
+		Claims: oidc.Claims{
+			Issuer:    testIdP,
+			Subject:   "248289761001",
+			Audience:  oidc.Audience{testIdPClientId},
+			ExpiresAt: oidcNow.Add(time.Hour).Unix(),
+			IssuedAt:  oidcNow.Unix(),
+		},
+		Name:          "Javier Bauza",
+		Email:         "javier@example.com",
+		EmailVerified: true,
+	}
+	sign := func(t *testing.T, mutate func(c *idTokenClaims)) string {
+		c := claims
+		if mutate != nil {
+			mutate(&c)
+		}
+		token, err := signer.Sign(oidc.TypeIDToken, c)
+		require.NoError(t, err)
+		return token
+	}
+	identity := repository.Identity{Issuer: testIdP, Subject: "248289761001"}
+	identityClaims := repository.IdentityClaims{DisplayName: "Javier Bauza", Email: "javier@example.com", EmailVerified: true}
+	notLinked := erro.ErrNotFound{Err: errors.New("identity is not linked to a user")}
+
+	testCases := []struct {
+		testName      string
+		disabled      bool
+		idpDown       bool
+		idToken       func(t *testing.T) string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, repo *repoMock, response AuthResponse, resError error)
+	}{
+		{
+			testName: "linked user",
+			idToken:  func(t *testing.T) string { return sign(t, nil) },
+			buildStubs: func(repo *repoMock) {
+				repo.On("AuthenticateIdentity", mock.Anything, identity).Return(repository.User{UserId: "u1"}, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthResponse{UserId: "u1"}, response)
+				repo.AssertNotCalled(t, "ProvisionIdentity", mock.Anything, mock.Anything, mock.Anything)
+			},
+		},
+		{
+			testName: "linked user with two-factor authentication",
+			idToken:  func(t *testing.T) string { return sign(t, nil) },
+			buildStubs: func(repo *repoMock) {
+				repo.On("AuthenticateIdentity", mock.Anything, identity).Return(repository.User{Challenge: "the-challenge"}, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthResponse{Challenge: "the-challenge"}, response, "the user is not logged in yet")
+			},
+		},
+		{
+			testName: "first login links a user",
+			idToken:  func(t *testing.T) string { return sign(t, nil) },
+			buildStubs: func(repo *repoMock) {
+				repo.On("AuthenticateIdentity", mock.Anything, identity).Return(repository.User{}, notLinked).Once()
+				repo.On("ProvisionIdentity", mock.Anything, identity, identityClaims).Return(repository.User{UserId: "u2"}, nil)
+				repo.On("AuthenticateIdentity", mock.Anything, identity).Return(repository.User{UserId: "u2"}, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthResponse{UserId: "u2"}, response)
+			},
+		},
+		{
+			testName: "concurrent first login",
+			idToken:  func(t *testing.T) string { return sign(t, nil) },
+			buildStubs: func(repo *repoMock) {
+				repo.On("AuthenticateIdentity", mock.Anything, identity).Return(repository.User{}, notLinked).Once()
+				repo.On("ProvisionIdentity", mock.Anything, identity, identityClaims).
+					Return(repository.User{}, erro.ErrConflict{Err: errors.New("identity is already linked to a user")})
+				repo.On("AuthenticateIdentity", mock.Anything, identity).Return(repository.User{UserId: "u1"}, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthResponse{UserId: "u1"}, response)
+			},
+		},
+		{
+			testName: "email of an account that can't be linked",
+			idToken:  func(t *testing.T) string { return sign(t, nil) },
+			buildStubs: func(repo *repoMock) {
+				repo.On("AuthenticateIdentity", mock.Anything, identity).Return(repository.User{}, notLinked)
+				repo.On("ProvisionIdentity", mock.Anything, identity, identityClaims).
+					Return(repository.User{}, erro.ErrConflict{Err: errors.New("email address belongs to another account that can't be linked automatically")})
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				require.IsType(t, erro.ErrConflict{}, resError)
+				assert.Contains(t, resError.Error(), "email address")
+			},
+		},
+		{
+			testName: "linked user deleted",
+			idToken:  func(t *testing.T) string { return sign(t, nil) },
+			buildStubs: func(repo *repoMock) {
+				repo.On("AuthenticateIdentity", mock.Anything, identity).
+					Return(repository.User{}, erro.ErrForbidden{Err: errors.New("the user linked to this identity is deleted")})
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				assert.IsType(t, erro.ErrForbidden{}, resError)
+				repo.AssertNotCalled(t, "ProvisionIdentity", mock.Anything, mock.Anything, mock.Anything)
+			},
+		},
+		{
+			testName: "other issuer",
+			idToken: func(t *testing.T) string {
+				return sign(t, func(c *idTokenClaims) { c.Issuer = "https://evil.example.com" })
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				require.IsType(t, erro.ErrUnauthorized{}, resError)
+				assert.Equal(t, erro.ErrInvalidIDToken, resError.Error())
+			},
+		},
+		{
+			testName: "other audience",
+			idToken: func(t *testing.T) string {
+				return sign(t, func(c *idTokenClaims) { c.Audience = oidc.Audience{"another-app"} })
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				assert.IsType(t, erro.ErrUnauthorized{}, resError)
+			},
+		},
+		{
+			testName: "expired",
+			idToken: func(t *testing.T) string {
+				return sign(t, func(c *idTokenClaims) { c.ExpiresAt = oidcNow.Unix() })
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				assert.IsType(t, erro.ErrUnauthorized{}, resError)
+			},
+		},
+		{
+			testName: "signed by another key",
+			idToken: func(t *testing.T) string {
+				key, err := oidc.GenerateKey()
+				require.NoError(t, err)
+				token, err := oidc.NewSigner(key).Sign(oidc.TypeIDToken, claims)
+				require.NoError(t, err)
+				return token
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				assert.IsType(t, erro.ErrUnauthorized{}, resError)
+			},
+		},
+		{
+			testName: "provider keys unavailable",
+			idpDown:  true,
+			idToken:  func(t *testing.T) string { return sign(t, nil) },
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				require.IsType(t, erro.ErrUnavailable{}, resError)
+				assert.Equal(t, erro.ErrIdPUnavailable, resError.Error())
+			},
+		},
+		{
+			testName: "missing token",
+			idToken:  func(t *testing.T) string { return "" },
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				assert.IsType(t, erro.ErrBadRequest{}, resError)
+			},
+		},
+		{
+			testName: "not configured",
+			disabled: true,
+			idToken:  func(t *testing.T) string { return sign(t, nil) },
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthResponse, resError error) {
+				require.IsType(t, erro.ErrNotFound{}, resError)
+				assert.Equal(t, erro.ErrFederationDisabled, resError.Error())
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			cfg := FederationConfig{Issuer: testIdP, ClientId: testIdPClientId}
+			if tc.disabled {
+				cfg = FederationConfig{}
+			}
+			repo := new(repoMock)
+			if tc.buildStubs != nil {
+				tc.buildStubs(repo)
+			}
+			var verifier TokenVerifier = keys
+			if tc.idpDown {
+				down := httptest.NewServer(http.NotFoundHandler())
+				defer down.Close()
+				verifier = oidc.NewKeySet(down.URL, down.Client(), time.Minute)
+			}
+			s := NewFederationService(repo, verifier, cfg, log.NewNopLogger()).(*federationService)
+			s.now = func() time.Time { return oidcNow }
+
+			response, err := s.Login(context.Background(), tc.idToken(t))
+			tc.checkResponse(t, repo, response, err)
+		})
+	}
+}
@@ -0,0 +1,480 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/logging"
+	"github.com/javibauza/final-project/rest-service/oidc"
+	"github.com/javibauza/final-project/rest-service/repository"
+)
+
+// Paths of the OpenID Connect provider, relative to its issuer.
+const (
+	AuthorizePath = "/oauth/authorize"
+	TokenPath     = "/oauth/token"
+	UserInfoPath  = "/oauth/userinfo"
+	JWKSPath      = "/oauth/jwks"
+)
+
+// Scopes the provider understands. Others are ignored.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+var supportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+const pkceMethodS256 = "S256"
+
+// OIDCService is a minimal OpenID Connect provider on top of the user store:
+// the authorization code flow with PKCE, for clients registered in the
+// grpcUserService.
+type OIDCService interface {
+	Discovery(ctx context.Context) (Discovery, error)
+	JWKS(ctx context.Context) (oidc.JWKS, error)
+	Authorize(ctx context.Context, request AuthorizeRequest) (AuthorizeResponse, error)
+	Token(ctx context.Context, request TokenRequest) (TokenResponse, error)
+	UserInfo(ctx context.Context, accessToken string) (UserInfo, error)
+	CreateSession(ctx context.Context, userId string) (Session, error)
+	AuthenticateSession(ctx context.Context, token string) (string, error)
+}
+
+type OIDCConfig struct {
+	// Issuer is the public base URL of the provider.
+	Issuer string
+	// TokenTTL is how long access, ID and session tokens are valid.
+	TokenTTL time.Duration
+}
+
+type oidcService struct {
+	repository repository.UserRepository
+	signer     *oidc.Signer
+	cfg        OIDCConfig
+	logger     log.Logger
+	now        func() time.Time
+}
+
+// Discovery is the provider's metadata.
+type Discovery struct {
+	Issuer                   string
+	AuthorizationEndpoint    string
+	TokenEndpoint            string
+	UserInfoEndpoint         string
+	JWKSURI                  string
+	ScopesSupported          []string
+	ResponseTypes            []string
+	GrantTypes               []string
+	CodeChallengeMethods     []string
+	TokenEndpointAuthMethods []string
+	SigningAlgs              []string
+	ClaimsSupported          []string
+}
+
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientId            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Login is set when the user submitted the login form with Name, Pwd
+	// and, for users with two-factor authentication, Code.
+	Login bool
+	Name  string
+	Pwd   string
+	Code  string
+}
+
+// AuthorizeResponse either redirects the user agent back to the client, with
+// a code or an error, or asks the user to log in to ClientName.
+type AuthorizeResponse struct {
+	RedirectURI string
+	UserId      string
+	ClientName  string
+	// LoginError is shown on the login form after a failed attempt.
+	LoginError string
+}
+
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	ClientId     string
+	ClientSecret string
+	CodeVerifier string
+}
+
+type TokenResponse struct {
+	AccessToken string
+	IDToken     string
+	TokenType   string
+	ExpiresIn   int64
+	Scope       string
+}
+
+// Session is what the REST API's own login issues: a bearer token that
+// authenticates the user to the REST API, and nothing else.
+type Session struct {
+	Token     string
+	ExpiresIn int64
+}
+
+// UserInfo holds the claims about a user the access token's scopes grant.
+type UserInfo struct {
+	Subject           string
+	Name              string
+	PreferredUsername string
+	Birthdate         string
+	Locale            string
+	Zoneinfo          string
+	Email             string
+	EmailVerified     bool
+}
+
+func NewOIDCService(rep repository.UserRepository, signer *oidc.Signer, cfg OIDCConfig, logger log.Logger) OIDCService {
+	return &oidcService{
+		repository: rep,
+		signer:     signer,
+		cfg:        cfg,
+		logger:     logger,
+		now:        time.Now,
+	}
+}
+
+func (s oidcService) Discovery(_ context.Context) (Discovery, error) {
+	issuer := strings.TrimSuffix(s.cfg.Issuer, "/")
+	return Discovery{
+		Issuer:                s.cfg.Issuer,
+		AuthorizationEndpoint: issuer + AuthorizePath,
+		TokenEndpoint:         issuer + TokenPath,
+		UserInfoEndpoint:      issuer + UserInfoPath,
+		JWKSURI:               issuer + JWKSPath,
+		ScopesSupported:       supportedScopes,
+		ResponseTypes:         []string{"code"},
+		GrantTypes:            []string{"authorization_code"},
+		CodeChallengeMethods:  []string{pkceMethodS256},
+		// Public clients authenticate with PKCE alone.
+		TokenEndpointAuthMethods: []string{"client_secret_basic", "client_secret_post", "none"},
+		SigningAlgs:              []string{"RS256"},
+		ClaimsSupported: []string{"iss", "sub", "aud", "exp", "iat", "nonce", "name", "preferred_username",
+			"birthdate", "locale", "zoneinfo", "email", "email_verified"},
+	}, nil
+}
+
+func (s oidcService) JWKS(_ context.Context) (oidc.JWKS, error) {
+	return s.signer.JWKS(), nil
+}
+
+// Authorize checks an authorization request and, once the user logged in,
+// issues a code for the client. Requests for an unknown client or redirect
+// URI fail with ErrBadRequest, as the user agent can't safely be sent back;
+// any other problem is reported to the client through the redirect.
+func (s oidcService) Authorize(ctx context.Context, request AuthorizeRequest) (AuthorizeResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "Authorize")
+
+	if request.ClientId == "" || request.RedirectURI == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("client_id", "redirect_uri"))
+		return AuthorizeResponse{}, erro.NewErrBadRequest(erro.ErrRequiredFields("client_id", "redirect_uri"))
+	}
+	client, err := s.repository.GetOAuthClient(ctx, request.ClientId)
+	if notFound, ok := err.(erro.ErrNotFound); ok {
+		level.Error(logger).Log("err", err.Error())
+		return AuthorizeResponse{}, erro.ErrBadRequest{Err: notFound.Err}
+	}
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthorizeResponse{}, err
+	}
+	if !contains(client.RedirectURIs, request.RedirectURI) {
+		level.Error(logger).Log("err", erro.ErrRedirectURINotRegistered, "clientId", client.Id)
+		return AuthorizeResponse{}, erro.NewErrBadRequest(erro.ErrRedirectURINotRegistered)
+	}
+
+	scopes := strings.Fields(request.Scope)
+	var oauthErr erro.ErrOAuth
+	switch {
+	case request.ResponseType != "code":
+		oauthErr = erro.NewErrOAuth(erro.OAuthUnsupportedResponseType, erro.ErrUnsupportedResponseType)
+	case !contains(scopes, ScopeOpenID):
+		oauthErr = erro.NewErrOAuth(erro.OAuthInvalidScope, erro.ErrOpenIDScope)
+	case request.CodeChallenge == "" || request.CodeChallengeMethod != pkceMethodS256:
+		oauthErr = erro.NewErrOAuth(erro.OAuthInvalidRequest, erro.ErrPKCERequired)
+	}
+	if oauthErr.Code != "" {
+		level.Error(logger).Log("err", oauthErr.Error(), "clientId", client.Id)
+		return AuthorizeResponse{RedirectURI: redirectWith(request.RedirectURI, map[string]string{
+			"error":             oauthErr.Code,
+			"error_description": oauthErr.Error(),
+			"state":             request.State,
+		})}, nil
+	}
+
+	if !request.Login {
+		return AuthorizeResponse{ClientName: client.Name}, nil
+	}
+	userId, loginErr, err := s.login(ctx, request)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthorizeResponse{}, err
+	}
+	if loginErr != "" {
+		level.Info(logger).Log("msg", "login failed", "err", loginErr, "clientId", client.Id)
+		return AuthorizeResponse{ClientName: client.Name, LoginError: loginErr}, nil
+	}
+
+	var granted []string
+	for _, scope := range supportedScopes {
+		if contains(scopes, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	code, err := s.repository.CreateAuthorizationCode(ctx, repository.AuthorizationCode{
+		ClientId:      client.Id,
+		UserId:        userId,
+		RedirectURI:   request.RedirectURI,
+		Scope:         strings.Join(granted, " "),
+		Nonce:         request.Nonce,
+		CodeChallenge: request.CodeChallenge,
+	})
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return AuthorizeResponse{}, err
+	}
+
+	return AuthorizeResponse{
+		UserId:      userId,
+		RedirectURI: redirectWith(request.RedirectURI, map[string]string{"code": code, "state": request.State}),
+	}, nil
+}
+
+// login checks the user's credentials, returning a message for the login
+// form when they are wrong.
+func (s oidcService) login(ctx context.Context, request AuthorizeRequest) (userId, loginErr string, err error) {
+	if request.Name == "" || request.Pwd == "" {
+		return "", erro.ErrLoginFailed, nil
+	}
+	user, err := s.repository.Authenticate(ctx, repository.User{Name: request.Name, Password: request.Pwd})
+	switch err.(type) {
+	case nil:
+	case erro.ErrNotFound, erro.ErrForbidden, erro.ErrBadRequest:
+		return "", erro.ErrLoginFailed, nil
+	default:
+		return "", "", err
+	}
+	if user.Challenge == "" {
+		return user.UserId, "", nil
+	}
+
+	if request.Code == "" {
+		return "", erro.ErrTOTPCodeRequired, nil
+	}
+	user, err = s.repository.CompleteAuthChallenge(ctx, user.Challenge, request.Code)
+	switch err.(type) {
+	case nil:
+		return user.UserId, "", nil
+	case erro.ErrForbidden, erro.ErrBadRequest:
+		return "", erro.ErrInvalidTOTPCode, nil
+	default:
+		return "", "", err
+	}
+}
+
+// Token exchanges an authorization code for an access token and an ID token.
+// Errors are ErrOAuth, but for those of the grpcUserService.
+func (s oidcService) Token(ctx context.Context, request TokenRequest) (TokenResponse, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "Token")
+
+	if request.GrantType != "authorization_code" {
+		level.Error(logger).Log("err", erro.ErrUnsupportedGrantType)
+		return TokenResponse{}, erro.NewErrOAuth(erro.OAuthUnsupportedGrantType, erro.ErrUnsupportedGrantType)
+	}
+	if request.ClientId == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("client_id"))
+		return TokenResponse{}, erro.NewErrOAuth(erro.OAuthInvalidClient, erro.ErrRequiredFields("client_id"))
+	}
+	if request.Code == "" || request.RedirectURI == "" || request.CodeVerifier == "" {
+		level.Error(logger).Log("err", erro.ErrRequiredFields("code", "redirect_uri", "code_verifier"))
+		return TokenResponse{}, erro.NewErrOAuth(erro.OAuthInvalidRequest, erro.ErrRequiredFields("code", "redirect_uri", "code_verifier"))
+	}
+
+	code, err := s.repository.ExchangeAuthorizationCode(ctx, repository.CodeExchange{
+		ClientId:     request.ClientId,
+		ClientSecret: request.ClientSecret,
+		Code:         request.Code,
+		RedirectURI:  request.RedirectURI,
+		CodeVerifier: request.CodeVerifier,
+	})
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		switch r := err.(type) {
+		case erro.ErrNotFound:
+			return TokenResponse{}, erro.ErrOAuth{Code: erro.OAuthInvalidClient, Err: r.Err}
+		case erro.ErrForbidden:
+			return TokenResponse{}, erro.ErrOAuth{Code: erro.OAuthInvalidClient, Err: r.Err}
+		case erro.ErrBadRequest:
+			return TokenResponse{}, erro.ErrOAuth{Code: erro.OAuthInvalidGrant, Err: r.Err}
+		}
+		return TokenResponse{}, err
+	}
+
+	now := s.now()
+	claims := oidc.Claims{
+		Issuer:    s.cfg.Issuer,
+		Subject:   code.UserId,
+		Audience:  oidc.Audience{s.cfg.Issuer},
+		ExpiresAt: now.Add(s.cfg.TokenTTL).Unix(),
+		IssuedAt:  now.Unix(),
+		Scope:     code.Scope,
+		ClientId:  code.ClientId,
+	}
+	accessToken, err := s.signer.Sign(oidc.TypeAccessToken, claims)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return TokenResponse{}, err
+	}
+	claims.Audience = oidc.Audience{code.ClientId}
+	claims.Scope, claims.ClientId = "", ""
+	claims.Nonce = code.Nonce
+	idToken, err := s.signer.Sign(oidc.TypeIDToken, claims)
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		AccessToken: accessToken,
+		IDToken:     idToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.cfg.TokenTTL / time.Second),
+		Scope:       code.Scope,
+	}, nil
+}
+
+// UserInfo returns the claims about the user an access token was issued for.
+// Invalid tokens, and tokens of users deleted since, fail with an
+// invalid_token ErrOAuth.
+func (s oidcService) UserInfo(ctx context.Context, accessToken string) (UserInfo, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "UserInfo")
+
+	var claims oidc.Claims
+	err := oidc.Verify(accessToken, oidc.TypeAccessToken, s.signer.JWKS(), &claims)
+	if err == nil {
+		err = claims.Validate(s.cfg.Issuer, s.cfg.Issuer, s.now())
+	}
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return UserInfo{}, erro.NewErrOAuth(erro.OAuthInvalidToken, erro.ErrInvalidAccessToken)
+	}
+
+	user, err := s.repository.GetUser(ctx, claims.Subject)
+	if _, notFound := err.(erro.ErrNotFound); notFound {
+		level.Error(logger).Log("err", err.Error())
+		return UserInfo{}, erro.NewErrOAuth(erro.OAuthInvalidToken, erro.ErrInvalidAccessToken)
+	}
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return UserInfo{}, err
+	}
+
+	info := UserInfo{Subject: user.UserId}
+	scopes := strings.Fields(claims.Scope)
+	if contains(scopes, ScopeProfile) {
+		info.Name = user.Profile.DisplayName
+		info.PreferredUsername = user.Name
+		info.Birthdate = user.BirthDate
+		info.Locale = user.Profile.Locale
+		info.Zoneinfo = user.Profile.Timezone
+	}
+	if contains(scopes, ScopeEmail) {
+		info.Email = user.Profile.Email
+		info.EmailVerified = user.EmailVerified
+	}
+	return info, nil
+}
+
+// CreateSession signs a session token for the user, after a login to the
+// REST API.
+func (s oidcService) CreateSession(ctx context.Context, userId string) (Session, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "CreateSession")
+
+	now := s.now()
+	token, err := s.signer.Sign(oidc.TypeSessionToken, oidc.Claims{
+		Issuer:    s.cfg.Issuer,
+		Subject:   userId,
+		Audience:  oidc.Audience{s.cfg.Issuer},
+		ExpiresAt: now.Add(s.cfg.TokenTTL).Unix(),
+		IssuedAt:  now.Unix(),
+	})
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return Session{}, err
+	}
+
+	return Session{Token: token, ExpiresIn: int64(s.cfg.TokenTTL / time.Second)}, nil
+}
+
+// AuthenticateSession returns the user a session token was issued to.
+// Invalid and expired tokens, and tokens of users deleted since, fail with
+// ErrUnauthorized.
+func (s oidcService) AuthenticateSession(ctx context.Context, token string) (string, error) {
+	logger := log.With(logging.WithContext(ctx, s.logger), "method", "AuthenticateSession")
+
+	var claims oidc.Claims
+	err := oidc.Verify(token, oidc.TypeSessionToken, s.signer.JWKS(), &claims)
+	if err == nil {
+		err = claims.Validate(s.cfg.Issuer, s.cfg.Issuer, s.now())
+	}
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", erro.ErrUnauthorized{Err: errors.New(erro.ErrInvalidSession)}
+	}
+
+	user, err := s.repository.GetUser(ctx, claims.Subject)
+	if _, notFound := err.(erro.ErrNotFound); notFound {
+		level.Error(logger).Log("err", err.Error())
+		return "", erro.ErrUnauthorized{Err: errors.New(erro.ErrInvalidSession)}
+	}
+	if err != nil {
+		level.Error(logger).Log("err", err.Error())
+		return "", err
+	}
+
+	return user.UserId, nil
+}
+
+// redirectWith adds the non-empty params to the query of uri, which has
+// been checked to be a registered, and so valid, redirect URI.
+func redirectWith(uri string, params map[string]string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	query := u.Query()
+	for k, v := range params {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,497 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/oidc"
+	"github.com/javibauza/final-project/rest-service/repository"
+)
+
+const testIssuer = "https://id.example.com"
+
+var oidcNow = time.Date(2026, 7, 9, 12, 0, 0, 0, time.UTC)
+
+func newTestOIDCService(t *testing.T, repo repository.UserRepository) *oidcService {
+	key, err := oidc.GenerateKey()
+	require.NoError(t, err)
+	s := NewOIDCService(repo, oidc.NewSigner(key), OIDCConfig{Issuer: testIssuer, TokenTTL: time.Hour}, log.NewNopLogger()).(*oidcService)
+	s.now = func() time.Time { return oidcNow }
+	return s
+}
+
+func TestDiscovery(t *testing.T) {
+	discovery, err := newTestOIDCService(t, new(repoMock)).Discovery(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, testIssuer, discovery.Issuer)
+	assert.Equal(t, testIssuer+"/oauth/authorize", discovery.AuthorizationEndpoint)
+	assert.Equal(t, testIssuer+"/oauth/token", discovery.TokenEndpoint)
+	assert.Equal(t, testIssuer+"/oauth/userinfo", discovery.UserInfoEndpoint)
+	assert.Equal(t, testIssuer+"/oauth/jwks", discovery.JWKSURI)
+	assert.Equal(t, []string{"openid", "profile", "email"}, discovery.ScopesSupported)
+	assert.Equal(t, []string{"S256"}, discovery.CodeChallengeMethods)
+}
+
+func TestAuthorize(t *testing.T) {
+	client := repository.OAuthClient{Id: "c1", Name: "Wiki", RedirectURIs: []string{"https://wiki.example.com/cb"}}
+	request := AuthorizeRequest{
+		ResponseType:        "code",
+		ClientId:            "c1",
+		RedirectURI:         "https://wiki.example.com/cb",
+		Scope:               "openid email offline_access",
+		State:               "xyz",
+		Nonce:               "n",
+		CodeChallenge:       "challenge",
+		CodeChallengeMethod: "S256",
+	}
+	login := func(req AuthorizeRequest, name, pwd, code string) AuthorizeRequest {
+		req.Login, req.Name, req.Pwd, req.Code = true, name, pwd, code
+		return req
+	}
+
+	testCases := []struct {
+		testName      string
+		request       AuthorizeRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, repo *repoMock, response AuthorizeResponse, resError error)
+	}{
+		{
+			testName: "login form",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(client, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthorizeResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthorizeResponse{ClientName: "Wiki"}, response)
+			},
+		},
+		{
+			testName: "logged in",
+			request:  login(request, "javier", "secret", ""),
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(client, nil)
+				repo.On("Authenticate", mock.Anything, repository.User{Name: "javier", Password: "secret"}).Return(repository.User{UserId: "u1"}, nil)
+				repo.On("CreateAuthorizationCode", mock.Anything, repository.AuthorizationCode{
+					ClientId:      "c1",
+					UserId:        "u1",
+					RedirectURI:   "https://wiki.example.com/cb",
+					Scope:         "openid email",
+					Nonce:         "n",
+					CodeChallenge: "challenge",
+				}).Return("the-code", nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthorizeResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, "u1", response.UserId)
+				assert.Equal(t, "https://wiki.example.com/cb?code=the-code&state=xyz", response.RedirectURI)
+			},
+		},
+		{
+			testName: "logged in with two-factor code",
+			request:  login(request, "javier", "secret", "123456"),
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(client, nil)
+				repo.On("Authenticate", mock.Anything, repository.User{Name: "javier", Password: "secret"}).Return(repository.User{Challenge: "ch"}, nil)
+				repo.On("CompleteAuthChallenge", mock.Anything, "ch", "123456").Return(repository.User{UserId: "u1"}, nil)
+				repo.On("CreateAuthorizationCode", mock.Anything, mock.AnythingOfType("repository.AuthorizationCode")).Return("the-code", nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthorizeResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, "u1", response.UserId)
+			},
+		},
+		{
+			testName: "two-factor code missing",
+			request:  login(request, "javier", "secret", ""),
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(client, nil)
+				repo.On("Authenticate", mock.Anything, repository.User{Name: "javier", Password: "secret"}).Return(repository.User{Challenge: "ch"}, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthorizeResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthorizeResponse{ClientName: "Wiki", LoginError: erro.ErrTOTPCodeRequired}, response)
+			},
+		},
+		{
+			testName: "wrong password",
+			request:  login(request, "javier", "wrong", ""),
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(client, nil)
+				repo.On("Authenticate", mock.Anything, repository.User{Name: "javier", Password: "wrong"}).Return(repository.User{}, erro.ErrForbidden{Err: errors.New("wrong password")})
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthorizeResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, AuthorizeResponse{ClientName: "Wiki", LoginError: erro.ErrLoginFailed}, response)
+			},
+		},
+		{
+			testName: "no openid scope",
+			request: func() AuthorizeRequest {
+				req := request
+				req.Scope = "email"
+				return req
+			}(),
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(client, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthorizeResponse, resError error) {
+				require.NoError(t, resError)
+				redirect, err := url.Parse(response.RedirectURI)
+				require.NoError(t, err)
+				assert.Equal(t, "wiki.example.com", redirect.Host)
+				assert.Equal(t, "invalid_scope", redirect.Query().Get("error"))
+				assert.Equal(t, "xyz", redirect.Query().Get("state"))
+			},
+		},
+		{
+			testName: "plain PKCE",
+			request: func() AuthorizeRequest {
+				req := request
+				req.CodeChallengeMethod = "plain"
+				return req
+			}(),
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(client, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthorizeResponse, resError error) {
+				require.NoError(t, resError)
+				redirect, err := url.Parse(response.RedirectURI)
+				require.NoError(t, err)
+				assert.Equal(t, "invalid_request", redirect.Query().Get("error"))
+			},
+		},
+		{
+			testName: "unregistered redirect uri",
+			request: func() AuthorizeRequest {
+				req := request
+				req.RedirectURI = "https://evil.example.com/cb"
+				return req
+			}(),
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(client, nil)
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthorizeResponse, resError error) {
+				assert.Empty(t, response, "the user agent is not redirected")
+				assert.IsType(t, erro.ErrBadRequest{}, resError)
+				assert.EqualError(t, resError, erro.ErrRedirectURINotRegistered)
+			},
+		},
+		{
+			testName: "unknown client",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetOAuthClient", mock.Anything, "c1").Return(repository.OAuthClient{}, erro.ErrNotFound{Err: errors.New("OAuth client not found")})
+			},
+			checkResponse: func(t *testing.T, repo *repoMock, response AuthorizeResponse, resError error) {
+				assert.Empty(t, response)
+				assert.IsType(t, erro.ErrBadRequest{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			response, err := newTestOIDCService(t, repo).Authorize(context.Background(), tc.request)
+			tc.checkResponse(t, repo, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	request := TokenRequest{GrantType: "authorization_code", Code: "the-code", RedirectURI: "https://wiki.example.com/cb", ClientId: "c1", CodeVerifier: "verifier"}
+	exchange := repository.CodeExchange{ClientId: "c1", Code: "the-code", RedirectURI: "https://wiki.example.com/cb", CodeVerifier: "verifier"}
+
+	testCases := []struct {
+		testName      string
+		request       TokenRequest
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, s *oidcService, response TokenResponse, resError error)
+	}{
+		{
+			testName: "tokens issued",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				repo.On("ExchangeAuthorizationCode", mock.Anything, exchange).
+					Return(repository.AuthorizationCode{ClientId: "c1", UserId: "u1", Scope: "openid email", Nonce: "n"}, nil)
+			},
+			checkResponse: func(t *testing.T, s *oidcService, response TokenResponse, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, "Bearer", response.TokenType)
+				assert.Equal(t, int64(3600), response.ExpiresIn)
+				assert.Equal(t, "openid email", response.Scope)
+
+				var idToken oidc.Claims
+				require.NoError(t, oidc.Verify(response.IDToken, oidc.TypeIDToken, s.signer.JWKS(), &idToken))
+				assert.Equal(t, oidc.Claims{
+					Issuer:    testIssuer,
+					Subject:   "u1",
+					Audience:  oidc.Audience{"c1"},
+					ExpiresAt: oidcNow.Add(time.Hour).Unix(),
+					IssuedAt:  oidcNow.Unix(),
+					Nonce:     "n",
+				}, idToken)
+
+				var accessToken oidc.Claims
+				require.NoError(t, oidc.Verify(response.AccessToken, oidc.TypeAccessToken, s.signer.JWKS(), &accessToken))
+				assert.Equal(t, "openid email", accessToken.Scope)
+				assert.Equal(t, "c1", accessToken.ClientId)
+				assert.NoError(t, accessToken.Validate(testIssuer, testIssuer, oidcNow))
+			},
+		},
+		{
+			testName:   "unsupported grant type",
+			request:    TokenRequest{GrantType: "password", ClientId: "c1"},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, s *oidcService, response TokenResponse, resError error) {
+				assert.Equal(t, erro.NewErrOAuth(erro.OAuthUnsupportedGrantType, erro.ErrUnsupportedGrantType), resError)
+			},
+		},
+		{
+			testName: "invalid code",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				repo.On("ExchangeAuthorizationCode", mock.Anything, exchange).
+					Return(repository.AuthorizationCode{}, erro.NewErrBadRequest("invalid, expired or already used authorization code"))
+			},
+			checkResponse: func(t *testing.T, s *oidcService, response TokenResponse, resError error) {
+				assert.Empty(t, response)
+				assert.Equal(t, erro.NewErrOAuth(erro.OAuthInvalidGrant, "invalid, expired or already used authorization code"), resError)
+			},
+		},
+		{
+			testName: "wrong client secret",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				repo.On("ExchangeAuthorizationCode", mock.Anything, exchange).
+					Return(repository.AuthorizationCode{}, erro.ErrForbidden{Err: errors.New("invalid client credentials")})
+			},
+			checkResponse: func(t *testing.T, s *oidcService, response TokenResponse, resError error) {
+				assert.Equal(t, erro.NewErrOAuth(erro.OAuthInvalidClient, "invalid client credentials"), resError)
+			},
+		},
+		{
+			testName: "user service unavailable",
+			request:  request,
+			buildStubs: func(repo *repoMock) {
+				repo.On("ExchangeAuthorizationCode", mock.Anything, exchange).
+					Return(repository.AuthorizationCode{}, erro.ErrInternal{Err: errors.New("unavailable")})
+			},
+			checkResponse: func(t *testing.T, s *oidcService, response TokenResponse, resError error) {
+				assert.IsType(t, erro.ErrInternal{}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			s := newTestOIDCService(t, repo)
+			response, err := s.Token(context.Background(), tc.request)
+			tc.checkResponse(t, s, response, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	user := repository.User{
+		UserId:        "u1",
+		Name:          "javier",
+		BirthDate:     "1990-05-01",
+		Profile:       repository.Profile{Email: "javier@example.com", DisplayName: "Javier", Locale: "es-AR", Timezone: "America/Argentina/Buenos_Aires"},
+		EmailVerified: true,
+	}
+	accessToken := func(s *oidcService, scope string, expiresAt time.Time) string {
+		token, err := s.signer.Sign(oidc.TypeAccessToken, oidc.Claims{Issuer: testIssuer, Subject: "u1", Audience: oidc.Audience{testIssuer}, ExpiresAt: expiresAt.Unix(), Scope: scope})
+		require.NoError(t, err)
+		return token
+	}
+
+	testCases := []struct {
+		testName      string
+		token         func(s *oidcService) string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, info UserInfo, resError error)
+	}{
+		{
+			testName: "profile and email",
+			token: func(s *oidcService) string {
+				return accessToken(s, "openid profile email", oidcNow.Add(time.Minute))
+			},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(user, nil)
+			},
+			checkResponse: func(t *testing.T, info UserInfo, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, UserInfo{
+					Subject:           "u1",
+					Name:              "Javier",
+					PreferredUsername: "javier",
+					Birthdate:         "1990-05-01",
+					Locale:            "es-AR",
+					Zoneinfo:          "America/Argentina/Buenos_Aires",
+					Email:             "javier@example.com",
+					EmailVerified:     true,
+				}, info)
+			},
+		},
+		{
+			testName: "openid only",
+			token: func(s *oidcService) string {
+				return accessToken(s, "openid", oidcNow.Add(time.Minute))
+			},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(user, nil)
+			},
+			checkResponse: func(t *testing.T, info UserInfo, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, UserInfo{Subject: "u1"}, info)
+			},
+		},
+		{
+			testName: "expired token",
+			token: func(s *oidcService) string {
+				return accessToken(s, "openid", oidcNow)
+			},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, info UserInfo, resError error) {
+				assert.Equal(t, erro.NewErrOAuth(erro.OAuthInvalidToken, erro.ErrInvalidAccessToken), resError)
+			},
+		},
+		{
+			testName: "id token",
+			token: func(s *oidcService) string {
+				token, err := s.signer.Sign(oidc.TypeIDToken, oidc.Claims{Issuer: testIssuer, Subject: "u1", Audience: oidc.Audience{testIssuer}, ExpiresAt: oidcNow.Add(time.Minute).Unix()})
+				require.NoError(t, err)
+				return token
+			},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, info UserInfo, resError error) {
+				assert.Equal(t, erro.NewErrOAuth(erro.OAuthInvalidToken, erro.ErrInvalidAccessToken), resError)
+			},
+		},
+		{
+			testName: "user deleted",
+			token: func(s *oidcService) string {
+				return accessToken(s, "openid", oidcNow.Add(time.Minute))
+			},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{}, erro.ErrNotFound{Err: errors.New("user not found")})
+			},
+			checkResponse: func(t *testing.T, info UserInfo, resError error) {
+				assert.Empty(t, info)
+				assert.Equal(t, erro.NewErrOAuth(erro.OAuthInvalidToken, erro.ErrInvalidAccessToken), resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+
+			s := newTestOIDCService(t, repo)
+			info, err := s.UserInfo(context.Background(), tc.token(s))
+			tc.checkResponse(t, info, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticateSession(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		token         func(s *oidcService) string
+		buildStubs    func(repo *repoMock)
+		checkResponse func(t *testing.T, userId string, resError error)
+	}{
+		{
+			testName: "session",
+			token: func(s *oidcService) string {
+				session, err := s.CreateSession(context.Background(), "u1")
+				require.NoError(t, err)
+				assert.Equal(t, int64(3600), session.ExpiresIn)
+				return session.Token
+			},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{UserId: "u1"}, nil)
+			},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				require.NoError(t, resError)
+				assert.Equal(t, "u1", userId)
+			},
+		},
+		{
+			testName: "session of a deleted user",
+			token: func(s *oidcService) string {
+				session, err := s.CreateSession(context.Background(), "u1")
+				require.NoError(t, err)
+				return session.Token
+			},
+			buildStubs: func(repo *repoMock) {
+				repo.On("GetUser", mock.Anything, "u1").Return(repository.User{}, erro.ErrNotFound{Err: errors.New("user not found")})
+			},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				assert.Empty(t, userId)
+				assert.Equal(t, erro.ErrUnauthorized{Err: errors.New(erro.ErrInvalidSession)}, resError)
+			},
+		},
+		{
+			testName: "expired session",
+			token: func(s *oidcService) string {
+				session, err := s.CreateSession(context.Background(), "u1")
+				require.NoError(t, err)
+				s.now = func() time.Time { return oidcNow.Add(time.Hour) }
+				return session.Token
+			},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				assert.Equal(t, erro.ErrUnauthorized{Err: errors.New(erro.ErrInvalidSession)}, resError)
+			},
+		},
+		{
+			testName: "access token",
+			token: func(s *oidcService) string {
+				token, err := s.signer.Sign(oidc.TypeAccessToken, oidc.Claims{Issuer: testIssuer, Subject: "u1", Audience: oidc.Audience{testIssuer}, ExpiresAt: oidcNow.Add(time.Minute).Unix(), Scope: "openid"})
+				require.NoError(t, err)
+				return token
+			},
+			buildStubs: func(repo *repoMock) {},
+			checkResponse: func(t *testing.T, userId string, resError error) {
+				assert.Empty(t, userId)
+				assert.Equal(t, erro.ErrUnauthorized{Err: errors.New(erro.ErrInvalidSession)}, resError)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			repo := new(repoMock)
+			tc.buildStubs(repo)
+			s := newTestOIDCService(t, repo)
+			userId, err := s.AuthenticateSession(context.Background(), tc.token(s))
+			tc.checkResponse(t, userId, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/javibauza/final-project/rest-service/logging"
+	"github.com/javibauza/final-project/rest-service/repository"
+)
+
+const (
+	leakedPwd  = "s3cr3t-passw0rd"
+	leakedHash = "$2a$12$Lhdc.gbeLbQbm8uz3H1T4.EPaxqclyblPeM1N1rxhNCth1/sZkCwC"
+)
+
+var leakyErr = errors.New("rpc error: pwd_hash=" + leakedHash + " password=" + leakedPwd)
+
+func TestLogRedaction(t *testing.T) {
+	testCases := []struct {
+		testName string
+		call     func(ctx context.Context, s Service, repo *repoMock)
+	}{
+		{
+			testName: "Authenticate",
+			call: func(ctx context.Context, s Service, repo *repoMock) {
+				repo.On("Authenticate", ctx, mock.AnythingOfType("repository.User")).Return(nil, leakyErr)
+				s.Authenticate(ctx, AuthRequest{Name: "javier", Pwd: leakedPwd})
+			},
+		},
+		{
+			testName: "CreateUser",
+			call: func(ctx context.Context, s Service, repo *repoMock) {
+				repo.On("CreateUser", ctx, mock.AnythingOfType("repository.User")).Return(repository.User{}, leakyErr)
+				s.CreateUser(ctx, CreateUserRequest{Name: "javier", Pwd: leakedPwd})
+			},
+		},
+		{
+			testName: "UpdateUser",
+			call: func(ctx context.Context, s Service, repo *repoMock) {
+				repo.On("UpdateUser", ctx, mock.AnythingOfType("repository.User"), mock.Anything).Return(repository.User{}, leakyErr)
+				s.UpdateUser(ctx, UpdateUserRequest{UserId: "u1", Pwd: leakedPwd})
+			},
+		},
+		{
+			testName: "GetUser",
+			call: func(ctx context.Context, s Service, repo *repoMock) {
+				repo.On("GetUser", ctx, "u1").Return(repository.User{}, leakyErr)
+				s.GetUser(ctx, "u1")
+			},
+		},
+		{
+			testName: "GetAuditLog",
+			call: func(ctx context.Context, s Service, repo *repoMock) {
+				repo.On("GetAuditLog", ctx, "u1", int32(0), "").Return(repository.AuditLog{}, leakyErr)
+				s.GetAuditLog(ctx, GetAuditLogRequest{UserId: "u1"})
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := logging.NewRedactor(log.NewJSONLogger(&buf))
+
+			repo := new(repoMock)
+			tc.call(context.Background(), NewService(repo, logger), repo)
+
+			assert.NotEmpty(t, buf.String())
+			assert.NotContains(t, buf.String(), leakedPwd)
+			assert.NotContains(t, buf.String(), leakedHash)
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+
+	"github.com/javibauza/final-project/grpc-service/tracing"
+	"github.com/javibauza/final-project/rest-service/oidc"
+)
+
+type Middleware func(Service) Service
+
+type tracingMiddleware struct {
+	next Service
+}
+
+func TracingMiddleware() Middleware {
+	return func(next Service) Service {
+		return &tracingMiddleware{next: next}
+	}
+}
+
+func (mw *tracingMiddleware) Authenticate(ctx context.Context, request AuthRequest) (AuthResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.Authenticate")
+	res, err := mw.next.Authenticate(ctx, request)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) CreateUser(ctx context.Context, request CreateUserRequest) (CreateUserResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.CreateUser")
+	res, err := mw.next.CreateUser(ctx, request)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) UpdateUser(ctx context.Context, request UpdateUserRequest) (UpdateUserResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.UpdateUser")
+	res, err := mw.next.UpdateUser(ctx, request)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) GetUser(ctx context.Context, userId string) (GetUserResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.GetUser")
+	res, err := mw.next.GetUser(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) GetAuditLog(ctx context.Context, req GetAuditLogRequest) (GetAuditLogResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.GetAuditLog")
+	res, err := mw.next.GetAuditLog(ctx, req)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) DeleteUser(ctx context.Context, userId string) error {
+	ctx, span := tracing.StartSpan(ctx, "service.DeleteUser")
+	err := mw.next.DeleteUser(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) RestoreUser(ctx context.Context, userId string) (GetUserResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.RestoreUser")
+	res, err := mw.next.RestoreUser(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) SendVerification(ctx context.Context, userId string) error {
+	ctx, span := tracing.StartSpan(ctx, "service.SendVerification")
+	err := mw.next.SendVerification(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) VerifyEmail(ctx context.Context, token string) (GetUserResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.VerifyEmail")
+	res, err := mw.next.VerifyEmail(ctx, token)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) CompleteAuthChallenge(ctx context.Context, request CompleteAuthChallengeRequest) (AuthResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.CompleteAuthChallenge")
+	res, err := mw.next.CompleteAuthChallenge(ctx, request)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) EnrollTOTP(ctx context.Context, userId string) (EnrollTOTPResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.EnrollTOTP")
+	res, err := mw.next.EnrollTOTP(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) ConfirmTOTP(ctx context.Context, request ConfirmTOTPRequest) (ConfirmTOTPResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.ConfirmTOTP")
+	res, err := mw.next.ConfirmTOTP(ctx, request)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) CreateAPIKey(ctx context.Context, request CreateAPIKeyRequest) (CreateAPIKeyResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.CreateAPIKey")
+	res, err := mw.next.CreateAPIKey(ctx, request)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) ListAPIKeys(ctx context.Context, userId string) ([]APIKey, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.ListAPIKeys")
+	res, err := mw.next.ListAPIKeys(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *tracingMiddleware) RevokeAPIKey(ctx context.Context, userId, keyId string) error {
+	ctx, span := tracing.StartSpan(ctx, "service.RevokeAPIKey")
+	err := mw.next.RevokeAPIKey(ctx, userId, keyId)
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+func (mw *tracingMiddleware) AuthenticateAPIKey(ctx context.Context, key string) (APIKeyOwner, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.AuthenticateAPIKey")
+	res, err := mw.next.AuthenticateAPIKey(ctx, key)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+type OIDCMiddleware func(OIDCService) OIDCService
+
+type oidcTracingMiddleware struct {
+	next OIDCService
+}
+
+func OIDCTracingMiddleware() OIDCMiddleware {
+	return func(next OIDCService) OIDCService {
+		return &oidcTracingMiddleware{next: next}
+	}
+}
+
+func (mw *oidcTracingMiddleware) Discovery(ctx context.Context) (Discovery, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.Discovery")
+	res, err := mw.next.Discovery(ctx)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *oidcTracingMiddleware) JWKS(ctx context.Context) (oidc.JWKS, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.JWKS")
+	res, err := mw.next.JWKS(ctx)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *oidcTracingMiddleware) Authorize(ctx context.Context, request AuthorizeRequest) (AuthorizeResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.Authorize")
+	res, err := mw.next.Authorize(ctx, request)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *oidcTracingMiddleware) Token(ctx context.Context, request TokenRequest) (TokenResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.Token")
+	res, err := mw.next.Token(ctx, request)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *oidcTracingMiddleware) UserInfo(ctx context.Context, accessToken string) (UserInfo, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.UserInfo")
+	res, err := mw.next.UserInfo(ctx, accessToken)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *oidcTracingMiddleware) CreateSession(ctx context.Context, userId string) (Session, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.CreateSession")
+	res, err := mw.next.CreateSession(ctx, userId)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+func (mw *oidcTracingMiddleware) AuthenticateSession(ctx context.Context, token string) (string, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.AuthenticateSession")
+	res, err := mw.next.AuthenticateSession(ctx, token)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
+
+type FederationMiddleware func(FederationService) FederationService
+
+type federationTracingMiddleware struct {
+	next FederationService
+}
+
+func FederationTracingMiddleware() FederationMiddleware {
+	return func(next FederationService) FederationService {
+		return &federationTracingMiddleware{next: next}
+	}
+}
+
+func (mw *federationTracingMiddleware) Login(ctx context.Context, idToken string) (AuthResponse, error) {
+	ctx, span := tracing.StartSpan(ctx, "service.FederatedLogin")
+	res, err := mw.next.Login(ctx, idToken)
+	tracing.EndSpan(span, err)
+
+	return res, err
+}
@@ -0,0 +1,53 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// ClientConfig returns a TLS config that verifies the server against the
+// reloader's CA bundle and presents the reloader's certificate, if any, for
+// mTLS. Both are read on every handshake, so connections made after a reload,
+// including reconnections of long-lived clients, use the new files.
+func ClientConfig(r *Reloader, serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		ServerName:           serverName,
+		GetClientCertificate: r.GetClientCertificate,
+		// RootCAs would fix the CA bundle when the config is built, so the
+		// default verification is replaced by verifyServer.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return verifyServer(cs, r.CAPool())
+		},
+	}
+}
+
+// verifyServer does what the default verification does, against roots, or
+// the system pool when roots is nil.
+func verifyServer(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no server certificate")
+	}
+	if cs.ServerName == "" {
+		return errors.New("no server name to verify the certificate against")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+func ServerConfig(r *Reloader) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
@@ -0,0 +1,162 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader keeps a certificate, its key and an optional CA bundle in memory
+// and reloads them when any of the files changes on disk.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	caPool *x509.CertPool
+	stamps []fileStamp
+}
+
+// fileStamp is what tells that a file changed on disk.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) Reload() error {
+	// Stamped first, so that a change while reading is seen by Watch.
+	stamps, err := r.fileStamps()
+	if err != nil {
+		return err
+	}
+
+	var cert *tls.Certificate
+	if r.certFile != "" || r.keyFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.caPool = pool
+	r.stamps = stamps
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Watch polls the files every interval and reloads them when they change,
+// until ctx is done. Reload errors are passed to onError and the previous
+// material is kept.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stamps, err := r.fileStamps()
+			if err != nil {
+				onError(err)
+				continue
+			}
+			r.mu.RLock()
+			changed := changedStamps(stamps, r.stamps)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+func (r *Reloader) CAPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.caPool
+}
+
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := r.Certificate(); cert != nil {
+		return cert, nil
+	}
+	return nil, errors.New("no certificate configured")
+}
+
+func (r *Reloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if cert := r.Certificate(); cert != nil {
+		return cert, nil
+	}
+	return &tls.Certificate{}, nil
+}
+
+// fileStamps stamps the files in a fixed order.
+func (r *Reloader) fileStamps() ([]fileStamp, error) {
+	var stamps []fileStamp
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{modTime: info.ModTime(), size: info.Size()})
+	}
+	return stamps, nil
+}
+
+// changedStamps reports whether any file changed. A restored backup can move
+// its time backwards, so any difference counts.
+func changedStamps(stamps, previous []fileStamp) bool {
+	if len(stamps) != len(previous) {
+		return true
+	}
+	for i := range stamps {
+		if !stamps[i].modTime.Equal(previous[i].modTime) || stamps[i].size != previous[i].size {
+			return true
+		}
+	}
+	return false
+}
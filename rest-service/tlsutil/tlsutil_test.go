@@ -0,0 +1,219 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/javibauza/final-project/grpc-service/pb"
+	grpctls "github.com/javibauza/final-project/grpc-service/tlsutil"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) issue(t *testing.T, cn string, serial int64) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	for name, data := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+	}
+}
+
+type userServer struct {
+	pb.UnimplementedUserServiceServer
+}
+
+func (s *userServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	return &pb.GetUserResponse{UserId: req.UserId, Status: &pb.Status{}}, nil
+}
+
+func TestClientConfigMTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t)
+	serverCert, serverKey := ca.issue(t, "localhost", 2)
+	goodCert, goodKey := ca.issue(t, "rest-service", 3)
+	badCert, badKey := ca.issue(t, "intruder", 4)
+	writeFiles(t, dir, map[string][]byte{
+		"ca.crt":     ca.pem,
+		"server.crt": serverCert, "server.key": serverKey,
+		"good.crt": goodCert, "good.key": goodKey,
+		"bad.crt": badCert, "bad.key": badKey,
+	})
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	serverReloader, err := grpctls.NewReloader(path("server.crt"), path("server.key"), path("ca.crt"))
+	assert.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(
+		grpctls.ServerConfig(serverReloader, true, []string{"rest-service"}),
+	)))
+	pb.RegisterUserServiceServer(server, &userServer{})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	testCases := []struct {
+		testName      string
+		certFile      string
+		keyFile       string
+		checkResponse func(t *testing.T, res *pb.GetUserResponse, err error)
+	}{
+		{
+			testName: "allowed client certificate",
+			certFile: path("good.crt"),
+			keyFile:  path("good.key"),
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error) {
+				assert.NoError(t, err)
+				assert.Equal(t, "u1", res.UserId)
+			},
+		},
+		{
+			testName: "client certificate not allowed",
+			certFile: path("bad.crt"),
+			keyFile:  path("bad.key"),
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error) {
+				assert.Error(t, err)
+			},
+		},
+		{
+			testName: "no client certificate",
+			checkResponse: func(t *testing.T, res *pb.GetUserResponse, err error) {
+				assert.Error(t, err)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			reloader, err := NewReloader(tc.certFile, tc.keyFile, path("ca.crt"))
+			assert.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := grpc.DialContext(ctx, listener.Addr().String(),
+				grpc.WithTransportCredentials(credentials.NewTLS(ClientConfig(reloader, "localhost"))),
+			)
+			assert.NoError(t, err)
+			defer conn.Close()
+
+			res, err := pb.NewUserServiceClient(conn).GetUser(ctx, &pb.GetUserRequest{UserId: "u1"})
+			tc.checkResponse(t, res, err)
+		})
+	}
+}
+
+func TestClientConfigCARotation(t *testing.T) {
+	dir := t.TempDir()
+	oldCA, rotatedCA := newCA(t), newCA(t)
+	serverCert, serverKey := rotatedCA.issue(t, "localhost", 2)
+	writeFiles(t, dir, map[string][]byte{
+		"ca.crt":     oldCA.pem,
+		"server.crt": serverCert, "server.key": serverKey,
+	})
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	serverReloader, err := grpctls.NewReloader(path("server.crt"), path("server.key"), "")
+	assert.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(grpctls.ServerConfig(serverReloader, false, nil))))
+	pb.RegisterUserServiceServer(server, &userServer{})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	reloader, err := NewReloader("", "", path("ca.crt"))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, listener.Addr().String(),
+		grpc.WithTransportCredentials(credentials.NewTLS(ClientConfig(reloader, "localhost"))),
+	)
+	assert.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewUserServiceClient(conn)
+
+	_, err = client.GetUser(ctx, &pb.GetUserRequest{UserId: "u1"})
+	assert.Error(t, err, "the server's CA is not trusted yet")
+
+	writeFiles(t, dir, map[string][]byte{"ca.crt": rotatedCA.pem})
+	assert.NoError(t, reloader.Reload())
+
+	res, err := client.GetUser(ctx, &pb.GetUserRequest{UserId: "u1"}, grpc.WaitForReady(true))
+	assert.NoError(t, err, "the same connection trusts the reloaded CA")
+	if assert.NotNil(t, res) {
+		assert.Equal(t, "u1", res.UserId)
+	}
+
+	otherConn, err := grpc.DialContext(ctx, listener.Addr().String(),
+		grpc.WithTransportCredentials(credentials.NewTLS(ClientConfig(reloader, "users.example.com"))),
+	)
+	assert.NoError(t, err)
+	defer otherConn.Close()
+	_, err = pb.NewUserServiceClient(otherConn).GetUser(ctx, &pb.GetUserRequest{UserId: "u1"})
+	assert.Error(t, err, "the certificate is not valid for another name")
+}
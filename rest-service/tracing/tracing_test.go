@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/javibauza/final-project/grpc-service/pb"
+	grpctracing "github.com/javibauza/final-project/grpc-service/tracing"
+)
+
+type userServer struct {
+	pb.UnimplementedUserServiceServer
+	traceparent string
+}
+
+func (s *userServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("traceparent"); len(values) > 0 {
+		s.traceparent = values[0]
+	}
+	return &pb.GetUserResponse{UserId: req.UserId, Status: &pb.Status{}}, nil
+}
+
+func TestPropagationFromHTTPToGRPC(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	upstream := &userServer{}
+	pb.RegisterUserServiceServer(server, upstream)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(grpctracing.UnaryClientInterceptor()),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+	)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	r := mux.NewRouter()
+	r.Use(HTTPMiddleware)
+	r.Methods("GET").Path("/api/{userId}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := pb.NewUserServiceClient(conn)
+		_, err := client.GetUser(r.Context(), &pb.GetUserRequest{UserId: mux.Vars(r)["userId"]})
+		assert.NoError(t, err)
+	})
+
+	req := httptest.NewRequest("GET", "/api/abc", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+
+	clientSpan, serverSpan := spans[0], spans[1]
+	assert.Equal(t, "GET /api/{userId}", serverSpan.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", serverSpan.SpanContext().TraceID().String())
+	assert.Equal(t, "/pb.UserService/GetUser", clientSpan.Name())
+	assert.Equal(t, serverSpan.SpanContext().SpanID(), clientSpan.Parent().SpanID())
+	assert.Equal(t,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-"+clientSpan.SpanContext().SpanID().String()+"-01",
+		upstream.traceparent,
+	)
+}
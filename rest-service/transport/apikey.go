@@ -0,0 +1,78 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/gorilla/mux"
+
+	"github.com/javibauza/final-project/rest-service/endpoints"
+	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/logging"
+)
+
+// APIKeyHeader carries the API key machine users authenticate with instead
+// of a username and password.
+const APIKeyHeader = "X-API-Key"
+
+const (
+	scopeRead  = "users:read"
+	scopeWrite = "users:write"
+)
+
+// authorizeAPIKey authenticates r as the owner of key and returns the key's
+// id. Keys may only act on their owner, reads need the users:read scope and
+// everything else users:write, and they can't manage API keys.
+func authorizeAPIKey(r *http.Request, authenticate endpoint.Endpoint, key string) (string, error) {
+	res, err := authenticate(r.Context(), endpoints.AuthAPIKeyRequest{Key: key})
+	if _, ok := err.(erro.ErrForbidden); ok {
+		return "", erro.ErrUnauthorized{Err: errors.New(erro.ErrInvalidAPIKey)}
+	}
+	if err != nil {
+		return "", err
+	}
+	owner, ok := res.(endpoints.AuthAPIKeyResponse)
+	if !ok {
+		return "", erro.ErrInternal{Err: errors.New(erro.ErrUnexpected)}
+	}
+	logging.SetUser(r.Context(), owner.UserId)
+
+	userId, managesKeys := apiKeyTarget(r)
+	if userId == "" || userId != owner.UserId {
+		return "", erro.ErrForbidden{Err: errors.New(erro.ErrAPIKeyOtherUser)}
+	}
+	if managesKeys {
+		return "", erro.ErrForbidden{Err: errors.New(erro.ErrAPIKeyManagement)}
+	}
+
+	scope := scopeWrite
+	if readOnly(r.Method) {
+		scope = scopeRead
+	}
+	for _, granted := range owner.Scopes {
+		if granted == scope {
+			return owner.KeyId, nil
+		}
+	}
+	return "", erro.ErrForbidden{Err: fmt.Errorf(erro.ErrAPIKeyScope, scope)}
+}
+
+// apiKeyTarget returns the user r acts on, empty when it acts on none, and
+// whether it manages that user's API keys. Gateway routes carry no mux vars,
+// so their path is parsed instead.
+func apiKeyTarget(r *http.Request) (userId string, managesKeys bool) {
+	if userId, ok := mux.Vars(r)["userId"]; ok {
+		template, _ := mux.CurrentRoute(r).GetPathTemplate()
+		return userId, strings.HasPrefix(template, "/api/{userId}/api-keys")
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, GatewayPrefix+"users/")
+	if path == r.URL.Path {
+		return "", false
+	}
+	parts := strings.SplitN(path, "/", 3)
+	return parts[0], len(parts) > 1 && parts[1] == "api-keys"
+}
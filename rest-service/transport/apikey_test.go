@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyTargetOnGatewayRoutes(t *testing.T) {
+	testCases := []struct {
+		path        string
+		userId      string
+		managesKeys bool
+	}{
+		{path: "/v1/users/u1", userId: "u1"},
+		{path: "/v1/users/u1/audit", userId: "u1"},
+		{path: "/v1/users/u1/api-keys", userId: "u1", managesKeys: true},
+		{path: "/v1/users/u1/api-keys/k1", userId: "u1", managesKeys: true},
+		{path: "/v1/users"},
+		{path: "/v1/auth"},
+		{path: "/v1/email/verify"},
+	}
+
+	for _, tc := range testCases {
+		userId, managesKeys := apiKeyTarget(httptest.NewRequest("GET", tc.path, nil))
+		assert.Equal(t, tc.userId, userId, tc.path)
+		assert.Equal(t, tc.managesKeys, managesKeys, tc.path)
+	}
+}
@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+
+	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/principal"
+)
+
+// authMiddleware authenticates requests that carry an APIKeyHeader as the
+// key's owner, as authorizeAPIKey describes. Requests on a user, reads
+// included, must be authenticated, by a key or the session sessionMiddleware
+// found, others may be anonymous.
+func authMiddleware(authenticateKey endpoint.Endpoint, logger log.Logger) mux.MiddlewareFunc {
+	encodeError := problemEncoder(logger)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := authenticate(r, authenticateKey)
+			if err != nil {
+				if _, ok := err.(erro.ErrUnauthorized); ok {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+				}
+				encodeError(httptransport.PopulateRequestContext(r.Context(), r), err, w)
+				return
+			}
+			if p != "" {
+				r = r.WithContext(principal.NewContext(r.Context(), p))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticate returns the principal of r, empty for anonymous requests.
+func authenticate(r *http.Request, authenticateKey endpoint.Endpoint) (string, error) {
+	if p := principal.FromContext(r.Context()); p != "" {
+		return p, nil
+	}
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		keyId, err := authorizeAPIKey(r, authenticateKey, key)
+		if err != nil {
+			return "", err
+		}
+		return principal.APIKey(keyId), nil
+	}
+
+	if userId, _ := apiKeyTarget(r); userId != "" {
+		return "", erro.ErrUnauthorized{Err: errors.New(erro.ErrAuthenticationRequired)}
+	}
+	return "", nil
+}
+
+func readOnly(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
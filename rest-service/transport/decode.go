@@ -0,0 +1,208 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	erro "github.com/javibauza/final-project/rest-service/errors"
+)
+
+type Config struct {
+	// MaxBodyBytes is the largest request body accepted.
+	MaxBodyBytes int64
+	// LegacyFieldNames also accepts the Go field names the API used before
+	// it had a snake_case contract, such as UserId and AddInfo.
+	LegacyFieldNames bool
+}
+
+func DefaultConfig() Config {
+	return Config{MaxBodyBytes: 1 << 20}
+}
+
+var legacyFieldNames = map[string]string{
+	"UserId":  "user_id",
+	"Name":    "user_name",
+	"Pwd":     "password",
+	"Age":     "age",
+	"AddInfo": "add_info",
+}
+
+// addInfoField is the free-form field user bodies had before the profile.
+// It is deprecated but still accepted, and stored in the profile metadata
+// under the same key.
+const addInfoField = "add_info"
+
+type jsonDecoder struct {
+	cfg Config
+}
+
+// decode reads a single JSON object from the body of r into v, rejecting
+// unknown fields, and reports any problem as an error problemFrom understands.
+func (d jsonDecoder) decode(r *http.Request, v interface{}) error {
+	return d.decodeAs(r, v, "application/json")
+}
+
+// decodeAs is decode for a body of one of the given media types.
+func (d jsonDecoder) decodeAs(r *http.Request, v interface{}, mediaTypes ...string) error {
+	body, err := d.read(r, mediaTypes...)
+	if err != nil {
+		return err
+	}
+	return decodeBody(body, v)
+}
+
+// decodeUserAs is decodeAs for user bodies, which may still carry add_info.
+func (d jsonDecoder) decodeUserAs(r *http.Request, v interface{}, mediaTypes ...string) error {
+	body, err := d.read(r, mediaTypes...)
+	if err != nil {
+		return err
+	}
+	if body, err = moveAddInfo(body); err != nil {
+		return err
+	}
+	return decodeBody(body, v)
+}
+
+// read returns the body of r, checking its media type and size and renaming
+// legacy fields.
+func (d jsonDecoder) read(r *http.Request, mediaTypes ...string) ([]byte, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !contains(mediaTypes, mediaType) {
+		return nil, erro.ErrUnsupportedMediaType{Err: errors.New("content type must be " + strings.Join(mediaTypes, " or "))}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, d.cfg.MaxBodyBytes+1))
+	if err != nil {
+		return nil, erro.NewErrBadRequest("could not read request body")
+	}
+	if int64(len(body)) > d.cfg.MaxBodyBytes {
+		return nil, erro.ErrPayloadTooLarge{Err: fmt.Errorf("request body must not be larger than %d bytes", d.cfg.MaxBodyBytes)}
+	}
+
+	if d.cfg.LegacyFieldNames {
+		if body, err = renameLegacyFields(body); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// decodeBody decodes a single JSON object from body into v, rejecting
+// unknown fields.
+func decodeBody(body []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if dec.More() {
+		return erro.NewErrBadRequest("request body must contain a single JSON object")
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func renameLegacyFields(body []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		// Let the strict decoder describe the problem.
+		return body, nil
+	}
+
+	renamed := false
+	for old, name := range legacyFieldNames {
+		value, ok := fields[old]
+		if !ok {
+			continue
+		}
+		if _, ok := fields[name]; ok {
+			return nil, erro.NewErrBadRequest(fmt.Sprintf("fields %q and %q must not be used together", old, name))
+		}
+		delete(fields, old)
+		fields[name] = value
+		renamed = true
+	}
+	if !renamed {
+		return body, nil
+	}
+	return json.Marshal(fields)
+}
+
+// moveAddInfo moves the add_info of a user body to profile.metadata.add_info.
+// A null add_info stays null there, so a merge patch removes the key.
+func moveAddInfo(body []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		// Let the strict decoder describe the problem.
+		return body, nil
+	}
+	value, ok := fields[addInfoField]
+	if !ok {
+		return body, nil
+	}
+	delete(fields, addInfoField)
+
+	profile := map[string]json.RawMessage{}
+	if raw, ok := fields["profile"]; ok {
+		if err := json.Unmarshal(raw, &profile); err != nil || profile == nil {
+			return nil, erro.NewErrBadRequest(fmt.Sprintf("field %q can only be used with a profile object", addInfoField))
+		}
+	}
+	metadata := map[string]json.RawMessage{}
+	if raw, ok := profile["metadata"]; ok {
+		if err := json.Unmarshal(raw, &metadata); err != nil || metadata == nil {
+			return nil, erro.NewErrBadRequest(fmt.Sprintf("field %q can only be used with a profile.metadata object", addInfoField))
+		}
+	}
+	if _, ok := metadata[addInfoField]; ok {
+		return nil, erro.NewErrBadRequest(fmt.Sprintf("fields %q and %q must not be used together", addInfoField, "profile.metadata."+addInfoField))
+	}
+
+	metadata[addInfoField] = value
+	var err error
+	if profile["metadata"], err = json.Marshal(metadata); err != nil {
+		return nil, err
+	}
+	if fields["profile"], err = json.Marshal(profile); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return erro.NewErrBadRequest("request body must not be empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return erro.NewErrBadRequest("request body contains malformed JSON")
+	case errors.As(err, &syntaxErr):
+		return erro.NewErrBadRequest(fmt.Sprintf("request body contains malformed JSON at offset %d", syntaxErr.Offset))
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return erro.NewErrBadRequest("request body must be a JSON object")
+		}
+		return erro.NewErrBadRequest(fmt.Sprintf("field %q must be of type %s", typeErr.Field, typeErr.Type))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return erro.NewErrBadRequest("request body contains unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field "))
+	default:
+		return erro.NewErrBadRequest(err.Error())
+	}
+}
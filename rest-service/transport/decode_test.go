@@ -0,0 +1,193 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/javibauza/final-project/rest-service/endpoints"
+)
+
+func TestDecodeCreateUserRequest(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		cfg           Config
+		contentType   string
+		body          string
+		checkResponse func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest)
+	}{
+		{
+			testName:    "snake_case fields",
+			cfg:         DefaultConfig(),
+			contentType: "application/json",
+			body:        `{"user_name": "javier", "password": "secret", "age": 30, "profile": {"locale": "es-MX", "metadata": {"add_info": "info"}}}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusCreated, status)
+				assert.Equal(t, endpoints.CreateUserRequest{Name: "javier", Pwd: "secret", Age: 30, Profile: endpoints.Profile{Locale: "es-MX", Metadata: map[string]string{"add_info": "info"}}}, req)
+				assert.Equal(t, "1", body["user_id"])
+			},
+		},
+		{
+			testName:    "content type with charset",
+			cfg:         DefaultConfig(),
+			contentType: "application/json; charset=utf-8",
+			body:        `{"user_name": "javier", "password": "secret"}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusCreated, status)
+			},
+		},
+		{
+			testName:    "legacy fields rejected by default",
+			cfg:         DefaultConfig(),
+			contentType: "application/json",
+			body:        `{"Name": "javier", "Pwd": "secret"}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+				assert.Contains(t, body["detail"], `unknown field "Name"`)
+			},
+		},
+		{
+			testName:    "legacy fields accepted in compatibility mode",
+			cfg:         Config{MaxBodyBytes: 1 << 20, LegacyFieldNames: true},
+			contentType: "application/json",
+			body:        `{"Name": "javier", "Pwd": "secret", "Age": 30}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusCreated, status)
+				assert.Equal(t, endpoints.CreateUserRequest{Name: "javier", Pwd: "secret", Age: 30}, req)
+			},
+		},
+		{
+			testName:    "deprecated add_info stored in the profile metadata",
+			cfg:         DefaultConfig(),
+			contentType: "application/json",
+			body:        `{"user_name": "javier", "password": "secret", "add_info": "info", "profile": {"locale": "es-MX"}}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusCreated, status)
+				assert.Equal(t, endpoints.CreateUserRequest{Name: "javier", Pwd: "secret", Profile: endpoints.Profile{Locale: "es-MX", Metadata: map[string]string{"add_info": "info"}}}, req)
+			},
+		},
+		{
+			testName:    "legacy AddInfo in compatibility mode",
+			cfg:         Config{MaxBodyBytes: 1 << 20, LegacyFieldNames: true},
+			contentType: "application/json",
+			body:        `{"Name": "javier", "Pwd": "secret", "AddInfo": "info"}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusCreated, status)
+				assert.Equal(t, endpoints.CreateUserRequest{Name: "javier", Pwd: "secret", Profile: endpoints.Profile{Metadata: map[string]string{"add_info": "info"}}}, req)
+			},
+		},
+		{
+			testName:    "add_info and its metadata key together",
+			cfg:         DefaultConfig(),
+			contentType: "application/json",
+			body:        `{"user_name": "javier", "password": "secret", "add_info": "a", "profile": {"metadata": {"add_info": "b"}}}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+				assert.Equal(t, `fields "add_info" and "profile.metadata.add_info" must not be used together`, body["detail"])
+			},
+		},
+		{
+			testName:    "legacy and new name together",
+			cfg:         Config{MaxBodyBytes: 1 << 20, LegacyFieldNames: true},
+			contentType: "application/json",
+			body:        `{"Name": "javier", "user_name": "javi", "password": "secret"}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+			},
+		},
+		{
+			testName:    "unknown field",
+			cfg:         DefaultConfig(),
+			contentType: "application/json",
+			body:        `{"user_name": "javier", "password": "secret", "admin": true}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+				assert.Contains(t, body["detail"], `unknown field "admin"`)
+			},
+		},
+		{
+			testName:    "malformed json",
+			cfg:         DefaultConfig(),
+			contentType: "application/json",
+			body:        `{"user_name": "javier",}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+				assert.Contains(t, body["detail"], "malformed JSON")
+			},
+		},
+		{
+			testName:    "wrong type",
+			cfg:         DefaultConfig(),
+			contentType: "application/json",
+			body:        `{"user_name": "javier", "password": "secret", "age": "thirty"}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+				assert.Equal(t, `field "age" must be of type uint32`, body["detail"])
+			},
+		},
+		{
+			testName:    "empty body",
+			cfg:         DefaultConfig(),
+			contentType: "application/json",
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+			},
+		},
+		{
+			testName:    "trailing data",
+			cfg:         DefaultConfig(),
+			contentType: "application/json",
+			body:        `{"user_name": "javier", "password": "secret"} {}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+			},
+		},
+		{
+			testName:    "wrong content type",
+			cfg:         DefaultConfig(),
+			contentType: "text/plain",
+			body:        `{"user_name": "javier", "password": "secret"}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusUnsupportedMediaType, status)
+			},
+		},
+		{
+			testName:    "body too large",
+			cfg:         Config{MaxBodyBytes: 32},
+			contentType: "application/json",
+			body:        `{"user_name": "javier", "password": "secret"}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.CreateUserRequest) {
+				assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			var got endpoints.CreateUserRequest
+			eps := endpoints.Endpoints{
+				CreateUser: func(ctx context.Context, request interface{}) (interface{}, error) {
+					got = request.(endpoints.CreateUserRequest)
+					return endpoints.CreateUserResponse{UserId: "1"}, nil
+				},
+			}
+			srv := httptest.NewServer(NewHTTPServer(eps, nil, tc.cfg, log.NewNopLogger()))
+			defer srv.Close()
+
+			res, err := http.Post(srv.URL+"/api", tc.contentType, strings.NewReader(tc.body))
+			assert.NoError(t, err)
+			defer res.Body.Close()
+
+			var body map[string]interface{}
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			tc.checkResponse(t, res.StatusCode, body, got)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	erro "github.com/javibauza/final-project/rest-service/errors"
+)
+
+// etag formats a user version as a strong entity tag.
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+func setETag(w http.ResponseWriter, version int64) {
+	if version > 0 {
+		w.Header().Set("ETag", etag(version))
+	}
+}
+
+// parseIfMatch returns the version an If-Match header requires, or 0 when
+// the header is absent or "*". Only a single strong tag is supported since a
+// user has exactly one current version.
+func parseIfMatch(r *http.Request) (int64, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+
+	tag, err := strconv.Unquote(value)
+	if err != nil || !strings.HasPrefix(value, `"`) {
+		return 0, erro.NewErrBadRequest(erro.ErrInvalidIfMatch)
+	}
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version < 1 {
+		// A well-formed tag this server never issued can't match.
+		return 0, erro.ErrPreconditionFailed{Err: errors.New(erro.ErrVersionMismatch)}
+	}
+
+	return version, nil
+}
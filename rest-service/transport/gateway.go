@@ -0,0 +1,128 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/log"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/javibauza/final-project/grpc-service/pb"
+	"github.com/javibauza/final-project/grpc-service/svcauth"
+	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/repository"
+)
+
+const GatewayPrefix = "/v1/"
+
+// NewGateway returns the HTTP/JSON reverse proxy generated from the
+// google.api.http rules in user.proto. Field names are the proto names, so
+// they match the .proto file exactly.
+//
+// The client's credentials are never forwarded: the calls to the
+// grpcUserService carry only rest-service's own, attached by the
+// connection's interceptors. Errors, both of the gateway and in the
+// responses' status, are problems as on the hand-written routes.
+func NewGateway(ctx context.Context, conn *grpc.ClientConn, logger log.Logger) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher),
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions: protojson.MarshalOptions{
+				UseProtoNames:   true,
+				EmitUnpopulated: true,
+			},
+			UnmarshalOptions: protojson.UnmarshalOptions{
+				DiscardUnknown: true,
+			},
+		}),
+		runtime.WithForwardResponseOption(forwardStatus),
+		runtime.WithErrorHandler(gatewayErrorHandler(logger)),
+		runtime.WithRoutingErrorHandler(gatewayRoutingErrorHandler),
+	)
+	if err := pb.RegisterUserServiceHandler(ctx, mux, conn); err != nil {
+		return nil, err
+	}
+	return stripCredentials(mux), nil
+}
+
+// incomingHeaderMatcher is the default matcher, but for the metadata the
+// grpcUserService authenticates callers and records their principal with.
+func incomingHeaderMatcher(key string) (string, bool) {
+	name, ok := runtime.DefaultHeaderMatcher(key)
+	if !ok {
+		return "", false
+	}
+	switch strings.ToLower(strings.TrimPrefix(name, runtime.MetadataPrefix)) {
+	case svcauth.AuthorizationMetadataKey, svcauth.APIKeyMetadataKey, svcauth.PrincipalMetadataKey:
+		return "", false
+	}
+	return name, true
+}
+
+// stripCredentials removes the Authorization header, which grpc-gateway
+// forwards as authorization metadata whatever the header matcher says.
+func stripCredentials(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+		r.Header.Del("Authorization")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardStatus fails responses with a non-zero application status, which
+// gatewayErrorHandler then writes as the hand-written routes would.
+func forwardStatus(ctx context.Context, w http.ResponseWriter, resp proto.Message) error {
+	if r, ok := resp.(interface{ GetStatus() *pb.Status }); ok && r.GetStatus().GetCode() != 0 {
+		return repository.StatusError(r.GetStatus())
+	}
+	return nil
+}
+
+// gatewayErrorHandler writes the errors of the gateway as problems.
+func gatewayErrorHandler(logger log.Logger) runtime.ErrorHandlerFunc {
+	encode := problemEncoder(logger)
+	return func(ctx context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+		ctx = context.WithValue(ctx, httptransport.ContextKeyRequestPath, r.URL.Path)
+		encode(ctx, gatewayError(err), w)
+	}
+}
+
+// gatewayRoutingErrorHandler answers requests no gateway route matches as
+// the router does.
+func gatewayRoutingErrorHandler(ctx context.Context, mux *runtime.ServeMux, m runtime.Marshaler, w http.ResponseWriter, r *http.Request, httpStatus int) {
+	switch httpStatus {
+	case http.StatusNotFound:
+		notFoundHandler().ServeHTTP(w, r)
+	case http.StatusMethodNotAllowed:
+		methodNotAllowedHandler().ServeHTTP(w, r)
+	default:
+		runtime.DefaultRoutingErrorHandler(ctx, mux, m, w, r, httpStatus)
+	}
+}
+
+// gatewayError maps the gRPC status of an error of the gateway itself, as
+// for an unknown route or a malformed body, to the errors of the service.
+// Errors of forwardStatus are already mapped.
+func gatewayError(err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	e := errors.New(s.Message())
+	switch s.Code() {
+	case codes.InvalidArgument:
+		return erro.ErrBadRequest{Err: e}
+	case codes.NotFound:
+		return erro.ErrNotFound{Err: e}
+	default:
+		return erro.ErrInternal{Err: e}
+	}
+}
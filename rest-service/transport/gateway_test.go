@@ -0,0 +1,261 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/javibauza/final-project/grpc-service/pb"
+	"github.com/javibauza/final-project/grpc-service/svcauth"
+	"github.com/javibauza/final-project/rest-service/endpoints"
+	"github.com/javibauza/final-project/rest-service/principal"
+	"github.com/javibauza/final-project/rest-service/repository"
+)
+
+type gatewayUserService struct {
+	pb.UnimplementedUserServiceServer
+}
+
+func (gatewayUserService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	switch req.UserId {
+	case "1":
+	case "db":
+		return &pb.GetUserResponse{Status: &pb.Status{Code: 2, Message: "unexpected error: database is locked"}}, nil
+	default:
+		return &pb.GetUserResponse{Status: &pb.Status{Code: 5, Message: "user not found"}}, nil
+	}
+	return &pb.GetUserResponse{UserId: "1", UserName: "javier", UserAge: 30, Profile: &pb.Profile{DisplayName: "Javier"}}, nil
+}
+
+func (gatewayUserService) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.CreateUserResponse, error) {
+	return &pb.CreateUserResponse{UserId: req.UserName + "-id"}, nil
+}
+
+// metadataUserService records the metadata of the calls it gets.
+type metadataUserService struct {
+	gatewayUserService
+	md chan metadata.MD
+}
+
+func (s metadataUserService) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.md <- md
+	return s.gatewayUserService.GetUser(ctx, req)
+}
+
+func newGatewayServer(t *testing.T, svc pb.UserServiceServer, opts ...grpc.DialOption) *httptest.Server {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterUserServiceServer(s, svc)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	conn, err := grpc.DialContext(context.Background(), "bufnet", opts...)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	gateway, err := NewGateway(context.Background(), conn, log.NewNopLogger())
+	assert.NoError(t, err)
+
+	eps := endpoints.Endpoints{
+		GetUser: func(ctx context.Context, request interface{}) (interface{}, error) {
+			return endpoints.GetUserResponse{UserId: request.(endpoints.GetUserRequest).UserId}, nil
+		},
+		AuthAPIKey: func(ctx context.Context, request interface{}) (interface{}, error) {
+			return endpoints.AuthAPIKeyResponse{KeyId: "k1", UserId: "1", Scopes: []string{"users:read"}}, nil
+		},
+		AuthSession: authTestSession,
+	}
+	srv := httptest.NewServer(NewHTTPServer(eps, gateway, DefaultConfig(), log.NewNopLogger()))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGateway(t *testing.T) {
+	srv := newGatewayServer(t, gatewayUserService{})
+
+	testCases := []struct {
+		testName      string
+		method        string
+		path          string
+		body          string
+		header        http.Header
+		checkResponse func(t *testing.T, status int, body map[string]interface{})
+	}{
+		{
+			testName: "get user",
+			method:   http.MethodGet,
+			path:     "/v1/users/1",
+			header:   http.Header{"Authorization": {"Bearer session-1"}},
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, "1", body["user_id"])
+				assert.Equal(t, "javier", body["user_name"])
+				assert.Equal(t, float64(30), body["user_age"])
+			},
+		},
+		{
+			testName: "application status is mapped",
+			method:   http.MethodGet,
+			path:     "/v1/users/2",
+			header:   http.Header{"Authorization": {"Bearer session-2"}},
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}) {
+				assert.Equal(t, http.StatusNotFound, status)
+				assert.Equal(t, CodeNotFound, body["code"])
+				assert.Equal(t, "user not found", body["detail"])
+				assert.Equal(t, "/v1/users/2", body["instance"])
+			},
+		},
+		{
+			testName: "unexpected errors are hidden",
+			method:   http.MethodGet,
+			path:     "/v1/users/db",
+			header:   http.Header{"Authorization": {"Bearer session-db"}},
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}) {
+				assert.Equal(t, http.StatusInternalServerError, status)
+				assert.Equal(t, CodeInternal, body["code"])
+				assert.Equal(t, internalErrorDetail, body["detail"])
+			},
+		},
+		{
+			testName: "anonymous get user",
+			method:   http.MethodGet,
+			path:     "/v1/users/1",
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}) {
+				assert.Equal(t, http.StatusUnauthorized, status)
+				assert.Equal(t, CodeUnauthorized, body["code"])
+			},
+		},
+		{
+			testName: "anonymous delete",
+			method:   http.MethodDelete,
+			path:     "/v1/users/1",
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}) {
+				assert.Equal(t, http.StatusUnauthorized, status)
+				assert.Equal(t, CodeUnauthorized, body["code"])
+			},
+		},
+		{
+			testName: "malformed body",
+			method:   http.MethodPost,
+			path:     "/v1/users",
+			body:     `{"user_name": `,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}) {
+				assert.Equal(t, http.StatusBadRequest, status)
+				assert.Equal(t, CodeBadRequest, body["code"])
+			},
+		},
+		{
+			testName: "unknown gateway route",
+			method:   http.MethodGet,
+			path:     "/v1/groups",
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}) {
+				assert.Equal(t, http.StatusNotFound, status)
+				assert.Equal(t, CodeNotFound, body["code"])
+				assert.NotEmpty(t, body["request_id"])
+			},
+		},
+		{
+			testName: "create user",
+			method:   http.MethodPost,
+			path:     "/v1/users",
+			body:     `{"user_name": "javier", "password": "secret"}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, "javier-id", body["user_id"])
+			},
+		},
+		{
+			testName: "hand-written route still served",
+			method:   http.MethodGet,
+			path:     "/api/7",
+			header:   http.Header{"Authorization": {"Bearer session-7"}},
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, "7", body["user_id"])
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			var body io.Reader
+			if tc.body != "" {
+				body = strings.NewReader(tc.body)
+			}
+			req, err := http.NewRequest(tc.method, srv.URL+tc.path, body)
+			assert.NoError(t, err)
+			for key, values := range tc.header {
+				req.Header[key] = values
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+
+			if res.StatusCode >= http.StatusBadRequest {
+				assert.Equal(t, ProblemContentType, res.Header.Get("Content-Type"))
+			}
+			var decoded map[string]interface{}
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&decoded))
+			tc.checkResponse(t, res.StatusCode, decoded)
+		})
+	}
+}
+
+func TestGatewayDropsClientCredentials(t *testing.T) {
+	svc := metadataUserService{md: make(chan metadata.MD, 1)}
+	srv := newGatewayServer(t, svc, grpc.WithUnaryInterceptor(repository.APIKeyInterceptor("rest-key")))
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/users/1", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer session-1")
+	req.Header.Set("Grpc-Metadata-Authorization", "Bearer client-token")
+	req.Header.Set("Grpc-Metadata-X-Api-Key", "client-key")
+	req.Header.Set("Grpc-Metadata-X-Principal", "user:admin")
+	req.Header.Set("Grpc-Metadata-Team", "core")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	md := <-svc.md
+	assert.Equal(t, []string{"rest-key"}, md.Get(svcauth.APIKeyMetadataKey), "only rest-service's key reaches the grpcUserService")
+	assert.Empty(t, md.Get(svcauth.AuthorizationMetadataKey))
+	assert.Empty(t, md.Get(svcauth.PrincipalMetadataKey))
+	assert.Equal(t, []string{"core"}, md.Get("team"), "other metadata is still forwarded")
+}
+
+func TestGatewayForwardsPrincipal(t *testing.T) {
+	svc := metadataUserService{md: make(chan metadata.MD, 1)}
+	srv := newGatewayServer(t, svc, grpc.WithUnaryInterceptor(principal.UnaryClientInterceptor()))
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/users/1", nil)
+	require.NoError(t, err)
+	req.Header.Set(APIKeyHeader, "fpk_k1_secret")
+	req.Header.Set("Grpc-Metadata-X-Principal", "user:admin")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	md := <-svc.md
+	assert.Equal(t, []string{principal.APIKey("k1")}, md.Get(svcauth.PrincipalMetadataKey))
+}
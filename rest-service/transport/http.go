@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/go-kit/log"
 	"github.com/gorilla/mux"
@@ -11,24 +13,54 @@ import (
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/javibauza/final-project/rest-service/endpoints"
 	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/logging"
+	"github.com/javibauza/final-project/rest-service/openapi"
+	"github.com/javibauza/final-project/rest-service/requestid"
+	"github.com/javibauza/final-project/rest-service/tracing"
 )
 
-type ErrorResponse struct {
-	Message string
-}
-
-func NewHTTPServer(endpoints endpoints.Endpoints, logger log.Logger) http.Handler {
+// NewHTTPServer serves the hand-written routes and, when gateway is not nil,
+// the generated gateway routes under GatewayPrefix.
+func NewHTTPServer(endpoints endpoints.Endpoints, gateway http.Handler, cfg Config, logger log.Logger) http.Handler {
 	r := mux.NewRouter()
+	// These run without the middleware below.
+	r.NotFoundHandler = requestid.HTTPMiddleware(notFoundHandler())
+	r.MethodNotAllowedHandler = requestid.HTTPMiddleware(methodNotAllowedHandler())
+	d := jsonDecoder{cfg: cfg}
 
+	r.Use(requestid.HTTPMiddleware)
+	r.Use(tracing.HTTPMiddleware)
+	r.Use(logging.AccessLogMiddleware(logger))
+	r.Use(sessionMiddleware(endpoints.AuthSession, logger))
+	r.Use(authMiddleware(endpoints.AuthAPIKey, logger))
 	r.Use(commonMiddleware)
 	options := []httptransport.ServerOption{
-		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerBefore(httptransport.PopulateRequestContext),
+		httptransport.ServerErrorEncoder(problemEncoder(logger)),
 	}
 
 	r.Methods("POST").Path("/api/auth").Handler(
 		httptransport.NewServer(
 			endpoints.Authenticate,
-			decodeAuthRequest,
+			d.decodeAuthRequest,
+			encodeAuthResponse,
+			options...,
+		),
+	)
+
+	r.Methods("POST").Path("/api/auth/challenge").Handler(
+		httptransport.NewServer(
+			endpoints.CompleteAuth,
+			d.decodeCompleteAuthRequest,
+			encodeAuthResponse,
+			options...,
+		),
+	)
+
+	r.Methods("POST").Path("/api/auth/federated").Handler(
+		httptransport.NewServer(
+			endpoints.FederatedAuth,
+			d.decodeFederatedAuthRequest,
 			encodeAuthResponse,
 			options...,
 		),
@@ -37,7 +69,7 @@ func NewHTTPServer(endpoints endpoints.Endpoints, logger log.Logger) http.Handle
 	r.Methods("POST").Path("/api").Handler(
 		httptransport.NewServer(
 			endpoints.CreateUser,
-			decodeCreateUserRequest,
+			d.decodeCreateUserRequest,
 			encodeCreateUserResponse,
 			options...,
 		),
@@ -46,7 +78,16 @@ func NewHTTPServer(endpoints endpoints.Endpoints, logger log.Logger) http.Handle
 	r.Methods("PUT").Path("/api/{userId}").Handler(
 		httptransport.NewServer(
 			endpoints.UpdateUser,
-			decodeUpdateUserRequest,
+			d.decodeUpdateUserRequest,
+			encodeUpdateUserResponse,
+			options...,
+		),
+	)
+
+	r.Methods("PATCH").Path("/api/{userId}").Handler(
+		httptransport.NewServer(
+			endpoints.UpdateUser,
+			d.decodePatchUserRequest,
 			encodeUpdateUserResponse,
 			options...,
 		),
@@ -61,6 +102,105 @@ func NewHTTPServer(endpoints endpoints.Endpoints, logger log.Logger) http.Handle
 		),
 	)
 
+	r.Methods("GET").Path("/api/{userId}/audit").Handler(
+		httptransport.NewServer(
+			endpoints.GetAuditLog,
+			decodeGetAuditLogRequest,
+			encodeGetAuditLogResponse,
+			options...,
+		),
+	)
+
+	r.Methods("DELETE").Path("/api/{userId}").Handler(
+		httptransport.NewServer(
+			endpoints.DeleteUser,
+			decodeDeleteUserRequest,
+			encodeDeleteUserResponse,
+			options...,
+		),
+	)
+
+	r.Methods("POST").Path("/api/{userId}/restore").Handler(
+		httptransport.NewServer(
+			endpoints.RestoreUser,
+			decodeRestoreUserRequest,
+			encodeGetUserResponse,
+			options...,
+		),
+	)
+
+	r.Methods("POST").Path("/api/{userId}/email/verification").Handler(
+		httptransport.NewServer(
+			endpoints.SendVerification,
+			decodeSendVerificationRequest,
+			encodeSendVerificationResponse,
+			options...,
+		),
+	)
+
+	r.Methods("POST").Path("/api/verify-email").Handler(
+		httptransport.NewServer(
+			endpoints.VerifyEmail,
+			d.decodeVerifyEmailRequest,
+			encodeGetUserResponse,
+			options...,
+		),
+	)
+
+	r.Methods("POST").Path("/api/{userId}/totp").Handler(
+		httptransport.NewServer(
+			endpoints.EnrollTOTP,
+			decodeEnrollTOTPRequest,
+			encodeSecretResponse,
+			options...,
+		),
+	)
+
+	r.Methods("POST").Path("/api/{userId}/totp/confirm").Handler(
+		httptransport.NewServer(
+			endpoints.ConfirmTOTP,
+			d.decodeConfirmTOTPRequest,
+			encodeSecretResponse,
+			options...,
+		),
+	)
+
+	r.Methods("POST").Path("/api/{userId}/api-keys").Handler(
+		httptransport.NewServer(
+			endpoints.CreateAPIKey,
+			d.decodeCreateAPIKeyRequest,
+			encodeCreateAPIKeyResponse,
+			options...,
+		),
+	)
+
+	r.Methods("GET").Path("/api/{userId}/api-keys").Handler(
+		httptransport.NewServer(
+			endpoints.ListAPIKeys,
+			decodeListAPIKeysRequest,
+			encodeListAPIKeysResponse,
+			options...,
+		),
+	)
+
+	r.Methods("DELETE").Path("/api/{userId}/api-keys/{keyId}").Handler(
+		httptransport.NewServer(
+			endpoints.RevokeAPIKey,
+			decodeRevokeAPIKeyRequest,
+			encodeDeleteUserResponse,
+			options...,
+		),
+	)
+
+	registerOIDCRoutes(r, endpoints, d, logger)
+
+	r.Methods("GET").Path("/openapi.json").Handler(openapi.SpecHandler())
+	r.Methods("GET").Path("/docs").Handler(openapi.DocsHandler())
+
+	if gateway != nil {
+		r.PathPrefix(GatewayPrefix).Handler(gateway)
+	}
+
 	return r
 }
 
@@ -71,50 +211,83 @@ func commonMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func decodeAuthRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+func (d jsonDecoder) decodeAuthRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
 	var req endpoints.AuthRequest
-	if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
-		return nil, e
+	if err := d.decode(r, &req); err != nil {
+		return nil, err
 	}
 	return req, nil
 }
 
 func encodeAuthResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if res, ok := response.(endpoints.AuthResponse); ok {
+		logging.SetUser(ctx, res.UserId)
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	return json.NewEncoder(w).Encode(response)
 }
 
-func decodeCreateUserRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+func (d jsonDecoder) decodeCompleteAuthRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var req endpoints.CompleteAuthChallengeRequest
+	if err := d.decode(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (d jsonDecoder) decodeFederatedAuthRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var req endpoints.FederatedAuthRequest
+	if err := d.decode(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (d jsonDecoder) decodeCreateUserRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
 	var req endpoints.CreateUserRequest
-	if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
-		return nil, e
+	if err := d.decodeUserAs(r, &req, "application/json"); err != nil {
+		return nil, err
 	}
 	return req, nil
 }
 
-func encodeCreateUserResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+func encodeCreateUserResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if res, ok := response.(endpoints.CreateUserResponse); ok {
+		logging.SetUser(ctx, res.UserId)
+		w.Header().Set("Location", "/api/"+url.PathEscape(res.UserId))
+		setETag(w, res.Version)
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(http.StatusCreated)
 	return json.NewEncoder(w).Encode(response)
 }
 
-func decodeUpdateUserRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+func (d jsonDecoder) decodeUpdateUserRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
 	var req endpoints.UpdateUserRequest
-	if e := json.NewDecoder(r.Body).Decode(&req); e != nil {
-		return nil, e
+	if err := d.decodeUserAs(r, &req, "application/json"); err != nil {
+		return nil, err
 	}
 
 	params := mux.Vars(r)
 	req.UserId = params["userId"]
 
+	version, err := parseIfMatch(r)
+	if err != nil {
+		return nil, err
+	}
+	req.ExpectedVersion = version
+
 	return req, nil
 }
 
 func encodeUpdateUserResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	if res, ok := response.(endpoints.UpdateUserResponse); ok {
+		setETag(w, res.Version)
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	return nil
+	return json.NewEncoder(w).Encode(response)
 }
 
 func decodeGetUserRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
@@ -127,33 +300,151 @@ func decodeGetUserRequest(_ context.Context, r *http.Request) (request interface
 }
 
 func encodeGetUserResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	if res, ok := response.(endpoints.GetUserResponse); ok {
+		setETag(w, res.Version)
+	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	return json.NewEncoder(w).Encode(response)
 }
 
-func encodeError(_ context.Context, err error, w http.ResponseWriter) {
-	if err == nil {
-		panic("encodeError with nil error")
+func decodeGetAuditLogRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var req endpoints.GetAuditLogRequest
+
+	params := mux.Vars(r)
+	req.UserId = params["userId"]
+
+	query := r.URL.Query()
+	if pageSize := query.Get("page_size"); pageSize != "" {
+		size, err := strconv.ParseInt(pageSize, 10, 32)
+		if err != nil {
+			return nil, erro.NewErrBadRequest(erro.ErrInvalidPageSize)
+		}
+		req.PageSize = int32(size)
 	}
+	req.PageToken = query.Get("page_token")
+
+	return req, nil
+}
+
+func encodeGetAuditLogResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(codeFrom(err))
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(response)
+}
+
+func decodeDeleteUserRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var req endpoints.DeleteUserRequest
+
+	params := mux.Vars(r)
+	req.UserId = params["userId"]
+
+	return req, nil
+}
+
+func encodeDeleteUserResponse(_ context.Context, w http.ResponseWriter, _ interface{}) error {
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func decodeRestoreUserRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var req endpoints.RestoreUserRequest
+
+	params := mux.Vars(r)
+	req.UserId = params["userId"]
+
+	return req, nil
+}
+
+func decodeSendVerificationRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var req endpoints.SendVerificationRequest
+
+	params := mux.Vars(r)
+	req.UserId = params["userId"]
+
+	return req, nil
+}
+
+func encodeSendVerificationResponse(_ context.Context, w http.ResponseWriter, _ interface{}) error {
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusAccepted)
+	return nil
 }
 
-func codeFrom(err error) int {
-	switch err.(type) {
-	case erro.ErrNotFound:
-		return http.StatusNotFound
-	case erro.ErrBadRequest:
-		return http.StatusBadRequest
-	case erro.ErrForbidden:
-		return http.StatusForbidden
-	case erro.ErrInternal:
-		return http.StatusInternalServerError
-	default:
-		return http.StatusInternalServerError
+func (d jsonDecoder) decodeVerifyEmailRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var req endpoints.VerifyEmailRequest
+	if err := d.decode(r, &req); err != nil {
+		return nil, err
 	}
+	return req, nil
+}
+
+func decodeEnrollTOTPRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var req endpoints.EnrollTOTPRequest
+
+	params := mux.Vars(r)
+	req.UserId = params["userId"]
+
+	return req, nil
+}
+
+func (d jsonDecoder) decodeConfirmTOTPRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var req endpoints.ConfirmTOTPRequest
+	if err := d.decode(r, &req); err != nil {
+		return nil, err
+	}
+	req.UserId = mux.Vars(r)["userId"]
+	return req, nil
+}
+
+// encodeSecretResponse writes a response that is only shown once, such as a
+// TOTP secret or recovery codes, and must not be cached.
+func encodeSecretResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(response)
+}
+
+func (d jsonDecoder) decodeCreateAPIKeyRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var req endpoints.CreateAPIKeyRequest
+	if err := d.decode(r, &req); err != nil {
+		return nil, err
+	}
+	req.UserId = mux.Vars(r)["userId"]
+	return req, nil
+}
+
+// encodeCreateAPIKeyResponse writes the new key, which is only shown once.
+func encodeCreateAPIKeyResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(response)
+}
+
+func decodeListAPIKeysRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var req endpoints.ListAPIKeysRequest
+
+	params := mux.Vars(r)
+	req.UserId = params["userId"]
+
+	return req, nil
+}
+
+func encodeListAPIKeysResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(response)
+}
+
+func decodeRevokeAPIKeyRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	var req endpoints.RevokeAPIKeyRequest
+
+	params := mux.Vars(r)
+	req.UserId = params["userId"]
+	req.KeyId = params["keyId"]
+
+	return req, nil
 }
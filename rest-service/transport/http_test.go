@@ -0,0 +1,658 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/javibauza/final-project/rest-service/endpoints"
+	erro "github.com/javibauza/final-project/rest-service/errors"
+)
+
+// authTestSession authenticates session tokens of the form
+// "session-<user id>".
+func authTestSession(ctx context.Context, request interface{}) (interface{}, error) {
+	token := request.(endpoints.AuthSessionRequest).Token
+	if !strings.HasPrefix(token, "session-") {
+		return nil, erro.ErrUnauthorized{Err: errors.New(erro.ErrInvalidSession)}
+	}
+	return endpoints.AuthSessionResponse{UserId: strings.TrimPrefix(token, "session-")}, nil
+}
+
+func TestWriteResponses(t *testing.T) {
+	eps := endpoints.Endpoints{
+		CreateUser: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.CreateUserRequest)
+			return endpoints.CreateUserResponse{UserId: "abc 1", Name: req.Name, Age: req.Age, Profile: req.Profile, Version: 1}, nil
+		},
+		UpdateUser: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.UpdateUserRequest)
+			if req.ExpectedVersion != 0 && req.ExpectedVersion != 4 {
+				return nil, erro.ErrPreconditionFailed{Err: errors.New(erro.ErrVersionMismatch)}
+			}
+			return endpoints.UpdateUserResponse{UserId: req.UserId, Name: "javier", Age: req.Age, Profile: endpoints.Profile{Metadata: map[string]string{"add_info": "info"}}, Version: 5}, nil
+		},
+		GetUser: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.GetUserRequest)
+			return endpoints.GetUserResponse{UserId: req.UserId, Name: "javier", Age: 31, Profile: endpoints.Profile{Metadata: map[string]string{"add_info": "info"}}, Version: 4, CreatedAt: "2026-01-02T03:04:05Z"}, nil
+		},
+		GetAuditLog: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.GetAuditLogRequest)
+			if req.PageToken == "bad" {
+				return nil, erro.NewErrBadRequest("invalid page_token")
+			}
+			return endpoints.GetAuditLogResponse{
+				Entries: []endpoints.AuditEntry{{
+					Id:        int64(req.PageSize),
+					Actor:     "rest-service",
+					Action:    "user.update",
+					Target:    req.UserId,
+					Changes:   map[string]string{"pwd_hash": "***"},
+					CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+				}},
+				NextPageToken: "next",
+			}, nil
+		},
+		DeleteUser: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.DeleteUserRequest)
+			if req.UserId != "u1" {
+				return nil, erro.ErrNotFound{Err: errors.New("user not found")}
+			}
+			return endpoints.DeleteUserResponse{}, nil
+		},
+		RestoreUser: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.RestoreUserRequest)
+			if req.UserId != "u1" {
+				return nil, erro.ErrPreconditionFailed{Err: errors.New("restore grace period has expired")}
+			}
+			return endpoints.GetUserResponse{UserId: req.UserId, Name: "javier", Age: 31, Profile: endpoints.Profile{Metadata: map[string]string{"add_info": "info"}}, Version: 6}, nil
+		},
+		SendVerification: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.SendVerificationRequest)
+			if req.UserId != "u1" {
+				return nil, erro.ErrPreconditionFailed{Err: errors.New("email address is already verified")}
+			}
+			return endpoints.SendVerificationResponse{}, nil
+		},
+		VerifyEmail: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.VerifyEmailRequest)
+			if req.Token != "the-token" {
+				return nil, erro.NewErrBadRequest("invalid or expired verification token")
+			}
+			return endpoints.GetUserResponse{UserId: "u1", Name: "javier", Profile: endpoints.Profile{Email: "javier@example.com"}, EmailVerified: true, Version: 7}, nil
+		},
+		CompleteAuth: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.CompleteAuthChallengeRequest)
+			if req.Challenge != "the-challenge" || req.Code != "123456" {
+				return nil, erro.ErrForbidden{Err: errors.New("wrong or already used two-factor code")}
+			}
+			return endpoints.AuthResponse{UserId: "u1"}, nil
+		},
+		EnrollTOTP: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.EnrollTOTPRequest)
+			return endpoints.EnrollTOTPResponse{Secret: "SECRET", URI: "otpauth://totp/final-project:" + req.UserId + "?secret=SECRET"}, nil
+		},
+		ConfirmTOTP: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.ConfirmTOTPRequest)
+			if req.Code != "123456" {
+				return nil, erro.ErrForbidden{Err: errors.New("wrong or already used two-factor code")}
+			}
+			return endpoints.ConfirmTOTPResponse{RecoveryCodes: []string{"abcde-fghij"}}, nil
+		},
+		CreateAPIKey: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.CreateAPIKeyRequest)
+			return endpoints.CreateAPIKeyResponse{
+				APIKey: endpoints.APIKey{KeyId: "k1", Name: req.Name, Scopes: req.Scopes, CreatedAt: "2026-01-02T03:04:05Z", ExpiresAt: req.ExpiresAt.Format(time.RFC3339)},
+				Key:    "fpk_k1_secret",
+			}, nil
+		},
+		ListAPIKeys: func(ctx context.Context, request interface{}) (interface{}, error) {
+			return endpoints.ListAPIKeysResponse{APIKeys: []endpoints.APIKey{}}, nil
+		},
+		RevokeAPIKey: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(endpoints.RevokeAPIKeyRequest)
+			if req.UserId != "u1" || req.KeyId != "k1" {
+				return nil, erro.ErrNotFound{Err: errors.New("API key not found")}
+			}
+			return endpoints.RevokeAPIKeyResponse{}, nil
+		},
+		AuthAPIKey: func(ctx context.Context, request interface{}) (interface{}, error) {
+			switch request.(endpoints.AuthAPIKeyRequest).Key {
+			case "fpk_read_secret":
+				return endpoints.AuthAPIKeyResponse{UserId: "u1", Scopes: []string{"users:read"}}, nil
+			case "fpk_write_secret":
+				return endpoints.AuthAPIKeyResponse{UserId: "u1", Scopes: []string{"users:read", "users:write"}}, nil
+			case "fpk_u2_secret":
+				return endpoints.AuthAPIKeyResponse{UserId: "u2", Scopes: []string{"users:read", "users:write"}}, nil
+			default:
+				return nil, erro.ErrForbidden{Err: errors.New("invalid, expired or revoked API key")}
+			}
+		},
+		AuthSession: authTestSession,
+	}
+	srv := httptest.NewServer(NewHTTPServer(eps, nil, DefaultConfig(), log.NewNopLogger()))
+	defer srv.Close()
+
+	testCases := []struct {
+		testName      string
+		method        string
+		path          string
+		body          string
+		header        http.Header
+		checkResponse func(t *testing.T, res *http.Response, body map[string]interface{})
+	}{
+		{
+			testName: "create returns 201 with location and user",
+			method:   http.MethodPost,
+			path:     "/api",
+			body:     `{"user_name": "javier", "password": "secret", "age": 30, "profile": {"email": "javier@example.com"}}`,
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusCreated, res.StatusCode)
+				assert.Equal(t, "/api/abc%201", res.Header.Get("Location"))
+				assert.Equal(t, `"1"`, res.Header.Get("ETag"))
+				assert.Equal(t, map[string]interface{}{
+					"user_id":   "abc 1",
+					"user_name": "javier",
+					"age":       float64(30),
+					"profile":   map[string]interface{}{"email": "javier@example.com"},
+				}, body)
+			},
+		},
+		{
+			testName: "update returns the updated user",
+			method:   http.MethodPut,
+			path:     "/api/u1",
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}},
+			body:     `{"age": 31}`,
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, `"5"`, res.Header.Get("ETag"))
+				assert.Equal(t, map[string]interface{}{
+					"user_id":        "u1",
+					"user_name":      "javier",
+					"age":            float64(31),
+					"profile":        map[string]interface{}{"metadata": map[string]interface{}{"add_info": "info"}},
+					"email_verified": false,
+				}, body)
+			},
+		},
+		{
+			testName: "get returns the version as etag",
+			method:   http.MethodGet,
+			path:     "/api/u1",
+			header:   http.Header{"Authorization": {"Bearer session-u1"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, `"4"`, res.Header.Get("ETag"))
+				assert.Equal(t, "2026-01-02T03:04:05Z", body["created_at"])
+				assert.NotContains(t, body, "last_login_at")
+			},
+		},
+		{
+			testName: "update with matching if-match",
+			method:   http.MethodPut,
+			path:     "/api/u1",
+			body:     `{"age": 31}`,
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}, "If-Match": {`"4"`}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, `"5"`, res.Header.Get("ETag"))
+			},
+		},
+		{
+			testName: "update with any if-match",
+			method:   http.MethodPut,
+			path:     "/api/u1",
+			body:     `{"age": 31}`,
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}, "If-Match": {"*"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+			},
+		},
+		{
+			testName: "update with stale if-match",
+			method:   http.MethodPut,
+			path:     "/api/u1",
+			body:     `{"age": 31}`,
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}, "If-Match": {`"3"`}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusPreconditionFailed, res.StatusCode)
+				assert.Equal(t, CodePreconditionFailed, body["code"])
+			},
+		},
+		{
+			testName: "update with unknown if-match",
+			method:   http.MethodPut,
+			path:     "/api/u1",
+			body:     `{"age": 31}`,
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}, "If-Match": {`"abc"`}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusPreconditionFailed, res.StatusCode)
+			},
+		},
+		{
+			testName: "update with malformed if-match",
+			method:   http.MethodPut,
+			path:     "/api/u1",
+			body:     `{"age": 31}`,
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}, "If-Match": {`W/"4"`}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+				assert.Equal(t, erro.ErrInvalidIfMatch, body["detail"])
+			},
+		},
+		{
+			testName: "audit log page",
+			method:   http.MethodGet,
+			path:     "/api/u1/audit?page_size=2&page_token=abc",
+			header:   http.Header{"Authorization": {"Bearer session-u1"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, map[string]interface{}{
+					"entries": []interface{}{map[string]interface{}{
+						"id":         float64(2),
+						"actor":      "rest-service",
+						"action":     "user.update",
+						"target":     "u1",
+						"changes":    map[string]interface{}{"pwd_hash": "***"},
+						"created_at": "2026-01-02T03:04:05Z",
+					}},
+					"next_page_token": "next",
+				}, body)
+			},
+		},
+		{
+			testName: "audit log with malformed page size",
+			method:   http.MethodGet,
+			path:     "/api/u1/audit?page_size=ten",
+			header:   http.Header{"Authorization": {"Bearer session-u1"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+				assert.Equal(t, erro.ErrInvalidPageSize, body["detail"])
+			},
+		},
+		{
+			testName: "audit log with invalid page token",
+			method:   http.MethodGet,
+			path:     "/api/u1/audit?page_token=bad",
+			header:   http.Header{"Authorization": {"Bearer session-u1"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+			},
+		},
+		{
+			testName: "delete returns 204",
+			method:   http.MethodDelete,
+			path:     "/api/u1",
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusNoContent, res.StatusCode)
+				assert.Nil(t, body)
+			},
+		},
+		{
+			testName: "delete unknown user",
+			method:   http.MethodDelete,
+			path:     "/api/u2",
+			header:   http.Header{"X-Api-Key": {"fpk_u2_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusNotFound, res.StatusCode)
+			},
+		},
+		{
+			testName: "restore returns the restored user",
+			method:   http.MethodPost,
+			path:     "/api/u1/restore",
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, `"6"`, res.Header.Get("ETag"))
+				assert.Equal(t, "u1", body["user_id"])
+			},
+		},
+		{
+			testName: "restore after the grace period",
+			method:   http.MethodPost,
+			path:     "/api/u2/restore",
+			header:   http.Header{"X-Api-Key": {"fpk_u2_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusPreconditionFailed, res.StatusCode)
+				assert.Equal(t, "restore grace period has expired", body["detail"])
+			},
+		},
+		{
+			testName: "send verification returns 202",
+			method:   http.MethodPost,
+			path:     "/api/u1/email/verification",
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusAccepted, res.StatusCode)
+				assert.Nil(t, body)
+			},
+		},
+		{
+			testName: "send verification to a verified address",
+			method:   http.MethodPost,
+			path:     "/api/u2/email/verification",
+			header:   http.Header{"X-Api-Key": {"fpk_u2_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusPreconditionFailed, res.StatusCode)
+			},
+		},
+		{
+			testName: "verify email returns the user",
+			method:   http.MethodPost,
+			path:     "/api/verify-email",
+			body:     `{"token": "the-token"}`,
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, `"7"`, res.Header.Get("ETag"))
+				assert.Equal(t, true, body["email_verified"])
+			},
+		},
+		{
+			testName: "verify email with an invalid token",
+			method:   http.MethodPost,
+			path:     "/api/verify-email",
+			body:     `{"token": "other"}`,
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+				assert.Equal(t, "invalid or expired verification token", body["detail"])
+			},
+		},
+		{
+			testName: "complete auth challenge",
+			method:   http.MethodPost,
+			path:     "/api/auth/challenge",
+			body:     `{"challenge": "the-challenge", "code": "123456"}`,
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, map[string]interface{}{"user_id": "u1"}, body)
+			},
+		},
+		{
+			testName: "complete auth challenge with a wrong code",
+			method:   http.MethodPost,
+			path:     "/api/auth/challenge",
+			body:     `{"challenge": "the-challenge", "code": "654321"}`,
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusForbidden, res.StatusCode)
+			},
+		},
+		{
+			testName: "enroll totp is not cached",
+			method:   http.MethodPost,
+			path:     "/api/u1/totp",
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+				assert.Equal(t, map[string]interface{}{
+					"secret": "SECRET",
+					"uri":    "otpauth://totp/final-project:u1?secret=SECRET",
+				}, body)
+			},
+		},
+		{
+			testName: "confirm totp returns the recovery codes",
+			method:   http.MethodPost,
+			path:     "/api/u1/totp/confirm",
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}},
+			body:     `{"code": "123456"}`,
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+				assert.Equal(t, []interface{}{"abcde-fghij"}, body["recovery_codes"])
+			},
+		},
+		{
+			testName: "confirm totp with a wrong code",
+			method:   http.MethodPost,
+			path:     "/api/u1/totp/confirm",
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}},
+			body:     `{"code": "654321"}`,
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusForbidden, res.StatusCode)
+			},
+		},
+		{
+			testName: "create api key returns 201 with the key",
+			method:   http.MethodPost,
+			path:     "/api/u1/api-keys",
+			header:   http.Header{"Authorization": {"Bearer session-u1"}},
+			body:     `{"name": "batch", "scopes": ["users:read"], "expires_at": "2026-08-01T00:00:00Z"}`,
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusCreated, res.StatusCode)
+				assert.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+				assert.Equal(t, map[string]interface{}{
+					"key_id":     "k1",
+					"name":       "batch",
+					"scopes":     []interface{}{"users:read"},
+					"created_at": "2026-01-02T03:04:05Z",
+					"expires_at": "2026-08-01T00:00:00Z",
+					"key":        "fpk_k1_secret",
+				}, body)
+			},
+		},
+		{
+			testName: "list api keys is never null",
+			method:   http.MethodGet,
+			path:     "/api/u1/api-keys",
+			header:   http.Header{"Authorization": {"Bearer session-u1"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, map[string]interface{}{"api_keys": []interface{}{}}, body)
+			},
+		},
+		{
+			testName: "revoke api key returns 204",
+			method:   http.MethodDelete,
+			path:     "/api/u1/api-keys/k1",
+			header:   http.Header{"Authorization": {"Bearer session-u1"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusNoContent, res.StatusCode)
+			},
+		},
+		{
+			testName: "revoke unknown api key",
+			method:   http.MethodDelete,
+			path:     "/api/u1/api-keys/k2",
+			header:   http.Header{"Authorization": {"Bearer session-u1"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusNotFound, res.StatusCode)
+			},
+		},
+		{
+			testName: "session updates its own user",
+			method:   http.MethodPut,
+			path:     "/api/u1",
+			header:   http.Header{"Authorization": {"Bearer session-u1"}},
+			body:     `{"age": 31}`,
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+			},
+		},
+		{
+			testName: "api key reads its own user",
+			method:   http.MethodGet,
+			path:     "/api/u1",
+			header:   http.Header{"X-Api-Key": {"fpk_read_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				assert.Equal(t, "u1", body["user_id"])
+			},
+		},
+		{
+			testName: "invalid api key",
+			method:   http.MethodGet,
+			path:     "/api/u1",
+			header:   http.Header{"X-Api-Key": {"fpk_other_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+				assert.Equal(t, ProblemContentType, res.Header.Get("Content-Type"))
+				assert.Equal(t, CodeUnauthorized, body["code"])
+				assert.Equal(t, "/api/u1", body["instance"])
+			},
+		},
+		{
+			testName: "api key on another user",
+			method:   http.MethodGet,
+			path:     "/api/u2",
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusForbidden, res.StatusCode)
+				assert.Equal(t, erro.ErrAPIKeyOtherUser, body["detail"])
+			},
+		},
+		{
+			testName: "api key without the write scope",
+			method:   http.MethodPut,
+			path:     "/api/u1",
+			body:     `{"age": 31}`,
+			header:   http.Header{"X-Api-Key": {"fpk_read_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusForbidden, res.StatusCode)
+				assert.Equal(t, "API key lacks the users:write scope", body["detail"])
+			},
+		},
+		{
+			testName: "api key with the write scope",
+			method:   http.MethodPut,
+			path:     "/api/u1",
+			body:     `{"age": 31}`,
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+			},
+		},
+		{
+			testName: "api key can't create api keys",
+			method:   http.MethodPost,
+			path:     "/api/u1/api-keys",
+			body:     `{"name": "batch", "scopes": ["users:write"]}`,
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusForbidden, res.StatusCode)
+				assert.Equal(t, erro.ErrAPIKeyManagement, body["detail"])
+			},
+		},
+		{
+			testName: "anonymous update",
+			method:   http.MethodPut,
+			path:     "/api/u1",
+			body:     `{"age": 31}`,
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+				assert.Equal(t, "Bearer", res.Header.Get("WWW-Authenticate"))
+				assert.Equal(t, erro.ErrAuthenticationRequired, body["detail"])
+			},
+		},
+		{
+			testName: "anonymous get",
+			method:   http.MethodGet,
+			path:     "/api/u1",
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+				assert.Equal(t, erro.ErrAuthenticationRequired, body["detail"])
+			},
+		},
+		{
+			testName: "anonymous audit log",
+			method:   http.MethodGet,
+			path:     "/api/u1/audit",
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+				assert.Equal(t, erro.ErrAuthenticationRequired, body["detail"])
+				assert.NotContains(t, body, "entries")
+			},
+		},
+		{
+			testName: "api key reads its own audit log",
+			method:   http.MethodGet,
+			path:     "/api/u1/audit",
+			header:   http.Header{"X-Api-Key": {"fpk_read_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+			},
+		},
+		{
+			testName: "session reads another user's audit log",
+			method:   http.MethodGet,
+			path:     "/api/u2/audit",
+			header:   http.Header{"Authorization": {"Bearer session-u1"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusForbidden, res.StatusCode)
+			},
+		},
+		{
+			testName: "anonymous api key management",
+			method:   http.MethodGet,
+			path:     "/api/u1/api-keys",
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+			},
+		},
+		{
+			testName: "invalid session",
+			method:   http.MethodDelete,
+			path:     "/api/u1",
+			header:   http.Header{"Authorization": {"Bearer forged"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+				assert.Equal(t, "Bearer", res.Header.Get("WWW-Authenticate"))
+				assert.Equal(t, erro.ErrInvalidSession, body["detail"])
+			},
+		},
+		{
+			testName: "session on another user",
+			method:   http.MethodPost,
+			path:     "/api/u2/api-keys",
+			body:     `{"name": "ci", "scopes": ["users:read"]}`,
+			header:   http.Header{"Authorization": {"Bearer session-u1"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusForbidden, res.StatusCode)
+				assert.Equal(t, erro.ErrSessionOtherUser, body["detail"])
+			},
+		},
+		{
+			testName: "api key on a route without a user",
+			method:   http.MethodPost,
+			path:     "/api",
+			body:     `{"user_name": "javier", "password": "secret"}`,
+			header:   http.Header{"X-Api-Key": {"fpk_write_secret"}},
+			checkResponse: func(t *testing.T, res *http.Response, body map[string]interface{}) {
+				assert.Equal(t, http.StatusForbidden, res.StatusCode)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			for key, values := range tc.header {
+				req.Header[key] = values
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+
+			var body map[string]interface{}
+			if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusAccepted {
+				assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			}
+			tc.checkResponse(t, res, body)
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+
+	"github.com/javibauza/final-project/rest-service/endpoints"
+	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/logging"
+	"github.com/javibauza/final-project/rest-service/service"
+)
+
+const formContentType = "application/x-www-form-urlencoded"
+
+// DiscoveryPath is where OpenID Connect clients find the provider metadata.
+const DiscoveryPath = "/.well-known/openid-configuration"
+
+// oauthError is an error response of RFC 6749 section 5.2.
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in to {{.ClientName}}</title>
+</head>
+<body>
+<h1>Sign in to {{.ClientName}}</h1>
+{{if .LoginError}}<p role="alert">{{.LoginError}}</p>{{end}}
+<form method="post">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientId}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<p><label>User name <input name="user_name" value="{{.Request.Name}}" autocomplete="username" required></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><label>Two-factor code, if enabled <input name="code" inputmode="numeric" autocomplete="one-time-code"></label></p>
+<p><button type="submit">Sign in</button></p>
+</form>
+</body>
+</html>
+`))
+
+// registerOIDCRoutes serves the OpenID Connect provider. Its errors are
+// reported to clients as RFC 6749 errors rather than problems.
+func registerOIDCRoutes(r *mux.Router, eps endpoints.Endpoints, d jsonDecoder, logger log.Logger) {
+	options := []httptransport.ServerOption{
+		httptransport.ServerBefore(httptransport.PopulateRequestContext),
+		httptransport.ServerErrorEncoder(oauthErrorEncoder(logger)),
+	}
+
+	r.Methods("GET").Path(DiscoveryPath).Handler(
+		httptransport.NewServer(
+			eps.Discovery,
+			decodeEmptyRequest,
+			encodePublicResponse,
+			options...,
+		),
+	)
+
+	r.Methods("GET").Path(service.JWKSPath).Handler(
+		httptransport.NewServer(
+			eps.JWKS,
+			decodeEmptyRequest,
+			encodePublicResponse,
+			options...,
+		),
+	)
+
+	r.Methods("GET").Path(service.AuthorizePath).Handler(
+		httptransport.NewServer(
+			eps.Authorize,
+			decodeAuthorizeRequest,
+			encodeAuthorizeResponse,
+			options...,
+		),
+	)
+
+	r.Methods("POST").Path(service.AuthorizePath).Handler(
+		httptransport.NewServer(
+			eps.Authorize,
+			d.decodeLoginRequest,
+			encodeAuthorizeResponse,
+			options...,
+		),
+	)
+
+	r.Methods("POST").Path(service.TokenPath).Handler(
+		httptransport.NewServer(
+			eps.Token,
+			d.decodeTokenRequest,
+			encodeTokenResponse,
+			options...,
+		),
+	)
+
+	r.Methods("GET", "POST").Path(service.UserInfoPath).Handler(
+		httptransport.NewServer(
+			eps.UserInfo,
+			decodeUserInfoRequest,
+			encodeUserInfoResponse,
+			options...,
+		),
+	)
+}
+
+// oauthErrorEncoder writes ErrOAuth as an RFC 6749 error, and any other
+// error as a problem.
+func oauthErrorEncoder(logger log.Logger) httptransport.ErrorEncoder {
+	encodeProblem := problemEncoder(logger)
+	return func(ctx context.Context, err error, w http.ResponseWriter) {
+		oauthErr, ok := err.(erro.ErrOAuth)
+		if !ok {
+			encodeProblem(ctx, err, w)
+			return
+		}
+
+		status := http.StatusBadRequest
+		switch oauthErr.Code {
+		case erro.OAuthInvalidClient:
+			status = http.StatusUnauthorized
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		case erro.OAuthInvalidToken:
+			status = http.StatusUnauthorized
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(oauthError{Error: oauthErr.Code, Description: oauthErr.Error()})
+	}
+}
+
+// form reads the url-encoded form in the body of r, with the limits of
+// decode.
+func (d jsonDecoder) form(r *http.Request) (url.Values, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != formContentType {
+		return nil, erro.ErrUnsupportedMediaType{Err: errors.New("content type must be " + formContentType)}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, d.cfg.MaxBodyBytes+1))
+	if err != nil {
+		return nil, erro.NewErrBadRequest("could not read request body")
+	}
+	if int64(len(body)) > d.cfg.MaxBodyBytes {
+		return nil, erro.ErrPayloadTooLarge{Err: fmt.Errorf("request body must not be larger than %d bytes", d.cfg.MaxBodyBytes)}
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, erro.NewErrOAuth(erro.OAuthInvalidRequest, erro.ErrInvalidForm)
+	}
+	return values, nil
+}
+
+// singleValued fails when a parameter is sent more than once, which RFC 6749
+// forbids.
+func singleValued(values url.Values) error {
+	for name, v := range values {
+		if len(v) > 1 {
+			return erro.ErrOAuth{Code: erro.OAuthInvalidRequest, Err: fmt.Errorf(erro.ErrRepeatedParameter, name)}
+		}
+	}
+	return nil
+}
+
+func authorizeRequestFrom(values url.Values) endpoints.AuthorizeRequest {
+	return endpoints.AuthorizeRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientId:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		Nonce:               values.Get("nonce"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+}
+
+func decodeEmptyRequest(_ context.Context, _ *http.Request) (request interface{}, err error) {
+	return struct{}{}, nil
+}
+
+// encodePublicResponse writes metadata any client may fetch and cache.
+func encodePublicResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(response)
+}
+
+func decodeAuthorizeRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	return authorizeRequestFrom(r.URL.Query()), nil
+}
+
+// decodeLoginRequest decodes the submitted login form, which resends the
+// parameters of the authorization request.
+func (d jsonDecoder) decodeLoginRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	values, err := d.form(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req := authorizeRequestFrom(values)
+	req.Login = true
+	req.Name = values.Get("user_name")
+	req.Pwd = values.Get("password")
+	req.Code = values.Get("code")
+	return req, nil
+}
+
+// encodeAuthorizeResponse redirects back to the client or shows the login
+// form, which must not be framed or cached.
+func encodeAuthorizeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(endpoints.AuthorizeResponse)
+	if !ok {
+		return erro.ErrInternal{Err: errors.New(erro.ErrUnexpected)}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if res.RedirectURI != "" {
+		if res.UserId != "" {
+			logging.SetUser(ctx, res.UserId)
+		}
+		w.Header().Del("Content-Type")
+		w.Header().Set("Location", res.RedirectURI)
+		w.WriteHeader(http.StatusFound)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; form-action "+formActionSources(res.Request.RedirectURI)+"; frame-ancestors 'none'")
+	w.WriteHeader(http.StatusOK)
+	return loginForm.Execute(w, res)
+}
+
+// formActionSources allows the login form to post to the server and to
+// follow the redirect that answers it, which browsers also check against
+// form-action. The redirect URI was already checked against the client's.
+func formActionSources(redirectURI string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Scheme == "" {
+		return "'self'"
+	}
+	source := u.Scheme + ":"
+	if u.Host != "" {
+		source = u.Scheme + "://" + u.Host
+	}
+	if strings.ContainsAny(source, " ;,'") {
+		return "'self'"
+	}
+	return "'self' " + source
+}
+
+// decodeTokenRequest decodes a token request. Confidential clients
+// authenticate with HTTP Basic or with client_secret in the body, but not
+// both.
+func (d jsonDecoder) decodeTokenRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	values, err := d.form(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := singleValued(values); err != nil {
+		return nil, err
+	}
+
+	req := endpoints.TokenRequest{
+		GrantType:    values.Get("grant_type"),
+		Code:         values.Get("code"),
+		RedirectURI:  values.Get("redirect_uri"),
+		ClientId:     values.Get("client_id"),
+		ClientSecret: values.Get("client_secret"),
+		CodeVerifier: values.Get("code_verifier"),
+	}
+
+	if id, secret, ok := r.BasicAuth(); ok {
+		// RFC 6749 form-encodes the credentials before encoding them in
+		// the header.
+		id, idErr := url.QueryUnescape(id)
+		secret, secretErr := url.QueryUnescape(secret)
+		if idErr != nil || secretErr != nil || req.ClientSecret != "" || (req.ClientId != "" && req.ClientId != id) {
+			return nil, erro.NewErrOAuth(erro.OAuthInvalidRequest, erro.ErrInvalidClientAuth)
+		}
+		req.ClientId, req.ClientSecret = id, secret
+	}
+	return req, nil
+}
+
+func encodeTokenResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(response)
+}
+
+// decodeUserInfoRequest reads the bearer access token from the Authorization
+// header.
+func decodeUserInfoRequest(_ context.Context, r *http.Request) (request interface{}, err error) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || strings.TrimSpace(parts[1]) == "" {
+		return nil, erro.NewErrOAuth(erro.OAuthInvalidToken, erro.ErrInvalidAccessToken)
+	}
+	return endpoints.UserInfoRequest{AccessToken: strings.TrimSpace(parts[1])}, nil
+}
+
+func encodeUserInfoResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,480 @@
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/javibauza/final-project/rest-service/endpoints"
+	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/oidc"
+	"github.com/javibauza/final-project/rest-service/repository"
+	"github.com/javibauza/final-project/rest-service/service"
+)
+
+const (
+	testClientId     = "app1"
+	testClientSecret = "app1-secret"
+	testRedirectURI  = "https://app.example.com/callback"
+	testVerifier     = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge    = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+// oidcRepo is an in-memory user store with one user and one confidential
+// client, enough for the OpenID Connect flow.
+type oidcRepo struct {
+	repository.UserRepository
+	codes map[string]repository.AuthorizationCode
+}
+
+func (r *oidcRepo) Authenticate(_ context.Context, user repository.User) (repository.User, error) {
+	if user.Name != "javier" || user.Password != "secret" {
+		return repository.User{}, erro.ErrForbidden{Err: errors.New("invalid credentials")}
+	}
+	return repository.User{UserId: "u1"}, nil
+}
+
+func (r *oidcRepo) GetUser(_ context.Context, userId string) (repository.User, error) {
+	if userId != "u1" {
+		return repository.User{}, erro.ErrNotFound{Err: errors.New("user not found")}
+	}
+	return repository.User{
+		UserId:        "u1",
+		Name:          "javier",
+		Profile:       repository.Profile{Email: "javier@example.com", DisplayName: "Javier"},
+		EmailVerified: true,
+	}, nil
+}
+
+func (r *oidcRepo) GetOAuthClient(_ context.Context, clientId string) (repository.OAuthClient, error) {
+	if clientId != testClientId {
+		return repository.OAuthClient{}, erro.ErrNotFound{Err: errors.New("OAuth client not found")}
+	}
+	return repository.OAuthClient{Id: testClientId, Name: "Test App", RedirectURIs: []string{testRedirectURI}, Confidential: true}, nil
+}
+
+func (r *oidcRepo) CreateAuthorizationCode(_ context.Context, code repository.AuthorizationCode) (string, error) {
+	r.codes["code1"] = code
+	return "code1", nil
+}
+
+func (r *oidcRepo) ExchangeAuthorizationCode(_ context.Context, exchange repository.CodeExchange) (repository.AuthorizationCode, error) {
+	if exchange.ClientSecret != testClientSecret {
+		return repository.AuthorizationCode{}, erro.ErrForbidden{Err: errors.New("invalid client credentials")}
+	}
+	code, ok := r.codes[exchange.Code]
+	delete(r.codes, exchange.Code)
+	sum := sha256.Sum256([]byte(exchange.CodeVerifier))
+	if !ok || code.ClientId != exchange.ClientId || code.RedirectURI != exchange.RedirectURI ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != code.CodeChallenge {
+		return repository.AuthorizationCode{}, erro.NewErrBadRequest("invalid, expired or already used authorization code")
+	}
+	return code, nil
+}
+
+// newOIDCTestServer serves the provider with its issuer at the server's URL.
+func newOIDCTestServer(t *testing.T) *httptest.Server {
+	var handler http.Handler
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	key, err := oidc.GenerateKey()
+	require.NoError(t, err)
+	repo := &oidcRepo{codes: map[string]repository.AuthorizationCode{}}
+	oidcSrv := service.NewOIDCService(repo, oidc.NewSigner(key), service.OIDCConfig{Issuer: srv.URL, TokenTTL: time.Hour}, log.NewNopLogger())
+	handler = NewHTTPServer(endpoints.MakeEndpoints(service.NewService(repo, log.NewNopLogger()), oidcSrv, nil), nil, DefaultConfig(), log.NewNopLogger())
+	return srv
+}
+
+func decodeJSON(t *testing.T, res *http.Response, v interface{}) {
+	defer res.Body.Close()
+	require.NoError(t, json.NewDecoder(res.Body).Decode(v))
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	srv := newOIDCTestServer(t)
+	client := srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := client.Get(srv.URL + "/.well-known/openid-configuration")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var discovery endpoints.DiscoveryResponse
+	decodeJSON(t, res, &discovery)
+	assert.Equal(t, srv.URL, discovery.Issuer)
+	assert.Equal(t, []string{"S256"}, discovery.CodeChallengeMethods)
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testClientId},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"openid profile email"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"code_challenge":        {testChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	res, err = client.Get(discovery.AuthorizationEndpoint + "?" + params.Encode())
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Equal(t, "DENY", res.Header.Get("X-Frame-Options"))
+	assert.Contains(t, res.Header.Get("Content-Security-Policy"), "form-action 'self' https://app.example.com;", "the redirect after login must not be blocked")
+	assert.Contains(t, string(body), "Sign in to Test App")
+	assert.Contains(t, string(body), `name="code_challenge" value="`+testChallenge+`"`)
+
+	params.Set("user_name", "javier")
+	params.Set("password", "wrong")
+	res, err = client.PostForm(discovery.AuthorizationEndpoint, params)
+	require.NoError(t, err)
+	body, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, string(body), erro.ErrLoginFailed)
+	assert.NotContains(t, string(body), "wrong")
+
+	params.Set("password", "secret")
+	res, err = client.PostForm(discovery.AuthorizationEndpoint, params)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusFound, res.StatusCode)
+	location, err := url.Parse(res.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, testRedirectURI, location.Scheme+"://"+location.Host+location.Path)
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+	code := location.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+	}
+	tokenRequest := func() *http.Request {
+		req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(exchange.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(testClientId, testClientSecret)
+		return req
+	}
+	res, err = client.Do(tokenRequest())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+	var tokens endpoints.TokenResponse
+	decodeJSON(t, res, &tokens)
+	assert.Equal(t, "Bearer", tokens.TokenType)
+	assert.Equal(t, "openid profile email", tokens.Scope)
+
+	res, err = client.Get(discovery.JWKSURI)
+	require.NoError(t, err)
+	var keys oidc.JWKS
+	decodeJSON(t, res, &keys)
+	var claims oidc.Claims
+	require.NoError(t, oidc.Verify(tokens.IDToken, oidc.TypeIDToken, keys, &claims))
+	assert.NoError(t, claims.Validate(discovery.Issuer, testClientId, time.Now()))
+	assert.Equal(t, "u1", claims.Subject)
+	assert.Equal(t, "n-0S6", claims.Nonce)
+
+	req, err := http.NewRequest(http.MethodGet, discovery.UserInfoEndpoint, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	res, err = client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	var info endpoints.UserInfoResponse
+	decodeJSON(t, res, &info)
+	verified := true
+	assert.Equal(t, endpoints.UserInfoResponse{
+		Subject:           "u1",
+		Name:              "Javier",
+		PreferredUsername: "javier",
+		Email:             "javier@example.com",
+		EmailVerified:     &verified,
+	}, info)
+
+	// Codes can only be exchanged once.
+	res, err = client.Do(tokenRequest())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	var oauthErr oauthError
+	decodeJSON(t, res, &oauthErr)
+	assert.Equal(t, erro.OAuthInvalidGrant, oauthErr.Error)
+}
+
+func TestOIDCErrors(t *testing.T) {
+	srv := newOIDCTestServer(t)
+	client := srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	testCases := []struct {
+		name          string
+		buildRequest  func(t *testing.T) *http.Request
+		checkResponse func(t *testing.T, res *http.Response)
+	}{
+		{
+			name: "UnknownClient",
+			buildRequest: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, srv.URL+"/oauth/authorize?client_id=other&redirect_uri="+url.QueryEscape(testRedirectURI), nil)
+				require.NoError(t, err)
+				return req
+			},
+			checkResponse: func(t *testing.T, res *http.Response) {
+				assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+				assert.Equal(t, ProblemContentType, res.Header.Get("Content-Type"))
+			},
+		},
+		{
+			name: "MissingPKCE",
+			buildRequest: func(t *testing.T) *http.Request {
+				params := url.Values{
+					"response_type": {"code"},
+					"client_id":     {testClientId},
+					"redirect_uri":  {testRedirectURI},
+					"scope":         {"openid"},
+					"state":         {"xyz"},
+				}
+				req, err := http.NewRequest(http.MethodGet, srv.URL+"/oauth/authorize?"+params.Encode(), nil)
+				require.NoError(t, err)
+				return req
+			},
+			checkResponse: func(t *testing.T, res *http.Response) {
+				require.Equal(t, http.StatusFound, res.StatusCode)
+				location, err := url.Parse(res.Header.Get("Location"))
+				require.NoError(t, err)
+				assert.Equal(t, erro.OAuthInvalidRequest, location.Query().Get("error"))
+				assert.Equal(t, "xyz", location.Query().Get("state"))
+			},
+		},
+		{
+			name: "WrongClientSecret",
+			buildRequest: func(t *testing.T) *http.Request {
+				form := url.Values{
+					"grant_type":    {"authorization_code"},
+					"code":          {"code1"},
+					"redirect_uri":  {testRedirectURI},
+					"code_verifier": {testVerifier},
+				}
+				req, err := http.NewRequest(http.MethodPost, srv.URL+"/oauth/token", strings.NewReader(form.Encode()))
+				require.NoError(t, err)
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.SetBasicAuth(testClientId, "wrong")
+				return req
+			},
+			checkResponse: func(t *testing.T, res *http.Response) {
+				assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+				assert.NotEmpty(t, res.Header.Get("WWW-Authenticate"))
+				var oauthErr oauthError
+				decodeJSON(t, res, &oauthErr)
+				assert.Equal(t, erro.OAuthInvalidClient, oauthErr.Error)
+			},
+		},
+		{
+			name: "SecretInHeaderAndBody",
+			buildRequest: func(t *testing.T) *http.Request {
+				form := url.Values{
+					"grant_type":    {"authorization_code"},
+					"client_secret": {testClientSecret},
+				}
+				req, err := http.NewRequest(http.MethodPost, srv.URL+"/oauth/token", strings.NewReader(form.Encode()))
+				require.NoError(t, err)
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.SetBasicAuth(testClientId, testClientSecret)
+				return req
+			},
+			checkResponse: func(t *testing.T, res *http.Response) {
+				assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+				var oauthErr oauthError
+				decodeJSON(t, res, &oauthErr)
+				assert.Equal(t, oauthError{Error: erro.OAuthInvalidRequest, Description: erro.ErrInvalidClientAuth}, oauthErr)
+			},
+		},
+		{
+			name: "RepeatedParameter",
+			buildRequest: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodPost, srv.URL+"/oauth/token", strings.NewReader("grant_type=authorization_code&code=a&code=b"))
+				require.NoError(t, err)
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return req
+			},
+			checkResponse: func(t *testing.T, res *http.Response) {
+				assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+				var oauthErr oauthError
+				decodeJSON(t, res, &oauthErr)
+				assert.Equal(t, erro.OAuthInvalidRequest, oauthErr.Error)
+			},
+		},
+		{
+			name: "TokenNotAForm",
+			buildRequest: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodPost, srv.URL+"/oauth/token", strings.NewReader("{}"))
+				require.NoError(t, err)
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+			checkResponse: func(t *testing.T, res *http.Response) {
+				assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+			},
+		},
+		{
+			name: "UserInfoWithoutToken",
+			buildRequest: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, srv.URL+"/oauth/userinfo", nil)
+				require.NoError(t, err)
+				return req
+			},
+			checkResponse: func(t *testing.T, res *http.Response) {
+				assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+				assert.Equal(t, `Bearer error="invalid_token"`, res.Header.Get("WWW-Authenticate"))
+			},
+		},
+		{
+			name: "UserInfoWithForgedToken",
+			buildRequest: func(t *testing.T) *http.Request {
+				req, err := http.NewRequest(http.MethodGet, srv.URL+"/oauth/userinfo", nil)
+				require.NoError(t, err)
+				req.Header.Set("Authorization", "Bearer a.b.c")
+				return req
+			},
+			checkResponse: func(t *testing.T, res *http.Response) {
+				assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+				var oauthErr oauthError
+				decodeJSON(t, res, &oauthErr)
+				assert.Equal(t, erro.OAuthInvalidToken, oauthErr.Error)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := client.Do(tc.buildRequest(t))
+			require.NoError(t, err)
+			defer res.Body.Close()
+			tc.checkResponse(t, res)
+		})
+	}
+}
+
+// federationStub logs in the user "u1" with the ID token "valid", and
+// challenges them with "two-factor".
+type federationStub struct{}
+
+func (federationStub) Login(_ context.Context, idToken string) (service.AuthResponse, error) {
+	if idToken == "two-factor" {
+		return service.AuthResponse{Challenge: "the-challenge"}, nil
+	}
+	if idToken != "valid" {
+		return service.AuthResponse{}, erro.ErrUnauthorized{Err: errors.New(erro.ErrInvalidIDToken)}
+	}
+	return service.AuthResponse{UserId: "u1"}, nil
+}
+
+func TestFederatedAuth(t *testing.T) {
+	key, err := oidc.GenerateKey()
+	require.NoError(t, err)
+	signer := oidc.NewSigner(key)
+	oidcSrv := service.NewOIDCService(nil, signer, service.OIDCConfig{Issuer: "https://id.example.com", TokenTTL: time.Hour}, log.NewNopLogger())
+	eps := endpoints.MakeEndpoints(nil, oidcSrv, federationStub{})
+	srv := httptest.NewServer(NewHTTPServer(eps, nil, DefaultConfig(), log.NewNopLogger()))
+	t.Cleanup(srv.Close)
+
+	testCases := []struct {
+		name          string
+		body          string
+		checkResponse func(t *testing.T, res *http.Response)
+	}{
+		{
+			name: "ValidToken",
+			body: `{"id_token": "valid"}`,
+			checkResponse: func(t *testing.T, res *http.Response) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				var body endpoints.AuthResponse
+				decodeJSON(t, res, &body)
+				assert.Equal(t, "u1", body.UserId)
+				assert.Equal(t, int64(3600), body.ExpiresIn)
+				var claims oidc.Claims
+				require.NoError(t, oidc.Verify(body.SessionToken, oidc.TypeSessionToken, signer.JWKS(), &claims))
+				assert.Equal(t, "u1", claims.Subject, "the login starts a session")
+			},
+		},
+		{
+			name: "TwoFactor",
+			body: `{"id_token": "two-factor"}`,
+			checkResponse: func(t *testing.T, res *http.Response) {
+				assert.Equal(t, http.StatusOK, res.StatusCode)
+				var body endpoints.AuthResponse
+				decodeJSON(t, res, &body)
+				assert.Equal(t, endpoints.AuthResponse{Challenge: "the-challenge"}, body, "no session before the challenge is completed")
+			},
+		},
+		{
+			name: "InvalidToken",
+			body: `{"id_token": "forged"}`,
+			checkResponse: func(t *testing.T, res *http.Response) {
+				assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+				assert.Equal(t, "application/problem+json", res.Header.Get("Content-Type"))
+			},
+		},
+		{
+			name: "UnknownField",
+			body: `{"id_token": "valid", "password": "secret"}`,
+			checkResponse: func(t *testing.T, res *http.Response) {
+				assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := http.Post(srv.URL+"/api/auth/federated", "application/json", strings.NewReader(tc.body))
+			require.NoError(t, err)
+			defer res.Body.Close()
+			tc.checkResponse(t, res)
+		})
+	}
+}
+
+func TestFormActionSources(t *testing.T) {
+	testCases := []struct {
+		testName    string
+		redirectURI string
+		sources     string
+	}{
+		{testName: "web client", redirectURI: "https://app.example.com:8443/cb?x=1", sources: "'self' https://app.example.com:8443"},
+		{testName: "native client", redirectURI: "com.example.app:/cb", sources: "'self' com.example.app:"},
+		{testName: "no redirect", redirectURI: "", sources: "'self'"},
+		{testName: "policy injection", redirectURI: "https://a;script-src*/cb", sources: "'self'"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			assert.Equal(t, tc.sources, formActionSources(tc.redirectURI))
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/javibauza/final-project/grpc-service/pb"
+	"github.com/javibauza/final-project/rest-service/endpoints"
+	"github.com/javibauza/final-project/rest-service/openapi"
+)
+
+type openAPIDocument struct {
+	OpenAPI string                                `json:"openapi"`
+	Paths   map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+func loadSpec(t *testing.T) openAPIDocument {
+	var doc openAPIDocument
+	assert.NoError(t, json.Unmarshal(openapi.Spec, &doc))
+	return doc
+}
+
+func TestOpenAPICoversEveryRoute(t *testing.T) {
+	doc := loadSpec(t)
+	assert.True(t, strings.HasPrefix(doc.OpenAPI, "3."))
+
+	router := NewHTTPServer(endpoints.Endpoints{}, http.NotFoundHandler(), DefaultConfig(), log.NewNopLogger()).(*mux.Router)
+
+	routes := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Prefix routes, such as the gateway, are documented path by path.
+			assert.Equal(t, GatewayPrefix, path)
+			return nil
+		}
+		for _, method := range methods {
+			key := method + " " + path
+			routes[key] = true
+			assert.Contains(t, doc.Paths[path], strings.ToLower(method), "route %s has no OpenAPI operation", key)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	for path, item := range doc.Paths {
+		if strings.HasPrefix(path, GatewayPrefix) {
+			continue
+		}
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			key := strings.ToUpper(method) + " " + path
+			assert.True(t, routes[key], "OpenAPI operation %s has no route", key)
+		}
+	}
+}
+
+func TestOpenAPICoversGatewayRules(t *testing.T) {
+	doc := loadSpec(t)
+
+	methods := pb.File_user_proto.Services().ByName("UserService").Methods()
+	for i := 0; i < methods.Len(); i++ {
+		rule, _ := proto.GetExtension(methods.Get(i).Options(), annotations.E_Http).(*annotations.HttpRule)
+		if rule == nil {
+			continue
+		}
+
+		var method, path string
+		switch pattern := rule.Pattern.(type) {
+		case *annotations.HttpRule_Get:
+			method, path = "get", pattern.Get
+		case *annotations.HttpRule_Post:
+			method, path = "post", pattern.Post
+		case *annotations.HttpRule_Put:
+			method, path = "put", pattern.Put
+		case *annotations.HttpRule_Patch:
+			method, path = "patch", pattern.Patch
+		case *annotations.HttpRule_Delete:
+			method, path = "delete", pattern.Delete
+		}
+		assert.Contains(t, doc.Paths[path], method, "%s has no OpenAPI operation", methods.Get(i).FullName())
+	}
+}
+
+func TestOpenAPIReferencesResolve(t *testing.T) {
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(openapi.Spec, &doc))
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				if ref, ok := child.(string); ok && key == "$ref" {
+					var target interface{} = doc
+					for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+						m, _ := target.(map[string]interface{})
+						target = m[part]
+					}
+					assert.NotNil(t, target, "unresolved reference %s", ref)
+					continue
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	srv := httptest.NewServer(NewHTTPServer(endpoints.Endpoints{}, nil, DefaultConfig(), log.NewNopLogger()))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/openapi.json")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/json; charset=utf-8", res.Header.Get("Content-Type"))
+
+	var doc openAPIDocument
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&doc))
+	assert.NotEmpty(t, doc.Paths)
+
+	res, err = http.Get(srv.URL + "/docs")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", res.Header.Get("Content-Type"))
+}
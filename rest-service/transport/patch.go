@@ -0,0 +1,192 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+
+	"github.com/javibauza/final-project/rest-service/endpoints"
+	erro "github.com/javibauza/final-project/rest-service/errors"
+)
+
+const MergePatchContentType = "application/merge-patch+json"
+
+// patchFields maps the fields a merge patch may carry to their
+// UpdateUserRequest update mask paths, in the order they are applied.
+var patchFields = []struct {
+	name string
+	path string
+}{
+	{"user_name", "user_name"},
+	{"password", "password"},
+	{"age", "user_age"},
+	{"birth_date", "birth_date"},
+	{"profile", "profile"},
+}
+
+// profilePatchFields are the members of a profile patch, each its own update
+// mask path.
+var profilePatchFields = []string{"email", "display_name", "locale", "timezone", "metadata"}
+
+// decodePatchUserRequest reads an RFC 7396 JSON Merge Patch. Every member
+// present is written, and null clears the field. The profile is patched
+// member by member, and its metadata key by key.
+func (d jsonDecoder) decodePatchUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var patch map[string]json.RawMessage
+	if err := d.decodeUserAs(r, &patch, MergePatchContentType, "application/json"); err != nil {
+		return nil, err
+	}
+	if patch == nil {
+		return nil, erro.NewErrBadRequest("request body must be a JSON object")
+	}
+
+	req := endpoints.UpdateUserRequest{UserId: mux.Vars(r)["userId"]}
+	for _, field := range patchFields {
+		value, ok := patch[field.name]
+		if !ok {
+			continue
+		}
+		delete(patch, field.name)
+
+		var err error
+		switch field.name {
+		case "user_name":
+			err = decodePatchField(field.name, value, &req.Name)
+		case "password":
+			err = decodePatchField(field.name, value, &req.Pwd)
+		case "age":
+			err = decodePatchField(field.name, value, &req.Age)
+		case "birth_date":
+			err = decodePatchField(field.name, value, &req.BirthDate)
+		case "profile":
+			err = decodePatchProfile(value, &req)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if field.name != "profile" {
+			req.Fields = append(req.Fields, field.path)
+		}
+	}
+	for name := range patch {
+		return nil, erro.NewErrBadRequest(fmt.Sprintf("request body contains unknown field %q", name))
+	}
+
+	version, err := parseIfMatch(r)
+	if err != nil {
+		return nil, err
+	}
+	req.ExpectedVersion = version
+
+	return req, nil
+}
+
+// decodePatchField decodes value into v, leaving v at its zero value when
+// value is null.
+func decodePatchField(name string, value json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(value, v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return erro.NewErrBadRequest(fmt.Sprintf("field %q must be of type %s or null", name, typeErr.Type))
+		}
+		return erro.NewErrBadRequest(fmt.Sprintf("field %q is invalid", name))
+	}
+	return nil
+}
+
+// decodePatchProfile adds the members of a profile patch to req. A null
+// profile or metadata clears all of it.
+func decodePatchProfile(value json.RawMessage, req *endpoints.UpdateUserRequest) error {
+	var patch map[string]json.RawMessage
+	if err := decodePatchObject("profile", value, &patch); err != nil {
+		return err
+	}
+	if patch == nil {
+		req.Fields = append(req.Fields, "profile")
+		return nil
+	}
+
+	for _, name := range profilePatchFields {
+		value, ok := patch[name]
+		if !ok {
+			continue
+		}
+		delete(patch, name)
+
+		path := "profile." + name
+		var err error
+		switch name {
+		case "email":
+			err = decodePatchField(path, value, &req.Profile.Email)
+		case "display_name":
+			err = decodePatchField(path, value, &req.Profile.DisplayName)
+		case "locale":
+			err = decodePatchField(path, value, &req.Profile.Locale)
+		case "timezone":
+			err = decodePatchField(path, value, &req.Profile.Timezone)
+		case "metadata":
+			err = decodePatchMetadata(value, req)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		req.Fields = append(req.Fields, path)
+	}
+	for name := range patch {
+		return erro.NewErrBadRequest(fmt.Sprintf("request body contains unknown field %q", "profile."+name))
+	}
+	return nil
+}
+
+// decodePatchMetadata adds a metadata patch to req, one path per key so that
+// keys not in the patch are kept. A null key removes it.
+func decodePatchMetadata(value json.RawMessage, req *endpoints.UpdateUserRequest) error {
+	var patch map[string]json.RawMessage
+	if err := decodePatchObject("profile.metadata", value, &patch); err != nil {
+		return err
+	}
+	if patch == nil {
+		req.Fields = append(req.Fields, "profile.metadata")
+		return nil
+	}
+
+	keys := make([]string, 0, len(patch))
+	for key := range patch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := "profile.metadata." + key
+		var metadataValue *string
+		if err := decodePatchField(path, patch[key], &metadataValue); err != nil {
+			return err
+		}
+		if metadataValue != nil {
+			if req.Profile.Metadata == nil {
+				req.Profile.Metadata = map[string]string{}
+			}
+			req.Profile.Metadata[key] = *metadataValue
+		}
+		req.Fields = append(req.Fields, path)
+	}
+	return nil
+}
+
+// decodePatchObject decodes a nested object of a merge patch, leaving v nil
+// when value is null.
+func decodePatchObject(name string, value json.RawMessage, v *map[string]json.RawMessage) error {
+	if err := json.Unmarshal(value, v); err != nil {
+		return erro.NewErrBadRequest(fmt.Sprintf("field %q must be of type object or null", name))
+	}
+	return nil
+}
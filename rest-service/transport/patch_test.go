@@ -0,0 +1,266 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/javibauza/final-project/rest-service/endpoints"
+)
+
+func TestDecodePatchUserRequest(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		cfg           Config
+		contentType   string
+		body          string
+		checkResponse func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest)
+	}{
+		{
+			testName:    "set fields",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"user_name": "javier", "age": 31}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, endpoints.UpdateUserRequest{
+					UserId: "u1",
+					Name:   "javier",
+					Age:    31,
+					Fields: []string{"user_name", "user_age"},
+				}, req)
+			},
+		},
+		{
+			testName:    "set age to zero",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"age": 0}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, endpoints.UpdateUserRequest{UserId: "u1", Fields: []string{"user_age"}}, req)
+			},
+		},
+		{
+			testName:    "clear age",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"age": null}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, endpoints.UpdateUserRequest{UserId: "u1", Fields: []string{"user_age"}}, req)
+			},
+		},
+		{
+			testName:    "set birth date",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"birth_date": "1988-07-09"}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, endpoints.UpdateUserRequest{UserId: "u1", BirthDate: "1988-07-09", Fields: []string{"birth_date"}}, req)
+			},
+		},
+		{
+			testName:    "clear birth date",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"birth_date": null}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, endpoints.UpdateUserRequest{UserId: "u1", Fields: []string{"birth_date"}}, req)
+			},
+		},
+		{
+			testName:    "clear profile",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"profile": null}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, endpoints.UpdateUserRequest{UserId: "u1", Fields: []string{"profile"}}, req)
+			},
+		},
+		{
+			testName:    "patch profile members",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"profile": {"locale": "es-MX", "timezone": null, "metadata": {"team": "core", "add_info": null}}}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, endpoints.Profile{Locale: "es-MX", Metadata: map[string]string{"team": "core"}}, req.Profile)
+				assert.ElementsMatch(t, []string{
+					"profile.locale",
+					"profile.timezone",
+					"profile.metadata.add_info",
+					"profile.metadata.team",
+				}, req.Fields)
+			},
+		},
+		{
+			testName:    "clear profile metadata",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"profile": {"metadata": null}}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, []string{"profile.metadata"}, req.Fields)
+			},
+		},
+		{
+			testName:    "unknown profile member",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"profile": {"nickname": "javi"}}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+				assert.Contains(t, body["detail"], `unknown field "profile.nickname"`)
+			},
+		},
+		{
+			testName:    "clear user_name",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"user_name": null}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, endpoints.UpdateUserRequest{UserId: "u1", Fields: []string{"user_name"}}, req)
+			},
+		},
+		{
+			testName:    "clear password",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"password": null}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, endpoints.UpdateUserRequest{UserId: "u1", Fields: []string{"password"}}, req)
+			},
+		},
+		{
+			testName:    "plain json accepted",
+			cfg:         DefaultConfig(),
+			contentType: "application/json",
+			body:        `{"profile": {"display_name": "Javier"}}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, []string{"profile.display_name"}, req.Fields)
+			},
+		},
+		{
+			testName:    "legacy fields accepted in compatibility mode",
+			cfg:         Config{MaxBodyBytes: 1 << 20, LegacyFieldNames: true},
+			contentType: MergePatchContentType,
+			body:        `{"Age": null}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, []string{"user_age"}, req.Fields)
+			},
+		},
+		{
+			testName:    "deprecated add_info patches the metadata key",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"add_info": null, "profile": {"locale": "es"}}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, []string{"profile.locale", "profile.metadata.add_info"}, req.Fields)
+			},
+		},
+		{
+			testName:    "legacy AddInfo in compatibility mode",
+			cfg:         Config{MaxBodyBytes: 1 << 20, LegacyFieldNames: true},
+			contentType: MergePatchContentType,
+			body:        `{"AddInfo": "info"}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusOK, status)
+				assert.Equal(t, []string{"profile.metadata.add_info"}, req.Fields)
+				assert.Equal(t, map[string]string{"add_info": "info"}, req.Profile.Metadata)
+			},
+		},
+		{
+			testName:    "add_info with a null profile",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"add_info": "info", "profile": null}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+			},
+		},
+		{
+			testName:    "unknown field",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"user_id": "u2"}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+				assert.Equal(t, `request body contains unknown field "user_id"`, body["detail"])
+			},
+		},
+		{
+			testName:    "wrong type",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `{"age": "old"}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+				assert.Equal(t, `field "age" must be of type uint32 or null`, body["detail"])
+			},
+		},
+		{
+			testName:    "not an object",
+			cfg:         DefaultConfig(),
+			contentType: MergePatchContentType,
+			body:        `null`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusBadRequest, status)
+			},
+		},
+		{
+			testName:    "unsupported content type",
+			cfg:         DefaultConfig(),
+			contentType: "text/plain",
+			body:        `{"age": 1}`,
+			checkResponse: func(t *testing.T, status int, body map[string]interface{}, req endpoints.UpdateUserRequest) {
+				assert.Equal(t, http.StatusUnsupportedMediaType, status)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			var got endpoints.UpdateUserRequest
+			eps := endpoints.Endpoints{
+				UpdateUser: func(ctx context.Context, request interface{}) (interface{}, error) {
+					got = request.(endpoints.UpdateUserRequest)
+					return endpoints.UpdateUserResponse{UserId: got.UserId}, nil
+				},
+				AuthAPIKey: func(ctx context.Context, request interface{}) (interface{}, error) {
+					return endpoints.AuthAPIKeyResponse{KeyId: "k1", UserId: "u1", Scopes: []string{"users:write"}}, nil
+				},
+			}
+			srv := httptest.NewServer(NewHTTPServer(eps, nil, tc.cfg, log.NewNopLogger()))
+			defer srv.Close()
+
+			req, err := http.NewRequest(http.MethodPatch, srv.URL+"/api/u1", strings.NewReader(tc.body))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Header.Set(APIKeyHeader, "fpk_k1_secret")
+
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+
+			var body map[string]interface{}
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			tc.checkResponse(t, res.StatusCode, body, got)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/logging"
+	"github.com/javibauza/final-project/rest-service/requestid"
+)
+
+const (
+	ProblemContentType = "application/problem+json"
+	problemTypePrefix  = "urn:user-api:problem:"
+
+	internalErrorDetail = "an internal error occurred"
+)
+
+// Stable, machine readable error codes. Clients may switch on them, so they
+// must never change once released.
+const (
+	CodeBadRequest           = "bad_request"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeConflict             = "conflict"
+	CodeUnavailable          = "unavailable"
+	CodeInternal             = "internal"
+)
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func problemFrom(err error) (int, string) {
+	switch err.(type) {
+	case erro.ErrNotFound:
+		return http.StatusNotFound, CodeNotFound
+	case erro.ErrBadRequest:
+		return http.StatusBadRequest, CodeBadRequest
+	case erro.ErrUnauthorized:
+		return http.StatusUnauthorized, CodeUnauthorized
+	case erro.ErrForbidden:
+		return http.StatusForbidden, CodeForbidden
+	case erro.ErrUnsupportedMediaType:
+		return http.StatusUnsupportedMediaType, CodeUnsupportedMediaType
+	case erro.ErrPayloadTooLarge:
+		return http.StatusRequestEntityTooLarge, CodePayloadTooLarge
+	case erro.ErrPreconditionFailed:
+		return http.StatusPreconditionFailed, CodePreconditionFailed
+	case erro.ErrConflict:
+		return http.StatusConflict, CodeConflict
+	case erro.ErrUnavailable:
+		return http.StatusServiceUnavailable, CodeUnavailable
+	default:
+		return http.StatusInternalServerError, CodeInternal
+	}
+}
+
+func newProblem(ctx context.Context, status int, code, detail, instance string) Problem {
+	return Problem{
+		Type:      problemTypePrefix + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  instance,
+		Code:      code,
+		RequestID: requestid.FromContext(ctx),
+	}
+}
+
+func writeProblem(w http.ResponseWriter, p Problem) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// problemEncoder writes err as a problem. The detail of internal errors may
+// come from upstream services, so it is logged and replaced by a generic
+// message.
+func problemEncoder(logger log.Logger) httptransport.ErrorEncoder {
+	return func(ctx context.Context, err error, w http.ResponseWriter) {
+		if err == nil {
+			// Only a bug can get here; answer rather than panic.
+			err = errors.New("error encoder called without an error")
+		}
+
+		status, code := problemFrom(err)
+		detail := err.Error()
+		if code == CodeInternal {
+			level.Error(logging.WithContext(ctx, logger)).Log("msg", "internal error", "err", err)
+			detail = internalErrorDetail
+		}
+
+		instance, _ := ctx.Value(httptransport.ContextKeyRequestPath).(string)
+		writeProblem(w, newProblem(ctx, status, code, detail, instance))
+	}
+}
+
+func notFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, newProblem(r.Context(), http.StatusNotFound, CodeNotFound, "no route matches "+r.URL.Path, r.URL.Path))
+	})
+}
+
+func methodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, newProblem(r.Context(), http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path, r.URL.Path))
+	})
+}
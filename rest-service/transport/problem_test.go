@@ -0,0 +1,169 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/javibauza/final-project/rest-service/endpoints"
+	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/requestid"
+)
+
+func TestProblemResponses(t *testing.T) {
+	testCases := []struct {
+		testName      string
+		method        string
+		path          string
+		err           error
+		checkResponse func(t *testing.T, res *http.Response, problem Problem, logs string)
+	}{
+		{
+			testName: "not found",
+			method:   http.MethodGet,
+			path:     "/api/2",
+			err:      erro.ErrNotFound{Err: errors.New("user not found")},
+			checkResponse: func(t *testing.T, res *http.Response, problem Problem, logs string) {
+				assert.Equal(t, http.StatusNotFound, res.StatusCode)
+				assert.Equal(t, Problem{
+					Type:      "urn:user-api:problem:not_found",
+					Title:     "Not Found",
+					Status:    http.StatusNotFound,
+					Detail:    "user not found",
+					Instance:  "/api/2",
+					Code:      CodeNotFound,
+					RequestID: "req-1",
+				}, problem)
+			},
+		},
+		{
+			testName: "bad request",
+			method:   http.MethodGet,
+			path:     "/api/2",
+			err:      erro.NewErrBadRequest("userId is required"),
+			checkResponse: func(t *testing.T, res *http.Response, problem Problem, logs string) {
+				assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+				assert.Equal(t, CodeBadRequest, problem.Code)
+				assert.Equal(t, "userId is required", problem.Detail)
+			},
+		},
+		{
+			testName: "conflict",
+			method:   http.MethodGet,
+			path:     "/api/2",
+			err:      erro.ErrConflict{Err: errors.New("email address is already in use")},
+			checkResponse: func(t *testing.T, res *http.Response, problem Problem, logs string) {
+				assert.Equal(t, http.StatusConflict, res.StatusCode)
+				assert.Equal(t, CodeConflict, problem.Code)
+				assert.Equal(t, "email address is already in use", problem.Detail)
+			},
+		},
+		{
+			testName: "unavailable",
+			method:   http.MethodGet,
+			path:     "/api/2",
+			err:      erro.ErrUnavailable{Err: errors.New(erro.ErrIdPUnavailable)},
+			checkResponse: func(t *testing.T, res *http.Response, problem Problem, logs string) {
+				assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+				assert.Equal(t, CodeUnavailable, problem.Code)
+				assert.Equal(t, erro.ErrIdPUnavailable, problem.Detail)
+			},
+		},
+		{
+			testName: "internal error detail is hidden",
+			method:   http.MethodGet,
+			path:     "/api/2",
+			err:      erro.ErrInternal{Err: errors.New("rpc error: dial tcp 10.0.0.7:50051: connection refused")},
+			checkResponse: func(t *testing.T, res *http.Response, problem Problem, logs string) {
+				assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+				assert.Equal(t, CodeInternal, problem.Code)
+				assert.Equal(t, internalErrorDetail, problem.Detail)
+				assert.Contains(t, logs, "10.0.0.7:50051")
+				assert.Contains(t, logs, "request_id=req-1")
+			},
+		},
+		{
+			testName: "unknown error type is internal",
+			method:   http.MethodGet,
+			path:     "/api/2",
+			err:      errors.New("boom"),
+			checkResponse: func(t *testing.T, res *http.Response, problem Problem, logs string) {
+				assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+				assert.Equal(t, internalErrorDetail, problem.Detail)
+			},
+		},
+		{
+			testName: "unknown route",
+			method:   http.MethodGet,
+			path:     "/nope",
+			checkResponse: func(t *testing.T, res *http.Response, problem Problem, logs string) {
+				assert.Equal(t, http.StatusNotFound, res.StatusCode)
+				assert.Equal(t, CodeNotFound, problem.Code)
+				assert.Equal(t, "/nope", problem.Instance)
+				assert.Equal(t, "req-1", problem.RequestID)
+				assert.Equal(t, "req-1", res.Header.Get(requestid.Header))
+			},
+		},
+		{
+			testName: "method not allowed",
+			method:   http.MethodDelete,
+			path:     "/api",
+			checkResponse: func(t *testing.T, res *http.Response, problem Problem, logs string) {
+				assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+				assert.Equal(t, CodeMethodNotAllowed, problem.Code)
+				assert.Equal(t, "req-1", problem.RequestID)
+			},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.testName, func(t *testing.T) {
+			var logs bytes.Buffer
+			eps := endpoints.Endpoints{
+				GetUser: func(ctx context.Context, request interface{}) (interface{}, error) {
+					return nil, tc.err
+				},
+				AuthSession: authTestSession,
+			}
+			srv := httptest.NewServer(NewHTTPServer(eps, nil, DefaultConfig(), log.NewLogfmtLogger(&logs)))
+			defer srv.Close()
+
+			req, err := http.NewRequest(tc.method, srv.URL+tc.path, nil)
+			assert.NoError(t, err)
+			req.Header.Set(requestid.Header, "req-1")
+			req.Header.Set("Authorization", "Bearer session-2")
+
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, ProblemContentType, res.Header.Get("Content-Type"))
+
+			var problem Problem
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&problem))
+			tc.checkResponse(t, res, problem, logs.String())
+		})
+	}
+}
+
+func TestProblemEncoderNilError(t *testing.T) {
+	var logs bytes.Buffer
+	w := httptest.NewRecorder()
+	ctx := requestid.NewContext(context.Background(), "req-1")
+
+	problemEncoder(log.NewLogfmtLogger(&logs))(ctx, nil, w)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	var problem Problem
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&problem))
+	assert.Equal(t, CodeInternal, problem.Code)
+	assert.Equal(t, "req-1", problem.RequestID)
+	assert.Contains(t, logs.String(), "without an error")
+}
@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+
+	"github.com/javibauza/final-project/rest-service/endpoints"
+	erro "github.com/javibauza/final-project/rest-service/errors"
+	"github.com/javibauza/final-project/rest-service/logging"
+	"github.com/javibauza/final-project/rest-service/principal"
+)
+
+// sessionMiddleware authenticates requests on a user that carry a session
+// token as a bearer Authorization, and no APIKeyHeader, as the session's
+// user. A session may only act on its own user.
+func sessionMiddleware(authenticate endpoint.Endpoint, logger log.Logger) mux.MiddlewareFunc {
+	encodeError := problemEncoder(logger)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userId, _ := apiKeyTarget(r)
+			token, ok := bearerToken(r)
+			if !ok || userId == "" || r.Header.Get(APIKeyHeader) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err := authorizeSession(r, authenticate, token, userId); err != nil {
+				if _, ok := err.(erro.ErrUnauthorized); ok {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+				}
+				encodeError(httptransport.PopulateRequestContext(r.Context(), r), err, w)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(principal.NewContext(r.Context(), principal.User(userId))))
+		})
+	}
+}
+
+func authorizeSession(r *http.Request, authenticate endpoint.Endpoint, token, userId string) error {
+	res, err := authenticate(r.Context(), endpoints.AuthSessionRequest{Token: token})
+	if err != nil {
+		return err
+	}
+	session, ok := res.(endpoints.AuthSessionResponse)
+	if !ok {
+		return erro.ErrInternal{Err: errors.New(erro.ErrUnexpected)}
+	}
+	logging.SetUser(r.Context(), session.UserId)
+
+	if session.UserId != userId {
+		return erro.ErrForbidden{Err: errors.New(erro.ErrSessionOtherUser)}
+	}
+	return nil
+}
+
+// bearerToken returns the token of a bearer Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	parts := strings.Fields(r.Header.Get("Authorization"))
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+	return parts[1], true
+}